@@ -0,0 +1,157 @@
+// Package candidatefilter decides the network interfaces and the local IPs the ICE candidates are gathered on.
+package candidatefilter
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// wireGuardCacheTTL is how long the result of a WireGuard interface lookup is kept,
+// the interfaces are checked for every gathering of every connection
+const wireGuardCacheTTL = time.Minute
+
+// Rules are the allow and deny rules of the candidate gathering. A deny rule takes precedence over an allow rule,
+// an empty allow list allows everything not denied.
+type Rules struct {
+	// AllowInterfaces are the glob patterns (e.g. "eth*") of the interfaces to gather on
+	AllowInterfaces []string
+	// DenyInterfaces are the glob patterns of the interfaces never to gather on
+	DenyInterfaces []string
+	// AllowIPs are the CIDRs (e.g. "192.168.0.0/16") of the local IPs to gather
+	AllowIPs []string
+	// DenyIPs are the CIDRs of the local IPs never to gather (e.g. "10.244.0.0/16" of the Kubernetes pods)
+	DenyIPs []string
+}
+
+// Filter applies the Rules
+type Filter struct {
+	allowInterfaces []string
+	denyInterfaces  []string
+	allowIPs        []*net.IPNet
+	denyIPs         []*net.IPNet
+}
+
+// New validates the rules and returns their Filter
+func New(rules Rules) (*Filter, error) {
+	for _, patterns := range [][]string{rules.AllowInterfaces, rules.DenyInterfaces} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	allowIPs, err := parseCIDRs(rules.AllowIPs)
+	if err != nil {
+		return nil, err
+	}
+	denyIPs, err := parseCIDRs(rules.DenyIPs)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		allowInterfaces: rules.AllowInterfaces,
+		denyInterfaces:  rules.DenyInterfaces,
+		allowIPs:        allowIPs,
+		denyIPs:         denyIPs,
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// InterfaceAllowed returns true if the candidates can be gathered on the interface
+func (f *Filter) InterfaceAllowed(name string) bool {
+	if matchAny(f.denyInterfaces, name) {
+		log.Debugf("ignoring interface %s - it is denied", name)
+		return false
+	}
+	if len(f.allowInterfaces) > 0 && !matchAny(f.allowInterfaces, name) {
+		log.Debugf("ignoring interface %s - it is not allowed", name)
+		return false
+	}
+	return true
+}
+
+// IPAllowed returns true if the local IP can be gathered
+func (f *Filter) IPAllowed(ip net.IP) bool {
+	if containsAny(f.denyIPs, ip) {
+		return false
+	}
+	return len(f.allowIPs) == 0 || containsAny(f.allowIPs, ip)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// the patterns have been validated by New
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type wireGuardLookup struct {
+	isWireGuard bool
+	checkedAt   time.Time
+}
+
+var (
+	wireGuardMu      sync.Mutex
+	wireGuardLookups = make(map[string]wireGuardLookup)
+	// lookupWireGuard is replaced by the tests
+	lookupWireGuard = wireGuardDevice
+)
+
+// IsWireGuard returns true if the interface is a WireGuard one, the tunnels mustn't be built over the tunnels.
+// The lookups are cached for a minute.
+func IsWireGuard(name string) bool {
+	wireGuardMu.Lock()
+	defer wireGuardMu.Unlock()
+
+	if lookup, ok := wireGuardLookups[name]; ok && time.Since(lookup.checkedAt) < wireGuardCacheTTL {
+		return lookup.isWireGuard
+	}
+	isWireGuard := lookupWireGuard(name)
+	wireGuardLookups[name] = wireGuardLookup{isWireGuard: isWireGuard, checkedAt: time.Now()}
+	return isWireGuard
+}
+
+func wireGuardDevice(name string) bool {
+	wg, err := wgctrl.New()
+	if err != nil {
+		log.Debugf("trying to create a wgctrl client failed with: %v", err)
+		return false
+	}
+	defer func() {
+		if err := wg.Close(); err != nil {
+			log.Debugf("failed closing wgctrl client: %v", err)
+		}
+	}()
+
+	_, err = wg.Device(name)
+	return err == nil
+}
@@ -0,0 +1,80 @@
+package candidatefilter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_InterfaceAllowed(t *testing.T) {
+	filter, err := New(Rules{
+		AllowInterfaces: []string{"eth*", "en?"},
+		DenyInterfaces:  []string{"eth9"},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, filter.InterfaceAllowed("eth0"))
+	assert.True(t, filter.InterfaceAllowed("en0"))
+	assert.False(t, filter.InterfaceAllowed("eth9"), "the deny rule should take precedence")
+	assert.False(t, filter.InterfaceAllowed("en10"))
+	assert.False(t, filter.InterfaceAllowed("docker0"))
+
+	filter, err = New(Rules{DenyInterfaces: []string{"docker*", "veth*"}})
+	require.NoError(t, err)
+	assert.True(t, filter.InterfaceAllowed("eth0"), "everything not denied should be allowed without allow rules")
+	assert.False(t, filter.InterfaceAllowed("veth1a2b"))
+}
+
+func TestFilter_IPAllowed(t *testing.T) {
+	filter, err := New(Rules{
+		AllowIPs: []string{"10.0.0.0/8", "fd00::/8"},
+		DenyIPs:  []string{"10.244.0.0/16"},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, filter.IPAllowed(net.ParseIP("10.1.2.3")))
+	assert.True(t, filter.IPAllowed(net.ParseIP("fd00::1")))
+	assert.False(t, filter.IPAllowed(net.ParseIP("10.244.1.5")), "the deny rule should take precedence")
+	assert.False(t, filter.IPAllowed(net.ParseIP("192.168.1.2")))
+
+	filter, err = New(Rules{DenyIPs: []string{"10.244.0.0/16"}})
+	require.NoError(t, err)
+	assert.True(t, filter.IPAllowed(net.ParseIP("192.168.1.2")))
+	assert.False(t, filter.IPAllowed(net.ParseIP("10.244.1.5")))
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	_, err := New(Rules{AllowInterfaces: []string{"eth["}})
+	assert.Error(t, err)
+	_, err = New(Rules{DenyIPs: []string{"10.244.0.0"}})
+	assert.Error(t, err)
+}
+
+func TestIsWireGuard_Cached(t *testing.T) {
+	lookups := 0
+	lookupWireGuard = func(name string) bool {
+		lookups++
+		return name == "wg0"
+	}
+	defer func() {
+		lookupWireGuard = wireGuardDevice
+		wireGuardMu.Lock()
+		wireGuardLookups = make(map[string]wireGuardLookup)
+		wireGuardMu.Unlock()
+	}()
+
+	assert.True(t, IsWireGuard("wg0"))
+	assert.True(t, IsWireGuard("wg0"))
+	assert.False(t, IsWireGuard("eth0"))
+	assert.Equal(t, 2, lookups, "the lookups should be cached")
+
+	// an expired lookup is done again
+	wireGuardMu.Lock()
+	wireGuardLookups["wg0"] = wireGuardLookup{isWireGuard: true, checkedAt: time.Now().Add(-wireGuardCacheTTL)}
+	wireGuardMu.Unlock()
+	assert.True(t, IsWireGuard("wg0"))
+	assert.Equal(t, 3, lookups)
+}
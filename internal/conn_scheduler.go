@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	// defaultMaxConcurrentConnAttempts limits how many peer connection attempts can gather their local candidates
+	// (ICE gathering, TURN allocations) at the same time
+	defaultMaxConcurrentConnAttempts = 32
+
+	connAttemptInitialInterval = time.Second
+	connAttemptMaxInterval     = 3 * time.Minute
+)
+
+// connScheduler decides when connection attempts to the remote peers are started.
+// Each peer retries with its own exponential backoff with jitter and all peers share
+// a limited number of slots for attempts that are gathering their local candidates.
+// The wait for the remote answer doesn't take a slot, a peer that is offline would hold it until the timeout.
+type connScheduler struct {
+	slots chan struct{}
+
+	// onNextAttempt is called every time a peer's next connection attempt has been scheduled
+	onNextAttempt func(peerKey string, at time.Time)
+
+	mu    sync.Mutex
+	peers map[string]*peerSchedule
+}
+
+// peerSchedule is the scheduling state of a single peer
+type peerSchedule struct {
+	peerKey string
+	backOff backoff.BackOff
+	// wakeUp interrupts a backoff wait, e.g. when the remote peer sent us an offer
+	wakeUp chan struct{}
+	// removed is closed once the peer is not known anymore
+	removed     chan struct{}
+	removedOnce sync.Once
+
+	mu      sync.Mutex
+	hasSlot bool
+}
+
+func newConnScheduler(maxConcurrent int, onNextAttempt func(peerKey string, at time.Time)) *connScheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrentConnAttempts
+	}
+	return &connScheduler{
+		slots:         make(chan struct{}, maxConcurrent),
+		onNextAttempt: onNextAttempt,
+		peers:         make(map[string]*peerSchedule),
+	}
+}
+
+func newConnAttemptBackOff() backoff.BackOff {
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     connAttemptInitialInterval,
+		RandomizationFactor: 0.5,
+		Multiplier:          2,
+		MaxInterval:         connAttemptMaxInterval,
+		MaxElapsedTime:      0, // retry as long as the peer is known
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+	b.Reset()
+	return b
+}
+
+// register creates the schedule of a peer. A previous schedule of the same peer is replaced.
+func (s *connScheduler) register(peerKey string) *peerSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := &peerSchedule{
+		peerKey: peerKey,
+		backOff: newConnAttemptBackOff(),
+		wakeUp:  make(chan struct{}, 1),
+		removed: make(chan struct{}),
+	}
+	s.peers[peerKey] = ps
+	return ps
+}
+
+// unregister removes the schedule of a peer and frees its slot
+func (s *connScheduler) unregister(ps *peerSchedule) {
+	s.release(ps)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peers[ps.peerKey] == ps {
+		delete(s.peers, ps.peerKey)
+	}
+}
+
+// remove stops scheduling attempts to a peer, its pending wait returns right away
+func (s *connScheduler) remove(peerKey string) {
+	s.mu.Lock()
+	ps, ok := s.peers[peerKey]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	ps.removedOnce.Do(func() {
+		close(ps.removed)
+	})
+}
+
+// reset resets the backoff of a peer and starts its next attempt right away.
+// Used when the remote peer has shown it is online, e.g. by sending an offer.
+func (s *connScheduler) reset(peerKey string) {
+	s.mu.Lock()
+	ps, ok := s.peers[peerKey]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ps.mu.Lock()
+	ps.backOff.Reset()
+	ps.mu.Unlock()
+
+	select {
+	case ps.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// succeeded resets the backoff of a peer after a successful connection,
+// so that a reconnection after a disconnect is attempted quickly
+func (s *connScheduler) succeeded(ps *peerSchedule) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.backOff.Reset()
+}
+
+// wait blocks until the next attempt of the peer is due.
+// Returns false if ctx has been cancelled or the peer has been removed.
+func (s *connScheduler) wait(ctx context.Context, ps *peerSchedule) bool {
+	ps.mu.Lock()
+	delay := ps.backOff.NextBackOff()
+	ps.mu.Unlock()
+
+	if s.onNextAttempt != nil {
+		s.onNextAttempt(ps.peerKey, time.Now().Add(delay))
+	}
+
+	timer := time.NewTimer(delay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	case <-ps.removed:
+		timer.Stop()
+		return false
+	case <-ps.wakeUp:
+		timer.Stop()
+	case <-timer.C:
+	}
+	return true
+}
+
+// acquire blocks until a slot is free for the local gathering of the peer's attempt.
+// Returns false if ctx has been cancelled or the peer has been removed.
+func (s *connScheduler) acquire(ctx context.Context, ps *peerSchedule) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ps.removed:
+		return false
+	case s.slots <- struct{}{}:
+	}
+
+	ps.mu.Lock()
+	ps.hasSlot = true
+	ps.mu.Unlock()
+	return true
+}
+
+// release frees the slot taken by the peer, if any
+func (s *connScheduler) release(ps *peerSchedule) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if !ps.hasSlot {
+		return
+	}
+	ps.hasSlot = false
+	<-s.slots
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnScheduler_LimitsConcurrentAttempts(t *testing.T) {
+	s := newConnScheduler(2, nil)
+	ctx := context.Background()
+
+	var schedules []*peerSchedule
+	for _, key := range []string{"peer1", "peer2", "peer3"} {
+		ps := s.register(key)
+		s.reset(key)
+		schedules = append(schedules, ps)
+	}
+
+	// waiting for the attempt doesn't take a slot
+	for _, ps := range schedules {
+		require.True(t, s.wait(ctx, ps))
+	}
+	assert.Empty(t, s.slots)
+
+	require.True(t, s.acquire(ctx, schedules[0]))
+	require.True(t, s.acquire(ctx, schedules[1]))
+
+	started := make(chan struct{})
+	go func() {
+		if s.acquire(ctx, schedules[2]) {
+			close(started)
+		}
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("third attempt shouldn't start while two attempts are in progress")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.release(schedules[0])
+	// releasing twice must not free a slot of another peer
+	s.release(schedules[0])
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("third attempt should start once a slot has been released")
+	}
+	assert.Len(t, s.slots, 2)
+
+	// a removed peer doesn't wait for a slot anymore
+	s.remove("peer1")
+	assert.False(t, s.acquire(ctx, schedules[0]))
+}
+
+func TestConnScheduler_Backoff(t *testing.T) {
+	var mu sync.Mutex
+	var next []time.Duration
+	s := newConnScheduler(1, func(_ string, at time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		next = append(next, time.Until(at))
+	})
+	ps := s.register("peer1")
+
+	// consume a few backoff intervals as if the attempts have failed
+	for i := 0; i < 4; i++ {
+		ps.backOff.NextBackOff()
+	}
+	grown := ps.backOff.NextBackOff()
+	assert.Greater(t, grown, 4*connAttemptInitialInterval, "interval should grow after failed attempts")
+
+	// a remote offer resets the backoff and wakes the waiting peer up
+	done := make(chan bool)
+	go func() {
+		done <- s.wait(context.Background(), ps)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	s.reset("peer1")
+
+	select {
+	case ok := <-done:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("reset should wake up the peer")
+	}
+
+	mu.Lock()
+	require.Len(t, next, 1)
+	assert.Greater(t, next[0], 4*connAttemptInitialInterval, "next attempt should have been scheduled with the grown interval")
+	mu.Unlock()
+
+	// after the reset the intervals start from the beginning again
+	assert.LessOrEqual(t, ps.backOff.NextBackOff(), 2*connAttemptInitialInterval)
+}
+
+func TestConnScheduler_Remove(t *testing.T) {
+	s := newConnScheduler(1, nil)
+	ps := s.register("peer1")
+
+	done := make(chan bool)
+	go func() {
+		done <- s.wait(context.Background(), ps)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.remove("peer1")
+
+	select {
+	case ok := <-done:
+		assert.False(t, ok, "removed peer shouldn't get an attempt")
+	case <-time.After(time.Second):
+		t.Fatal("remove should interrupt the wait")
+	}
+
+	s.unregister(ps)
+	assert.Empty(t, s.peers)
+}
@@ -2,12 +2,16 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	mgmProto "github.com/netbirdio/netbird/management/proto"
 	"time"
 
+	"ztnav2client/internal/candidatefilter"
+	"ztnav2client/internal/ice"
+	"ztnav2client/internal/netproxy"
+	"ztnav2client/internal/signaling"
 	nbStatus "ztnav2client/status"
 
-	signal "github.com/netbirdio/netbird/signal/client"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cenkalti/backoff/v4"
@@ -40,6 +44,11 @@ func RunClient(ctx context.Context, config *Config, statusRecorder *nbStatus.Sta
 		return err
 	}
 
+	proxyDialer, err := newProxyDialer(config.ProxyURL)
+	if err != nil {
+		return err
+	}
+
 	operation := func() error {
 		// if context cancelled we not start new backoff cycle
 		select {
@@ -63,7 +72,7 @@ func RunClient(ctx context.Context, config *Config, statusRecorder *nbStatus.Sta
 		//statusRecorder.UpdateLocalPeerState(localPeerState)
 
 		// with the global Wiretrustee config in hand connect (just a connection, no stream yet) Signal
-		signalClient, err := connectToSignal(engineCtx, config.SignalService.Protocol, config.SignalService.Uri, myPrivateKey)
+		signalClient, err := newSignalClient(engineCtx, config, myPrivateKey, proxyDialer)
 		if err != nil {
 			log.Error(err)
 			return err
@@ -75,11 +84,12 @@ func RunClient(ctx context.Context, config *Config, statusRecorder *nbStatus.Sta
 			}
 		}()
 
-		engineConfig, err := createEngineConfig(myPrivateKey, config, config.PeerConfig)
+		engineConfig, err := createEngineConfig(myPrivateKey, config, &config.PeerConfig)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
+		engineConfig.ProxyDialer = proxyDialer
 
 		engine := NewEngine(engineCtx, cancel, signalClient, engineConfig, statusRecorder)
 
@@ -120,15 +130,46 @@ func RunClient(ctx context.Context, config *Config, statusRecorder *nbStatus.Sta
 }
 
 // createEngineConfig converts configuration received from Management Service to EngineConfig
-func createEngineConfig(key wgtypes.Key, config *Config, peerConfig mgmProto.PeerConfig) (*EngineConfig, error) {
+func createEngineConfig(key wgtypes.Key, config *Config, peerConfig *mgmProto.PeerConfig) (*EngineConfig, error) {
 
 	engineConf := &EngineConfig{
-		WgIfaceName:  config.WgIface,
-		WgAddr:       peerConfig.Address,
-		WgPrivateKey: key,
-		WgPort:       config.WgPort,
-		SSHKey:       []byte(config.SSHKey),
+		WgIfaceName:    config.WgIface,
+		WgAddr:         peerConfig.Address,
+		WgPrivateKey:   key,
+		WgPort:         config.WgPort,
+		SSHKey:         []byte(config.SSHKey),
+		LazyConnection: config.LazyConnection,
+		DisableICETCP:  config.DisableICETCP,
+		TCPMuxPort:     config.ICETCPPort,
+
+		DisablePortMapping:        config.DisablePortMapping,
+		MaxConcurrentConnAttempts: config.MaxConcurrentConnAttempts,
+	}
+
+	if config.LazyConnectionIdleTimeout != "" {
+		idleTimeout, err := time.ParseDuration(config.LazyConnectionIdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LazyConnectionIdleTimeout %q: %w", config.LazyConnectionIdleTimeout, err)
+		}
+		engineConf.LazyConnectionIdleTimeout = idleTimeout
+	}
+
+	candidateFilter, err := candidatefilter.New(candidatefilter.Rules{
+		AllowInterfaces: config.InterfaceAllowList,
+		DenyInterfaces:  config.InterfaceDenyList,
+		AllowIPs:        config.IPAllowList,
+		DenyIPs:         config.IPDenyList,
+	})
+	if err != nil {
+		return nil, err
 	}
+	engineConf.CandidateFilter = candidateFilter
+
+	mDNSMode, err := parseMulticastDNSMode(config.MulticastDNSMode)
+	if err != nil {
+		return nil, err
+	}
+	engineConf.MulticastDNSMode = mDNSMode
 
 	if config.PreSharedKey != "" {
 		preSharedKey, err := wgtypes.ParseKey(config.PreSharedKey)
@@ -141,20 +182,106 @@ func createEngineConfig(key wgtypes.Key, config *Config, peerConfig mgmProto.Pee
 	return engineConf, nil
 }
 
-// connectToSignal creates Signal Service client and established a connection
-func connectToSignal(ctx context.Context, sigProtocol string, sigUri string, ourPrivateKey wgtypes.Key) (*signal.GrpcClient, error) {
-	var sigTLSEnabled bool
-	if sigProtocol == "https" {
-		sigTLSEnabled = true
-	} else {
-		sigTLSEnabled = false
+// parseMulticastDNSMode converts the MulticastDNSMode of the Config
+func parseMulticastDNSMode(mode string) (ice.MulticastDNSMode, error) {
+	switch mode {
+	case "", "disabled":
+		return ice.MulticastDNSModeDisabled, nil
+	case "query":
+		return ice.MulticastDNSModeQueryOnly, nil
+	case "gather":
+		return ice.MulticastDNSModeQueryAndGather, nil
+	default:
+		return 0, fmt.Errorf("invalid MulticastDNSMode %q, expected disabled, query or gather", mode)
+	}
+}
+
+// newProxyDialer creates the dialer of the configured proxy or of the proxy of the environment, nil if there is none
+func newProxyDialer(proxyURL string) (netproxy.Dialer, error) {
+	dialer, err := netproxy.New(proxyURL)
+	if err != nil {
+		log.Errorf("invalid proxy configuration: %v", err)
+		return nil, err
+	}
+	switch {
+	case proxyURL != "":
+		log.Infof("connecting to the Signal Service and the TURN servers through the configured proxy")
+	case dialer != nil:
+		log.Infof("connecting to the Signal Service and the TURN servers through the proxy of the HTTPS_PROXY environment variable")
 	}
+	return dialer, nil
+}
 
-	signalClient, err := signal.NewClient(ctx, sigUri, ourPrivateKey, sigTLSEnabled)
+// connectToSignal creates Signal Service client of the configured transport and established a connection.
+// The Signal Service is reached through the proxyDialer if it isn't nil.
+func connectToSignal(ctx context.Context, signalService SignalService, ourPrivateKey wgtypes.Key, proxyDialer netproxy.Dialer) (signaling.Client, error) {
+	signalClient, err := signaling.NewClient(ctx, signalService.Transport, signalService.Protocol, signalService.Uri, ourPrivateKey, proxyDialer)
 	if err != nil {
-		log.Errorf("error while connecting to the Signal Exchange Service %s: %s", sigUri, err)
+		log.Errorf("error while connecting to the Signal Exchange Service %s: %s", signalService.Uri, err)
 		return nil, gstatus.Errorf(codes.FailedPrecondition, "failed connecting to Signal Service : %s", err)
 	}
 
 	return signalClient, nil
 }
+
+// newSignalClient connects to the Signal Service and, if enabled, joins the LAN signaling.
+// With LAN signaling the engine starts also when the Signal Service is unreachable,
+// the Signal Service is then connected in the background and added once reachable.
+func newSignalClient(ctx context.Context, config *Config, ourPrivateKey wgtypes.Key, proxyDialer netproxy.Dialer) (signaling.Client, error) {
+	var lanClient *signaling.LANClient
+	if config.LANSignaling {
+		lanClient = newLANClient(ctx, config.LANSignalingGroup, ourPrivateKey)
+	}
+
+	signalClient, err := connectToSignal(ctx, config.SignalService, ourPrivateKey, proxyDialer)
+	if lanClient == nil {
+		return signalClient, err
+	}
+	if err != nil {
+		log.Warnf("using LAN signaling only until the Signal Service is reachable")
+		parallelClient := signaling.NewParallelClient(lanClient)
+		go connectToSignalInBackground(ctx, parallelClient, config.SignalService, ourPrivateKey, proxyDialer)
+		return parallelClient, nil
+	}
+	log.Infof("using LAN signaling in parallel with the Signal Service")
+	return signaling.NewParallelClient(signalClient, lanClient), nil
+}
+
+// newLANClient joins the LAN signaling group.
+// LAN signaling is optional, returns nil if the group can't be joined.
+func newLANClient(ctx context.Context, group string, ourPrivateKey wgtypes.Key) *signaling.LANClient {
+	lanClient, err := signaling.NewLANClient(ctx, signaling.LANConfig{Group: group}, ourPrivateKey)
+	if err != nil {
+		log.Warnf("LAN signaling is disabled: %v", err)
+		return nil
+	}
+	return lanClient
+}
+
+// connectToSignalInBackground keeps connecting to the Signal Service until it succeeds or ctx is done,
+// the connected client is added to the parallelClient
+func connectToSignalInBackground(ctx context.Context, parallelClient *signaling.ParallelClient, signalService SignalService,
+	ourPrivateKey wgtypes.Key, proxyDialer netproxy.Dialer) {
+	backOff := backoff.WithContext(&backoff.ExponentialBackOff{
+		InitialInterval:     time.Second,
+		RandomizationFactor: 1,
+		Multiplier:          1.7,
+		MaxInterval:         30 * time.Second,
+		MaxElapsedTime:      0, // as long as the engine runs
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}, ctx)
+
+	err := backoff.Retry(func() error {
+		signalClient, err := connectToSignal(ctx, signalService, ourPrivateKey, proxyDialer)
+		if err != nil {
+			return err
+		}
+		log.Infof("connected to the Signal Service, using it in parallel with LAN signaling")
+		parallelClient.AddClient(signalClient)
+		return nil
+	}, backOff)
+	if err != nil {
+		log.Debugf("stopped connecting to the Signal Service: %v", err)
+	}
+}
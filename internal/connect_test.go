@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/netbirdio/netbird/signal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+
+	"ztnav2client/internal/signaling"
+)
+
+func TestNewSignalClient_LANWithoutSignalService(t *testing.T) {
+	// the Signal Service is down at the start
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	signalAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := &Config{
+		SignalService:     SignalService{Uri: signalAddr, Protocol: "http"},
+		LANSignaling:      true,
+		LANSignalingGroup: fmt.Sprintf("239.255.77.88:%d", 40000+rand.Intn(10000)),
+	}
+	lanClient, err := signaling.NewLANClient(ctx, signaling.LANConfig{Group: config.LANSignalingGroup}, key)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	require.NoError(t, lanClient.Close())
+
+	signalClient, err := newSignalClient(ctx, config, key, nil)
+	require.NoError(t, err, "the engine should start with LAN signaling only")
+	defer signalClient.Close() //nolint:errcheck
+	assert.True(t, signalClient.Ready())
+
+	received := make(chan *sProto.Message, 1)
+	go func() {
+		_ = signalClient.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+
+	// the Signal Service is up again and is used once connected in the background
+	listener, err = net.Listen("tcp", signalAddr)
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	sProto.RegisterSignalExchangeServer(grpcServer, server.NewServer())
+	go func() { _ = grpcServer.Serve(listener) }()
+	defer grpcServer.Stop()
+
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	remote, err := signaling.NewGrpcClient(ctx, signalAddr, remoteKey, false, nil)
+	require.NoError(t, err)
+	defer remote.Close() //nolint:errcheck
+	// the server only forwards the messages of the registered peers
+	go func() {
+		_ = remote.Receive(func(msg *sProto.Message) error { return nil })
+	}()
+
+	require.Eventually(t, func() bool {
+		err := remote.Send(&sProto.Message{
+			Key:       remoteKey.PublicKey().String(),
+			RemoteKey: key.PublicKey().String(),
+			Body:      &sProto.Body{Type: sProto.Body_OFFER, Payload: "ufrag:pwd"},
+		})
+		if err != nil {
+			return false
+		}
+		select {
+		case msg := <-received:
+			return msg.GetBody().GetPayload() == "ufrag:pwd"
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 15*time.Second, 100*time.Millisecond)
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -12,7 +13,13 @@ import (
 
 	nbdns "github.com/netbirdio/netbird/dns"
 	"github.com/netbirdio/netbird/route"
+	"ztnav2client/internal/candidatefilter"
+	"ztnav2client/internal/lazyconn"
+	"ztnav2client/internal/nat"
+	"ztnav2client/internal/netproxy"
+	"ztnav2client/internal/portmap"
 	"ztnav2client/internal/routemanager"
+	"ztnav2client/internal/signaling"
 	nbstatus "ztnav2client/status"
 
 	"github.com/netbirdio/netbird/iface"
@@ -37,8 +44,23 @@ const (
 	PeerConnectionTimeoutMin = 30000 // ms
 )
 
+const (
+	// udpMuxListenAttempts is how many random ports are tried to listen on all the local addresses
+	udpMuxListenAttempts = 3
+	// udpMuxAddressCheckInterval is how often the UDPMux checks for new and gone local addresses
+	udpMuxAddressCheckInterval = 10 * time.Second
+	// tcpMuxReadBufferSize is the number of packets buffered per ICE-TCP connection
+	tcpMuxReadBufferSize = 32
+	// tcpMuxWriteBufferSize limits the pending writes of an ICE-TCP connection, further packets are dropped
+	tcpMuxWriteBufferSize = 4 * 1024 * 1024
+)
+
 var ErrResetConnection = fmt.Errorf("reset connection")
 
+// errMulticastDNSWithNATExternalIPs is returned by Start when the host candidates would have to be both
+// hidden behind an mDNS name and replaced by the NAT external IPs
+var errMulticastDNSWithNATExternalIPs = errors.New("the mDNS gather mode can't be used with NAT external IPs")
+
 // EngineConfig is a config for the Engine
 type EngineConfig struct {
 	WgPort      int
@@ -54,6 +76,9 @@ type EngineConfig struct {
 	IFaceBlackList       []string
 	DisableIPv6Discovery bool
 
+	// CandidateFilter holds the interface and IP rules of the candidate gathering, all are allowed if nil
+	CandidateFilter *candidatefilter.Filter
+
 	PreSharedKey *wgtypes.Key
 
 	// UDPMuxPort default value 0 - the system will pick an available port
@@ -62,16 +87,46 @@ type EngineConfig struct {
 	// UDPMuxSrflxPort default value 0 - the system will pick an available port
 	UDPMuxSrflxPort int
 
+	// TCPMuxPort is the port of the ICE-TCP listener, default value 0 - the system will pick an available port
+	TCPMuxPort int
+
+	// DisableICETCP disables the passive ICE-TCP host candidates and the TCP listener
+	DisableICETCP bool
+
+	// DisablePortMapping disables requesting a mapping of the UDPMux port from the gateway (UPnP-IGD, NAT-PMP, PCP).
+	// The mapped address is otherwise advertised as a server reflexive candidate.
+	DisablePortMapping bool
+
 	// SSHKey is a private SSH key in a PEM format
 	SSHKey []byte
 
 	NATExternalIPs []string
+
+	// MulticastDNSMode is the use of mDNS for the host candidates, ice.MulticastDNSModeDisabled if 0.
+	// Gathering .local candidates keeps the local IPs out of the signaling but can't be combined with NATExternalIPs,
+	// which replace the host candidates by the external IPs.
+	MulticastDNSMode ice.MulticastDNSMode
+
+	// LazyConnection defers connecting to a peer until there is outbound traffic to it or it sends us an offer.
+	// Until then the peer is installed in WireGuard with a local placeholder endpoint.
+	LazyConnection bool
+
+	// LazyConnectionIdleTimeout is the period without traffic after which a lazy connection is closed again.
+	// 0 means that activated connections are kept open.
+	LazyConnectionIdleTimeout time.Duration
+
+	// MaxConcurrentConnAttempts limits the number of peer connection attempts gathering their local candidates
+	// (host interfaces, STUN bindings, TURN allocations) at the same time. 0 means defaultMaxConcurrentConnAttempts
+	MaxConcurrentConnAttempts int
+
+	// ProxyDialer reaches the TURN servers over TCP/TLS through a proxy, the servers are dialed directly if nil
+	ProxyDialer netproxy.Dialer
 }
 
 // Engine is a mechanism responsible for reacting on Signal and Management stream events and managing connections to the remote peers.
 type Engine struct {
-	// signal is a Signal Service client
-	signal signal.Client
+	// signal is a client of the signaling transport
+	signal signaling.Client
 	// mgmClient is a Management Service client
 	mgmClient mgm.Client
 	// peerConns is a map that holds all the peers that are known to this peer
@@ -96,6 +151,21 @@ type Engine struct {
 	udpMuxSrflx     ice.UniversalUDPMux
 	udpMuxConn      *net.UDPConn
 	udpMuxConnSrflx *net.UDPConn
+	// udpMuxPort is the port of the UDPMux, udpMuxConn is only set if it falls back to a wildcard socket
+	udpMuxPort int
+	// portMapper keeps a mapping of the UDPMux port on the gateway, nil if port mapping is disabled
+	portMapper *portmap.Mapper
+	// portMapping is the external address of the mapping reported by the portMapper, nil if there is none
+	portMappingMu *sync.Mutex
+	portMapping   *net.UDPAddr
+	// tcpMux accepts the ICE-TCP connections of the remote peers, nil if ICE-TCP is disabled
+	tcpMux *ice.TCPMuxDefault
+	// turnPool shares the TURN allocations of the peer connections, one per TURN server
+	turnPool *ice.TURNPool
+	// serverProber ranks the STUN and TURN servers by their health for the connection attempts
+	serverProber *serverProber
+	// natDetector discovers the NAT behaviour whenever the STUN servers change and periodically
+	natDetector *natDetector
 
 	// networkSerial is the latest CurrentSerial (state ID) of the network sent by the Management service
 	networkSerial uint64
@@ -103,6 +173,17 @@ type Engine struct {
 	statusRecorder *nbstatus.Status
 
 	routeManager routemanager.Manager
+
+	// lazyConnMgr manages peers waiting for traffic when EngineConfig.LazyConnection is enabled, nil otherwise
+	lazyConnMgr *lazyconn.Manager
+
+	// connScheduler decides when connection attempts to peers are started
+	connScheduler *connScheduler
+
+	// signalCounter numbers the signaling messages sent to the remote peers
+	signalCounter *signaling.MessageCounter
+	// replayWindows holds the counters of the signaling messages recently received from each remote peer
+	replayWindows map[string]*signaling.ReplayWindow
 }
 
 // Peer is an instance of the Connection Peer
@@ -114,10 +195,10 @@ type Peer struct {
 // NewEngine creates a new Connection Engine
 func NewEngine(
 	ctx context.Context, cancel context.CancelFunc,
-	signalClient signal.Client,
+	signalClient signaling.Client,
 	config *EngineConfig, statusRecorder *nbstatus.Status,
 ) *Engine {
-	return &Engine{
+	e := &Engine{
 		ctx:            ctx,
 		cancel:         cancel,
 		signal:         signalClient,
@@ -128,7 +209,14 @@ func NewEngine(
 		TURNs:          []*ice.URL{},
 		networkSerial:  0,
 		statusRecorder: statusRecorder,
-	}
+		signalCounter:  signaling.NewMessageCounter(),
+		replayWindows:  make(map[string]*signaling.ReplayWindow),
+		portMappingMu:  &sync.Mutex{},
+	}
+	e.connScheduler = newConnScheduler(config.MaxConcurrentConnAttempts, e.onNextConnAttempt)
+	e.serverProber = newServerProber(newServerProbe(config.ProxyDialer), statusRecorder.UpdateRelayStates)
+	e.natDetector = newNATDetector(e.onNATDetected)
+	return e
 }
 
 func (e *Engine) Stop() error {
@@ -140,10 +228,6 @@ func (e *Engine) Stop() error {
 		return err
 	}
 
-	// very ugly but we want to remove peers from the WireGuard interface first before removing interface.
-	// Removing peers happens in the conn.CLose() asynchronously
-	time.Sleep(500 * time.Millisecond)
-
 	log.Debugf("removing Netbird interface %s", e.config.WgIfaceName)
 	if e.wgInterface.Interface != nil {
 		err = e.wgInterface.Close()
@@ -153,6 +237,18 @@ func (e *Engine) Stop() error {
 		}
 	}
 
+	if e.turnPool != nil {
+		if err := e.turnPool.Close(); err != nil {
+			log.Debugf("close turn pool: %v", err)
+		}
+	}
+
+	if e.portMapper != nil {
+		if err := e.portMapper.Close(); err != nil {
+			log.Debugf("close port mapper: %v", err)
+		}
+	}
+
 	if e.udpMux != nil {
 		if err := e.udpMux.Close(); err != nil {
 			log.Debugf("close udp mux: %v", err)
@@ -177,10 +273,20 @@ func (e *Engine) Stop() error {
 		}
 	}
 
+	if e.tcpMux != nil {
+		if err := e.tcpMux.Close(); err != nil {
+			log.Debugf("close tcp mux: %v", err)
+		}
+	}
+
 	if e.routeManager != nil {
 		e.routeManager.Stop()
 	}
 
+	if e.lazyConnMgr != nil {
+		e.lazyConnMgr.Close()
+	}
+
 	log.Infof("stopped Netbird Engine")
 
 	return nil
@@ -198,6 +304,10 @@ func (e *Engine) Start() error {
 	myPrivateKey := e.config.WgPrivateKey
 	var err error
 
+	if e.config.MulticastDNSMode == ice.MulticastDNSModeQueryAndGather && len(e.config.NATExternalIPs) > 0 {
+		return errMulticastDNSWithNATExternalIPs
+	}
+
 	e.wgInterface, err = iface.NewWGIFace(wgIfaceName, wgAddr, 1420)
 	if err != nil {
 		log.Errorf("failed creating wireguard interface instance %s: [%s]", wgIfaceName, err.Error())
@@ -209,7 +319,7 @@ func (e *Engine) Start() error {
 		networkName = "udp4"
 	}
 
-	e.udpMuxConn, err = net.ListenUDP(networkName, &net.UDPAddr{Port: e.config.UDPMuxPort})
+	e.udpMux, err = e.newUDPMux(networkName)
 	if err != nil {
 		log.Errorf("failed listening on UDP port %d: [%s]", e.config.UDPMuxPort, err.Error())
 		return err
@@ -221,8 +331,18 @@ func (e *Engine) Start() error {
 		return err
 	}
 
-	e.udpMux = ice.NewUDPMuxDefault(ice.UDPMuxParams{UDPConn: e.udpMuxConn})
 	e.udpMuxSrflx = ice.NewUniversalUDPMuxDefault(ice.UniversalUDPMuxParams{UDPConn: e.udpMuxConnSrflx})
+	e.turnPool = ice.NewTURNPool(ice.TURNPoolParams{})
+
+	if !e.config.DisablePortMapping {
+		// the peers learn the mapped address with their next connection attempt
+		e.portMapper = portmap.NewMapper(portmap.Config{}, e.onPortMappingChange)
+		e.portMapper.Start(e.udpMuxPort)
+	}
+
+	if !e.config.DisableICETCP {
+		e.tcpMux = e.newTCPMux()
+	}
 
 	err = e.wgInterface.Create()
 	if err != nil {
@@ -238,8 +358,19 @@ func (e *Engine) Start() error {
 
 	e.routeManager = routemanager.NewManager(e.ctx, e.config.WgPrivateKey.PublicKey().String(), e.wgInterface, e.statusRecorder)
 
+	if e.config.LazyConnection {
+		e.lazyConnMgr = lazyconn.NewManager(e.ctx, e.wgInterface, e.config.LazyConnectionIdleTimeout, e.onLazyPeerActivity, e.onLazyPeerIdle)
+		e.lazyConnMgr.Start()
+	}
+
 	e.receiveSignalEvents()
 
+	go e.serverProber.run(e.ctx)
+	go e.natDetector.run(e.ctx)
+	if udpMux, ok := e.udpMux.(*ice.MultiUDPMuxDefault); ok {
+		go e.watchUDPMuxAddresses(udpMux)
+	}
+
 	return nil
 }
 
@@ -327,6 +458,13 @@ func (e *Engine) removePeer(peerKey string) error {
 		}
 	}()
 
+	e.connScheduler.remove(peerKey)
+	delete(e.replayWindows, peerKey)
+
+	if e.lazyConnMgr != nil {
+		e.lazyConnMgr.RemovePeer(peerKey)
+	}
+
 	conn, exists := e.peerConns[peerKey]
 	if exists {
 		delete(e.peerConns, peerKey)
@@ -379,14 +517,44 @@ func (e *Engine) GetConnectedPeers() []string {
 	return peers
 }
 
-func signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtypes.Key, s signal.Client) error {
+func signalEndOfCandidates(session signaling.Session, myKey wgtypes.Key, remoteKey wgtypes.Key, s signaling.Client) error {
+	payload, err := signaling.SealEndOfCandidates(session, remoteKey, myKey)
+	if err != nil {
+		return err
+	}
 
-	err := s.Send(&sProto.Message{
+	err = s.Send(&sProto.Message{
 		Key:       myKey.PublicKey().String(),
 		RemoteKey: remoteKey.String(),
 		Body: &sProto.Body{
 			Type:    sProto.Body_CANDIDATE,
-			Payload: candidate.Marshal(),
+			Payload: payload,
+		},
+	})
+
+	log.Debugf("Sent Signal End Of Candidates, myKey=%v, remoteKey=%v", myKey.PublicKey().String(), remoteKey.String())
+
+	return err
+}
+
+// signalCandidate signals a local candidate to the remote peer, sealed if the remote peer seals its candidates too
+func signalCandidate(candidate ice.Candidate, session signaling.Session, seal bool, myKey wgtypes.Key, remoteKey wgtypes.Key, s signaling.Client) error {
+	var err error
+	payload := candidate.Marshal()
+	if seal {
+		// the candidate reveals our addresses, only the remote peer should be able to read it
+		payload, err = signaling.SealCandidate(payload, session, remoteKey, myKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.Send(&sProto.Message{
+		Key:       myKey.PublicKey().String(),
+		RemoteKey: remoteKey.String(),
+		Body: &sProto.Body{
+			Type:    sProto.Body_CANDIDATE,
+			Payload: payload,
 		},
 	})
 
@@ -400,7 +568,7 @@ func signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtyp
 }
 
 // SignalOfferAnswer signals either an offer or an answer to remote peer
-func SignalOfferAnswer(offerAnswer peer.OfferAnswer, myKey wgtypes.Key, remoteKey wgtypes.Key, s signal.Client, isAnswer bool) error {
+func SignalOfferAnswer(offerAnswer peer.OfferAnswer, myKey wgtypes.Key, remoteKey wgtypes.Key, s signaling.Client, isAnswer bool) error {
 	var t sProto.Body_Type
 	if isAnswer {
 		t = sProto.Body_ANSWER
@@ -412,6 +580,17 @@ func SignalOfferAnswer(offerAnswer peer.OfferAnswer, myKey wgtypes.Key, remoteKe
 		UFrag: offerAnswer.IceCredentials.UFrag,
 		Pwd:   offerAnswer.IceCredentials.Pwd,
 	}, t)
+	if err == nil {
+		signaling.SetSession(msg.Body, signaling.Session{
+			ID:      offerAnswer.SessionID,
+			ReplyTo: offerAnswer.ReplyToSessionID,
+			Counter: offerAnswer.Counter,
+		})
+		signaling.SetCapabilities(msg.Body, offerAnswer.Capabilities.Strings())
+		if offerAnswer.Version != "" {
+			msg.Body.NetBirdVersion = offerAnswer.Version
+		}
+	}
 
 	log.Debugf("Sending Signal Offer %v , myKey=%v, remoteKey=%v, signal=%v, t=%v, msg=%v", offerAnswer, myKey, remoteKey, s, t, msg)
 
@@ -496,12 +675,18 @@ func (e *Engine) updateConfig(conf *mgmProto.PeerConfig) error {
 		log.Infof("updated peer address from %s to %s", oldAddr, conf.Address)
 	}
 
-	e.statusRecorder.UpdateLocalPeerState(nbstatus.LocalPeerState{
+	localPeerState := nbstatus.LocalPeerState{
 		IP:              e.config.WgAddr,
 		PubKey:          e.config.WgPrivateKey.PublicKey().String(),
 		KernelInterface: iface.WireguardModuleIsLoaded(),
 		FQDN:            conf.GetFqdn(),
-	})
+	}
+	if natResult, ok := e.natDetector.getResult(); ok {
+		localPeerState.NATMapping = natResult.Mapping.String()
+		localPeerState.NATFiltering = natResult.Filtering.String()
+		localPeerState.PublicAddress = natResult.PublicAddr.String()
+	}
+	e.statusRecorder.UpdateLocalPeerState(localPeerState)
 
 	return nil
 }
@@ -520,6 +705,8 @@ func (e *Engine) updateSTUNs(stuns []*mgmProto.HostConfig) error {
 		newSTUNs = append(newSTUNs, url)
 	}
 	e.STUNs = newSTUNs
+	e.serverProber.setServers(append(append([]*ice.URL(nil), e.STUNs...), e.TURNs...))
+	e.natDetector.setServers(e.STUNs)
 
 	return nil
 }
@@ -540,6 +727,7 @@ func (e *Engine) updateTURNs(turns []*mgmProto.ProtectedHostConfig) error {
 		newTURNs = append(newTURNs, url)
 	}
 	e.TURNs = newTURNs
+	e.serverProber.setServers(append(append([]*ice.URL(nil), e.STUNs...), e.TURNs...))
 
 	return nil
 }
@@ -693,7 +881,14 @@ func (e *Engine) addNewPeer(peerConfig *mgmProto.RemotePeerConfig) error {
 			log.Warnf("error adding peer %s to status recorder, got error: %v", peerKey, err)
 		}
 
-		go e.connWorker(conn, peerKey)
+		if e.lazyConnMgr != nil {
+			err = e.lazyConnMgr.AddPeer(lazyPeerConfig(conn))
+			if err != nil {
+				return err
+			}
+		} else {
+			go e.connWorker(conn, peerKey)
+		}
 	}
 	err := e.statusRecorder.UpdatePeerFQDN(peerKey, peerConfig.Fqdn)
 	if err != nil {
@@ -702,13 +897,68 @@ func (e *Engine) addNewPeer(peerConfig *mgmProto.RemotePeerConfig) error {
 	return nil
 }
 
+// onPortMappingChange keeps the external address of the port mapping for the next connection attempts
+func (e *Engine) onPortMappingChange(external *net.UDPAddr) {
+	e.portMappingMu.Lock()
+	defer e.portMappingMu.Unlock()
+	e.portMapping = external
+}
+
+// udpMuxMappedAddrs returns the external addresses the gateway forwards to the UDPMux port
+func (e *Engine) udpMuxMappedAddrs() []*net.UDPAddr {
+	e.portMappingMu.Lock()
+	defer e.portMappingMu.Unlock()
+	if e.portMapping == nil {
+		return nil
+	}
+	return []*net.UDPAddr{e.portMapping}
+}
+
+// onNATDetected publishes the discovered NAT behaviour
+func (e *Engine) onNATDetected(result nat.Result) {
+	log.Infof("discovered the NAT behaviour: %s", result)
+	if e.natDetector.symmetric() {
+		log.Infof("behind a symmetric NAT, server reflexive candidates won't be gathered")
+	}
+	e.statusRecorder.UpdateLocalPeerNAT(result.Mapping.String(), result.Filtering.String(), result.PublicAddr.String())
+}
+
+// stunTurnServers returns the STUN and TURN servers for a connection attempt, ranked by their health.
+// The STUN servers are left out behind a symmetric NAT as the remote peers can't reach the server reflexive candidates.
+func (e *Engine) stunTurnServers() []*ice.URL {
+	var stunTurn []*ice.URL
+	if !e.natDetector.symmetric() {
+		stunTurn = append(stunTurn, e.serverProber.rank(e.STUNs)...)
+	}
+	stunTurn = append(stunTurn, e.serverProber.rank(e.TURNs)...)
+	return stunTurn
+}
+
+// connWorker keeps connecting to the remote peer until the peer is removed or its connection is closed.
+// The timing of the attempts is decided by the connScheduler.
 func (e *Engine) connWorker(conn *peer.Conn, peerKey string) {
-	for {
+	schedule := e.connScheduler.register(peerKey)
+	defer e.connScheduler.unregister(schedule)
 
-		// randomize starting time a bit
-		min := 500
-		max := 2000
-		time.Sleep(time.Duration(rand.Intn(max-min)+min) * time.Millisecond)
+	conn.SetOnGatheringStart(func(ctx context.Context) error {
+		if !e.connScheduler.acquire(ctx, schedule) {
+			return fmt.Errorf("no connection attempt slot for peer %s: %w", peerKey, context.Canceled)
+		}
+		return nil
+	})
+	// the local candidates are gathered, let other peers use the slot while the checks are running
+	conn.SetOnGatheringDone(func() {
+		e.connScheduler.release(schedule)
+	})
+	conn.SetOnConnected(func() {
+		e.connScheduler.release(schedule)
+		e.connScheduler.succeeded(schedule)
+	})
+
+	for {
+		if !e.connScheduler.wait(e.ctx, schedule) {
+			return
+		}
 
 		// if peer has been removed -> give up
 		if !e.peerExists(peerKey) {
@@ -721,19 +971,16 @@ func (e *Engine) connWorker(conn *peer.Conn, peerKey string) {
 			continue
 		}
 
-		// we might have received new STUN and TURN servers meanwhile, so update them
-		e.syncMsgMux.Lock()
-		conf := conn.GetConf()
-		conf.StunTurn = append(e.STUNs, e.TURNs...)
-		conn.UpdateConf(conf)
-		e.syncMsgMux.Unlock()
+		e.updateConnAttempt(conn)
 
 		err := conn.Open()
+		e.connScheduler.release(schedule)
 		if err != nil {
 			log.Debugf("connection to peer %s failed: %v", peerKey, err)
 			switch err.(type) {
 			case *peer.ConnectionClosedError:
 				// conn has been forced to close, so we exit the loop
+				e.rearmLazyPeer(conn, peerKey)
 				return
 			default:
 			}
@@ -741,6 +988,90 @@ func (e *Engine) connWorker(conn *peer.Conn, peerKey string) {
 	}
 }
 
+// updateConnAttempt updates the config of the next connection attempt with the STUN and TURN servers,
+// we might have received new ones meanwhile or their health has changed, and with the port mapping
+// that may have appeared or changed since the connection has been created
+func (e *Engine) updateConnAttempt(conn *peer.Conn) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+	conn.UpdateStunTurn(e.stunTurnServers())
+	conn.UpdateUDPMuxMappedAddrs(e.udpMuxMappedAddrs())
+}
+
+// onNextConnAttempt publishes the time of the next connection attempt to a peer
+func (e *Engine) onNextConnAttempt(peerKey string, at time.Time) {
+	err := e.statusRecorder.UpdatePeerNextAttempt(peerKey, at)
+	if err != nil {
+		log.Debugf("error updating peer's %s next connection attempt in the status recorder: %v", peerKey, err)
+	}
+}
+
+// onLazyPeerActivity starts connecting to a lazy peer once outbound traffic to it has been detected
+func (e *Engine) onLazyPeerActivity(peerKey string) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	conn, ok := e.peerConns[peerKey]
+	if !ok {
+		return
+	}
+	log.Infof("activating lazy connection to peer %s", peerKey)
+	go e.connWorker(conn, peerKey)
+}
+
+// onLazyPeerIdle closes the connection to an idle lazy peer.
+// The peer is installed back in lazy mode by the connWorker once the connection has been cleaned up.
+func (e *Engine) onLazyPeerIdle(peerKey string) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	conn, ok := e.peerConns[peerKey]
+	if !ok {
+		return
+	}
+	err := conn.Close()
+	if err != nil {
+		log.Debugf("failed closing idle connection to peer %s: %v", peerKey, err)
+	}
+}
+
+// rearmLazyPeer puts a closed but still known peer back into lazy mode.
+// A closed Conn can't be opened again, so the peer gets a new one for its next activation.
+func (e *Engine) rearmLazyPeer(conn *peer.Conn, peerKey string) {
+	if e.lazyConnMgr == nil {
+		return
+	}
+
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	// the peer has been removed or replaced in the meantime
+	if e.peerConns[peerKey] != conn {
+		return
+	}
+
+	newConn, err := e.createPeerConn(peerKey, conn.GetConf().ProxyConfig.AllowedIps)
+	if err != nil {
+		log.Errorf("failed creating a new connection to lazy peer %s: %v", peerKey, err)
+		return
+	}
+	e.peerConns[peerKey] = newConn
+
+	err = e.lazyConnMgr.AddPeer(lazyPeerConfig(newConn))
+	if err != nil {
+		log.Errorf("failed switching peer %s back to lazy mode: %v", peerKey, err)
+	}
+}
+
+func lazyPeerConfig(conn *peer.Conn) lazyconn.PeerConfig {
+	conf := conn.GetConf()
+	return lazyconn.PeerConfig{
+		PublicKey:    conf.Key,
+		AllowedIPs:   conf.ProxyConfig.AllowedIps,
+		PreSharedKey: conf.ProxyConfig.PreSharedKey,
+	}
+}
+
 func (e Engine) peerExists(peerKey string) bool {
 	e.syncMsgMux.Lock()
 	defer e.syncMsgMux.Unlock()
@@ -750,9 +1081,7 @@ func (e Engine) peerExists(peerKey string) bool {
 
 func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, error) {
 	log.Debugf("creating peer connection %s", pubKey)
-	var stunTurn []*ice.URL
-	stunTurn = append(stunTurn, e.STUNs...)
-	stunTurn = append(stunTurn, e.TURNs...)
+	stunTurn := e.stunTurnServers()
 
 	proxyConfig := proxy.Config{
 		RemoteKey:    pubKey,
@@ -769,14 +1098,22 @@ func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, er
 		LocalKey:             e.config.WgPrivateKey.PublicKey().String(),
 		StunTurn:             stunTurn,
 		InterfaceBlackList:   e.config.IFaceBlackList,
+		CandidateFilter:      e.config.CandidateFilter,
 		DisableIPv6Discovery: e.config.DisableIPv6Discovery,
 		Timeout:              timeout,
 		UDPMux:               e.udpMux,
 		UDPMuxSrflx:          e.udpMuxSrflx,
+		TURNPool:             e.turnPool,
+		ProxyDialer:          e.config.ProxyDialer,
 		ProxyConfig:          proxyConfig,
 		LocalWgPort:          e.config.WgPort,
 		NATExternalIPs:       e.parseNATExternalIPMappings(),
+		MulticastDNSMode:     e.config.MulticastDNSMode,
+	}
+	if e.tcpMux != nil {
+		config.TCPMux = e.tcpMux
 	}
+	config.UDPMuxMappedAddrs = e.udpMuxMappedAddrs()
 
 	peerConn, err := peer.NewConn(config, e.statusRecorder)
 	if err != nil {
@@ -789,18 +1126,27 @@ func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, er
 	}
 
 	signalOffer := func(offerAnswer peer.OfferAnswer) error {
+		offerAnswer.Counter = e.signalCounter.Next()
 		return SignalOfferAnswer(offerAnswer, e.config.WgPrivateKey, wgPubKey, e.signal, false)
 	}
 
-	signalCandidate := func(candidate ice.Candidate) error {
-		return signalCandidate(candidate, e.config.WgPrivateKey, wgPubKey, e.signal)
+	signalCandidate := func(candidate ice.Candidate, sessionID uint64) error {
+		session := signaling.Session{ID: sessionID, Counter: e.signalCounter.Next()}
+		return signalCandidate(candidate, session, peerConn.SealedCandidates(), e.config.WgPrivateKey, wgPubKey, e.signal)
+	}
+
+	signalEndOfCandidates := func(sessionID uint64) error {
+		session := signaling.Session{ID: sessionID, Counter: e.signalCounter.Next()}
+		return signalEndOfCandidates(session, e.config.WgPrivateKey, wgPubKey, e.signal)
 	}
 
 	signalAnswer := func(offerAnswer peer.OfferAnswer) error {
+		offerAnswer.Counter = e.signalCounter.Next()
 		return SignalOfferAnswer(offerAnswer, e.config.WgPrivateKey, wgPubKey, e.signal, true)
 	}
 
 	peerConn.SetSignalCandidate(signalCandidate)
+	peerConn.SetSignalEndOfCandidates(signalEndOfCandidates)
 	peerConn.SetSignalOffer(signalOffer)
 	peerConn.SetSignalAnswer(signalAnswer)
 
@@ -820,40 +1166,34 @@ func (e *Engine) receiveSignalEvents() {
 				return fmt.Errorf("wrongly addressed message %s", msg.Key)
 			}
 
+			switch msg.GetBody().Type {
+			case sProto.Body_OFFER, sProto.Body_ANSWER:
+				if !e.acceptSignalCounter(msg.Key, signaling.GetSession(msg.GetBody())) {
+					return fmt.Errorf("replayed or stale message of peer %s", msg.Key)
+				}
+			}
+
 			switch msg.GetBody().Type {
 			case sProto.Body_OFFER:
+				if e.lazyConnMgr != nil && e.lazyConnMgr.ActivatePeer(msg.Key) {
+					log.Infof("activating lazy connection to peer %s on remote offer", msg.Key)
+					go e.connWorker(conn, msg.Key)
+				}
+				// the remote peer is online, don't make it wait for our backoff
+				e.connScheduler.reset(msg.Key)
 				remoteCred, err := signal.UnMarshalCredential(msg)
 				if err != nil {
 					return err
 				}
-				conn.OnRemoteOffer(peer.OfferAnswer{
-					IceCredentials: peer.IceCredentials{
-						UFrag: remoteCred.UFrag,
-						Pwd:   remoteCred.Pwd,
-					},
-					WgListenPort: int(msg.GetBody().GetWgListenPort()),
-					Version:      msg.GetBody().GetNetBirdVersion(),
-				})
+				conn.OnRemoteOffer(toOfferAnswer(msg, remoteCred))
 			case sProto.Body_ANSWER:
 				remoteCred, err := signal.UnMarshalCredential(msg)
 				if err != nil {
 					return err
 				}
-				conn.OnRemoteAnswer(peer.OfferAnswer{
-					IceCredentials: peer.IceCredentials{
-						UFrag: remoteCred.UFrag,
-						Pwd:   remoteCred.Pwd,
-					},
-					WgListenPort: int(msg.GetBody().GetWgListenPort()),
-					Version:      msg.GetBody().GetNetBirdVersion(),
-				})
+				conn.OnRemoteAnswer(toOfferAnswer(msg, remoteCred))
 			case sProto.Body_CANDIDATE:
-				candidate, err := ice.UnmarshalCandidate(msg.GetBody().Payload)
-				if err != nil {
-					log.Errorf("failed on parsing remote candidate %s -> %s", candidate, err)
-					return err
-				}
-				conn.OnRemoteCandidate(candidate)
+				return e.handleRemoteCandidate(conn, msg)
 			}
 
 			return nil
@@ -870,6 +1210,154 @@ func (e *Engine) receiveSignalEvents() {
 	e.signal.WaitStreamConnected()
 }
 
+// handleRemoteCandidate opens a candidate or an end-of-candidates received from a remote peer and passes it to the connection.
+// The candidates must be sealed if the remote peer has negotiated the sealing, see peer.CapabilitySealedCandidates.
+// Note: the caller should hold syncMsgMux.
+func (e *Engine) handleRemoteCandidate(conn *peer.Conn, msg *sProto.Message) error {
+	remoteKey, err := wgtypes.ParseKey(msg.Key)
+	if err != nil {
+		return err
+	}
+	payload, session, err := signaling.OpenCandidate(msg.GetBody().Payload, remoteKey, e.config.WgPrivateKey, conn.SealedCandidates())
+	if err != nil {
+		log.Warnf("rejected remote candidate: %v", err)
+		return err
+	}
+	if !e.acceptSignalCounter(msg.Key, session) {
+		return fmt.Errorf("replayed or stale candidate of peer %s", msg.Key)
+	}
+	if signaling.IsEndOfCandidates(payload) {
+		conn.OnRemoteEndOfCandidates(session.ID)
+		return nil
+	}
+	candidate, err := ice.UnmarshalCandidate(payload)
+	if err != nil {
+		log.Errorf("failed on parsing remote candidate %s -> %s", candidate, err)
+		return err
+	}
+	conn.OnRemoteCandidate(candidate, session.ID, signaling.IsSealedCandidate(msg.GetBody().Payload))
+	return nil
+}
+
+// acceptSignalCounter checks the session and the counter of a message received from a remote peer against the replay
+// window of the peer.
+// Note: the caller should hold syncMsgMux.
+func (e *Engine) acceptSignalCounter(peerKey string, session signaling.Session) bool {
+	window, ok := e.replayWindows[peerKey]
+	if !ok {
+		window = &signaling.ReplayWindow{}
+		e.replayWindows[peerKey] = window
+	}
+	return window.Accept(session.ID, session.Counter)
+}
+
+// toOfferAnswer converts a received offer or answer message
+func toOfferAnswer(msg *sProto.Message, remoteCred *signal.Credential) peer.OfferAnswer {
+	session := signaling.GetSession(msg.GetBody())
+	return peer.OfferAnswer{
+		IceCredentials: peer.IceCredentials{
+			UFrag: remoteCred.UFrag,
+			Pwd:   remoteCred.Pwd,
+		},
+		WgListenPort:     int(msg.GetBody().GetWgListenPort()),
+		Version:          msg.GetBody().GetNetBirdVersion(),
+		Capabilities:     peer.ParseCapabilities(signaling.GetCapabilities(msg.GetBody())),
+		SessionID:        session.ID,
+		ReplyToSessionID: session.ReplyTo,
+		Counter:          session.Counter,
+	}
+}
+
+// newUDPMux listens on the UDPMux port of every allowed local address, so that the packets of a host candidate
+// are sent from its address. The source address of a wildcard socket would be picked by the routing table,
+// which is wrong on multi-homed hosts, so the wildcard socket is only the fallback if there is no local address.
+// The local addresses of the returned MultiUDPMuxDefault are kept up to date by watchUDPMuxAddresses.
+func (e *Engine) newUDPMux(networkName string) (ice.UDPMux, error) {
+	opts := []ice.UDPMuxFromPortOption{
+		ice.UDPMuxFromPortWithInterfaceFilter(peer.InterfaceFilter(e.config.IFaceBlackList, e.config.CandidateFilter)),
+	}
+	if e.config.CandidateFilter != nil {
+		opts = append(opts, ice.UDPMuxFromPortWithIPFilter(e.config.CandidateFilter.IPAllowed))
+	}
+	if e.config.DisableIPv6Discovery {
+		opts = append(opts, ice.UDPMuxFromPortWithNetworks(ice.NetworkTypeUDP4))
+	}
+
+	var udpMux *ice.MultiUDPMuxDefault
+	var err error
+	for attempt := 0; attempt < udpMuxListenAttempts; attempt++ {
+		udpMux, err = ice.NewMultiUDPMuxFromPort(e.config.UDPMuxPort, opts...)
+		// the random port picked on the first address may be taken on another one
+		if err == nil || e.config.UDPMuxPort != 0 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := udpMux.GetListenAddresses()
+	if len(addrs) == 0 {
+		_ = udpMux.Close()
+		log.Warnf("no local address to listen on for the host candidates, listening on all the addresses")
+		e.udpMuxConn, err = net.ListenUDP(networkName, &net.UDPAddr{Port: e.config.UDPMuxPort})
+		if err != nil {
+			return nil, err
+		}
+		e.udpMuxPort = e.udpMuxConn.LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+		return ice.NewUDPMuxDefault(ice.UDPMuxParams{UDPConn: e.udpMuxConn}), nil
+	}
+
+	e.udpMuxPort = addrs[0].(*net.UDPAddr).Port //nolint:forcetypeassert
+	log.Debugf("listening for the host candidates on %v", addrs)
+	return udpMux, nil
+}
+
+// watchUDPMuxAddresses listens on the local addresses that have appeared and stops listening on the gone ones
+// every udpMuxAddressCheckInterval, the sockets bound by Start would miss the addresses of a new network
+func (e *Engine) watchUDPMuxAddresses(udpMux *ice.MultiUDPMuxDefault) {
+	ticker := time.NewTicker(udpMuxAddressCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := udpMux.UpdateListenAddresses()
+		if err != nil {
+			log.Warnf("failed updating the local addresses of the UDP mux: %v", err)
+		}
+		if changed {
+			log.Infof("local addresses have changed, listening for the host candidates on %v", udpMux.GetListenAddresses())
+			// the NAT of the new network may behave differently
+			e.natDetector.redetect()
+		}
+	}
+}
+
+// newTCPMux starts the ICE-TCP listener. ICE-TCP is optional, returns nil if the listener can't be started.
+func (e *Engine) newTCPMux() *ice.TCPMuxDefault {
+	networkName := "tcp"
+	if e.config.DisableIPv6Discovery {
+		networkName = "tcp4"
+	}
+
+	listener, err := net.ListenTCP(networkName, &net.TCPAddr{Port: e.config.TCPMuxPort})
+	if err != nil {
+		log.Warnf("failed listening on TCP port %d, ICE-TCP candidates are disabled: %v", e.config.TCPMuxPort, err)
+		return nil
+	}
+	log.Debugf("accepting ICE-TCP connections on %s", listener.Addr())
+
+	return ice.NewTCPMuxDefault(ice.TCPMuxParams{
+		Listener:        listener,
+		ReadBufferSize:  tcpMuxReadBufferSize,
+		WriteBufferSize: tcpMuxWriteBufferSize,
+	})
+}
+
 func (e *Engine) parseNATExternalIPMappings() []string {
 	var mappedIPs []string
 	var ignoredIFaces = make(map[string]interface{})
@@ -0,0 +1,516 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/route"
+	signal "github.com/netbirdio/netbird/signal/client"
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"ztnav2client/internal/candidatefilter"
+	"ztnav2client/internal/ice"
+	"ztnav2client/internal/lazyconn"
+	"ztnav2client/internal/peer"
+	"ztnav2client/internal/routemanager"
+	"ztnav2client/internal/signaling"
+	nbstatus "ztnav2client/status"
+)
+
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// the signal is ready but the remote peers never answer, so the connections stay in the negotiation
+	signalClient := &signal.MockClient{
+		ReadyFunc: func() bool { return true },
+	}
+	return newTestEngineWithSignal(t, key, signalClient)
+}
+
+func newTestEngineWithSignal(t *testing.T, key wgtypes.Key, signalClient signaling.Client) *Engine {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	engine := NewEngine(ctx, cancel, signalClient, &EngineConfig{
+		WgIfaceName:  "utun-test",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33100,
+	}, nbstatus.NewRecorder())
+
+	var err error
+	engine.wgInterface, err = iface.NewWGIFace(engine.config.WgIfaceName, engine.config.WgAddr, iface.DefaultMTU)
+	require.NoError(t, err)
+	engine.routeManager = &routemanager.MockManager{
+		UpdateRoutesFunc: func(uint64, []*route.Route) error { return nil },
+	}
+	return engine
+}
+
+func generatePeerKey(t *testing.T) string {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	return key.PublicKey().String()
+}
+
+func testNetworkMap(serial uint64, peers map[string]string) *mgmProto.NetworkMap {
+	networkMap := &mgmProto.NetworkMap{Serial: serial}
+	for key, allowedIP := range peers {
+		networkMap.RemotePeers = append(networkMap.RemotePeers, &mgmProto.RemotePeerConfig{
+			WgPubKey:   key,
+			AllowedIps: []string{allowedIP},
+		})
+	}
+	networkMap.RemotePeersIsEmpty = len(networkMap.RemotePeers) == 0
+	return networkMap
+}
+
+func applyNetworkMap(t *testing.T, e *Engine, networkMap *mgmProto.NetworkMap) {
+	t.Helper()
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+	require.NoError(t, e.updateNetworkMap(networkMap))
+}
+
+func peerConn(e *Engine, key string) *peer.Conn {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+	return e.peerConns[key]
+}
+
+func TestEngine_StartMulticastDNSWithNATExternalIPs(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &EngineConfig{
+		WgIfaceName:      "utun-test",
+		WgAddr:           "100.64.0.1/24",
+		WgPrivateKey:     key,
+		NATExternalIPs:   []string{"203.0.113.1"},
+		MulticastDNSMode: ice.MulticastDNSModeQueryAndGather,
+	}, nbstatus.NewRecorder())
+
+	assert.ErrorIs(t, engine.Start(), errMulticastDNSWithNATExternalIPs)
+}
+
+func TestEngine_NewUDPMux(t *testing.T) {
+	engine := NewEngine(context.Background(), func() {}, &signal.MockClient{}, &EngineConfig{}, nbstatus.NewRecorder())
+
+	udpMux, err := engine.newUDPMux("udp")
+	require.NoError(t, err)
+	defer udpMux.Close() //nolint:errcheck
+	require.NotZero(t, engine.udpMuxPort)
+	for _, addr := range udpMux.GetListenAddresses() {
+		udpAddr := addr.(*net.UDPAddr) //nolint:forcetypeassert
+		assert.False(t, udpAddr.IP.IsUnspecified(), "the mux should listen on the local addresses")
+		assert.Equal(t, engine.udpMuxPort, udpAddr.Port, "the addresses should share the port")
+	}
+
+	// without an allowed local address the mux falls back to the wildcard socket
+	filter, err := candidatefilter.New(candidatefilter.Rules{AllowIPs: []string{"198.51.100.0/24"}})
+	require.NoError(t, err)
+	engine = NewEngine(context.Background(), func() {}, &signal.MockClient{}, &EngineConfig{CandidateFilter: filter}, nbstatus.NewRecorder())
+
+	udpMux, err = engine.newUDPMux("udp")
+	require.NoError(t, err)
+	defer udpMux.Close() //nolint:errcheck
+	require.NotNil(t, engine.udpMuxConn)
+	assert.Equal(t, engine.udpMuxConn.LocalAddr().(*net.UDPAddr).Port, engine.udpMuxPort) //nolint:forcetypeassert
+}
+
+func TestEngine_UpdateNetworkMapClosesConnections(t *testing.T) {
+	engine := newTestEngine(t)
+	defer func() {
+		engine.syncMsgMux.Lock()
+		defer engine.syncMsgMux.Unlock()
+		assert.NoError(t, engine.removeAllPeers())
+	}()
+
+	peer1, peer2, peer3 := generatePeerKey(t), generatePeerKey(t), generatePeerKey(t)
+
+	applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{
+		peer1: "100.64.0.10/32",
+		peer2: "100.64.0.11/32",
+		peer3: "100.64.0.12/32",
+	}))
+	conn1, conn2, conn3 := peerConn(engine, peer1), peerConn(engine, peer2), peerConn(engine, peer3)
+
+	// wait until the connections are in the middle of an attempt
+	require.Eventually(t, func() bool {
+		return conn1.State() == peer.StateNegotiating &&
+			conn2.State() == peer.StateNegotiating &&
+			conn3.State() == peer.StateNegotiating
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// removed peer
+	applyNetworkMap(t, engine, testNetworkMap(2, map[string]string{
+		peer1: "100.64.0.10/32",
+		peer2: "100.64.0.11/32",
+	}))
+	assert.Equal(t, peer.StateClosed, conn3.State(), "removed connection should be closed once the update has been applied")
+	assert.Nil(t, peerConn(engine, peer3))
+
+	// modified peer
+	applyNetworkMap(t, engine, testNetworkMap(3, map[string]string{
+		peer1: "100.64.0.20/32",
+		peer2: "100.64.0.11/32",
+	}))
+	assert.Equal(t, peer.StateClosed, conn1.State(), "modified connection should be closed once the update has been applied")
+	newConn1 := peerConn(engine, peer1)
+	require.NotNil(t, newConn1)
+	assert.NotSame(t, conn1, newConn1)
+	assert.Equal(t, "100.64.0.20/32", newConn1.GetConf().ProxyConfig.AllowedIps)
+	assert.Same(t, conn2, peerConn(engine, peer2), "untouched connection shouldn't be recreated")
+	assert.Equal(t, peer.StateNegotiating, conn2.State())
+
+	// all peers removed
+	applyNetworkMap(t, engine, testNetworkMap(4, nil))
+	assert.Equal(t, peer.StateClosed, newConn1.State())
+	assert.Equal(t, peer.StateClosed, conn2.State())
+	assert.Empty(t, engine.peerConns)
+}
+
+func TestEngine_RapidNetworkMapUpdates(t *testing.T) {
+	engine := newTestEngine(t)
+
+	peer1, peer2 := generatePeerKey(t), generatePeerKey(t)
+
+	var created []*peer.Conn
+	serial := uint64(0)
+	for i := 0; i < 20; i++ {
+		serial++
+		peers := map[string]string{peer1: "100.64.0.10/32"}
+		switch i % 3 {
+		case 0:
+			peers[peer2] = "100.64.0.11/32"
+		case 1:
+			// modify
+			peers[peer2] = "100.64.0.12/32"
+		case 2:
+			// remove
+		}
+		applyNetworkMap(t, engine, testNetworkMap(serial, peers))
+		if conn := peerConn(engine, peer2); conn != nil {
+			created = append(created, conn)
+		}
+	}
+
+	conn1 := peerConn(engine, peer1)
+	last := peerConn(engine, peer2)
+	require.NotNil(t, last)
+	for _, conn := range created {
+		if conn == last {
+			continue
+		}
+		assert.Equal(t, peer.StateClosed, conn.State(), "replaced connection should be closed")
+	}
+
+	engine.syncMsgMux.Lock()
+	assert.Len(t, engine.peerConns, 2)
+	require.NoError(t, engine.removeAllPeers())
+	engine.syncMsgMux.Unlock()
+
+	assert.Equal(t, peer.StateClosed, last.State())
+	assert.Equal(t, peer.StateClosed, conn1.State())
+}
+
+func TestEngine_SignalingOverHub(t *testing.T) {
+	hub := signaling.NewHub()
+
+	var engines []*Engine
+	var keys []wgtypes.Key
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		signalClient := hub.NewClient(key.PublicKey().String())
+		defer signalClient.Close()
+
+		keys = append(keys, key)
+		engines = append(engines, newTestEngineWithSignal(t, key, signalClient))
+	}
+
+	for i, engine := range engines {
+		engine.receiveSignalEvents()
+		remoteKey := keys[1-i].PublicKey().String()
+		applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{remoteKey: "100.64.0.10/32"}))
+	}
+
+	// both peers got the credentials of each other and started the ICE checks
+	for i, engine := range engines {
+		conn := peerConn(engine, keys[1-i].PublicKey().String())
+		require.NotNil(t, conn)
+		require.Eventually(t, func() bool {
+			state := conn.State()
+			return state == peer.StateConnecting || state == peer.StateConnected
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+
+	for _, engine := range engines {
+		engine.syncMsgMux.Lock()
+		assert.NoError(t, engine.removeAllPeers())
+		engine.syncMsgMux.Unlock()
+	}
+}
+
+func TestEngine_LazyPeerReconnectsAfterIdle(t *testing.T) {
+	hub := signaling.NewHub()
+
+	var engines []*Engine
+	var keys []string
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		signalClient := hub.NewClient(key.PublicKey().String())
+		defer signalClient.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		engine := NewEngine(ctx, cancel, signalClient, &EngineConfig{
+			WgIfaceName:    fmt.Sprintf("utun-lazy%d", i),
+			WgAddr:         fmt.Sprintf("100.64.0.%d/24", i+1),
+			WgPrivateKey:   key,
+			WgPort:         33110 + i,
+			LazyConnection: true,
+		}, nbstatus.NewRecorder())
+		engine.wgInterface, err = iface.NewWGIFace(engine.config.WgIfaceName, engine.config.WgAddr, iface.DefaultMTU)
+		require.NoError(t, err)
+		require.NoError(t, engine.wgInterface.Create())
+		defer engine.wgInterface.Close() //nolint:errcheck
+		require.NoError(t, engine.wgInterface.Configure(key.String(), engine.config.WgPort))
+		engine.routeManager = &routemanager.MockManager{
+			UpdateRoutesFunc: func(uint64, []*route.Route) error { return nil },
+		}
+		engine.lazyConnMgr = lazyconn.NewManager(ctx, engine.wgInterface, 0, engine.onLazyPeerActivity, engine.onLazyPeerIdle)
+
+		engines = append(engines, engine)
+		keys = append(keys, key.PublicKey().String())
+	}
+
+	for i, engine := range engines {
+		engine.receiveSignalEvents()
+		applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{keys[1-i]: fmt.Sprintf("100.64.0.%d/32", 2-i)}))
+	}
+
+	activate := func() {
+		require.Eventually(t, func() bool {
+			return engines[0].lazyConnMgr.ActivatePeer(keys[1])
+		}, 5*time.Second, 10*time.Millisecond, "the peer should be waiting in lazy mode")
+		engines[0].onLazyPeerActivity(keys[1])
+	}
+	waitConnected := func() {
+		for i, engine := range engines {
+			require.Eventually(t, func() bool {
+				conn := peerConn(engine, keys[1-i])
+				return conn != nil && conn.State() == peer.StateConnected
+			}, 30*time.Second, 50*time.Millisecond)
+		}
+	}
+
+	activate()
+	waitConnected()
+
+	// both sides go idle, the closed connections are replaced once they have been cleaned up
+	closed := []*peer.Conn{peerConn(engines[0], keys[1]), peerConn(engines[1], keys[0])}
+	for i, engine := range engines {
+		engine.onLazyPeerIdle(keys[1-i])
+	}
+	for i, engine := range engines {
+		require.Eventually(t, func() bool {
+			conn := peerConn(engine, keys[1-i])
+			return conn != closed[i] && conn.State() == peer.StateIdle
+		}, 15*time.Second, 10*time.Millisecond)
+	}
+
+	// the remote side is activated by the offer
+	activate()
+	waitConnected()
+
+	for _, engine := range engines {
+		engine.syncMsgMux.Lock()
+		assert.NoError(t, engine.removeAllPeers())
+		engine.syncMsgMux.Unlock()
+		engine.lazyConnMgr.Close()
+	}
+}
+
+func TestEngine_PortMappingAfterConnCreated(t *testing.T) {
+	engine := newTestEngine(t)
+
+	conn, err := engine.createPeerConn(generatePeerKey(t), "100.64.0.10/32")
+	require.NoError(t, err)
+	assert.Empty(t, conn.GetConf().UDPMuxMappedAddrs)
+
+	// the gateway maps the port after the connection has been created
+	external := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41000}
+	engine.onPortMappingChange(external)
+	engine.updateConnAttempt(conn)
+	assert.Equal(t, []*net.UDPAddr{external}, conn.GetConf().UDPMuxMappedAddrs)
+
+	// and the mapping is lost
+	engine.onPortMappingChange(nil)
+	engine.updateConnAttempt(conn)
+	assert.Empty(t, conn.GetConf().UDPMuxMappedAddrs)
+}
+
+func TestEngine_RemovePeerForgetsReplayWindow(t *testing.T) {
+	engine := newTestEngine(t)
+	peer1 := generatePeerKey(t)
+
+	applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{peer1: "100.64.0.10/32"}))
+	engine.syncMsgMux.Lock()
+	assert.True(t, engine.acceptSignalCounter(peer1, signaling.Session{ID: 1, Counter: 5000}))
+	assert.False(t, engine.acceptSignalCounter(peer1, signaling.Session{ID: 1, Counter: 5000}))
+	engine.syncMsgMux.Unlock()
+
+	applyNetworkMap(t, engine, testNetworkMap(2, nil))
+
+	// the peer comes back with a clock that stepped back and a session that happens to be known
+	applyNetworkMap(t, engine, testNetworkMap(3, map[string]string{peer1: "100.64.0.10/32"}))
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+	assert.True(t, engine.acceptSignalCounter(peer1, signaling.Session{ID: 1, Counter: 1000}), "the window of the removed peer should be forgotten")
+	assert.NoError(t, engine.removeAllPeers())
+}
+
+func TestEngine_CandidatesOfPeersWithoutSealing(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	var sentMu sync.Mutex
+	var sent []*sProto.Message
+	engine := newTestEngineWithSignal(t, key, &signal.MockClient{
+		ReadyFunc: func() bool { return true },
+		SendFunc: func(msg *sProto.Message) error {
+			sentMu.Lock()
+			defer sentMu.Unlock()
+			sent = append(sent, msg)
+			return nil
+		},
+	})
+
+	legacyKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	sealingKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	legacyPeer, sealingPeer := legacyKey.PublicKey().String(), sealingKey.PublicKey().String()
+	applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{
+		legacyPeer:  "100.64.0.10/32",
+		sealingPeer: "100.64.0.11/32",
+	}))
+	defer func() {
+		engine.syncMsgMux.Lock()
+		defer engine.syncMsgMux.Unlock()
+		assert.NoError(t, engine.removeAllPeers())
+	}()
+	legacyConn, sealingConn := peerConn(engine, legacyPeer), peerConn(engine, sealingPeer)
+
+	// a peer of a version before the sealing answers without capabilities
+	credentials := peer.IceCredentials{UFrag: "aaaaaaaaaaaaaaaa", Pwd: "bbbbbbbbbbbbbbbbbbbbbbbb"}
+	require.Eventually(t, func() bool {
+		return legacyConn.State() == peer.StateNegotiating && sealingConn.State() == peer.StateNegotiating
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return legacyConn.OnRemoteAnswer(peer.OfferAnswer{IceCredentials: credentials, WgListenPort: 51820})
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return sealingConn.OnRemoteAnswer(peer.OfferAnswer{
+			IceCredentials: credentials,
+			WgListenPort:   51820,
+			Capabilities:   peer.LocalCapabilities(),
+			SessionID:      5,
+		})
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// our candidates are sealed only to the peer sealing its own
+	require.Eventually(t, func() bool {
+		return legacyConn.State() == peer.StateConnecting && sealingConn.State() == peer.StateConnecting
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, legacyConn.SealedCandidates())
+	assert.True(t, sealingConn.SealedCandidates())
+	require.Eventually(t, func() bool {
+		sentMu.Lock()
+		defer sentMu.Unlock()
+		var plaintext, sealed bool
+		for _, msg := range sent {
+			if msg.GetBody().GetType() != sProto.Body_CANDIDATE {
+				continue
+			}
+			switch msg.RemoteKey {
+			case legacyPeer:
+				_, err := ice.UnmarshalCandidate(msg.GetBody().GetPayload())
+				assert.NoError(t, err, "the legacy peer should get plaintext candidates")
+				plaintext = true
+			case sealingPeer:
+				_, _, err := signaling.OpenCandidate(msg.GetBody().GetPayload(), key.PublicKey(), sealingKey, true)
+				assert.NoError(t, err, "the sealing peer should get sealed candidates")
+				sealed = true
+			}
+		}
+		return plaintext && sealed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// and only the plaintext candidates of the legacy peer are accepted
+	candidate, err := ice.NewCandidateHost(&ice.CandidateHostConfig{Network: "udp", Address: "198.51.100.7", Port: 51820, Component: 1})
+	require.NoError(t, err)
+	candidateMessage := func(sender string, payload string) *sProto.Message {
+		return &sProto.Message{Key: sender, Body: &sProto.Body{Type: sProto.Body_CANDIDATE, Payload: payload}}
+	}
+	sealedPayload, err := signaling.SealCandidate(candidate.Marshal(), signaling.Session{ID: 5, Counter: 10}, key.PublicKey(), sealingKey)
+	require.NoError(t, err)
+
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+	assert.NoError(t, engine.handleRemoteCandidate(legacyConn, candidateMessage(legacyPeer, candidate.Marshal())))
+	assert.Error(t, engine.handleRemoteCandidate(sealingConn, candidateMessage(sealingPeer, candidate.Marshal())))
+	assert.NoError(t, engine.handleRemoteCandidate(sealingConn, candidateMessage(sealingPeer, sealedPayload)))
+}
+
+func TestEngine_ConnAttemptSlotReleasedAfterGathering(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.connScheduler = newConnScheduler(1, engine.onNextConnAttempt)
+	defer func() {
+		engine.syncMsgMux.Lock()
+		defer engine.syncMsgMux.Unlock()
+		assert.NoError(t, engine.removeAllPeers())
+	}()
+
+	peer1, peer2 := generatePeerKey(t), generatePeerKey(t)
+	applyNetworkMap(t, engine, testNetworkMap(1, map[string]string{
+		peer1: "100.64.0.10/32",
+		peer2: "100.64.0.11/32",
+	}))
+	conn1, conn2 := peerConn(engine, peer1), peerConn(engine, peer2)
+
+	// both peers wait for the answer at the same time although there is a single slot
+	require.Eventually(t, func() bool {
+		return conn1.State() == peer.StateNegotiating && conn2.State() == peer.StateNegotiating
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, engine.connScheduler.slots)
+
+	// and both gather their candidates one after the other, the slot is free once they are checking the pairs
+	for _, conn := range []*peer.Conn{conn1, conn2} {
+		require.True(t, conn.OnRemoteAnswer(peer.OfferAnswer{
+			IceCredentials: peer.IceCredentials{UFrag: "aaaaaaaaaaaaaaaa", Pwd: "bbbbbbbbbbbbbbbbbbbbbbbb"},
+			WgListenPort:   51820,
+		}))
+	}
+	require.Eventually(t, func() bool {
+		return conn1.State() == peer.StateConnecting && conn2.State() == peer.StateConnecting &&
+			len(engine.connScheduler.slots) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,217 @@
+package ice
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+const (
+	// activeTCPPort is the port signaled for the active TCP candidates, they never accept connections (RFC 6544 section 4.5)
+	activeTCPPort = 9
+
+	// tcpConnectTimeout limits a single connection attempt of an active or simultaneous-open candidate
+	tcpConnectTimeout = 5 * time.Second
+
+	// simultaneousOpenRetryInterval is the pause between the connection attempts of a simultaneous-open candidate.
+	// The attempts are repeated until one of them crosses the attempt of the remote peer or reaches its listener.
+	simultaneousOpenRetryInterval = 200 * time.Millisecond
+	// simultaneousOpenAttempts limits the connection attempts to a single remote simultaneous-open candidate
+	simultaneousOpenAttempts = 50
+)
+
+// activeTCPConn is the connection of an active or a simultaneous-open TCP host candidate (RFC 6544).
+// It keeps one TCP connection per remote candidate, the connections are established by connect once the candidate
+// has been paired with a passive (active) or another simultaneous-open remote candidate.
+// The packets are framed like the ones of the passive candidates of the TCPMux.
+type activeTCPConn struct {
+	*tcpPacketConn
+
+	tcpType TCPType
+	// localAddr is the address the connections are made from, the port is 0 for the active candidates
+	localAddr *net.TCPAddr
+	// listener accepts the connection attempts of the remote simultaneous-open candidates, nil for the active candidates
+	listener net.Listener
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    logging.LeveledLogger
+
+	mu sync.Mutex
+	// connecting holds the remote addresses with a connection attempt in progress
+	connecting map[string]struct{}
+	wg         sync.WaitGroup
+}
+
+// newActiveTCPConn creates the connection of an active TCP candidate on the local IP
+func newActiveTCPConn(ctx context.Context, localIP net.IP, log logging.LeveledLogger) *activeTCPConn {
+	return newTCPCandidateConn(ctx, TCPTypeActive, &net.TCPAddr{IP: localIP}, nil, log)
+}
+
+// newSimultaneousOpenTCPConn reserves a port on the local IP for a simultaneous-open TCP candidate.
+// The port both accepts connections and is the source of the outgoing connection attempts.
+func newSimultaneousOpenTCPConn(ctx context.Context, localIP net.IP, log logging.LeveledLogger) (*activeTCPConn, error) {
+	listenConfig := &net.ListenConfig{Control: reuseTCPPort}
+	listener, err := listenConfig.Listen(ctx, tcp, (&net.TCPAddr{IP: localIP}).String())
+	if err != nil {
+		return nil, err
+	}
+	localAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		_ = listener.Close()
+		return nil, ErrGetTransportAddress
+	}
+
+	c := newTCPCandidateConn(ctx, TCPTypeSimultaneousOpen, localAddr, listener, log)
+	c.wg.Add(1)
+	go c.acceptLoop()
+	return c, nil
+}
+
+func newTCPCandidateConn(ctx context.Context, tcpType TCPType, localAddr *net.TCPAddr, listener net.Listener, log logging.LeveledLogger) *activeTCPConn {
+	connCtx, cancel := context.WithCancel(ctx)
+	return &activeTCPConn{
+		tcpPacketConn: newTCPPacketConn(tcpPacketParams{
+			ReadBuffer: 20,
+			LocalAddr:  localAddr,
+			Logger:     log,
+		}),
+		tcpType:    tcpType,
+		localAddr:  localAddr,
+		listener:   listener,
+		ctx:        connCtx,
+		cancel:     cancel,
+		log:        log,
+		connecting: map[string]struct{}{},
+	}
+}
+
+// connect starts connecting to the remote candidate address unless there is already a connection or an attempt
+func (c *activeTCPConn) connect(remote net.Addr) {
+	remoteAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	key := remoteAddr.String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil || c.hasConn(key) {
+		return
+	}
+	if _, ok := c.connecting[key]; ok {
+		return
+	}
+	c.connecting[key] = struct{}{}
+
+	c.wg.Add(1)
+	go c.dial(remoteAddr)
+}
+
+func (c *activeTCPConn) hasConn(remoteAddr string) bool {
+	c.tcpPacketConn.mu.Lock()
+	defer c.tcpPacketConn.mu.Unlock()
+	_, ok := c.conns[remoteAddr]
+	return ok
+}
+
+func (c *activeTCPConn) dial(remoteAddr *net.TCPAddr) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		delete(c.connecting, remoteAddr.String())
+		c.mu.Unlock()
+	}()
+
+	dialer := &net.Dialer{
+		LocalAddr: c.localAddr,
+		Timeout:   tcpConnectTimeout,
+	}
+	attempts := 1
+	if c.tcpType == TCPTypeSimultaneousOpen {
+		// the outgoing connections leave from the port of the listener
+		dialer.Control = reuseTCPPort
+		attempts = simultaneousOpenAttempts
+	}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		conn, err := dialer.DialContext(c.ctx, tcp, remoteAddr.String())
+		if err == nil {
+			c.log.Debugf("%s TCP connection established %s -> %s", c.tcpType, conn.LocalAddr(), conn.RemoteAddr())
+			if err := c.AddConn(conn, nil); err != nil {
+				// e.g. the remote simultaneous-open candidate has connected to our listener in the meantime
+				c.log.Debugf("dropping TCP connection %s -> %s: %v", conn.LocalAddr(), conn.RemoteAddr(), err)
+				_ = conn.Close()
+			}
+			return
+		}
+		if c.ctx.Err() != nil || c.hasConn(remoteAddr.String()) {
+			return
+		}
+		c.log.Tracef("%s TCP connection attempt %d from %s to %s failed: %v", c.tcpType, attempt, c.localAddr, remoteAddr, err)
+
+		if attempt < attempts {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(simultaneousOpenRetryInterval):
+			}
+		}
+	}
+}
+
+func (c *activeTCPConn) acceptLoop() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if c.ctx.Err() == nil {
+				c.log.Warnf("stopped accepting TCP connections of the simultaneous-open candidate %s: %v", c.localAddr, err)
+			}
+			return
+		}
+		c.log.Debugf("%s TCP connection accepted %s <- %s", c.tcpType, conn.LocalAddr(), conn.RemoteAddr())
+		if err := c.AddConn(conn, nil); err != nil {
+			// our own attempt to the same remote candidate has already succeeded
+			c.log.Debugf("dropping TCP connection %s <- %s: %v", conn.LocalAddr(), conn.RemoteAddr(), err)
+			_ = conn.Close()
+		}
+	}
+}
+
+// ReadFrom reads the next packet of any of the connections. Unlike the passive candidates, a failure of a single
+// connection doesn't stop the candidate, the other remote candidates are still reachable.
+func (c *activeTCPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, remoteAddr, err := c.tcpPacketConn.ReadFrom(b)
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) && c.ctx.Err() == nil {
+			c.log.Debugf("%s TCP connection %s <-> %s failed: %v", c.tcpType, c.localAddr, remoteAddr, err)
+			continue
+		}
+		return n, remoteAddr, err
+	}
+}
+
+// Close stops the connection attempts and closes all the connections
+func (c *activeTCPConn) Close() error {
+	c.mu.Lock()
+	c.cancel()
+	c.mu.Unlock()
+
+	var closeErr error
+	if c.listener != nil {
+		closeErr = c.listener.Close()
+	}
+	c.wg.Wait()
+
+	err := c.tcpPacketConn.Close()
+	if closeErr == nil {
+		closeErr = err
+	}
+	return closeErr
+}
@@ -0,0 +1,143 @@
+//go:build !js
+// +build !js
+
+package ice
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPTypesCompatible(t *testing.T) {
+	testCases := []struct {
+		local, remote TCPType
+		compatible    bool
+	}{
+		{TCPTypeActive, TCPTypePassive, true},
+		{TCPTypePassive, TCPTypeActive, true},
+		{TCPTypeSimultaneousOpen, TCPTypeSimultaneousOpen, true},
+		{TCPTypeActive, TCPTypeActive, false},
+		{TCPTypePassive, TCPTypePassive, false},
+		{TCPTypeActive, TCPTypeSimultaneousOpen, false},
+		{TCPTypeSimultaneousOpen, TCPTypePassive, false},
+		// UDP and peer reflexive candidates
+		{TCPTypeUnspecified, TCPTypeUnspecified, true},
+		{TCPTypePassive, TCPTypeUnspecified, true},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.compatible, tcpTypesCompatible(testCase.local, testCase.remote),
+			"local %s, remote %s", testCase.local, testCase.remote)
+	}
+}
+
+// TestActiveTCP connects two agents over loopback TCP, vnet doesn't support TCP
+func TestActiveTCP(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	newTCPMux := func(t *testing.T) TCPMux {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		return NewTCPMuxDefault(TCPMuxParams{
+			Listener:       listener,
+			Logger:         loggerFactory.NewLogger("ice"),
+			ReadBufferSize: 20,
+		})
+	}
+
+	newAgent := func(t *testing.T, tcpType TCPType, tcpMux TCPMux) *Agent {
+		agent, err := NewAgent(&AgentConfig{
+			NetworkTypes:     []NetworkType{NetworkTypeTCP4},
+			CandidateTypes:   []CandidateType{CandidateTypeHost},
+			MulticastDNSMode: MulticastDNSModeDisabled,
+			TCPMux:           tcpMux,
+			TCPTypes:         []TCPType{tcpType},
+			IncludeLoopback:  true,
+			IPFilter: func(ip net.IP) bool {
+				return ip.IsLoopback()
+			},
+			LoggerFactory: loggerFactory,
+		})
+		require.NoError(t, err)
+		return agent
+	}
+
+	testCases := []struct {
+		name         string
+		aType, bType TCPType
+	}{
+		{
+			name:  "active to passive",
+			aType: TCPTypePassive,
+			bType: TCPTypeActive,
+		},
+		{
+			name:  "passive to active",
+			aType: TCPTypeActive,
+			bType: TCPTypePassive,
+		},
+		{
+			name:  "simultaneous-open",
+			aType: TCPTypeSimultaneousOpen,
+			bType: TCPTypeSimultaneousOpen,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			var aMux, bMux TCPMux
+			if testCase.aType == TCPTypePassive {
+				aMux = newTCPMux(t)
+				defer func() {
+					_ = aMux.(*TCPMuxDefault).Close()
+				}()
+			}
+			if testCase.bType == TCPTypePassive {
+				bMux = newTCPMux(t)
+				defer func() {
+					_ = bMux.(*TCPMuxDefault).Close()
+				}()
+			}
+
+			aAgent := newAgent(t, testCase.aType, aMux)
+			bAgent := newAgent(t, testCase.bType, bMux)
+
+			aConn, bConn := connect(aAgent, bAgent)
+
+			pair := bAgent.getSelectedPair()
+			require.NotNil(t, pair)
+			assert.Equal(t, NetworkTypeTCP4, pair.Local.NetworkType())
+			assert.Equal(t, testCase.bType, pair.Local.TCPType())
+
+			data := []byte("hello world")
+			_, err := aConn.Write(data)
+			require.NoError(t, err)
+			buf := make([]byte, receiveMTU)
+			n, err := bConn.Read(buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, buf[:n])
+
+			_, err = bConn.Write(data)
+			require.NoError(t, err)
+			n, err = aConn.Read(buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, buf[:n])
+
+			require.NoError(t, aConn.Close())
+			require.NoError(t, bConn.Close())
+		})
+	}
+}
@@ -48,6 +48,12 @@ type Agent struct {
 	connectionState ConnectionState
 	gatheringState  GatheringState
 
+	// remoteGatheringComplete is set once the remote peer has signaled that it has no more candidates
+	remoteGatheringComplete bool
+	// pendingRemoteCandidates counts the remote candidates passed to AddRemoteCandidate but not added yet,
+	// e.g. being resolved with mDNS, the end of candidates doesn't count before they are in the checklist
+	pendingRemoteCandidates int32
+
 	mDNSMode MulticastDNSMode
 	mDNSName string
 	mDNSConn *mdns.Conn
@@ -81,6 +87,18 @@ type Agent struct {
 	// 0 means never
 	keepaliveInterval time.Duration
 
+	// How often should we refresh the consent of the selected pair? 0 means never
+	consentCheckInterval time.Duration
+
+	// How long can the selected pair go without a consent? 0 means forever
+	consentTimeout time.Duration
+
+	// consentLost is set (atomically) once the consent of the selected pair has expired, nothing is sent anymore
+	consentLost int32
+
+	// How often should the controlling agent look for a better pair than the selected one? 0 means never
+	renominationInterval time.Duration
+
 	// How often should we run our internal taskLoop to check for state changes when connecting
 	checkInterval time.Duration
 
@@ -125,8 +143,12 @@ type Agent struct {
 
 	net         *vnet.Net
 	tcpMux      TCPMux
+	tcpTypes    []TCPType
 	udpMux      UDPMux
 	udpMuxSrflx UniversalUDPMux
+	turnPool    *TURNPool
+
+	udpMuxMappedAddrs []*net.UDPAddr
 
 	interfaceFilter func(string) bool
 	ipFilter        func(net.IP) bool
@@ -326,8 +348,14 @@ func NewAgent(config *AgentConfig) (*Agent, error) { //nolint:gocognit
 	if a.tcpMux == nil {
 		a.tcpMux = newInvalidTCPMux()
 	}
+	a.tcpTypes = config.TCPTypes
+	if len(a.tcpTypes) == 0 {
+		a.tcpTypes = []TCPType{TCPTypePassive}
+	}
 	a.udpMux = config.UDPMux
 	a.udpMuxSrflx = config.UDPMuxSrflx
+	a.udpMuxMappedAddrs = config.UDPMuxMappedAddrs
+	a.turnPool = config.TURNPool
 
 	if a.net == nil {
 		a.net = vnet.NewNet(nil)
@@ -498,7 +526,7 @@ func (a *Agent) connectivityChecks() {
 			}()
 
 			switch a.connectionState {
-			case ConnectionStateFailed:
+			case ConnectionStateFailed, ConnectionStateConsentLost:
 				// The connection is currently failed so don't send any checks
 				// In the future it may be restarted though
 				return
@@ -513,6 +541,13 @@ func (a *Agent) connectivityChecks() {
 					a.updateConnectionState(ConnectionStateFailed)
 					return
 				}
+
+				// Both sides have all their candidates and every pair has failed, no pair could succeed anymore
+				if a.allCandidatePairsFailed() {
+					a.log.Debug("all candidate pairs have failed after the end of candidates, setting the connection to Failed")
+					a.updateConnectionState(ConnectionStateFailed)
+					return
+				}
 			}
 
 			a.selector.ContactCandidates()
@@ -535,6 +570,7 @@ func (a *Agent) connectivityChecks() {
 			updateInterval(a.checkInterval)
 		case ConnectionStateConnected, ConnectionStateDisconnected:
 			updateInterval(a.keepaliveInterval)
+			updateInterval(a.consentCheckInterval)
 		default:
 		}
 		// Ensure we run our task loop as quickly as the minimum of our various configured timeouts
@@ -558,10 +594,16 @@ func (a *Agent) connectivityChecks() {
 func (a *Agent) updateConnectionState(newState ConnectionState) {
 	if a.connectionState != newState {
 		// Connection has gone to failed, release all gathered candidates
-		if newState == ConnectionStateFailed {
+		if newState == ConnectionStateFailed || newState == ConnectionStateConsentLost {
 			a.deleteAllCandidates()
 		}
 
+		if newState == ConnectionStateConsentLost {
+			atomic.StoreInt32(&a.consentLost, 1)
+		} else {
+			atomic.StoreInt32(&a.consentLost, 0)
+		}
+
 		a.log.Infof("Setting new connection state: %s", newState)
 		a.connectionState = newState
 
@@ -582,6 +624,13 @@ func (a *Agent) setSelectedPair(p *CandidatePair) {
 	}
 
 	p.nominated = true
+	if p.lastConsent.IsZero() {
+		p.lastConsent = time.Now()
+	}
+	if p.nextConsentCheck.IsZero() && a.consentCheckInterval != 0 {
+		// the checks that have selected the pair have just given the consent
+		p.nextConsentCheck = time.Now().Add(randomizeConsentInterval(a.consentCheckInterval))
+	}
 	a.selectedPair.Store(p)
 	a.log.Tracef("Set selected candidate pair: %s", p)
 
@@ -625,6 +674,25 @@ func (a *Agent) pingAllCandidates() {
 	}
 }
 
+// allCandidatePairsFailed returns true if the local and the remote gathering have completed,
+// all the remote candidates have been added and every candidate pair has failed.
+// An empty checklist never counts as failed, the candidates may still be on their way.
+// Note: the caller should hold the agent lock.
+func (a *Agent) allCandidatePairsFailed() bool {
+	if !a.remoteGatheringComplete || a.gatheringState != GatheringStateComplete {
+		return false
+	}
+	if len(a.checklist) == 0 || atomic.LoadInt32(&a.pendingRemoteCandidates) > 0 {
+		return false
+	}
+	for _, p := range a.checklist {
+		if p.state != CandidatePairStateFailed {
+			return false
+		}
+	}
+	return true
+}
+
 func (a *Agent) getBestAvailableCandidatePair() *CandidatePair {
 	var best *CandidatePair
 	for _, p := range a.checklist {
@@ -663,6 +731,22 @@ func (a *Agent) addPair(local, remote Candidate) *CandidatePair {
 	return p
 }
 
+// addPairIfCompatible pairs the candidates unless their TCP types can't connect to each other.
+// An active or simultaneous-open local candidate starts connecting to the remote one.
+// Note: the caller should hold the agent lock.
+func (a *Agent) addPairIfCompatible(local, remote Candidate) {
+	if !tcpTypesCompatible(local.TCPType(), remote.TCPType()) {
+		return
+	}
+	a.addPair(local, remote)
+
+	if host, ok := local.(*CandidateHost); ok {
+		if conn, ok := host.conn.(*activeTCPConn); ok {
+			conn.connect(remote.addr())
+		}
+	}
+}
+
 func (a *Agent) findPair(local, remote Candidate) *CandidatePair {
 	for _, p := range a.checklist {
 		if p.Local.Equal(local) && p.Remote.Equal(remote) {
@@ -718,6 +802,54 @@ func (a *Agent) checkKeepalive() {
 	}
 }
 
+// checkConsent refreshes the consent of the remote peer to receive the traffic of the selected pair
+// with a binding request every randomized consentCheckInterval, and moves the agent to ConnectionStateConsentLost
+// once there has been no authenticated response for the consentTimeout (RFC 7675).
+// Note: the caller should hold the agent lock.
+func (a *Agent) checkConsent() {
+	selectedPair := a.getSelectedPair()
+	if selectedPair == nil {
+		return
+	}
+
+	if a.consentTimeout != 0 && time.Since(selectedPair.lastConsent) > a.consentTimeout {
+		a.log.Warnf("consent of the selected pair %s expired, last consent %s ago", selectedPair, time.Since(selectedPair.lastConsent))
+		a.updateConnectionState(ConnectionStateConsentLost)
+		return
+	}
+
+	if a.consentCheckInterval != 0 && !time.Now().Before(selectedPair.nextConsentCheck) {
+		a.selector.PingCandidate(selectedPair.Local, selectedPair.Remote)
+		selectedPair.consentRequestsSent++
+		selectedPair.nextConsentCheck = time.Now().Add(randomizeConsentInterval(a.consentCheckInterval))
+	}
+}
+
+// randomizeConsentInterval returns a random interval between 0.8 and 1.2 times the consent check interval
+// to prevent the checks of many agents from synchronizing (RFC 7675 section 5.1)
+func randomizeConsentInterval(interval time.Duration) time.Duration {
+	return interval*4/5 + time.Duration(globalMathRandomGenerator.Intn(int(interval*2/5)+1))
+}
+
+// SetRenominationInterval enables the renomination of a controlling agent: every interval it checks the candidate
+// pairs of the established connection and nominates a pair with a higher priority or a lower round trip time
+// than the selected one. The remote agent must support the NOMINATION attribute, 0 disables the renomination.
+func (a *Agent) SetRenominationInterval(interval time.Duration) error {
+	return a.run(a.context(), func(ctx context.Context, agent *Agent) {
+		agent.renominationInterval = interval
+	})
+}
+
+// SetRemoteGatheringComplete marks the remote candidates as complete (end-of-candidates).
+// Once the local gathering has completed as well and all the candidate pairs have failed,
+// the connection fails without waiting for the disconnected and failed timeouts.
+func (a *Agent) SetRemoteGatheringComplete() error {
+	return a.run(a.context(), func(ctx context.Context, agent *Agent) {
+		agent.remoteGatheringComplete = true
+		agent.requestConnectivityCheck()
+	})
+}
+
 // AddRemoteCandidate adds a new remote candidate
 func (a *Agent) AddRemoteCandidate(c Candidate) error {
 	if c == nil {
@@ -745,11 +877,14 @@ func (a *Agent) AddRemoteCandidate(c Candidate) error {
 			return ErrAddressParseFailed
 		}
 
+		atomic.AddInt32(&a.pendingRemoteCandidates, 1)
 		go a.resolveAndAddMulticastCandidate(hostCandidate)
 		return nil
 	}
 
+	atomic.AddInt32(&a.pendingRemoteCandidates, 1)
 	go func() {
+		defer atomic.AddInt32(&a.pendingRemoteCandidates, -1)
 		if err := a.run(a.context(), func(ctx context.Context, agent *Agent) {
 			agent.addRemoteCandidate(c)
 		}); err != nil {
@@ -761,6 +896,7 @@ func (a *Agent) AddRemoteCandidate(c Candidate) error {
 }
 
 func (a *Agent) resolveAndAddMulticastCandidate(c *CandidateHost) {
+	defer atomic.AddInt32(&a.pendingRemoteCandidates, -1)
 	if a.mDNSConn == nil {
 		return
 	}
@@ -811,7 +947,7 @@ func (a *Agent) addRemoteCandidate(c Candidate) {
 
 	if localCandidates, ok := a.localCandidates[c.NetworkType()]; ok {
 		for _, localCandidate := range localCandidates {
-			a.addPair(localCandidate, c)
+			a.addPairIfCompatible(localCandidate, c)
 		}
 	}
 
@@ -820,6 +956,13 @@ func (a *Agent) addRemoteCandidate(c Candidate) {
 
 func (a *Agent) addCandidate(ctx context.Context, c Candidate, candidateConn net.PacketConn) error {
 	return a.run(ctx, func(ctx context.Context, agent *Agent) {
+		if a.mDNSMode == MulticastDNSModeQueryAndGather && c.Type() != CandidateTypeHost {
+			// the host candidates are hidden behind the mDNS name, the related addresses mustn't reveal them
+			if hider, ok := c.(interface{ hideRelatedAddress() }); ok {
+				hider.hideRelatedAddress()
+			}
+		}
+
 		set := a.localCandidates[c.NetworkType()]
 		for _, candidate := range set {
 			if candidate.Equal(c) {
@@ -841,7 +984,7 @@ func (a *Agent) addCandidate(ctx context.Context, c Candidate, candidateConn net
 
 		if remoteCandidates, ok := a.remoteCandidates[c.NetworkType()]; ok {
 			for _, remoteCandidate := range remoteCandidates {
-				a.addPair(c, remoteCandidate)
+				a.addPairIfCompatible(c, remoteCandidate)
 			}
 		}
 
@@ -907,6 +1050,9 @@ func (a *Agent) removeUfragFromMux() {
 	if a.udpMuxSrflx != nil {
 		a.udpMuxSrflx.RemoveConnByUfrag(a.localUfrag)
 	}
+	if a.turnPool != nil {
+		a.turnPool.RemoveConnByUfrag(a.localUfrag)
+	}
 }
 
 // Close cleans up the Agent
@@ -972,13 +1118,17 @@ func (a *Agent) findRemoteCandidate(networkType NetworkType, addr net.Addr) Cand
 func (a *Agent) sendBindingRequest(m *stun.Message, local, remote Candidate) {
 	a.log.Tracef("ping STUN from %s to %s", local.String(), remote.String())
 
-	a.invalidatePendingBindingRequests(time.Now())
+	now := time.Now()
+	a.invalidatePendingBindingRequests(now)
 	a.pendingBindingRequests = append(a.pendingBindingRequests, bindingRequest{
-		timestamp:      time.Now(),
+		timestamp:      now,
 		transactionID:  m.TransactionID,
 		destination:    remote.addr(),
 		isUseCandidate: m.Contains(stun.AttrUseCandidate),
 	})
+	if p := a.findPair(local, remote); p != nil {
+		p.requestSent(now)
+	}
 
 	a.sendSTUN(m, local, remote)
 }
@@ -1003,6 +1153,10 @@ func (a *Agent) sendBindingSuccess(m *stun.Message, local, remote Candidate) {
 		a.log.Warnf("Failed to handle inbound ICE from: %s to: %s error: %s", local, remote, err)
 	} else {
 		a.sendSTUN(out, local, remote)
+		if p := a.findPair(local, remote); p != nil {
+			p.requestsReceived++
+			p.responsesSent++
+		}
 	}
 }
 
@@ -1241,6 +1395,7 @@ func (a *Agent) Restart(ufrag, pwd string) error {
 		agent.remoteUfrag = ""
 		agent.remotePwd = ""
 		a.gatheringState = GatheringStateNew
+		a.remoteGatheringComplete = false
 		a.checklist = make([]*CandidatePair, 0)
 		a.pendingBindingRequests = make([]bindingRequest, 0)
 		a.setSelectedPair(nil)
@@ -22,6 +22,12 @@ const (
 	// defaultFailedTimeout is the default time till an Agent transitions to failed after disconnected
 	defaultFailedTimeout = 25 * time.Second
 
+	// defaultConsentCheckInterval is the default interval of the consent freshness checks (RFC 7675 section 5.1)
+	defaultConsentCheckInterval = 5 * time.Second
+
+	// defaultConsentTimeout is the default time without a consent after which the consent is lost (RFC 7675 section 5.1)
+	defaultConsentTimeout = 30 * time.Second
+
 	// wait time before nominating a host candidate
 	defaultHostAcceptanceMinWait = 0
 
@@ -84,6 +90,17 @@ type AgentConfig struct {
 	// A keepalive interval of 0 means we never send keepalive packets
 	KeepaliveInterval *time.Duration
 
+	// ConsentCheckInterval determines how often the consent of the remote peer to receive
+	// the traffic of the selected pair is refreshed with authenticated binding requests (RFC 7675),
+	// each check is randomized between 0.8 and 1.2 times the interval.
+	// When this is nil, it defaults to 5 seconds. An interval of 0 disables the consent checks.
+	ConsentCheckInterval *time.Duration
+
+	// ConsentTimeout is how long the selected pair can go without an authenticated binding response
+	// before the consent is lost and the agent goes to ConnectionStateConsentLost.
+	// When this is nil, it defaults to 30 seconds. A timeout of 0 means the consent never expires.
+	ConsentTimeout *time.Duration
+
 	// CheckInterval controls how often our task loop runs when in the
 	// connecting state.
 	CheckInterval *time.Duration
@@ -146,10 +163,15 @@ type AgentConfig struct {
 	InsecureSkipVerify bool
 
 	// TCPMux will be used for multiplexing incoming TCP connections for ICE TCP.
-	// Currently only passive candidates are supported. This functionality is
+	// It accepts the connections of the passive candidates. This functionality is
 	// experimental and the API might change in the future.
 	TCPMux TCPMux
 
+	// TCPTypes are the types of the TCP host candidates gathered for the TCP network types (RFC 6544).
+	// Passive candidates need TCPMux, active and simultaneous-open ones connect to the remote candidates themselves.
+	// Defaults to passive candidates only.
+	TCPTypes []TCPType
+
 	// UDPMux is used for multiplexing multiple incoming UDP connections on a single port
 	// when this is set, the agent ignores PortMin and PortMax configurations and will
 	// defer to UDPMux for incoming connections
@@ -161,6 +183,16 @@ type AgentConfig struct {
 	// It embeds UDPMux to do the actual connection multiplexing
 	UDPMuxSrflx UniversalUDPMux
 
+	// UDPMuxMappedAddrs are the external addresses forwarded to the port of the UDPMux by a port mapping
+	// of the gateway (UPnP-IGD, NAT-PMP or PCP). They are gathered as server reflexive candidates that share
+	// the muxed connection of the host candidates.
+	UDPMuxMappedAddrs []*net.UDPAddr
+
+	// TURNPool shares the TURN allocations between the agents, when this is set the agent takes its relayed
+	// addresses from the pool instead of making its own allocations.
+	// The plain UDP allocations are made over the UDPMuxSrflx if that is set.
+	TURNPool *TURNPool
+
 	// Proxy Dialer is a dialer that should be implemented by the user based on golang.org/x/net/proxy
 	// dial interface in order to support corporate proxies
 	ProxyDialer proxy.Dialer
@@ -222,6 +254,18 @@ func (config *AgentConfig) initWithDefaults(a *Agent) {
 		a.keepaliveInterval = *config.KeepaliveInterval
 	}
 
+	if config.ConsentCheckInterval == nil {
+		a.consentCheckInterval = defaultConsentCheckInterval
+	} else {
+		a.consentCheckInterval = *config.ConsentCheckInterval
+	}
+
+	if config.ConsentTimeout == nil {
+		a.consentTimeout = defaultConsentTimeout
+	} else {
+		a.consentTimeout = *config.ConsentTimeout
+	}
+
 	if config.CheckInterval == nil {
 		a.checkInterval = defaultCheckInterval
 	} else {
@@ -2,6 +2,7 @@ package ice
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,36 +12,7 @@ func (a *Agent) GetCandidatePairsStats() []CandidatePairStats {
 	err := a.run(a.context(), func(ctx context.Context, agent *Agent) {
 		result := make([]CandidatePairStats, 0, len(agent.checklist))
 		for _, cp := range agent.checklist {
-			stat := CandidatePairStats{
-				Timestamp:         time.Now(),
-				LocalCandidateID:  cp.Local.ID(),
-				RemoteCandidateID: cp.Remote.ID(),
-				State:             cp.state,
-				Nominated:         cp.nominated,
-				// PacketsSent uint32
-				// PacketsReceived uint32
-				// BytesSent uint64
-				// BytesReceived uint64
-				// LastPacketSentTimestamp time.Time
-				// LastPacketReceivedTimestamp time.Time
-				// FirstRequestTimestamp time.Time
-				// LastRequestTimestamp time.Time
-				// LastResponseTimestamp time.Time
-				// TotalRoundTripTime float64
-				// CurrentRoundTripTime float64
-				// AvailableOutgoingBitrate float64
-				// AvailableIncomingBitrate float64
-				// CircuitBreakerTriggerCount uint32
-				// RequestsReceived uint64
-				// RequestsSent uint64
-				// ResponsesReceived uint64
-				// ResponsesSent uint64
-				// RetransmissionsReceived uint64
-				// RetransmissionsSent uint64
-				// ConsentRequestsSent uint64
-				// ConsentExpiredTimestamp time.Time
-			}
-			result = append(result, stat)
+			result = append(result, cp.stats())
 		}
 		res = result
 	})
@@ -51,6 +23,59 @@ func (a *Agent) GetCandidatePairsStats() []CandidatePairStats {
 	return res
 }
 
+// GetSelectedCandidatePairStats returns the stats of the selected candidate pair, false if no pair has been selected
+func (a *Agent) GetSelectedCandidatePairStats() (CandidatePairStats, bool) {
+	var res CandidatePairStats
+	var ok bool
+	err := a.run(a.context(), func(ctx context.Context, agent *Agent) {
+		selectedPair := agent.getSelectedPair()
+		if selectedPair == nil {
+			return
+		}
+		res = selectedPair.stats()
+		ok = true
+	})
+	if err != nil {
+		a.log.Errorf("error getting selected candidate pair stats %v", err)
+		return CandidatePairStats{}, false
+	}
+	return res, ok
+}
+
+// stats returns the stats of the pair
+// Note: the caller should hold the agent lock.
+func (p *CandidatePair) stats() CandidatePairStats {
+	return CandidatePairStats{
+		Timestamp:                   time.Now(),
+		LocalCandidateID:            p.Local.ID(),
+		RemoteCandidateID:           p.Remote.ID(),
+		State:                       p.state,
+		Nominated:                   p.nominated,
+		PacketsSent:                 atomic.LoadUint32(&p.packetsSent),
+		PacketsReceived:             atomic.LoadUint32(&p.packetsReceived),
+		BytesSent:                   atomic.LoadUint64(&p.bytesSent),
+		BytesReceived:               atomic.LoadUint64(&p.bytesReceived),
+		LastPacketSentTimestamp:     p.Local.LastSent(),
+		LastPacketReceivedTimestamp: p.Remote.LastReceived(),
+		FirstRequestTimestamp:       p.firstRequestTimestamp,
+		LastRequestTimestamp:        p.lastRequestTimestamp,
+		LastResponseTimestamp:       p.lastResponseTimestamp,
+		TotalRoundTripTime:          p.totalRoundTripTime.Seconds(),
+		CurrentRoundTripTime:        p.currentRoundTripTime.Seconds(),
+		// AvailableOutgoingBitrate float64
+		// AvailableIncomingBitrate float64
+		// CircuitBreakerTriggerCount uint32
+		RequestsReceived:  p.requestsReceived,
+		RequestsSent:      p.requestsSent,
+		ResponsesReceived: p.responsesReceived,
+		ResponsesSent:     p.responsesSent,
+		// RetransmissionsReceived uint64
+		// RetransmissionsSent uint64
+		ConsentRequestsSent: p.consentRequestsSent,
+		// ConsentExpiredTimestamp time.Time
+	}
+}
+
 // GetLocalCandidatesStats returns a list of local candidates stats
 func (a *Agent) GetLocalCandidatesStats() []CandidateStats {
 	var res []CandidateStats
@@ -927,6 +927,51 @@ func TestCandidatePairStats(t *testing.T) {
 	assert.NoError(t, a.Close())
 }
 
+func TestSelectedCandidatePairStats(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
+	cfg := &AgentConfig{NetworkTypes: []NetworkType{NetworkTypeUDP4}}
+	aAgent, err := NewAgent(cfg)
+	require.NoError(t, err)
+	bAgent, err := NewAgent(cfg)
+	require.NoError(t, err)
+
+	_, ok := aAgent.GetSelectedCandidatePairStats()
+	assert.False(t, ok, "no pair has been selected yet")
+
+	aConn, bConn := connect(aAgent, bAgent)
+
+	data := []byte("data")
+	_, err = aConn.Write(data)
+	require.NoError(t, err)
+	buf := make([]byte, receiveMTU)
+	_, err = bConn.Read(buf)
+	require.NoError(t, err)
+
+	stats, ok := aAgent.GetSelectedCandidatePairStats()
+	require.True(t, ok)
+	assert.True(t, stats.Nominated)
+	assert.Equal(t, uint32(1), stats.PacketsSent)
+	assert.Equal(t, uint64(len(data)), stats.BytesSent)
+	assert.NotZero(t, stats.RequestsSent)
+	assert.NotZero(t, stats.ResponsesReceived)
+	assert.Greater(t, stats.CurrentRoundTripTime, float64(0))
+	assert.GreaterOrEqual(t, stats.TotalRoundTripTime, stats.CurrentRoundTripTime)
+
+	stats, ok = bAgent.GetSelectedCandidatePairStats()
+	require.True(t, ok)
+	assert.Equal(t, uint32(1), stats.PacketsReceived)
+	assert.Equal(t, uint64(len(data)), stats.BytesReceived)
+	assert.NotZero(t, stats.ResponsesSent)
+
+	assert.NoError(t, aAgent.Close())
+	assert.NoError(t, bAgent.Close())
+}
+
 func TestLocalCandidateStats(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
@@ -1304,6 +1349,195 @@ func TestConnectionStateFailedDeleteAllCandidates(t *testing.T) {
 	assert.NoError(t, bAgent.Close())
 }
 
+func TestConnectionStateConsentLost(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
+	// only the consent checks detect that the remote agent has stopped responding
+	disconnectedTimeout := time.Minute
+	noTimeout := time.Duration(0)
+	consentCheckInterval := 50 * time.Millisecond
+	consentTimeout := 500 * time.Millisecond
+
+	cfg := &AgentConfig{
+		NetworkTypes:         supportedNetworkTypes(),
+		DisconnectedTimeout:  &disconnectedTimeout,
+		FailedTimeout:        &noTimeout,
+		KeepaliveInterval:    &noTimeout,
+		ConsentCheckInterval: &consentCheckInterval,
+		ConsentTimeout:       &consentTimeout,
+	}
+
+	aAgent, err := NewAgent(cfg)
+	require.NoError(t, err)
+	bAgent, err := NewAgent(cfg)
+	require.NoError(t, err)
+
+	consentLost := make(chan struct{})
+	require.NoError(t, aAgent.OnConnectionStateChange(func(c ConnectionState) {
+		if c == ConnectionStateConsentLost {
+			close(consentLost)
+		}
+	}))
+
+	aConn, _ := connect(aAgent, bAgent)
+
+	// the consent checks keep the consent of the selected pair fresh
+	select {
+	case <-consentLost:
+		t.Fatal("consent shouldn't be lost while the remote agent responds")
+	case <-time.After(2 * consentTimeout):
+	}
+	_, err = aConn.Write([]byte("data"))
+	assert.NoError(t, err)
+
+	// the remote agent stops responding
+	require.NoError(t, bAgent.Close())
+	<-consentLost
+
+	_, err = aConn.Write([]byte("data"))
+	assert.ErrorIs(t, err, ErrConsentLost)
+
+	done := make(chan struct{})
+	require.NoError(t, aAgent.run(context.Background(), func(ctx context.Context, agent *Agent) {
+		assert.Empty(t, agent.localCandidates)
+		close(done)
+	}))
+	<-done
+
+	assert.NoError(t, aAgent.Close())
+}
+
+func TestRemoteGatheringCompleteFailsFast(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
+	// only the end of candidates can fail the connection within the test
+	timeout := time.Minute
+	agent, err := NewAgent(&AgentConfig{
+		NetworkTypes:        []NetworkType{NetworkTypeUDP4},
+		CandidateTypes:      []CandidateType{CandidateTypeHost},
+		DisconnectedTimeout: &timeout,
+		FailedTimeout:       &timeout,
+		IncludeLoopback:     true,
+	})
+	require.NoError(t, err)
+
+	// the remote candidate never answers the checks
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	defer func() {
+		_ = silent.Close()
+	}()
+	remote, err := NewCandidateHost(&CandidateHostConfig{
+		Network:   "udp",
+		Address:   "127.0.0.1",
+		Port:      silent.LocalAddr().(*net.UDPAddr).Port, //nolint:forcetypeassert
+		Component: ComponentRTP,
+	})
+	require.NoError(t, err)
+
+	gathered := make(chan struct{})
+	require.NoError(t, agent.OnCandidate(func(c Candidate) {
+		if c == nil {
+			close(gathered)
+		}
+	}))
+	failed := make(chan struct{})
+	require.NoError(t, agent.OnConnectionStateChange(func(c ConnectionState) {
+		if c == ConnectionStateFailed {
+			close(failed)
+		}
+	}))
+
+	dialDone := make(chan struct{})
+	go func() {
+		defer close(dialDone)
+		_, dialErr := agent.Dial(context.Background(), "remoteUfrag", "remotePwd")
+		assert.Error(t, dialErr)
+	}()
+	require.NoError(t, agent.GatherCandidates())
+	<-gathered
+	require.NoError(t, agent.AddRemoteCandidate(remote))
+
+	// the remote peer may still signal candidates
+	select {
+	case <-failed:
+		t.Fatal("connection shouldn't fail before the end of the remote candidates")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	require.NoError(t, agent.SetRemoteGatheringComplete())
+	<-failed
+
+	assert.NoError(t, agent.Close())
+	<-dialDone
+}
+
+func TestRemoteGatheringCompleteBeforeCandidates(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
+	timeout := time.Minute
+	agent, err := NewAgent(&AgentConfig{
+		NetworkTypes:        []NetworkType{NetworkTypeUDP4},
+		CandidateTypes:      []CandidateType{CandidateTypeHost},
+		DisconnectedTimeout: &timeout,
+		FailedTimeout:       &timeout,
+	})
+	require.NoError(t, err)
+
+	gathered := make(chan struct{})
+	require.NoError(t, agent.OnCandidate(func(c Candidate) {
+		if c == nil {
+			close(gathered)
+		}
+	}))
+	failed := make(chan struct{})
+	require.NoError(t, agent.OnConnectionStateChange(func(c ConnectionState) {
+		if c == ConnectionStateFailed {
+			close(failed)
+		}
+	}))
+
+	dialDone := make(chan struct{})
+	go func() {
+		defer close(dialDone)
+		_, dialErr := agent.Dial(context.Background(), "remoteUfrag", "remotePwd")
+		assert.Error(t, dialErr)
+	}()
+	require.NoError(t, agent.GatherCandidates())
+	<-gathered
+
+	// the end of candidates overtook the candidates, the empty checklist mustn't fail the connection
+	require.NoError(t, agent.SetRemoteGatheringComplete())
+	select {
+	case <-failed:
+		t.Fatal("connection shouldn't fail without any candidate pair")
+	case <-time.After(time.Second):
+	}
+
+	assert.NoError(t, agent.Close())
+	<-dialDone
+}
+
+func TestRandomizeConsentInterval(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		interval := randomizeConsentInterval(5 * time.Second)
+		assert.GreaterOrEqual(t, interval, 4*time.Second)
+		assert.LessOrEqual(t, interval, 6*time.Second)
+	}
+}
+
 // Assert that the ICE Agent can go directly from Connecting -> Failed on both sides
 func TestConnectionStateConnectingToFailed(t *testing.T) {
 	report := test.CheckRoutines(t)
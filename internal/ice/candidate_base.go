@@ -189,6 +189,11 @@ func (c *candidateBase) RelatedAddress() *CandidateRelatedAddress {
 	return c.relatedAddress
 }
 
+// hideRelatedAddress drops the related address, it would reveal the local address behind the candidate
+func (c *candidateBase) hideRelatedAddress() {
+	c.relatedAddress = nil
+}
+
 func (c *candidateBase) TCPType() TCPType {
 	return c.tcpType
 }
@@ -267,6 +272,10 @@ func (c *candidateBase) handleInboundPacket(buf []byte, srcAddr net.Addr) {
 		a.log.Warnf("Failed to write packet: %s", err)
 		return
 	}
+
+	if p := a.getSelectedPair(); p != nil && addrEqual(p.Remote.addr(), srcAddr) {
+		p.dataReceived(len(buf))
+	}
 }
 
 // close stops the recvLoop
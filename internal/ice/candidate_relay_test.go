@@ -4,6 +4,7 @@
 package ice
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/pion/transport/test"
 	"github.com/pion/turn/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func optimisticAuthHandler(username string, realm string, srcAddr net.Addr) (key []byte, ok bool) {
@@ -85,3 +87,210 @@ func TestRelayOnlyConnection(t *testing.T) {
 	assert.NoError(t, bAgent.Close())
 	assert.NoError(t, server.Close())
 }
+
+func TestRelayOnlyConnectionUDPMux(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	serverPort := randomPort(t)
+	serverListener, err := net.ListenPacket("udp4", "127.0.0.1:"+strconv.Itoa(serverPort))
+	assert.NoError(t, err)
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       "pion.ly",
+		AuthHandler: optimisticAuthHandler,
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            serverListener,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	newAgent := func() (*Agent, *UniversalUDPMuxDefault) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+		udpMux := NewUniversalUDPMuxDefault(UniversalUDPMuxParams{UDPConn: conn})
+
+		agent, err := NewAgent(&AgentConfig{
+			NetworkTypes: []NetworkType{NetworkTypeUDP4},
+			Urls: []*URL{
+				{
+					Scheme:   SchemeTypeTURN,
+					Host:     "127.0.0.1",
+					Username: "username",
+					Password: "password",
+					Port:     serverPort,
+					Proto:    ProtoTypeUDP,
+				},
+			},
+			CandidateTypes: []CandidateType{CandidateTypeRelay},
+			UDPMuxSrflx:    udpMux,
+		})
+		assert.NoError(t, err)
+		return agent, udpMux
+	}
+
+	aAgent, aMux := newAgent()
+	aNotifier, aConnected := onConnected()
+	assert.NoError(t, aAgent.OnConnectionStateChange(aNotifier))
+	bAgent, bMux := newAgent()
+	bNotifier, bConnected := onConnected()
+	assert.NoError(t, bAgent.OnConnectionStateChange(bNotifier))
+
+	connect(aAgent, bAgent)
+	<-aConnected
+	<-bConnected
+
+	// the relayed candidate is the shared allocation of the mux
+	pair, err := aAgent.GetSelectedCandidatePair()
+	assert.NoError(t, err)
+	assert.Equal(t, CandidateTypeRelay, pair.Local.Type())
+	aMux.relayPool.mu.Lock()
+	assert.Len(t, aMux.relayPool.allocations, 1)
+	for _, entry := range aMux.relayPool.allocations {
+		assert.Equal(t, entry.mux.LocalAddr().String(), pair.Local.addr().String())
+	}
+	aMux.relayPool.mu.Unlock()
+	assert.Equal(t, aMux.LocalAddr().(*net.UDPAddr).Port, pair.Local.RelatedAddress().Port) //nolint:forcetypeassert
+
+	assert.NoError(t, aAgent.Close())
+	assert.NoError(t, bAgent.Close())
+	assert.NoError(t, aMux.Close())
+	assert.NoError(t, bMux.Close())
+	assert.NoError(t, server.Close())
+}
+
+// relayAddressGeneratorIPv6 relays on the IPv6 loopback, the generators of the server only listen on IPv4
+type relayAddressGeneratorIPv6 struct {
+	turn.RelayAddressGeneratorNone
+}
+
+func (r *relayAddressGeneratorIPv6) Validate() error {
+	return nil
+}
+
+func (r *relayAddressGeneratorIPv6) AllocatePacketConn(string, int) (net.PacketConn, net.Addr, error) {
+	conn, err := net.ListenPacket(udp6, "[::1]:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.LocalAddr(), nil
+}
+
+func TestUDPMuxRelayIPv6(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	serverListener, err := net.ListenPacket(udp6, "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       "pion.ly",
+		AuthHandler: optimisticAuthHandler,
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            serverListener,
+				RelayAddressGenerator: &relayAddressGeneratorIPv6{},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	conn, err := net.ListenUDP(udp6, &net.UDPAddr{IP: net.IPv6loopback})
+	require.NoError(t, err)
+	udpMux := NewUniversalUDPMuxDefault(UniversalUDPMuxParams{UDPConn: conn})
+	defer udpMux.Close() //nolint:errcheck
+
+	agent, err := NewAgent(&AgentConfig{
+		NetworkTypes: []NetworkType{NetworkTypeUDP6},
+		Urls: []*URL{
+			{
+				Scheme:   SchemeTypeTURN,
+				Host:     "::1",
+				Username: "username",
+				Password: "password",
+				Port:     serverListener.LocalAddr().(*net.UDPAddr).Port, //nolint:forcetypeassert
+				Proto:    ProtoTypeUDP,
+			},
+		},
+		CandidateTypes: []CandidateType{CandidateTypeRelay},
+		UDPMuxSrflx:    udpMux,
+	})
+	require.NoError(t, err)
+	defer agent.Close() //nolint:errcheck
+
+	gathered := make(chan struct{})
+	require.NoError(t, agent.OnCandidate(func(candidate Candidate) {
+		if candidate == nil {
+			close(gathered)
+		}
+	}))
+	require.NoError(t, agent.GatherCandidates())
+	<-gathered
+
+	candidates, err := agent.GetLocalCandidates()
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, NetworkTypeUDP6, candidates[0].NetworkType())
+	assert.Equal(t, net.IPv6loopback.String(), candidates[0].Address())
+	assert.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, candidates[0].RelatedAddress().Port) //nolint:forcetypeassert
+}
+
+func TestUDPMuxRelayCredentialsChange(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	serverListener, err := net.ListenPacket(udp4, "127.0.0.1:0")
+	require.NoError(t, err)
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: "pion.ly",
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return turn.GenerateAuthKey(username, realm, "password"), true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            serverListener,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	conn, err := net.ListenUDP(udp4, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	udpMux := NewUniversalUDPMuxDefault(UniversalUDPMuxParams{UDPConn: conn})
+	defer udpMux.Close() //nolint:errcheck
+
+	serverAddr := serverListener.LocalAddr()
+	url := URL{
+		Scheme:   SchemeTypeTURN,
+		Host:     "127.0.0.1",
+		Port:     serverAddr.(*net.UDPAddr).Port, //nolint:forcetypeassert
+		Username: "1000:user",
+		Password: "password",
+		Proto:    ProtoTypeUDP,
+	}
+	_, _, err = udpMux.GetRelayedConn(context.Background(), "ufrag1", url, serverAddr, NetworkTypeUDP4)
+	require.NoError(t, err)
+
+	// the short-term credentials have been rotated
+	rotated := url
+	rotated.Username = "2000:user"
+	_, relayConfig, err := udpMux.GetRelayedConn(context.Background(), "ufrag2", rotated, serverAddr, NetworkTypeUDP4)
+	require.NoError(t, err, "the allocation with the previous credentials should be replaced")
+
+	udpMux.relayPool.mu.Lock()
+	defer udpMux.relayPool.mu.Unlock()
+	require.Len(t, udpMux.relayPool.allocations, 1)
+	entry, ok := udpMux.relayPool.allocations[turnPoolKey(rotated, NetworkTypeUDP4)]
+	require.True(t, ok)
+	assert.Equal(t, entry.mux.LocalAddr().(*net.UDPAddr).Port, relayConfig.Port) //nolint:forcetypeassert
+}
@@ -2,6 +2,8 @@ package ice
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/stun"
 )
@@ -25,6 +27,33 @@ type CandidatePair struct {
 	state                    CandidatePairState
 	nominated                bool
 	nominateOnBindingSuccess bool
+
+	// lastConsent is when the last authenticated binding success response of the pair has been received
+	lastConsent time.Time
+	// nextConsentCheck is when the next consent freshness check of the selected pair is due
+	nextConsentCheck time.Time
+
+	// currentRoundTripTime is the round trip time of the last binding request answered on the pair, 0 if unknown
+	currentRoundTripTime time.Duration
+	totalRoundTripTime   time.Duration
+
+	// the connectivity check counters, guarded by the agent lock
+	requestsSent          uint64
+	requestsReceived      uint64
+	responsesSent         uint64
+	responsesReceived     uint64
+	consentRequestsSent   uint64
+	firstRequestTimestamp time.Time
+	lastRequestTimestamp  time.Time
+	lastResponseTimestamp time.Time
+
+	// the data counters are updated atomically by the reads and writes of the Conn
+	packetsSent     uint32
+	packetsReceived uint32
+	bytesSent       uint64
+	bytesReceived   uint64
+	// nomination is the NOMINATION of the controlling agent waiting for the binding success of the pair
+	nomination uint32
 }
 
 func (p *CandidatePair) String() string {
@@ -88,7 +117,37 @@ func (p *CandidatePair) priority() uint64 {
 }
 
 func (p *CandidatePair) Write(b []byte) (int, error) {
-	return p.Local.writeTo(b, p.Remote)
+	n, err := p.Local.writeTo(b, p.Remote)
+	if err == nil {
+		atomic.AddUint32(&p.packetsSent, 1)
+		atomic.AddUint64(&p.bytesSent, uint64(n))
+	}
+	return n, err
+}
+
+// dataReceived counts a packet of the Conn received on the pair
+func (p *CandidatePair) dataReceived(n int) {
+	atomic.AddUint32(&p.packetsReceived, 1)
+	atomic.AddUint64(&p.bytesReceived, uint64(n))
+}
+
+// requestSent counts a binding request sent on the pair
+// Note: the caller should hold the agent lock.
+func (p *CandidatePair) requestSent(now time.Time) {
+	p.requestsSent++
+	if p.firstRequestTimestamp.IsZero() {
+		p.firstRequestTimestamp = now
+	}
+	p.lastRequestTimestamp = now
+}
+
+// responseReceived counts a binding success response of the pair with its round trip time
+// Note: the caller should hold the agent lock.
+func (p *CandidatePair) responseReceived(rtt time.Duration) {
+	p.responsesReceived++
+	p.lastResponseTimestamp = time.Now()
+	p.currentRoundTripTime = rtt
+	p.totalRoundTripTime += rtt
 }
 
 func (a *Agent) sendSTUN(msg *stun.Message, local, remote Candidate) {
@@ -38,6 +38,9 @@ var (
 	// ErrNoCandidatePairs indicates agent does not have a valid candidate pair
 	ErrNoCandidatePairs = errors.New("no candidate pairs available")
 
+	// ErrConsentLost indicates the remote peer no longer consents to receive the traffic of the selected pair
+	ErrConsentLost = errors.New("consent of the selected candidate pair lost")
+
 	// ErrCanceledByCaller indicates agent connection was canceled by the caller
 	ErrCanceledByCaller = errors.New("connecting canceled by caller")
 
@@ -135,6 +138,11 @@ var (
 	errSendSTUNPacket                = errors.New("failed to send STUN packet")
 	errXORMappedAddrTimeout          = errors.New("timeout while waiting for XORMappedAddr")
 	errNotImplemented                = errors.New("not implemented yet")
+	errTURNRefreshTimeout            = errors.New("no response to the TURN refresh")
+	errTURNRefreshFailed             = errors.New("TURN refresh failed")
+	errUnhandledTURNURL              = errors.New("unable to handle TURN URL")
+	errRelayedAddressFamily          = errors.New("TURN server relayed another address family")
+	errTURNPoolClosed                = errors.New("TURN pool is closed")
 	errNoUDPMuxAvailable             = errors.New("no UDP mux is available")
 	errNoTCPMuxAvailable             = errors.New("no TCP mux is available")
 	errInvalidAddress                = errors.New("invalid address")
@@ -8,6 +8,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -113,6 +114,13 @@ func (a *Agent) gatherCandidates(ctx context.Context) {
 				}
 				wg.Done()
 			}()
+			if a.udpMux != nil && len(a.udpMuxMappedAddrs) > 0 {
+				wg.Add(1)
+				go func() {
+					a.gatherCandidatesUDPMuxMapped(ctx)
+					wg.Done()
+				}()
+			}
 			if a.extIPMapper != nil && a.extIPMapper.candidateType == CandidateTypeServerReflexive {
 				wg.Add(1)
 				go func() {
@@ -178,55 +186,24 @@ func (a *Agent) gatherCandidatesLocal(ctx context.Context, networkTypes []Networ
 		}
 
 		for network := range networks {
-			type connAndPort struct {
-				conn net.PacketConn
-				port int
-			}
-			var (
-				conns   []connAndPort
-				tcpType TCPType
-			)
+			var conns []connAndPort
 
 			switch network {
 			case tcp:
-				// Handle ICE TCP passive mode
-				var muxConns []net.PacketConn
-				if multi, ok := a.tcpMux.(AllConnsGetter); ok {
-					a.log.Debugf("GetAllConns by ufrag: %s", a.localUfrag)
-					muxConns, err = multi.GetAllConns(a.localUfrag, mappedIP.To4() == nil, ip)
-					if err != nil {
-						if !errors.Is(err, ErrTCPMuxNotInitialized) {
-							a.log.Warnf("error getting all tcp conns by ufrag: %s %s %s", network, ip, a.localUfrag)
+				for _, tcpType := range a.tcpTypes {
+					switch tcpType {
+					case TCPTypePassive:
+						conns = append(conns, a.passiveTCPConns(ip, mappedIP)...)
+					case TCPTypeActive, TCPTypeSimultaneousOpen:
+						if conn, ok := a.newActiveTCPConn(tcpType, ip); ok {
+							conns = append(conns, conn)
 						}
-						continue
-					}
-				} else {
-					a.log.Debugf("GetConn by ufrag: %s", a.localUfrag)
-					conn, err := a.tcpMux.GetConnByUfrag(a.localUfrag, mappedIP.To4() == nil, ip)
-					if err != nil {
-						if !errors.Is(err, ErrTCPMuxNotInitialized) {
-							a.log.Warnf("error getting tcp conn by ufrag: %s %s %s", network, ip, a.localUfrag)
-						}
-						continue
-					}
-					muxConns = []net.PacketConn{conn}
-				}
-
-				// Extract the port for each PacketConn we got.
-				for _, conn := range muxConns {
-					if tcpConn, ok := conn.LocalAddr().(*net.TCPAddr); ok {
-						conns = append(conns, connAndPort{conn, tcpConn.Port})
-					} else {
-						a.log.Warnf("failed to get port of conn from TCPMux: %s %s %s", network, ip, a.localUfrag)
 					}
 				}
 				if len(conns) == 0 {
 					// Didn't succeed with any, try the next network.
 					continue
 				}
-				tcpType = TCPTypePassive
-				// is there a way to verify that the listen address is even
-				// accessible from the current interface.
 			case udp:
 				conn, err := listenUDPInPortRange(a.net, a.log, int(a.portMax), int(a.portMin), network, &net.UDPAddr{IP: ip, Port: 0})
 				if err != nil {
@@ -235,7 +212,7 @@ func (a *Agent) gatherCandidatesLocal(ctx context.Context, networkTypes []Networ
 				}
 
 				if udpConn, ok := conn.LocalAddr().(*net.UDPAddr); ok {
-					conns = append(conns, connAndPort{conn, udpConn.Port})
+					conns = append(conns, connAndPort{conn: conn, port: udpConn.Port})
 				} else {
 					a.log.Warnf("failed to get port of UDPAddr from ListenUDPInPortRange: %s %s %s", network, ip, a.localUfrag)
 					continue
@@ -248,7 +225,7 @@ func (a *Agent) gatherCandidatesLocal(ctx context.Context, networkTypes []Networ
 					Address:   address,
 					Port:      connAndPort.port,
 					Component: ComponentRTP,
-					TCPType:   tcpType,
+					TCPType:   connAndPort.tcpType,
 				}
 
 				c, err := NewCandidateHost(&hostConfig)
@@ -275,6 +252,72 @@ func (a *Agent) gatherCandidatesLocal(ctx context.Context, networkTypes []Networ
 	}
 }
 
+// connAndPort is a connection of a host candidate
+type connAndPort struct {
+	conn    net.PacketConn
+	port    int
+	tcpType TCPType
+}
+
+// passiveTCPConns returns the connections of the passive TCP candidates on the local IP, provided by the TCPMux
+func (a *Agent) passiveTCPConns(ip, mappedIP net.IP) []connAndPort {
+	var muxConns []net.PacketConn
+	if multi, ok := a.tcpMux.(AllConnsGetter); ok {
+		a.log.Debugf("GetAllConns by ufrag: %s", a.localUfrag)
+		conns, err := multi.GetAllConns(a.localUfrag, mappedIP.To4() == nil, ip)
+		if err != nil {
+			if !errors.Is(err, ErrTCPMuxNotInitialized) {
+				a.log.Warnf("error getting all tcp conns by ufrag: %s %s %s", tcp, ip, a.localUfrag)
+			}
+			return nil
+		}
+		muxConns = conns
+	} else {
+		a.log.Debugf("GetConn by ufrag: %s", a.localUfrag)
+		conn, err := a.tcpMux.GetConnByUfrag(a.localUfrag, mappedIP.To4() == nil, ip)
+		if err != nil {
+			if !errors.Is(err, ErrTCPMuxNotInitialized) {
+				a.log.Warnf("error getting tcp conn by ufrag: %s %s %s", tcp, ip, a.localUfrag)
+			}
+			return nil
+		}
+		muxConns = []net.PacketConn{conn}
+	}
+
+	// Extract the port for each PacketConn we got.
+	// is there a way to verify that the listen address is even
+	// accessible from the current interface.
+	var conns []connAndPort
+	for _, conn := range muxConns {
+		if tcpConn, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			conns = append(conns, connAndPort{conn: conn, port: tcpConn.Port, tcpType: TCPTypePassive})
+		} else {
+			a.log.Warnf("failed to get port of conn from TCPMux: %s %s %s", tcp, ip, a.localUfrag)
+		}
+	}
+	return conns
+}
+
+// newActiveTCPConn creates the connection of an active or a simultaneous-open TCP candidate on the local IP.
+// The connections to the remote candidates are made once the candidate gets paired.
+func (a *Agent) newActiveTCPConn(tcpType TCPType, ip net.IP) (connAndPort, bool) {
+	if a.net.IsVirtual() {
+		a.log.Debugf("%s TCP candidates are not supported by vnet", tcpType)
+		return connAndPort{}, false
+	}
+
+	if tcpType == TCPTypeActive {
+		return connAndPort{conn: newActiveTCPConn(a.context(), ip, a.log), port: activeTCPPort, tcpType: tcpType}, true
+	}
+
+	conn, err := newSimultaneousOpenTCPConn(a.context(), ip, a.log)
+	if err != nil {
+		a.log.Warnf("could not listen for %s TCP candidate %s: %v", tcpType, ip, err)
+		return connAndPort{}, false
+	}
+	return connAndPort{conn: conn, port: conn.localAddr.Port, tcpType: tcpType}, true
+}
+
 func (a *Agent) gatherCandidatesLocalUDPMux(ctx context.Context) error { //nolint:gocognit
 	if a.udpMux == nil {
 		return errUDPMuxDisabled
@@ -282,18 +325,43 @@ func (a *Agent) gatherCandidatesLocalUDPMux(ctx context.Context) error { //nolin
 
 	localAddresses := a.udpMux.GetListenAddresses()
 
+	// the mux may listen on all the addresses, the filters still apply to its candidates
+	var allowedIPs []net.IP
+	if a.interfaceFilter != nil || a.ipFilter != nil {
+		var err error
+		if allowedIPs, err = localInterfaces(a.net, a.interfaceFilter, a.ipFilter, a.networkTypes, true); err != nil {
+			return err
+		}
+	}
+
+	// the mDNS name stands for all the local addresses, a single candidate is gathered per IP version
+	mDNSGathered := map[bool]bool{}
+
 	for _, addr := range localAddresses {
 		udpAddr, ok := addr.(*net.UDPAddr)
 		if !ok {
 			return errInvalidAddress
 		}
 		candidateIP := udpAddr.IP
+		if allowedIPs != nil && !containsIP(allowedIPs, candidateIP) {
+			a.log.Debugf("Skipping filtered mux address %s", candidateIP)
+			continue
+		}
+		address := candidateIP.String()
+		if a.mDNSMode == MulticastDNSModeQueryAndGather {
+			isIPv6 := candidateIP.To4() == nil
+			if mDNSGathered[isIPv6] {
+				continue
+			}
+			mDNSGathered[isIPv6] = true
+			address = a.mDNSName
+		}
 		if a.extIPMapper != nil && a.extIPMapper.candidateType == CandidateTypeHost {
 			if mappedIP, innerErr := a.extIPMapper.findExternalIP(candidateIP.String()); innerErr != nil {
 				a.log.Warnf("1:1 NAT mapping is enabled but no external IP is found for %s", candidateIP.String())
 				continue
 			} else {
-				candidateIP = mappedIP
+				address = mappedIP.String()
 			}
 		}
 
@@ -303,7 +371,7 @@ func (a *Agent) gatherCandidatesLocalUDPMux(ctx context.Context) error { //nolin
 		}
 		hostConfig := CandidateHostConfig{
 			Network:   udp,
-			Address:   candidateIP.String(),
+			Address:   address,
 			Port:      udpAddr.Port,
 			Component: ComponentRTP,
 		}
@@ -387,6 +455,87 @@ func (a *Agent) gatherCandidatesSrflxMapped(ctx context.Context, networkTypes []
 	}
 }
 
+// gatherCandidatesUDPMuxMapped gathers the server reflexive candidates of the port mappings of the UDPMux port.
+// Their base is a listen address of the UDPMux of the same IP version.
+func (a *Agent) gatherCandidatesUDPMuxMapped(ctx context.Context) {
+	for _, mappedAddr := range a.udpMuxMappedAddrs {
+		isIPv6 := mappedAddr.IP.To4() == nil
+		if !a.isIPVersionEnabled(isIPv6) {
+			continue
+		}
+
+		// the gateway forwards to the address the packets to the internet are sent from, a loopback address
+		// is only the base if the UDPMux doesn't listen on another one
+		sourceIP := a.sourceIPFor(mappedAddr.IP)
+		var baseAddr *net.UDPAddr
+		for _, addr := range a.udpMux.GetListenAddresses() {
+			udpAddr, ok := addr.(*net.UDPAddr)
+			if !ok || (udpAddr.IP.To4() == nil) != isIPv6 {
+				continue
+			}
+			if udpAddr.IP.Equal(sourceIP) {
+				baseAddr = udpAddr
+				break
+			}
+			if baseAddr == nil || baseAddr.IP.IsLoopback() {
+				baseAddr = udpAddr
+			}
+		}
+		if baseAddr == nil {
+			a.log.Warnf("No UDPMux listen address for the port mapping %s", mappedAddr)
+			continue
+		}
+
+		conn, err := a.udpMux.GetConn(a.localUfrag, baseAddr)
+		if err != nil {
+			a.log.Warnf("Failed to get the UDPMux connection of %s: %v", baseAddr, err)
+			continue
+		}
+
+		network := NetworkTypeUDP4.String()
+		if isIPv6 {
+			network = NetworkTypeUDP6.String()
+		}
+		srflxConfig := CandidateServerReflexiveConfig{
+			Network:   network,
+			Address:   mappedAddr.IP.String(),
+			Port:      mappedAddr.Port,
+			Component: ComponentRTP,
+			RelAddr:   baseAddr.IP.String(),
+			RelPort:   baseAddr.Port,
+		}
+		c, err := NewCandidateServerReflexive(&srflxConfig)
+		if err != nil {
+			// the connection is shared with the host candidates, it isn't closed here
+			a.log.Warnf("Failed to create server reflexive candidate for the port mapping %s: %v", mappedAddr, err)
+			continue
+		}
+
+		if err := a.addCandidate(ctx, c, conn); err != nil {
+			if closeErr := c.close(); closeErr != nil {
+				a.log.Warnf("Failed to close candidate: %v", closeErr)
+			}
+			a.log.Warnf("Failed to append to localCandidates and run onCandidateHdlr: %v", err)
+		}
+	}
+}
+
+// sourceIPFor returns the local address the kernel sends the packets to the remote address from, nil if unknown
+func (a *Agent) sourceIPFor(remote net.IP) net.IP {
+	// no packet is sent by connecting a UDP socket
+	conn, err := a.net.Dial(udp, net.JoinHostPort(remote.String(), "9"))
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if localAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return localAddr.IP
+	}
+	return nil
+}
+
 func (a *Agent) gatherCandidatesSrflxUDPMux(ctx context.Context, urls []*URL, networkTypes []NetworkType) { //nolint:gocognit
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -529,11 +678,11 @@ func (a *Agent) gatherCandidatesSrflx(ctx context.Context, urls []*URL, networkT
 	}
 }
 
-func (a *Agent) gatherCandidatesRelay(ctx context.Context, urls []*URL) { //nolint:gocognit
+func (a *Agent) gatherCandidatesRelay(ctx context.Context, urls []*URL) {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
-	network := NetworkTypeUDP4.String()
+	relayNetworks := a.relayNetworkTypes()
 	for i := range urls {
 		switch {
 		case urls[i].Scheme != SchemeTypeTURN && urls[i].Scheme != SchemeTypeTURNS:
@@ -546,161 +695,350 @@ func (a *Agent) gatherCandidatesRelay(ctx context.Context, urls []*URL) { //noli
 			return
 		}
 
-		wg.Add(1)
-		go func(url URL) {
-			defer wg.Done()
-			TURNServerAddr := fmt.Sprintf("%s:%d", url.Host, url.Port)
-			var (
-				locConn       net.PacketConn
-				err           error
-				RelAddr       string
-				RelPort       int
-				relayProtocol string
-			)
-
-			switch {
-			case url.Proto == ProtoTypeUDP && url.Scheme == SchemeTypeTURN:
-				if locConn, err = a.net.ListenPacket(network, "0.0.0.0:0"); err != nil {
-					a.log.Warnf("Failed to listen %s: %v", network, err)
-					return
-				}
+		if a.udpMuxSrflx != nil && urls[i].Scheme == SchemeTypeTURN && urls[i].Proto == ProtoTypeUDP {
+			// the allocation is made over the shared socket of the mux, it has a single relayed address
+			wg.Add(1)
+			go func(url URL) {
+				defer wg.Done()
+				a.gatherCandidateRelayUDPMux(ctx, url)
+			}(*urls[i])
+			continue
+		}
 
-				RelAddr = locConn.LocalAddr().(*net.UDPAddr).IP.String() //nolint:forcetypeassert
-				RelPort = locConn.LocalAddr().(*net.UDPAddr).Port        //nolint:forcetypeassert
-				relayProtocol = udp
-			case a.proxyDialer != nil && url.Proto == ProtoTypeTCP &&
-				(url.Scheme == SchemeTypeTURN || url.Scheme == SchemeTypeTURNS):
-				conn, connectErr := a.proxyDialer.Dial(NetworkTypeTCP4.String(), TURNServerAddr)
-				if connectErr != nil {
-					a.log.Warnf("Failed to Dial TCP Addr %s via proxy dialer: %v", TURNServerAddr, connectErr)
-					return
-				}
+		// one allocation per address family of the relayed addresses
+		for _, relayNetwork := range relayNetworks {
+			wg.Add(1)
+			go func(url URL, relayNetwork NetworkType) {
+				defer wg.Done()
+				a.gatherCandidateRelay(ctx, url, relayNetwork)
+			}(*urls[i], relayNetwork)
+		}
+	}
+}
 
-				RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
-				RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
-				if url.Scheme == SchemeTypeTURN {
-					relayProtocol = tcp
-				} else if url.Scheme == SchemeTypeTURNS {
-					relayProtocol = "tls"
-				}
-				locConn = turn.NewSTUNConn(conn)
+// gatherCandidateRelayUDPMux gathers the relayed address of the allocation on the TURN server shared by all the agents
+// of the UDPMuxSrflx, so the relayed traffic leaves from the port of the mux.
+// The server allows a single allocation per client address, its relayed address is of the address family of the server.
+func (a *Agent) gatherCandidateRelayUDPMux(ctx context.Context, url URL) {
+	TURNServerAddr := net.JoinHostPort(url.Host, strconv.Itoa(url.Port))
+	serverAddr, serverIP, err := a.resolveTURNServer(udp, TURNServerAddr)
+	if err != nil {
+		a.log.Warnf("Failed to resolve UDP Addr %s: %v", TURNServerAddr, err)
+		return
+	}
+	relayNetwork := NetworkTypeUDP4
+	if serverIP.To4() == nil {
+		relayNetwork = NetworkTypeUDP6
+	}
 
-			case url.Proto == ProtoTypeTCP && url.Scheme == SchemeTypeTURN:
-				tcpAddr, connectErr := net.ResolveTCPAddr(NetworkTypeTCP4.String(), TURNServerAddr)
-				if connectErr != nil {
-					a.log.Warnf("Failed to resolve TCP Addr %s: %v", TURNServerAddr, connectErr)
-					return
-				}
+	conn, relayConfig, err := a.udpMuxSrflx.GetRelayedConn(ctx, a.localUfrag, url, serverAddr, relayNetwork)
+	if err != nil {
+		a.logAllocateError(url, relayNetwork, err)
+		return
+	}
 
-				conn, connectErr := net.DialTCP(NetworkTypeTCP4.String(), nil, tcpAddr)
-				if connectErr != nil {
-					a.log.Warnf("Failed to Dial TCP Addr %s: %v", TURNServerAddr, connectErr)
-					return
-				}
+	candidate, err := NewCandidateRelay(&relayConfig)
+	if err != nil {
+		closeConnAndLog(conn, a.log, fmt.Sprintf("Failed to create relay candidate: %s %s:%d: %v", relayNetwork, relayConfig.Address, relayConfig.Port, err))
+		return
+	}
 
-				RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
-				RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
-				relayProtocol = tcp
-				locConn = turn.NewSTUNConn(conn)
-			case url.Proto == ProtoTypeUDP && url.Scheme == SchemeTypeTURNS:
-				udpAddr, connectErr := net.ResolveUDPAddr(network, TURNServerAddr)
-				if connectErr != nil {
-					a.log.Warnf("Failed to resolve UDP Addr %s: %v", TURNServerAddr, connectErr)
-					return
-				}
+	if err := a.addCandidate(ctx, candidate, conn); err != nil {
+		if closeErr := candidate.close(); closeErr != nil {
+			a.log.Warnf("Failed to close candidate: %v", closeErr)
+		}
+		a.log.Warnf("Failed to append to localCandidates and run onCandidateHdlr: %v", err)
+	}
+}
 
-				conn, connectErr := dtls.Dial(network, udpAddr, &dtls.Config{ //nolint:contextcheck
-					ServerName:         url.Host,
-					InsecureSkipVerify: a.insecureSkipVerify, //nolint:gosec
-				})
-				if connectErr != nil {
-					a.log.Warnf("Failed to Dial DTLS Addr %s: %v", TURNServerAddr, connectErr)
-					return
-				}
+// relayNetworkTypes returns the network types of the relayed addresses to allocate, based on the IP versions
+// of the agent's NetworkTypes. The relayed addresses are always UDP (RFC 8656), the transport to the server may not be.
+func (a *Agent) relayNetworkTypes() []NetworkType {
+	var relayNetworks []NetworkType
+	if a.isIPVersionEnabled(false) {
+		relayNetworks = append(relayNetworks, NetworkTypeUDP4)
+	}
+	if a.isIPVersionEnabled(true) {
+		relayNetworks = append(relayNetworks, NetworkTypeUDP6)
+	}
+	return relayNetworks
+}
 
-				RelAddr = conn.LocalAddr().(*net.UDPAddr).IP.String() //nolint:forcetypeassert
-				RelPort = conn.LocalAddr().(*net.UDPAddr).Port        //nolint:forcetypeassert
-				relayProtocol = "dtls"
-				locConn = &fakePacketConn{conn}
-			case url.Proto == ProtoTypeTCP && url.Scheme == SchemeTypeTURNS:
-				conn, connectErr := tls.Dial(NetworkTypeTCP4.String(), TURNServerAddr, &tls.Config{
-					InsecureSkipVerify: a.insecureSkipVerify, //nolint:gosec
-				})
-				if connectErr != nil {
-					a.log.Warnf("Failed to Dial TLS Addr %s: %v", TURNServerAddr, connectErr)
-					return
-				}
-				RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
-				RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
-				relayProtocol = "tls"
-				locConn = turn.NewSTUNConn(conn)
-			default:
-				a.log.Warnf("Unable to handle URL in gatherCandidatesRelay %v", url)
-				return
+func (a *Agent) isIPVersionEnabled(ipv6 bool) bool {
+	for _, networkType := range a.networkTypes {
+		if networkType.IsIPv6() == ipv6 {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTURNServer resolves the TURN server for the transport protocol (udp or tcp).
+// An IPv4 address is preferred, an IPv6 one is used if the server has no IPv4 address or IPv4 is disabled.
+func (a *Agent) resolveTURNServer(protocol, hostPort string) (net.Addr, net.IP, error) {
+	err := ErrDetermineNetworkType
+	for _, ipv6 := range []bool{false, true} {
+		if !a.isIPVersionEnabled(ipv6) {
+			continue
+		}
+		network := protocol + "4"
+		if ipv6 {
+			network = protocol + "6"
+		}
+
+		if protocol == udp {
+			udpAddr, resolveErr := a.net.ResolveUDPAddr(network, hostPort)
+			if resolveErr == nil {
+				return udpAddr, udpAddr.IP, nil
 			}
+			err = resolveErr
+			continue
+		}
+		tcpAddr, resolveErr := net.ResolveTCPAddr(network, hostPort)
+		if resolveErr == nil {
+			return tcpAddr, tcpAddr.IP, nil
+		}
+		err = resolveErr
+	}
+	return nil, nil, err
+}
+
+// gatherCandidateRelay gathers a relayed address of the relayNetwork on the TURN server of the URL.
+// The allocation is shared with the other agents if the agent has a TURNPool.
+func (a *Agent) gatherCandidateRelay(ctx context.Context, url URL, relayNetwork NetworkType) {
+	if a.turnPool != nil {
+		a.gatherCandidateRelayPool(ctx, url, relayNetwork)
+		return
+	}
+
+	allocation, err := a.allocateRelay(url, relayNetwork)
+	if err != nil {
+		a.logAllocateError(url, relayNetwork, err)
+		return
+	}
+
+	relayConfig := allocation.candidateConfig()
+	relayConfig.OnClose = func() error {
+		allocation.client.Close()
+		return allocation.locConn.Close()
+	}
+	relayConnClose := func() {
+		if relayConErr := allocation.relayConn.Close(); relayConErr != nil {
+			a.log.Warnf("Failed to close relay %v", relayConErr)
+		}
+	}
+	candidate, err := NewCandidateRelay(&relayConfig)
+	if err != nil {
+		relayConnClose()
+
+		allocation.client.Close()
+		closeConnAndLog(allocation.locConn, a.log, fmt.Sprintf("Failed to create relay candidate: %s %s: %v", relayNetwork, allocation.relayConn.LocalAddr(), err))
+		return
+	}
+
+	if err := a.addCandidate(ctx, candidate, allocation.relayConn); err != nil {
+		relayConnClose()
+
+		if closeErr := candidate.close(); closeErr != nil {
+			a.log.Warnf("Failed to close candidate: %v", closeErr)
+		}
+		a.log.Warnf("Failed to append to localCandidates and run onCandidateHdlr: %v", err)
+	}
+}
+
+// gatherCandidateRelayPool gathers the relayed address of the allocation shared by the agents of the TURNPool
+func (a *Agent) gatherCandidateRelayPool(ctx context.Context, url URL, relayNetwork NetworkType) {
+	conn, allocation, err := a.turnPool.getConn(ctx, a.localUfrag, url, relayNetwork, func() (*turnAllocation, error) {
+		return a.allocateRelay(url, relayNetwork)
+	})
+	if err != nil {
+		a.logAllocateError(url, relayNetwork, err)
+		return
+	}
 
-			client, err := turn.NewClient(&turn.ClientConfig{
-				TURNServerAddr: TURNServerAddr,
-				Conn:           locConn,
-				Username:       url.Username,
-				Password:       url.Password,
-				LoggerFactory:  a.loggerFactory,
-				Net:            a.net,
+	relayConfig := allocation.candidateConfig()
+	candidate, err := NewCandidateRelay(&relayConfig)
+	if err != nil {
+		closeConnAndLog(conn, a.log, fmt.Sprintf("Failed to create relay candidate: %s %s: %v", relayNetwork, allocation.relayConn.LocalAddr(), err))
+		return
+	}
+
+	if err := a.addCandidate(ctx, candidate, conn); err != nil {
+		if closeErr := candidate.close(); closeErr != nil {
+			a.log.Warnf("Failed to close candidate: %v", closeErr)
+		}
+		a.log.Warnf("Failed to append to localCandidates and run onCandidateHdlr: %v", err)
+	}
+}
+
+func (a *Agent) logAllocateError(url URL, relayNetwork NetworkType, err error) {
+	if relayNetwork.IsIPv6() {
+		// many TURN servers don't relay IPv6 (error 440), that is not worth a warning
+		a.log.Debugf("Failed to allocate %s on %s: %v", relayNetwork, url, err)
+		return
+	}
+	a.log.Warnf("Failed to allocate %s on %s: %v", relayNetwork, url, err)
+}
+
+// allocateRelay makes an allocation of a relayed address of the relayNetwork on the TURN server of the URL
+func (a *Agent) allocateRelay(url URL, relayNetwork NetworkType) (*turnAllocation, error) { //nolint:gocognit
+	TURNServerAddr := net.JoinHostPort(url.Host, strconv.Itoa(url.Port))
+	var (
+		locConn       net.PacketConn
+		err           error
+		RelAddr       string
+		RelPort       int
+		relayProtocol string
+		// serverAddr is the resolved address of the server, nil if the server is reached through the proxy dialer
+		serverAddr net.Addr
+		serverIP   net.IP
+	)
+
+	switch {
+	case url.Proto == ProtoTypeUDP && url.Scheme == SchemeTypeTURN:
+		if serverAddr, serverIP, err = a.resolveTURNServer(udp, TURNServerAddr); err != nil {
+			return nil, fmt.Errorf("failed to resolve UDP Addr %s: %w", TURNServerAddr, err)
+		}
+		network, listenAddr := NetworkTypeUDP4.String(), "0.0.0.0:0"
+		if serverIP.To4() == nil {
+			network, listenAddr = NetworkTypeUDP6.String(), "[::]:0"
+		}
+		if locConn, err = a.net.ListenPacket(network, listenAddr); err != nil {
+			return nil, fmt.Errorf("failed to listen %s: %w", network, err)
+		}
+
+		RelAddr = locConn.LocalAddr().(*net.UDPAddr).IP.String() //nolint:forcetypeassert
+		RelPort = locConn.LocalAddr().(*net.UDPAddr).Port        //nolint:forcetypeassert
+		relayProtocol = udp
+	case a.proxyDialer != nil && url.Proto == ProtoTypeTCP &&
+		(url.Scheme == SchemeTypeTURN || url.Scheme == SchemeTypeTURNS):
+		conn, connectErr := a.proxyDialer.Dial(NetworkTypeTCP4.String(), TURNServerAddr)
+		if connectErr != nil {
+			return nil, fmt.Errorf("failed to Dial TCP Addr %s via proxy dialer: %w", TURNServerAddr, connectErr)
+		}
+
+		RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
+		RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
+		if url.Scheme == SchemeTypeTURN {
+			relayProtocol = tcp
+		} else if url.Scheme == SchemeTypeTURNS {
+			// the proxy only tunnels the TCP connection, the TLS session is made with the TURN server through it
+			tlsConn := tls.Client(conn, &tls.Config{
+				ServerName:         url.Host,
+				InsecureSkipVerify: a.insecureSkipVerify, //nolint:gosec
 			})
-			if err != nil {
-				closeConnAndLog(locConn, a.log, fmt.Sprintf("Failed to build new turn.Client %s %s", TURNServerAddr, err))
-				return
+			if handshakeErr := tlsConn.Handshake(); handshakeErr != nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("failed TLS handshake with %s via proxy dialer: %w", TURNServerAddr, handshakeErr)
 			}
+			conn = tlsConn
+			relayProtocol = "tls"
+		}
+		locConn = turn.NewSTUNConn(conn)
 
-			if err = client.Listen(); err != nil {
-				client.Close()
-				closeConnAndLog(locConn, a.log, fmt.Sprintf("Failed to listen on turn.Client %s %s", TURNServerAddr, err))
-				return
-			}
+	case url.Proto == ProtoTypeTCP && url.Scheme == SchemeTypeTURN:
+		if serverAddr, serverIP, err = a.resolveTURNServer(tcp, TURNServerAddr); err != nil {
+			return nil, fmt.Errorf("failed to resolve TCP Addr %s: %w", TURNServerAddr, err)
+		}
 
-			relayConn, err := client.Allocate()
-			if err != nil {
-				client.Close()
-				closeConnAndLog(locConn, a.log, fmt.Sprintf("Failed to allocate on turn.Client %s %s", TURNServerAddr, err))
-				return
-			}
+		conn, connectErr := net.DialTCP(serverAddr.Network(), nil, serverAddr.(*net.TCPAddr)) //nolint:forcetypeassert
+		if connectErr != nil {
+			return nil, fmt.Errorf("failed to Dial TCP Addr %s: %w", TURNServerAddr, connectErr)
+		}
 
-			rAddr := relayConn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
-			relayConfig := CandidateRelayConfig{
-				Network:       network,
-				Component:     ComponentRTP,
-				Address:       rAddr.IP.String(),
-				Port:          rAddr.Port,
-				RelAddr:       RelAddr,
-				RelPort:       RelPort,
-				RelayProtocol: relayProtocol,
-				OnClose: func() error {
-					client.Close()
-					return locConn.Close()
-				},
-			}
-			relayConnClose := func() {
-				if relayConErr := relayConn.Close(); relayConErr != nil {
-					a.log.Warnf("Failed to close relay %v", relayConErr)
-				}
-			}
-			candidate, err := NewCandidateRelay(&relayConfig)
-			if err != nil {
-				relayConnClose()
+		RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
+		RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
+		relayProtocol = tcp
+		locConn = turn.NewSTUNConn(conn)
+	case url.Proto == ProtoTypeUDP && url.Scheme == SchemeTypeTURNS:
+		if serverAddr, serverIP, err = a.resolveTURNServer(udp, TURNServerAddr); err != nil {
+			return nil, fmt.Errorf("failed to resolve UDP Addr %s: %w", TURNServerAddr, err)
+		}
 
-				client.Close()
-				closeConnAndLog(locConn, a.log, fmt.Sprintf("Failed to create relay candidate: %s %s: %v", network, rAddr.String(), err))
-				return
-			}
+		conn, connectErr := dtls.Dial(serverAddr.Network(), serverAddr.(*net.UDPAddr), &dtls.Config{ //nolint:contextcheck,forcetypeassert
+			ServerName:         url.Host,
+			InsecureSkipVerify: a.insecureSkipVerify, //nolint:gosec
+		})
+		if connectErr != nil {
+			return nil, fmt.Errorf("failed to Dial DTLS Addr %s: %w", TURNServerAddr, connectErr)
+		}
 
-			if err := a.addCandidate(ctx, candidate, relayConn); err != nil {
-				relayConnClose()
+		RelAddr = conn.LocalAddr().(*net.UDPAddr).IP.String() //nolint:forcetypeassert
+		RelPort = conn.LocalAddr().(*net.UDPAddr).Port        //nolint:forcetypeassert
+		relayProtocol = "dtls"
+		locConn = &fakePacketConn{conn}
+	case url.Proto == ProtoTypeTCP && url.Scheme == SchemeTypeTURNS:
+		if serverAddr, serverIP, err = a.resolveTURNServer(tcp, TURNServerAddr); err != nil {
+			return nil, fmt.Errorf("failed to resolve TCP Addr %s: %w", TURNServerAddr, err)
+		}
 
-				if closeErr := candidate.close(); closeErr != nil {
-					a.log.Warnf("Failed to close candidate: %v", closeErr)
-				}
-				a.log.Warnf("Failed to append to localCandidates and run onCandidateHdlr: %v", err)
-			}
-		}(*urls[i])
+		conn, connectErr := tls.Dial(serverAddr.Network(), serverAddr.String(), &tls.Config{
+			ServerName:         url.Host,
+			InsecureSkipVerify: a.insecureSkipVerify, //nolint:gosec
+		})
+		if connectErr != nil {
+			return nil, fmt.Errorf("failed to Dial TLS Addr %s: %w", TURNServerAddr, connectErr)
+		}
+		RelAddr = conn.LocalAddr().(*net.TCPAddr).IP.String() //nolint:forcetypeassert
+		RelPort = conn.LocalAddr().(*net.TCPAddr).Port        //nolint:forcetypeassert
+		relayProtocol = "tls"
+		locConn = turn.NewSTUNConn(conn)
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnhandledTURNURL, url)
+	}
+
+	clientConn := locConn
+	clientServerAddr := TURNServerAddr
+	if serverIP != nil && serverIP.To4() == nil {
+		// the turn.Client resolves the server as IPv4 only, the turnServerConn sends to the IPv6 address instead
+		clientServerAddr = turnPlaceholderServerAddr
+		clientConn = newTURNServerConn(locConn, serverAddr, relayNetwork, url.Username, url.Password)
+	} else if relayNetwork.IsIPv6() {
+		clientConn = newTURNServerConn(locConn, serverAddr, relayNetwork, url.Username, url.Password)
+	}
+
+	transport := newTURNTransportConn(clientConn)
+	client, err := turn.NewClient(&turn.ClientConfig{
+		TURNServerAddr: clientServerAddr,
+		Conn:           transport,
+		Username:       url.Username,
+		Password:       url.Password,
+		LoggerFactory:  a.loggerFactory,
+		Net:            a.net,
+	})
+	if err != nil {
+		_ = locConn.Close()
+		return nil, fmt.Errorf("failed to build new turn.Client %s: %w", TURNServerAddr, err)
+	}
+
+	if err = client.Listen(); err != nil {
+		client.Close()
+		_ = locConn.Close()
+		return nil, fmt.Errorf("failed to listen on turn.Client %s: %w", TURNServerAddr, err)
+	}
+
+	relayConn, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		_ = locConn.Close()
+		return nil, fmt.Errorf("failed to allocate on turn.Client %s: %w", TURNServerAddr, err)
+	}
+
+	allocation := &turnAllocation{
+		client:        client,
+		relayConn:     relayConn,
+		locConn:       locConn,
+		relayNetwork:  relayNetwork,
+		relAddr:       RelAddr,
+		relPort:       RelPort,
+		relayProtocol: relayProtocol,
+		transport:     transport,
+	}
+	rAddr := relayConn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	if (rAddr.IP.To4() == nil) != relayNetwork.IsIPv6() {
+		// the server ignored the REQUESTED-ADDRESS-FAMILY, the relayed address is gathered by the other allocation
+		allocation.close()
+		return nil, fmt.Errorf("%w: got %s", errRelayedAddressFamily, rAddr)
 	}
+	return allocation, nil
 }
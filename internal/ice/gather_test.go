@@ -628,6 +628,113 @@ func TestMultiUDPMuxUsage(t *testing.T) {
 	assert.NoError(t, a.Close())
 }
 
+// Assert that the port mappings of the UDPMux port are gathered as server reflexive candidates
+func TestUDPMuxMappedAddrs(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	udpMux := NewUDPMuxDefault(UDPMuxParams{UDPConn: conn})
+	defer func() {
+		_ = udpMux.Close()
+	}()
+	muxAddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+
+	a, err := NewAgent(&AgentConfig{
+		NetworkTypes:   []NetworkType{NetworkTypeUDP4},
+		CandidateTypes: []CandidateType{CandidateTypeHost, CandidateTypeServerReflexive},
+		UDPMux:         udpMux,
+		UDPMuxMappedAddrs: []*net.UDPAddr{
+			{IP: net.IP{203, 0, 113, 1}, Port: 40000},
+			// IPv6 isn't gathered
+			{IP: net.ParseIP("2001:db8::1"), Port: 40000},
+		},
+		IncludeLoopback: true,
+	})
+	require.NoError(t, err)
+
+	candidateCh := make(chan Candidate)
+	require.NoError(t, a.OnCandidate(func(c Candidate) {
+		if c == nil {
+			close(candidateCh)
+			return
+		}
+		candidateCh <- c
+	}))
+	require.NoError(t, a.GatherCandidates())
+
+	var srflx []Candidate
+	for c := range candidateCh {
+		if c.Type() == CandidateTypeServerReflexive {
+			srflx = append(srflx, c)
+		}
+	}
+	require.Len(t, srflx, 1)
+	assert.Equal(t, "203.0.113.1", srflx[0].Address())
+	assert.Equal(t, 40000, srflx[0].Port())
+	assert.Equal(t, &CandidateRelatedAddress{Address: "127.0.0.1", Port: muxAddr.Port}, srflx[0].RelatedAddress())
+
+	assert.NoError(t, a.Close())
+}
+
+func TestUDPMuxFilters(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	udpMux := NewUDPMuxDefault(UDPMuxParams{UDPConn: conn})
+	defer func() {
+		_ = udpMux.Close()
+	}()
+
+	gather := func(ipFilter func(net.IP) bool) []Candidate {
+		a, err := NewAgent(&AgentConfig{
+			NetworkTypes:    []NetworkType{NetworkTypeUDP4},
+			CandidateTypes:  []CandidateType{CandidateTypeHost},
+			UDPMux:          udpMux,
+			IncludeLoopback: true,
+			IPFilter:        ipFilter,
+		})
+		require.NoError(t, err)
+		defer func() {
+			assert.NoError(t, a.Close())
+		}()
+
+		candidateCh := make(chan Candidate)
+		require.NoError(t, a.OnCandidate(func(c Candidate) {
+			if c == nil {
+				close(candidateCh)
+				return
+			}
+			candidateCh <- c
+		}))
+		require.NoError(t, a.GatherCandidates())
+
+		var candidates []Candidate
+		for c := range candidateCh {
+			candidates = append(candidates, c)
+		}
+		return candidates
+	}
+
+	assert.Len(t, gather(func(ip net.IP) bool { return true }), 1)
+	assert.Empty(t, gather(func(ip net.IP) bool { return !ip.IsLoopback() }), "the filtered mux address shouldn't be gathered")
+}
+
 // Assert that candidates are given for each mux in a MultiTCPMux
 func TestMultiTCPMuxUsage(t *testing.T) {
 	report := test.CheckRoutines(t)
@@ -753,8 +860,8 @@ type universalUDPMuxMock struct {
 	conn                      *net.UDPConn
 }
 
-func (m *universalUDPMuxMock) GetRelayedAddr(turnAddr net.Addr, deadline time.Duration) (*net.Addr, error) {
-	return nil, errNotImplemented
+func (m *universalUDPMuxMock) GetRelayedConn(ctx context.Context, ufrag string, url URL, serverAddr net.Addr, relayNetwork NetworkType) (net.PacketConn, CandidateRelayConfig, error) {
+	return nil, CandidateRelayConfig{}, errNotImplemented
 }
 
 func (m *universalUDPMuxMock) GetConnForURL(ufrag string, url string, addr net.Addr) (net.PacketConn, error) {
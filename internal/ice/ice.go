@@ -25,6 +25,10 @@ const (
 
 	// ConnectionStateClosed ICE agent has finished and is no longer handling requests
 	ConnectionStateClosed
+
+	// ConnectionStateConsentLost ICE agent connected successfully, but the remote peer has stopped confirming
+	// its consent to receive the traffic of the selected pair (RFC 7675), nothing is sent anymore
+	ConnectionStateConsentLost
 )
 
 func (c ConnectionState) String() string {
@@ -43,6 +47,8 @@ func (c ConnectionState) String() string {
 		return "Disconnected"
 	case ConnectionStateClosed:
 		return "Closed"
+	case ConnectionStateConsentLost:
+		return "ConsentLost"
 	default:
 		return "Invalid"
 	}
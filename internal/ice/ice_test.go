@@ -19,6 +19,7 @@ func TestConnectedState_String(t *testing.T) {
 		{ConnectionStateFailed, "Failed"},
 		{ConnectionStateDisconnected, "Disconnected"},
 		{ConnectionStateClosed, "Closed"},
+		{ConnectionStateConsentLost, "ConsentLost"},
 	}
 
 	for i, testCase := range testCases {
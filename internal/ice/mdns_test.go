@@ -5,6 +5,7 @@ package ice
 
 import (
 	"context"
+	"net"
 	"regexp"
 	"testing"
 	"time"
@@ -134,6 +135,66 @@ func TestMulticastDNSStaticHostName(t *testing.T) {
 	assert.NoError(t, agent.Close())
 }
 
+func TestMulticastDNSUDPMux(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	udpMux := NewUDPMuxDefault(UDPMuxParams{UDPConn: conn})
+	defer func() {
+		_ = udpMux.Close()
+	}()
+
+	agent, err := NewAgent(&AgentConfig{
+		NetworkTypes:         []NetworkType{NetworkTypeUDP4},
+		CandidateTypes:       []CandidateType{CandidateTypeHost, CandidateTypeServerReflexive},
+		UDPMux:               udpMux,
+		UDPMuxMappedAddrs:    []*net.UDPAddr{{IP: net.IP{203, 0, 113, 1}, Port: 40000}},
+		IncludeLoopback:      true,
+		MulticastDNSMode:     MulticastDNSModeQueryAndGather,
+		MulticastDNSHostName: "udpMux.local",
+	})
+	assert.NoError(t, err)
+
+	candidateCh := make(chan Candidate)
+	assert.NoError(t, agent.OnCandidate(func(c Candidate) {
+		if c == nil {
+			close(candidateCh)
+			return
+		}
+		candidateCh <- c
+	}))
+	assert.NoError(t, agent.GatherCandidates())
+
+	var candidates []Candidate
+	for c := range candidateCh {
+		candidates = append(candidates, c)
+	}
+	if assert.Len(t, candidates, 2) {
+		for _, c := range candidates {
+			switch c.Type() {
+			case CandidateTypeHost:
+				// the local address of the mux isn't revealed
+				assert.Equal(t, "udpMux.local", c.Address())
+			case CandidateTypeServerReflexive:
+				assert.Equal(t, "203.0.113.1", c.Address())
+				assert.Nil(t, c.RelatedAddress())
+				assert.NotContains(t, c.Marshal(), "raddr")
+			default:
+				t.Errorf("unexpected candidate %s", c)
+			}
+		}
+	}
+	assert.NoError(t, agent.Close())
+}
+
 func TestGenerateMulticastDNSName(t *testing.T) {
 	name, err := generateMulticastDNSName()
 	if err != nil {
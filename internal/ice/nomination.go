@@ -0,0 +1,37 @@
+package ice
+
+import (
+	"encoding/binary"
+
+	"github.com/pion/stun"
+)
+
+// attrNomination is the NOMINATION attribute of the ICE renomination (draft-thatcher-ice-renomination)
+const attrNomination stun.AttrType = 0xC001
+
+// NominationAttr represents NOMINATION attribute. The controlling agent increases its value with every nomination,
+// the controlled agent selects the pair of the highest nomination it has received.
+type NominationAttr uint32
+
+const nominationSize = 4 // 32 bit
+
+// AddTo adds NOMINATION attribute to message.
+func (n NominationAttr) AddTo(m *stun.Message) error {
+	v := make([]byte, nominationSize)
+	binary.BigEndian.PutUint32(v, uint32(n))
+	m.Add(attrNomination, v)
+	return nil
+}
+
+// GetFrom decodes NOMINATION attribute from message.
+func (n *NominationAttr) GetFrom(m *stun.Message) error {
+	v, err := m.Get(attrNomination)
+	if err != nil {
+		return err
+	}
+	if err = stun.CheckSize(attrNomination, len(v), nominationSize); err != nil {
+		return err
+	}
+	*n = NominationAttr(binary.BigEndian.Uint32(v))
+	return nil
+}
@@ -0,0 +1,38 @@
+package ice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pion/stun"
+)
+
+func TestNomination_GetFrom(t *testing.T) { //nolint:dupl
+	m := new(stun.Message)
+	n := NominationAttr(3)
+	if err := n.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+		t.Error("unexpected error")
+	}
+	if err := m.Build(stun.BindingRequest, &n); err != nil {
+		t.Error(err)
+	}
+	m1 := new(stun.Message)
+	if _, err := m1.Write(m.Raw); err != nil {
+		t.Error(err)
+	}
+	var n1 NominationAttr
+	if err := n1.GetFrom(m1); err != nil {
+		t.Error(err)
+	}
+	if n1 != n {
+		t.Error("not equal")
+	}
+	t.Run("IncorrectSize", func(t *testing.T) {
+		m3 := new(stun.Message)
+		m3.Add(attrNomination, make([]byte, 100))
+		var n2 NominationAttr
+		if err := n2.GetFrom(m3); !stun.IsAttrSizeInvalid(err) {
+			t.Error("should error")
+		}
+	})
+}
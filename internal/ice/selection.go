@@ -16,16 +16,29 @@ type pairCandidateSelector interface {
 	HandleBindingRequest(m *stun.Message, local, remote Candidate)
 }
 
+// renominationRTTMargin is how much lower the round trip time of a pair has to be to renominate it
+// instead of a pair with a higher priority, so that small variations don't move the connection back and forth
+const renominationRTTMargin = 20 * time.Millisecond
+
 type controllingSelector struct {
 	startTime     time.Time
 	agent         *Agent
 	nominatedPair *CandidatePair
 	log           logging.LeveledLogger
+
+	// nomination is the value of the NOMINATION attribute of the last nomination
+	nomination uint32
+	// renominationStart is when the nomination of a pair replacing the selected one has started
+	renominationStart time.Time
+	// lastRenominationCheck is when the pairs have been last checked for a better one than the selected pair
+	lastRenominationCheck time.Time
 }
 
 func (s *controllingSelector) Start() {
 	s.startTime = time.Now()
 	s.nominatedPair = nil
+	s.nomination = 0
+	s.lastRenominationCheck = time.Time{}
 }
 
 func (s *controllingSelector) isNominatable(c Candidate) bool {
@@ -50,6 +63,8 @@ func (s *controllingSelector) ContactCandidates() {
 		if s.agent.validateSelectedPair() {
 			s.log.Trace("checking keepalive")
 			s.agent.checkKeepalive()
+			s.agent.checkConsent()
+			s.renominate()
 		}
 	case s.nominatedPair != nil:
 		s.nominatePair(s.nominatedPair)
@@ -57,28 +72,104 @@ func (s *controllingSelector) ContactCandidates() {
 		p := s.agent.getBestValidCandidatePair()
 		if p != nil && s.isNominatable(p.Local) && s.isNominatable(p.Remote) {
 			s.log.Tracef("Nominatable pair found, nominating (%s, %s)", p.Local.String(), p.Remote.String())
-			p.nominated = true
-			s.nominatedPair = p
-			s.nominatePair(p)
+			s.nominate(p)
 			return
 		}
 		s.agent.pingAllCandidates()
 	}
 }
 
+// nominate starts the nomination of the pair with the next NOMINATION value
+func (s *controllingSelector) nominate(p *CandidatePair) {
+	p.nominated = true
+	s.nominatedPair = p
+	s.nomination++
+	s.nominatePair(p)
+}
+
+// renominate keeps checking the candidate pairs of the established connection and nominates a better one
+// than the selected pair, see isBetterPair. A renomination that isn't confirmed within the renomination interval
+// is given up by nominating the selected pair again.
+// Note: the caller should hold the agent lock.
+func (s *controllingSelector) renominate() {
+	interval := s.agent.renominationInterval
+	if interval == 0 || s.agent.connectionState != ConnectionStateConnected {
+		return
+	}
+
+	selectedPair := s.agent.getSelectedPair()
+	if s.nominatedPair != nil && s.nominatedPair != selectedPair {
+		if time.Since(s.renominationStart) < interval && s.nominatedPair.state != CandidatePairStateFailed {
+			s.nominatePair(s.nominatedPair)
+			return
+		}
+		s.log.Debugf("renomination of pair %s hasn't been confirmed, staying on pair %s", s.nominatedPair, selectedPair)
+		s.nominate(selectedPair)
+		return
+	}
+
+	if time.Since(s.lastRenominationCheck) < interval {
+		return
+	}
+	s.lastRenominationCheck = time.Now()
+
+	var best *CandidatePair
+	for _, p := range s.agent.checklist {
+		if p == selectedPair || p.state != CandidatePairStateSucceeded || !isBetterPair(p, selectedPair) {
+			continue
+		}
+		if best == nil || isBetterPair(p, best) {
+			best = p
+		}
+	}
+	if best != nil {
+		s.log.Debugf("renominating pair %s replacing pair %s", best, selectedPair)
+		s.renominationStart = time.Now()
+		s.nominate(best)
+		return
+	}
+
+	// check the new pairs and measure the round trip time of the valid ones for the next time
+	s.agent.pingAllCandidates()
+	for _, p := range s.agent.checklist {
+		if p != selectedPair && p.state == CandidatePairStateSucceeded {
+			s.PingCandidate(p.Local, p.Remote)
+		}
+	}
+}
+
+// isBetterPair returns true if the pair p should replace the other pair: its round trip time is lower
+// by more than the renominationRTTMargin or, with similar or unknown round trip times, it has a higher priority
+func isBetterPair(p, other *CandidatePair) bool {
+	if p.currentRoundTripTime != 0 && other.currentRoundTripTime != 0 {
+		switch {
+		case p.currentRoundTripTime+renominationRTTMargin < other.currentRoundTripTime:
+			return true
+		case other.currentRoundTripTime+renominationRTTMargin < p.currentRoundTripTime:
+			return false
+		}
+	}
+	return p.priority() > other.priority()
+}
+
 func (s *controllingSelector) nominatePair(pair *CandidatePair) {
 	// The controlling agent MUST include the USE-CANDIDATE attribute in
 	// order to nominate a candidate pair (Section 8.1.1).  The controlled
 	// agent MUST NOT include the USE-CANDIDATE attribute in a Binding
 	// request.
-	msg, err := stun.Build(stun.BindingRequest, stun.TransactionID,
-		stun.NewUsername(s.agent.remoteUfrag+":"+s.agent.localUfrag),
+	setters := []stun.Setter{
+		stun.BindingRequest, stun.TransactionID,
+		stun.NewUsername(s.agent.remoteUfrag + ":" + s.agent.localUfrag),
 		UseCandidate(),
 		AttrControlling(s.agent.tieBreaker),
 		PriorityAttr(pair.Local.Priority()),
-		stun.NewShortTermIntegrity(s.agent.remotePwd),
-		stun.Fingerprint,
-	)
+	}
+	// a remote agent supporting the renomination follows the latest nomination
+	if s.agent.renominationInterval != 0 {
+		setters = append(setters, NominationAttr(s.nomination))
+	}
+	setters = append(setters, stun.NewShortTermIntegrity(s.agent.remotePwd), stun.Fingerprint)
+	msg, err := stun.Build(setters...)
 	if err != nil {
 		s.log.Error(err.Error())
 		return
@@ -105,8 +196,7 @@ func (s *controllingSelector) HandleBindingRequest(m *stun.Message, local, remot
 		} else if bestPair.equal(p) && s.isNominatable(p.Local) && s.isNominatable(p.Remote) {
 			s.log.Tracef("The candidate (%s, %s) is the best candidate available, marking it as nominated",
 				p.Local.String(), p.Remote.String())
-			s.nominatedPair = p
-			s.nominatePair(p)
+			s.nominate(p)
 		}
 	}
 }
@@ -137,9 +227,14 @@ func (s *controllingSelector) HandleSuccessResponse(m *stun.Message, local, remo
 	}
 
 	p.state = CandidatePairStateSucceeded
+	p.lastConsent = time.Now()
+	p.responseReceived(time.Since(pendingRequest.timestamp))
 	s.log.Tracef("Found valid candidate pair: %s", p)
-	if pendingRequest.isUseCandidate && s.agent.getSelectedPair() == nil {
-		s.agent.setSelectedPair(p)
+	if pendingRequest.isUseCandidate {
+		// the first nomination or a confirmed renomination
+		if selectedPair := s.agent.getSelectedPair(); selectedPair == nil || (p == s.nominatedPair && p != selectedPair) {
+			s.agent.setSelectedPair(p)
+		}
 	}
 }
 
@@ -162,9 +257,13 @@ func (s *controllingSelector) PingCandidate(local, remote Candidate) {
 type controlledSelector struct {
 	agent *Agent
 	log   logging.LeveledLogger
+
+	// nomination is the highest NOMINATION value of the controlling agent accepted
+	nomination uint32
 }
 
 func (s *controlledSelector) Start() {
+	s.nomination = 0
 }
 
 func (s *controlledSelector) ContactCandidates() {
@@ -172,6 +271,7 @@ func (s *controlledSelector) ContactCandidates() {
 		if s.agent.validateSelectedPair() {
 			s.log.Trace("checking keepalive")
 			s.agent.checkKeepalive()
+			s.agent.checkConsent()
 		}
 	} else {
 		s.agent.pingAllCandidates()
@@ -228,11 +328,20 @@ func (s *controlledSelector) HandleSuccessResponse(m *stun.Message, local, remot
 	}
 
 	p.state = CandidatePairStateSucceeded
+	p.lastConsent = time.Now()
+	p.responseReceived(time.Since(pendingRequest.timestamp))
 	s.log.Tracef("Found valid candidate pair: %s", p)
 	if p.nominateOnBindingSuccess {
-		if selectedPair := s.agent.getSelectedPair(); selectedPair == nil {
+		selectedPair := s.agent.getSelectedPair()
+		switch {
+		case selectedPair == nil:
+			s.agent.setSelectedPair(p)
+		case p.nomination != 0 && p.nomination >= s.nomination && p != selectedPair:
+			// a renomination of the controlling agent
+			s.nomination = p.nomination
 			s.agent.setSelectedPair(p)
 		}
+		p.nominateOnBindingSuccess = false
 	}
 }
 
@@ -244,6 +353,14 @@ func (s *controlledSelector) HandleBindingRequest(m *stun.Message, local, remote
 		p = s.agent.addPair(local, remote)
 	}
 
+	// the NOMINATION attribute of a controlling agent supporting the renomination
+	var nomination NominationAttr
+	renomination := nomination.GetFrom(m) == nil
+	if useCandidate && renomination && uint32(nomination) < s.nomination {
+		s.log.Tracef("ignore nominate pair %s, nomination %d older than %d", p, nomination, s.nomination)
+		useCandidate = false
+	}
+
 	if useCandidate {
 		// https://tools.ietf.org/html/rfc8445#section-7.3.1.5
 
@@ -252,10 +369,17 @@ func (s *controlledSelector) HandleBindingRequest(m *stun.Message, local, remote
 			// previously sent by this pair produced a successful response and
 			// generated a valid pair (Section 7.2.5.3.2).  The agent sets the
 			// nominated flag value of the valid pair to true.
-			if selectedPair := s.agent.getSelectedPair(); selectedPair == nil ||
-				(selectedPair != p && selectedPair.priority() <= p.priority()) {
+			selectedPair := s.agent.getSelectedPair()
+			switch {
+			case renomination:
+				// the latest nomination is followed regardless of the priority
+				s.nomination = uint32(nomination)
+				if selectedPair != p {
+					s.agent.setSelectedPair(p)
+				}
+			case selectedPair == nil || (selectedPair != p && selectedPair.priority() <= p.priority()):
 				s.agent.setSelectedPair(p)
-			} else if selectedPair != p {
+			case selectedPair != p:
 				s.log.Tracef("ignore nominate new pair %s, already nominated pair %s", p, selectedPair)
 			}
 		} else {
@@ -268,6 +392,9 @@ func (s *controlledSelector) HandleBindingRequest(m *stun.Message, local, remote
 			// candidate pair state to Failed, and set the checklist state to
 			// Failed.
 			p.nominateOnBindingSuccess = true
+			if renomination {
+				p.nomination = uint32(nomination)
+			}
 		}
 	}
 
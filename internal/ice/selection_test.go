@@ -0,0 +1,134 @@
+package ice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlledSelector_Renomination(t *testing.T) {
+	var config AgentConfig
+	runAgentTest(t, &config, func(ctx context.Context, a *Agent) {
+		s := &controlledSelector{agent: a, log: a.log}
+		a.selector = s
+
+		local, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.2", Port: 777, Component: 1})
+		require.NoError(t, err)
+		local.conn = &mockPacketConn{}
+		hostRemote, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.3", Port: 888, Component: 1})
+		require.NoError(t, err)
+		relayRemote, err := NewCandidateRelay(&CandidateRelayConfig{Network: "udp", Address: "10.0.0.3", Port: 999, Component: 1})
+		require.NoError(t, err)
+
+		hostPair := a.addPair(local, hostRemote)
+		hostPair.state = CandidatePairStateSucceeded
+		relayPair := a.addPair(local, relayRemote)
+		relayPair.state = CandidatePairStateSucceeded
+		require.Greater(t, hostPair.priority(), relayPair.priority())
+
+		nominate := func(remote Candidate, setters ...stun.Setter) {
+			setters = append([]stun.Setter{stun.BindingRequest, stun.TransactionID, UseCandidate()}, setters...)
+			msg, err := stun.Build(setters...)
+			require.NoError(t, err)
+			s.HandleBindingRequest(msg, local, remote)
+		}
+
+		nominate(hostRemote, NominationAttr(1))
+		assert.Equal(t, hostPair, a.getSelectedPair())
+
+		// the latest nomination is followed even to a pair with a lower priority
+		nominate(relayRemote, NominationAttr(2))
+		assert.Equal(t, relayPair, a.getSelectedPair())
+
+		// an older nomination arriving late
+		nominate(hostRemote, NominationAttr(1))
+		assert.Equal(t, relayPair, a.getSelectedPair())
+
+		nominate(hostRemote, NominationAttr(3))
+		assert.Equal(t, hostPair, a.getSelectedPair())
+
+		// a controlling agent without the renomination can't move to a lower priority pair
+		nominate(relayRemote)
+		assert.Equal(t, hostPair, a.getSelectedPair())
+	})
+}
+
+func TestControllingSelector_Renomination(t *testing.T) {
+	var config AgentConfig
+	runAgentTest(t, &config, func(ctx context.Context, a *Agent) {
+		s := &controllingSelector{agent: a, log: a.log}
+		s.Start()
+		a.selector = s
+		a.isControlling = true
+		a.renominationInterval = time.Second
+
+		local, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.2", Port: 777, Component: 1})
+		require.NoError(t, err)
+		local.conn = &mockPacketConn{}
+		hostRemote, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.3", Port: 888, Component: 1})
+		require.NoError(t, err)
+		relayRemote, err := NewCandidateRelay(&CandidateRelayConfig{Network: "udp", Address: "10.0.0.3", Port: 999, Component: 1})
+		require.NoError(t, err)
+
+		// the remote agent is responding
+		hostRemote.seen(false)
+		relayRemote.seen(false)
+
+		relayPair := a.addPair(local, relayRemote)
+		relayPair.state = CandidatePairStateSucceeded
+		relayPair.currentRoundTripTime = 100 * time.Millisecond
+		s.nominate(relayPair)
+		a.setSelectedPair(relayPair)
+
+		// the host pair has been found after the connection has been established
+		hostPair := a.addPair(local, hostRemote)
+		hostPair.state = CandidatePairStateSucceeded
+		hostPair.currentRoundTripTime = 10 * time.Millisecond
+
+		s.ContactCandidates()
+		assert.Equal(t, hostPair, s.nominatedPair)
+		assert.Equal(t, uint32(2), s.nomination)
+		assert.Equal(t, relayPair, a.getSelectedPair(), "selected once the remote agent has confirmed the nomination")
+
+		request := a.pendingBindingRequests[len(a.pendingBindingRequests)-1]
+		assert.True(t, request.isUseCandidate)
+		response, err := stun.Build(stun.NewTransactionIDSetter(request.transactionID), stun.BindingSuccess)
+		assert.NoError(t, err)
+		s.HandleSuccessResponse(response, local, hostRemote, hostRemote.addr())
+		assert.Equal(t, hostPair, a.getSelectedPair())
+
+		// the relay pair isn't better anymore
+		s.lastRenominationCheck = time.Time{}
+		s.ContactCandidates()
+		assert.Equal(t, hostPair, s.nominatedPair)
+	})
+}
+
+func TestIsBetterPair(t *testing.T) {
+	newPair := func(priority uint32, rtt time.Duration) *CandidatePair {
+		local, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.2", Port: 777, Component: 1, Priority: priority})
+		require.NoError(t, err)
+		remote, err := NewCandidateHost(&CandidateHostConfig{Network: "udp", Address: "192.168.0.3", Port: 888, Component: 1, Priority: priority})
+		require.NoError(t, err)
+		p := newCandidatePair(local, remote, true)
+		p.currentRoundTripTime = rtt
+		return p
+	}
+
+	high := newPair(200, 50*time.Millisecond)
+	low := newPair(100, 40*time.Millisecond)
+	assert.True(t, isBetterPair(high, low), "similar round trip times, the priority decides")
+	assert.False(t, isBetterPair(low, high))
+
+	fast := newPair(100, 10*time.Millisecond)
+	assert.True(t, isBetterPair(fast, high), "much lower round trip time")
+	assert.False(t, isBetterPair(high, fast))
+
+	unknown := newPair(100, 0)
+	assert.True(t, isBetterPair(high, unknown), "unknown round trip time, the priority decides")
+	assert.False(t, isBetterPair(unknown, fast))
+}
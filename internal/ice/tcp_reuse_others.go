@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package ice
+
+import "syscall"
+
+// reuseTCPPort is a no-op on the platforms without port reuse, the simultaneous-open candidates only accept
+// connections there
+func reuseTCPPort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package ice
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// reuseTCPPort lets the listener and the outgoing connections of a simultaneous-open candidate share its port
+func reuseTCPPort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = windows.SetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, windows.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
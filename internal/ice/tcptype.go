@@ -46,3 +46,23 @@ func (t TCPType) String() string {
 		return ErrUnknownType.Error()
 	}
 }
+
+// tcpTypesCompatible returns true if a local candidate can form a pair with a remote one (RFC 6544 section 6.2):
+// active with passive, passive with active and simultaneous-open with simultaneous-open.
+// Candidates of an unspecified type, e.g. UDP or peer reflexive ones, pair with any.
+func tcpTypesCompatible(local, remote TCPType) bool {
+	if local == TCPTypeUnspecified || remote == TCPTypeUnspecified {
+		return true
+	}
+
+	switch local {
+	case TCPTypeActive:
+		return remote == TCPTypePassive
+	case TCPTypePassive:
+		return remote == TCPTypeActive
+	case TCPTypeSimultaneousOpen:
+		return remote == TCPTypeSimultaneousOpen
+	default:
+		return false
+	}
+}
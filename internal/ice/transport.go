@@ -88,6 +88,10 @@ func (c *Conn) Write(p []byte) (int, error) {
 		return 0, errICEWriteSTUNMessage
 	}
 
+	if atomic.LoadInt32(&c.agent.consentLost) != 0 {
+		return 0, ErrConsentLost
+	}
+
 	pair := c.agent.getSelectedPair()
 	if pair == nil {
 		if err = c.agent.run(c.agent.context(), func(ctx context.Context, a *Agent) {
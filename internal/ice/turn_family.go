@@ -0,0 +1,98 @@
+package ice
+
+import (
+	"net"
+
+	"github.com/pion/stun"
+)
+
+const (
+	// requestedAddressFamilyIPv4 and requestedAddressFamilyIPv6 are the values of the REQUESTED-ADDRESS-FAMILY attribute (RFC 6156 section 4.1.1)
+	requestedAddressFamilyIPv4 byte = 0x01
+	requestedAddressFamilyIPv6 byte = 0x02
+)
+
+// turnPlaceholderServerAddr is passed to the turn.Client when the TURN server is reached over IPv6.
+// The client resolves the server as an IPv4 address only, the turnServerConn sends its packets to the real address.
+const turnPlaceholderServerAddr = "0.0.0.0:3478"
+
+// turnServerConn is the transport of a turn.Client which
+//   - adds the REQUESTED-ADDRESS-FAMILY attribute to the Allocate requests, so the relayed address can be an IPv6 one
+//   - sends all the packets to the TURN server address, which may be an IPv6 one
+//
+// The client doesn't support both, so the Allocate requests are rebuilt on the way out keeping the transaction ID
+// and signing them again with the long-term credentials.
+type turnServerConn struct {
+	net.PacketConn
+
+	serverAddr net.Addr
+	family     byte
+	username   string
+	password   string
+}
+
+func newTURNServerConn(conn net.PacketConn, serverAddr net.Addr, relayNetwork NetworkType, username, password string) *turnServerConn {
+	family := requestedAddressFamilyIPv4
+	if relayNetwork.IsIPv6() {
+		family = requestedAddressFamilyIPv6
+	}
+	return &turnServerConn{
+		PacketConn: conn,
+		serverAddr: serverAddr,
+		family:     family,
+		username:   username,
+		password:   password,
+	}
+}
+
+// WriteTo sends the packet to the TURN server regardless of the address
+func (c *turnServerConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	out := p
+	if c.family != requestedAddressFamilyIPv4 && stun.IsMessage(p) {
+		if rewritten, err := c.addRequestedAddressFamily(p); err == nil {
+			out = rewritten
+		}
+	}
+	if _, err := c.PacketConn.WriteTo(out, c.serverAddr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// addRequestedAddressFamily returns the Allocate request with the REQUESTED-ADDRESS-FAMILY attribute.
+// Other messages are returned unchanged.
+func (c *turnServerConn) addRequestedAddressFamily(p []byte) ([]byte, error) {
+	msg := &stun.Message{Raw: append([]byte(nil), p...)}
+	if err := msg.Decode(); err != nil {
+		return nil, err
+	}
+	if msg.Type != stun.NewType(stun.MethodAllocate, stun.ClassRequest) || msg.Contains(stun.AttrRequestedAddressFamily) {
+		return p, nil
+	}
+
+	rebuilt := &stun.Message{Type: msg.Type, TransactionID: msg.TransactionID}
+	rebuilt.WriteHeader()
+	for _, attr := range msg.Attributes {
+		if attr.Type == stun.AttrMessageIntegrity || attr.Type == stun.AttrFingerprint {
+			continue
+		}
+		rebuilt.Add(attr.Type, attr.Value)
+	}
+	rebuilt.Add(stun.AttrRequestedAddressFamily, []byte{c.family, 0, 0, 0})
+
+	if msg.Contains(stun.AttrMessageIntegrity) {
+		var realm stun.Realm
+		if err := realm.GetFrom(msg); err != nil {
+			return nil, err
+		}
+		if err := stun.NewLongTermIntegrity(c.username, realm.String(), c.password).AddTo(rebuilt); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Contains(stun.AttrFingerprint) {
+		if err := stun.Fingerprint.AddTo(rebuilt); err != nil {
+			return nil, err
+		}
+	}
+	return rebuilt.Raw, nil
+}
@@ -0,0 +1,95 @@
+package ice
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTURNServerConn(t *testing.T) {
+	server, err := net.ListenUDP(udp, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	local, err := net.ListenUDP(udp, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	conn := newTURNServerConn(local, server.LocalAddr(), NetworkTypeUDP6, "user", "pass")
+	defer conn.Close() //nolint:errcheck
+
+	receive := func() *stun.Message {
+		t.Helper()
+		buf := make([]byte, receiveMTU)
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		msg := &stun.Message{Raw: buf[:n]}
+		require.NoError(t, msg.Decode())
+		return msg
+	}
+	// the destination is ignored, all the packets go to the TURN server
+	otherAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+	t.Run("authenticated allocate", func(t *testing.T) {
+		realm := stun.NewRealm("realm")
+		integrity := stun.NewLongTermIntegrity("user", "realm", "pass")
+		request, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+			stun.NewUsername("user"), realm, stun.NewNonce("nonce"), integrity, stun.Fingerprint)
+		require.NoError(t, err)
+
+		n, err := conn.WriteTo(request.Raw, otherAddr)
+		require.NoError(t, err)
+		assert.Equal(t, len(request.Raw), n)
+
+		msg := receive()
+		assert.Equal(t, request.TransactionID, msg.TransactionID)
+		family, err := msg.Get(stun.AttrRequestedAddressFamily)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{requestedAddressFamilyIPv6, 0, 0, 0}, family)
+		assert.NoError(t, integrity.Check(msg), "request should be signed again")
+		assert.NoError(t, stun.Fingerprint.Check(msg))
+	})
+
+	t.Run("anonymous allocate", func(t *testing.T) {
+		request, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest), stun.Fingerprint)
+		require.NoError(t, err)
+		_, err = conn.WriteTo(request.Raw, otherAddr)
+		require.NoError(t, err)
+
+		msg := receive()
+		assert.True(t, msg.Contains(stun.AttrRequestedAddressFamily))
+		assert.False(t, msg.Contains(stun.AttrMessageIntegrity))
+		assert.NoError(t, stun.Fingerprint.Check(msg))
+	})
+
+	t.Run("other requests are unchanged", func(t *testing.T) {
+		request, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassRequest), stun.Fingerprint)
+		require.NoError(t, err)
+		_, err = conn.WriteTo(request.Raw, otherAddr)
+		require.NoError(t, err)
+
+		msg := receive()
+		assert.Equal(t, request.Raw, msg.Raw)
+	})
+}
+
+func TestAgent_RelayNetworkTypes(t *testing.T) {
+	testCases := []struct {
+		name         string
+		networkTypes []NetworkType
+		expected     []NetworkType
+	}{
+		{"all", supportedNetworkTypes(), []NetworkType{NetworkTypeUDP4, NetworkTypeUDP6}},
+		{"IPv4", []NetworkType{NetworkTypeUDP4, NetworkTypeTCP4}, []NetworkType{NetworkTypeUDP4}},
+		{"IPv6", []NetworkType{NetworkTypeUDP6}, []NetworkType{NetworkTypeUDP6}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a := &Agent{networkTypes: testCase.networkTypes}
+			assert.Equal(t, testCase.expected, a.relayNetworkTypes())
+		})
+	}
+}
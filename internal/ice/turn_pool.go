@@ -0,0 +1,469 @@
+package ice
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+)
+
+const (
+	// defaultTURNPoolIdleTimeout is how long an allocation without connections is kept for the next agents
+	defaultTURNPoolIdleTimeout = 2 * time.Minute
+	// turnPoolCleanupInterval is the interval of the checks for the idle allocations
+	turnPoolCleanupInterval = 30 * time.Second
+	// turnRefreshTimeout is how long a refresh of the allocation or of its permissions may wait for its response,
+	// the turn.Client gives up retransmitting the request well before
+	turnRefreshTimeout = 30 * time.Second
+)
+
+// turnAllocation is an allocation on a TURN server with the transport it has been made over
+type turnAllocation struct {
+	client    *turn.Client
+	relayConn net.PacketConn
+	locConn   net.PacketConn
+
+	relayNetwork  NetworkType
+	relAddr       string
+	relPort       int
+	relayProtocol string
+
+	// transport fails once reading from the TURN server has failed or the allocation couldn't be refreshed
+	transport *turnTransportConn
+}
+
+// candidateConfig returns the config of the relay candidate of the allocation
+func (t *turnAllocation) candidateConfig() CandidateRelayConfig {
+	rAddr := t.relayConn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	return CandidateRelayConfig{
+		Network:       t.relayNetwork.String(),
+		Component:     ComponentRTP,
+		Address:       rAddr.IP.String(),
+		Port:          rAddr.Port,
+		RelAddr:       t.relAddr,
+		RelPort:       t.relPort,
+		RelayProtocol: t.relayProtocol,
+	}
+}
+
+// close releases the allocation and closes the transport
+func (t *turnAllocation) close() {
+	_ = t.relayConn.Close()
+	t.client.Close()
+	_ = t.locConn.Close()
+}
+
+// turnTransportConn is the connection of a turn.Client to the TURN server, it notifies when reading has failed
+// or a refresh of the allocation or of its permissions has failed, as the client itself only logs that
+type turnTransportConn struct {
+	net.PacketConn
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// refreshes are the timeouts of the refresh requests waiting for their response indexed by the transaction ID
+	refreshes map[[stun.TransactionIDSize]byte]*time.Timer
+}
+
+func newTURNTransportConn(conn net.PacketConn) *turnTransportConn {
+	return &turnTransportConn{
+		PacketConn: conn,
+		done:       make(chan struct{}),
+		refreshes:  make(map[[stun.TransactionIDSize]byte]*time.Timer),
+	}
+}
+
+func (c *turnTransportConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err != nil {
+		c.fail(err)
+		return n, addr, err
+	}
+	c.handleResponse(p[:n])
+	return n, addr, err
+}
+
+func (c *turnTransportConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.handleRequest(p)
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// handleRequest starts the timeout of a refresh request, the retransmissions of the request are ignored
+func (c *turnTransportConn) handleRequest(p []byte) {
+	msg, ok := decodeRefresh(p, stun.ClassRequest)
+	if !ok {
+		return
+	}
+	if lifetime, err := msg.Get(stun.AttrLifetime); err == nil && binary.BigEndian.Uint32(lifetime) == 0 {
+		// the allocation is being released, the response doesn't matter
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.refreshes[msg.TransactionID]; ok {
+		return
+	}
+	method := msg.Type.Method
+	c.refreshes[msg.TransactionID] = time.AfterFunc(turnRefreshTimeout, func() {
+		c.fail(fmt.Errorf("%w: %s", errTURNRefreshTimeout, method))
+	})
+}
+
+// handleResponse fails the transport if the response of a refresh is an error the turn.Client doesn't recover from
+func (c *turnTransportConn) handleResponse(p []byte) {
+	var msg *stun.Message
+	var ok bool
+	if msg, ok = decodeRefresh(p, stun.ClassSuccessResponse); !ok {
+		if msg, ok = decodeRefresh(p, stun.ClassErrorResponse); !ok {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	timer, ok := c.refreshes[msg.TransactionID]
+	delete(c.refreshes, msg.TransactionID)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	timer.Stop()
+
+	if msg.Type.Class != stun.ClassErrorResponse {
+		return
+	}
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(msg); err == nil {
+		switch {
+		case code.Code == stun.CodeStaleNonce:
+			// the turn.Client retries with the new nonce
+			return
+		case code.Code == stun.CodeForbidden && msg.Type.Method == stun.MethodCreatePermission:
+			// only the permission of that peer has been denied
+			return
+		}
+	}
+	c.fail(fmt.Errorf("%w: %s %s", errTURNRefreshFailed, msg.Type, code))
+}
+
+func (c *turnTransportConn) fail(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// failed returns true once the transport has failed
+func (c *turnTransportConn) failed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeRefresh decodes the STUN message of the class refreshing an allocation or its permissions
+func decodeRefresh(p []byte, class stun.MessageClass) (*stun.Message, bool) {
+	if !stun.IsMessage(p) {
+		return nil, false
+	}
+	msg := &stun.Message{Raw: append([]byte{}, p...)}
+	if err := msg.Decode(); err != nil || msg.Type.Class != class {
+		return nil, false
+	}
+	switch msg.Type.Method {
+	case stun.MethodRefresh, stun.MethodCreatePermission:
+		return msg, true
+	default:
+		return nil, false
+	}
+}
+
+// TURNPoolParams are parameters of the TURNPool
+type TURNPoolParams struct {
+	Logger logging.LeveledLogger
+	// IdleTimeout is how long an allocation without connections is kept, 2 minutes by default
+	IdleTimeout time.Duration
+}
+
+// TURNPool shares the TURN allocations between the agents, so a client keeps a single allocation per TURN server
+// and relayed address family instead of one per agent.
+// The relayed packets are muxed to the connections of the agents by their ufrag like the UDPMux does, the permissions
+// and channels of the remote peers are created by the turn.Client on the first packet sent to them and the client
+// refreshes the allocation, the permissions and the channels until the allocation is released.
+// An allocation is released once its transport has failed or it has been idle for the IdleTimeout.
+type TURNPool struct {
+	params TURNPoolParams
+	log    logging.LeveledLogger
+
+	mu sync.Mutex
+	// allocations are indexed by the URL, the credentials and the relayed address family
+	allocations map[string]*pooledAllocation
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// pooledAllocation is an allocation of the pool with the mux of its relayed connection
+type pooledAllocation struct {
+	allocation *turnAllocation
+	mux        *UDPMuxDefault
+	err        error
+	// ready is closed once the allocation has been made or has failed
+	ready chan struct{}
+	// idleSince is when the last connection has been removed, guarded by the mutex of the pool
+	idleSince time.Time
+}
+
+// NewTURNPool creates a TURNPool
+func NewTURNPool(params TURNPoolParams) *TURNPool {
+	if params.Logger == nil {
+		params.Logger = logging.NewDefaultLoggerFactory().NewLogger("ice")
+	}
+	if params.IdleTimeout == 0 {
+		params.IdleTimeout = defaultTURNPoolIdleTimeout
+	}
+
+	p := &TURNPool{
+		params:      params,
+		log:         params.Logger,
+		allocations: make(map[string]*pooledAllocation),
+		closed:      make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.cleanupLoop()
+	return p
+}
+
+func turnPoolKey(url URL, relayNetwork NetworkType) string {
+	return fmt.Sprintf("%s|%s|%s|%s", url.String(), url.Username, url.Password, relayNetwork)
+}
+
+// getConn returns the connection of the agent with the given ufrag on the relayed address of the allocation.
+// The first agent needing the allocation makes it with the allocate function, the others wait for it
+// until the allocation has been made or the ctx of the gathering is done.
+func (p *TURNPool) getConn(ctx context.Context, ufrag string, url URL, relayNetwork NetworkType, allocate func() (*turnAllocation, error)) (net.PacketConn, *turnAllocation, error) {
+	key := turnPoolKey(url, relayNetwork)
+
+	p.mu.Lock()
+	select {
+	case <-p.closed:
+		p.mu.Unlock()
+		return nil, nil, errTURNPoolClosed
+	default:
+	}
+	entry, ok := p.allocations[key]
+	// a failed allocation is made again
+	if ok && entry.failed() {
+		delete(p.allocations, key)
+		ok = false
+	}
+	owner := !ok
+	if owner {
+		entry = &pooledAllocation{ready: make(chan struct{})}
+		p.allocations[key] = entry
+	}
+	p.mu.Unlock()
+
+	if owner {
+		go p.allocate(key, entry, allocate)
+	}
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	if entry.err != nil {
+		return nil, nil, entry.err
+	}
+
+	conn, err := entry.mux.GetConn(ufrag, entry.mux.LocalAddr())
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, entry.allocation, nil
+}
+
+func (p *TURNPool) allocate(key string, entry *pooledAllocation, allocate func() (*turnAllocation, error)) {
+	defer close(entry.ready)
+
+	allocation, err := allocate()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		// the next agents make the allocation again instead of finding the failed one
+		if p.allocations[key] == entry {
+			delete(p.allocations, key)
+		}
+		entry.err = err
+		return
+	}
+	select {
+	case <-p.closed:
+		// the pool has been closed in the meantime
+		allocation.close()
+		entry.err = errTURNPoolClosed
+		return
+	default:
+	}
+
+	entry.allocation = allocation
+	entry.mux = NewUDPMuxDefault(UDPMuxParams{
+		Logger:  p.log,
+		UDPConn: allocation.relayConn,
+	})
+	p.log.Debugf("allocated relayed address %s for the TURN pool", allocation.relayConn.LocalAddr())
+
+	p.wg.Add(1)
+	go p.watchTransport(key, entry)
+}
+
+// watchTransport releases the allocation once its transport has failed, the next agents make a new one
+func (p *TURNPool) watchTransport(key string, entry *pooledAllocation) {
+	defer p.wg.Done()
+	select {
+	case <-p.closed:
+		return
+	case <-entry.allocation.transport.done:
+	}
+
+	p.mu.Lock()
+	if p.allocations[key] != entry {
+		// released as idle, that has closed the transport
+		p.mu.Unlock()
+		return
+	}
+	delete(p.allocations, key)
+	p.mu.Unlock()
+
+	p.log.Warnf("relayed address %s failed, releasing the allocation: %v", entry.allocation.relayConn.LocalAddr(), entry.allocation.transport.err)
+	entry.close()
+}
+
+func (p *TURNPool) cleanupLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(turnPoolCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			p.releaseIdle(time.Now())
+		}
+	}
+}
+
+// releaseIdle releases the allocations which have had no connections for the IdleTimeout
+func (p *TURNPool) releaseIdle(now time.Time) {
+	var idle []*pooledAllocation
+	p.mu.Lock()
+	for key, entry := range p.allocations {
+		if !entry.allocated() {
+			continue
+		}
+		if entry.mux.connCount() > 0 {
+			entry.idleSince = time.Time{}
+			continue
+		}
+		if entry.idleSince.IsZero() {
+			entry.idleSince = now
+			continue
+		}
+		if now.Sub(entry.idleSince) >= p.params.IdleTimeout {
+			delete(p.allocations, key)
+			idle = append(idle, entry)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, entry := range idle {
+		p.log.Debugf("releasing idle relayed address %s", entry.allocation.relayConn.LocalAddr())
+		entry.close()
+	}
+}
+
+// release releases the allocation of the key if it has been made
+func (p *TURNPool) release(key string) {
+	p.mu.Lock()
+	entry, ok := p.allocations[key]
+	if !ok || !entry.allocated() {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.allocations, key)
+	p.mu.Unlock()
+
+	p.log.Debugf("releasing relayed address %s", entry.allocation.relayConn.LocalAddr())
+	entry.close()
+}
+
+// RemoveConnByUfrag removes the connections of the agent from all the allocations
+func (p *TURNPool) RemoveConnByUfrag(ufrag string) {
+	p.mu.Lock()
+	var muxes []*UDPMuxDefault
+	for _, entry := range p.allocations {
+		if entry.allocated() {
+			muxes = append(muxes, entry.mux)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, mux := range muxes {
+		mux.RemoveConnByUfrag(ufrag)
+	}
+}
+
+// Close releases all the allocations, no further allocations could be made
+func (p *TURNPool) Close() error {
+	p.closeOnce.Do(func() {
+		p.mu.Lock()
+		close(p.closed)
+		allocations := p.allocations
+		p.allocations = make(map[string]*pooledAllocation)
+		p.mu.Unlock()
+
+		p.wg.Wait()
+		for _, entry := range allocations {
+			if entry.allocated() {
+				entry.close()
+			}
+		}
+	})
+	return nil
+}
+
+func (e *pooledAllocation) allocated() bool {
+	select {
+	case <-e.ready:
+		return e.err == nil
+	default:
+		return false
+	}
+}
+
+// failed returns true if the allocation couldn't be made, its relayed connection has been closed
+// or its transport has failed
+func (e *pooledAllocation) failed() bool {
+	select {
+	case <-e.ready:
+		return e.err != nil || e.mux.IsClosed() || e.allocation.transport.failed()
+	default:
+		return false
+	}
+}
+
+func (e *pooledAllocation) close() {
+	// closes the connections of the agents and the relayed connection
+	_ = e.mux.Close()
+	e.allocation.close()
+}
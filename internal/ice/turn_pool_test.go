@@ -0,0 +1,194 @@
+//go:build !js
+// +build !js
+
+package ice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/transport/test"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTURNPool(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	serverPort := randomPort(t)
+	serverListener, err := net.ListenPacket("udp4", "127.0.0.1:"+strconv.Itoa(serverPort))
+	require.NoError(t, err)
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       "pion.ly",
+		AuthHandler: optimisticAuthHandler,
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            serverListener,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	newAgent := func(pool *TURNPool) *Agent {
+		agent, err := NewAgent(&AgentConfig{
+			NetworkTypes: []NetworkType{NetworkTypeUDP4},
+			Urls: []*URL{
+				{
+					Scheme:   SchemeTypeTURN,
+					Host:     "127.0.0.1",
+					Username: "username",
+					Password: "password",
+					Port:     serverPort,
+					Proto:    ProtoTypeUDP,
+				},
+			},
+			CandidateTypes: []CandidateType{CandidateTypeRelay},
+			TURNPool:       pool,
+		})
+		require.NoError(t, err)
+		return agent
+	}
+
+	// the pools of two clients
+	aPool := NewTURNPool(TURNPoolParams{})
+	defer aPool.Close() //nolint:errcheck
+	bPool := NewTURNPool(TURNPoolParams{})
+	defer bPool.Close() //nolint:errcheck
+
+	aAgent := newAgent(aPool)
+	aNotifier, aConnected := onConnected()
+	require.NoError(t, aAgent.OnConnectionStateChange(aNotifier))
+	bAgent := newAgent(bPool)
+	bNotifier, bConnected := onConnected()
+	require.NoError(t, bAgent.OnConnectionStateChange(bNotifier))
+
+	connect(aAgent, bAgent)
+	<-aConnected
+	<-bConnected
+
+	pair, err := aAgent.GetSelectedCandidatePair()
+	require.NoError(t, err)
+	assert.Equal(t, CandidateTypeRelay, pair.Local.Type())
+
+	// another agent of the first client gets the same relayed address
+	otherAgent := newAgent(aPool)
+	gathered := make(chan struct{})
+	require.NoError(t, otherAgent.OnCandidate(func(candidate Candidate) {
+		if candidate == nil {
+			close(gathered)
+		}
+	}))
+	require.NoError(t, otherAgent.GatherCandidates())
+	<-gathered
+	candidates, err := otherAgent.GetLocalCandidates()
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, pair.Local.addr().String(), candidates[0].addr().String())
+
+	aPool.mu.Lock()
+	assert.Len(t, aPool.allocations, 1)
+	aPool.mu.Unlock()
+
+	// the allocation is released once all its agents have been closed for the idle timeout
+	assert.NoError(t, otherAgent.Close())
+	now := time.Now()
+	aPool.releaseIdle(now)
+	aPool.releaseIdle(now.Add(aPool.params.IdleTimeout))
+	aPool.mu.Lock()
+	assert.Len(t, aPool.allocations, 1, "allocation is still used by the connected agent")
+	aPool.mu.Unlock()
+
+	assert.NoError(t, aAgent.Close())
+	assert.NoError(t, bAgent.Close())
+	aPool.releaseIdle(now)
+	aPool.releaseIdle(now.Add(aPool.params.IdleTimeout))
+	aPool.mu.Lock()
+	assert.Empty(t, aPool.allocations)
+	aPool.mu.Unlock()
+}
+
+func TestTURNPool_GetConnWaitsForTheContext(t *testing.T) {
+	pool := NewTURNPool(TURNPoolParams{})
+	defer pool.Close() //nolint:errcheck
+
+	url := URL{Scheme: SchemeTypeTURN, Host: "127.0.0.1", Port: 3478, Username: "username", Password: "password", Proto: ProtoTypeUDP}
+	release := make(chan struct{})
+	errAllocate := errors.New("allocation failed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err := pool.getConn(ctx, "ufrag", url, NetworkTypeUDP4, func() (*turnAllocation, error) {
+		<-release
+		return nil, errAllocate
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the agent shouldn't wait for a hanging allocation")
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.allocations) == 0
+	}, time.Second, 10*time.Millisecond, "the failed allocation should be evicted")
+
+	allocated := false
+	_, _, err = pool.getConn(context.Background(), "ufrag", url, NetworkTypeUDP4, func() (*turnAllocation, error) {
+		allocated = true
+		return nil, errAllocate
+	})
+	assert.ErrorIs(t, err, errAllocate)
+	assert.True(t, allocated, "the allocation should be made again")
+}
+
+func TestTURNTransportConn_RefreshFailure(t *testing.T) {
+	server, err := net.ListenUDP(udp, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+	local, err := net.ListenUDP(udp, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	transport := newTURNTransportConn(local)
+	defer transport.Close() //nolint:errcheck
+
+	send := func(method stun.Method, setters ...stun.Setter) *stun.Message {
+		t.Helper()
+		setters = append([]stun.Setter{stun.TransactionID, stun.NewType(method, stun.ClassRequest)}, setters...)
+		request, err := stun.Build(setters...)
+		require.NoError(t, err)
+		_, err = transport.WriteTo(request.Raw, server.LocalAddr())
+		require.NoError(t, err)
+		return request
+	}
+	respond := func(request *stun.Message, class stun.MessageClass, setters ...stun.Setter) {
+		t.Helper()
+		setters = append([]stun.Setter{request, stun.NewType(request.Type.Method, class)}, setters...)
+		response, err := stun.Build(setters...)
+		require.NoError(t, err)
+		transport.handleResponse(response.Raw)
+	}
+	lifetime := stun.RawAttribute{Type: stun.AttrLifetime, Value: []byte{0, 0, 2, 88}}
+
+	respond(send(stun.MethodRefresh, lifetime), stun.ClassSuccessResponse)
+	respond(send(stun.MethodRefresh, lifetime), stun.ClassErrorResponse, stun.CodeStaleNonce)
+	respond(send(stun.MethodCreatePermission), stun.ClassErrorResponse, stun.CodeForbidden)
+	assert.False(t, transport.failed(), "the turn.Client recovers from these responses")
+
+	// the release of the allocation isn't watched
+	send(stun.MethodRefresh, stun.RawAttribute{Type: stun.AttrLifetime, Value: []byte{0, 0, 0, 0}})
+	transport.mu.Lock()
+	assert.Empty(t, transport.refreshes)
+	transport.mu.Unlock()
+
+	respond(send(stun.MethodRefresh, lifetime), stun.ClassErrorResponse, stun.CodeAllocMismatch)
+	assert.True(t, transport.failed())
+	assert.ErrorIs(t, transport.err, errTURNRefreshFailed)
+}
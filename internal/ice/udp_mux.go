@@ -195,6 +195,13 @@ func (m *UDPMuxDefault) RemoveConnByUfrag(ufrag string) {
 	}
 }
 
+// connCount returns the number of the muxed connections
+func (m *UDPMuxDefault) connCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.connsIPv4) + len(m.connsIPv6)
+}
+
 // IsClosed returns true if the mux had been closed
 func (m *UDPMuxDefault) IsClosed() bool {
 	select {
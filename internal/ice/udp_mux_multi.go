@@ -5,6 +5,7 @@ package ice
 
 import (
 	"net"
+	"sync"
 
 	"github.com/pion/logging"
 	"github.com/pion/transport/vnet"
@@ -14,8 +15,20 @@ import (
 // allowing users to pass multiple UDPMux instances to the ICE agent
 // configuration.
 type MultiUDPMuxDefault struct {
+	mu             sync.RWMutex
 	muxes          []UDPMux
 	localAddrToMux map[string]UDPMux
+	closed         bool
+
+	// fromPort is set if the instance has been created by NewMultiUDPMuxFromPort,
+	// its local addresses can be updated then
+	fromPort *multiUDPMuxFromPort
+}
+
+// multiUDPMuxFromPort is how NewMultiUDPMuxFromPort has listened on the local addresses
+type multiUDPMuxFromPort struct {
+	port   int
+	params multiUDPMuxFromPortParam
 }
 
 // NewMultiUDPMuxDefault creates an instance of MultiUDPMuxDefault that
@@ -36,7 +49,9 @@ func NewMultiUDPMuxDefault(muxes ...UDPMux) *MultiUDPMuxDefault {
 // GetConn returns a PacketConn given the connection's ufrag and network
 // creates the connection if an existing one can't be found.
 func (m *MultiUDPMuxDefault) GetConn(ufrag string, addr net.Addr) (net.PacketConn, error) {
+	m.mu.RLock()
 	mux, ok := m.localAddrToMux[addr.String()]
+	m.mu.RUnlock()
 	if !ok {
 		return nil, errNoUDPMuxAvailable
 	}
@@ -46,6 +61,8 @@ func (m *MultiUDPMuxDefault) GetConn(ufrag string, addr net.Addr) (net.PacketCon
 // RemoveConnByUfrag stops and removes the muxed packet connection
 // from all underlying UDPMux instances.
 func (m *MultiUDPMuxDefault) RemoveConnByUfrag(ufrag string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	for _, mux := range m.muxes {
 		mux.RemoveConnByUfrag(ufrag)
 	}
@@ -53,6 +70,9 @@ func (m *MultiUDPMuxDefault) RemoveConnByUfrag(ufrag string) {
 
 // Close the multi mux, no further connections could be created
 func (m *MultiUDPMuxDefault) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
 	var err error
 	for _, mux := range m.muxes {
 		if e := mux.Close(); e != nil {
@@ -64,6 +84,8 @@ func (m *MultiUDPMuxDefault) Close() error {
 
 // GetListenAddresses returns the list of addresses that this mux is listening on
 func (m *MultiUDPMuxDefault) GetListenAddresses() []net.Addr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	addrs := make([]net.Addr, 0, len(m.localAddrToMux))
 	for _, mux := range m.muxes {
 		addrs = append(addrs, mux.GetListenAddresses()...)
@@ -72,7 +94,8 @@ func (m *MultiUDPMuxDefault) GetListenAddresses() []net.Addr {
 }
 
 // NewMultiUDPMuxFromPort creates an instance of MultiUDPMuxDefault that
-// listen all interfaces on the provided port.
+// listen all interfaces on the provided port. If the port is 0, the port picked
+// for the first address is used for all the others.
 func NewMultiUDPMuxFromPort(port int, opts ...UDPMuxFromPortOption) (*MultiUDPMuxDefault, error) {
 	params := multiUDPMuxFromPortParam{
 		networks: []NetworkType{NetworkTypeUDP4, NetworkTypeUDP6},
@@ -86,36 +109,104 @@ func NewMultiUDPMuxFromPort(port int, opts ...UDPMuxFromPortOption) (*MultiUDPMu
 		return nil, err
 	}
 
-	conns := make([]net.PacketConn, 0, len(ips))
+	muxes := make([]UDPMux, 0, len(ips))
 	for _, ip := range ips {
-		conn, listenErr := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+		mux, listenErr := listenUDPMux(ip, port, params)
 		if listenErr != nil {
 			err = listenErr
 			break
 		}
-		if params.readBufferSize > 0 {
-			_ = conn.SetReadBuffer(params.readBufferSize)
+		if port == 0 {
+			port = mux.GetListenAddresses()[0].(*net.UDPAddr).Port //nolint:forcetypeassert
 		}
-		if params.writeBufferSize > 0 {
-			_ = conn.SetWriteBuffer(params.writeBufferSize)
-		}
-		conns = append(conns, conn)
+		muxes = append(muxes, mux)
 	}
 
 	if err != nil {
-		for _, conn := range conns {
-			_ = conn.Close()
+		for _, mux := range muxes {
+			_ = mux.Close()
 		}
 		return nil, err
 	}
 
-	muxes := make([]UDPMux, 0, len(conns))
-	for _, conn := range conns {
-		mux := NewUDPMuxDefault(UDPMuxParams{Logger: params.logger, UDPConn: conn})
-		muxes = append(muxes, mux)
+	m := NewMultiUDPMuxDefault(muxes...)
+	m.fromPort = &multiUDPMuxFromPort{port: port, params: params}
+	return m, nil
+}
+
+func listenUDPMux(ip net.IP, port int, params multiUDPMuxFromPortParam) (*UDPMuxDefault, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	if params.readBufferSize > 0 {
+		_ = conn.SetReadBuffer(params.readBufferSize)
+	}
+	if params.writeBufferSize > 0 {
+		_ = conn.SetWriteBuffer(params.writeBufferSize)
+	}
+	return NewUDPMuxDefault(UDPMuxParams{Logger: params.logger, UDPConn: conn}), nil
+}
+
+// UpdateListenAddresses listens on the local addresses that have appeared since the mux has been created
+// and stops listening on the ones that are gone, e.g. after a network change. The muxes of the unchanged
+// addresses and their connections are kept. Returns true if the addresses have changed, only the instances
+// created by NewMultiUDPMuxFromPort are updated.
+func (m *MultiUDPMuxDefault) UpdateListenAddresses() (bool, error) {
+	if m.fromPort == nil {
+		return false, nil
+	}
+	params := m.fromPort.params
+	ips, err := localInterfaces(vnet.NewNet(nil), params.ifFilter, params.ipFilter, params.networks, params.includeLoopback)
+	if err != nil {
+		return false, err
+	}
+	listen := make(map[string]net.IP, len(ips))
+	for _, ip := range ips {
+		listen[(&net.UDPAddr{IP: ip, Port: m.fromPort.port}).String()] = ip
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false, nil
+	}
+
+	changed := false
+	muxes := make([]UDPMux, 0, len(m.muxes))
+	for _, mux := range m.muxes {
+		addrs := mux.GetListenAddresses()
+		if len(addrs) > 0 {
+			if _, ok := listen[addrs[0].String()]; ok {
+				muxes = append(muxes, mux)
+				continue
+			}
+		}
+		for _, addr := range addrs {
+			delete(m.localAddrToMux, addr.String())
+		}
+		_ = mux.Close()
+		changed = true
 	}
 
-	return NewMultiUDPMuxDefault(muxes...), nil
+	for key, ip := range listen {
+		if _, ok := m.localAddrToMux[key]; ok {
+			continue
+		}
+		mux, listenErr := listenUDPMux(ip, m.fromPort.port, params)
+		if listenErr != nil {
+			// the other addresses are still listened on
+			err = listenErr
+			continue
+		}
+		muxes = append(muxes, mux)
+		for _, addr := range mux.GetListenAddresses() {
+			m.localAddrToMux[addr.String()] = mux
+		}
+		changed = true
+	}
+	m.muxes = muxes
+	return changed, err
 }
 
 // UDPMuxFromPortOption provide options for NewMultiUDPMuxFromPort
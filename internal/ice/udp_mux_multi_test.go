@@ -148,3 +148,72 @@ func TestUnspecifiedUDPMux(t *testing.T) {
 
 	require.NoError(t, udpMuxMulti.Close())
 }
+
+func TestMultiUDPMuxFromPort_RandomPort(t *testing.T) {
+	udpMuxMulti, err := NewMultiUDPMuxFromPort(0, UDPMuxFromPortWithLoopback(), UDPMuxFromPortWithNetworks(NetworkTypeUDP4))
+	require.NoError(t, err)
+	defer func() {
+		_ = udpMuxMulti.Close()
+	}()
+
+	addrs := udpMuxMulti.GetListenAddresses()
+	require.NotEmpty(t, addrs)
+	port := addrs[0].(*net.UDPAddr).Port //nolint:forcetypeassert
+	require.NotZero(t, port)
+	for _, addr := range addrs {
+		require.Equal(t, port, addr.(*net.UDPAddr).Port, "all the addresses should share the port") //nolint:forcetypeassert
+	}
+}
+
+func TestMultiUDPMuxFromPort_UpdateListenAddresses(t *testing.T) {
+	allowLoopback, allowOthers := true, false
+	udpMuxMulti, err := NewMultiUDPMuxFromPort(0,
+		UDPMuxFromPortWithLoopback(),
+		UDPMuxFromPortWithNetworks(NetworkTypeUDP4),
+		UDPMuxFromPortWithIPFilter(func(ip net.IP) bool {
+			if ip.IsLoopback() {
+				return allowLoopback
+			}
+			return allowOthers
+		}),
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = udpMuxMulti.Close()
+	}()
+
+	addrs := udpMuxMulti.GetListenAddresses()
+	require.Len(t, addrs, 1)
+	loopbackAddr := addrs[0]
+	conn, err := udpMuxMulti.GetConn("ufrag1", loopbackAddr)
+	require.NoError(t, err)
+
+	changed, err := udpMuxMulti.UpdateListenAddresses()
+	require.NoError(t, err)
+	require.False(t, changed, "the addresses haven't changed")
+
+	// a new address is listened on with the same port, the connections of the kept addresses still work
+	allowOthers = true
+	changed, err = udpMuxMulti.UpdateListenAddresses()
+	require.NoError(t, err)
+	addrs = udpMuxMulti.GetListenAddresses()
+	if len(addrs) == 1 {
+		t.Skip("no other IPv4 address on this machine")
+	}
+	require.True(t, changed)
+	for _, addr := range addrs {
+		require.Equal(t, loopbackAddr.(*net.UDPAddr).Port, addr.(*net.UDPAddr).Port) //nolint:forcetypeassert
+	}
+	sameConn, err := udpMuxMulti.GetConn("ufrag1", loopbackAddr)
+	require.NoError(t, err)
+	require.Equal(t, conn, sameConn)
+
+	// a gone address isn't listened on anymore
+	allowLoopback = false
+	changed, err = udpMuxMulti.UpdateListenAddresses()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Len(t, udpMuxMulti.GetListenAddresses(), len(addrs)-1)
+	_, err = udpMuxMulti.GetConn("ufrag1", loopbackAddr)
+	require.ErrorIs(t, err, errNoUDPMuxAvailable)
+}
@@ -1,12 +1,15 @@
 package ice
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
 )
 
 // UniversalUDPMux allows multiple connections to go over a single UDP port for
@@ -15,7 +18,7 @@ import (
 type UniversalUDPMux interface {
 	UDPMux
 	GetXORMappedAddr(stunAddr net.Addr, deadline time.Duration) (*stun.XORMappedAddress, error)
-	GetRelayedAddr(turnAddr net.Addr, deadline time.Duration) (*net.Addr, error)
+	GetRelayedConn(ctx context.Context, ufrag string, url URL, serverAddr net.Addr, relayNetwork NetworkType) (net.PacketConn, CandidateRelayConfig, error)
 	GetConnForURL(ufrag string, url string, addr net.Addr) (net.PacketConn, error)
 }
 
@@ -28,6 +31,12 @@ type UniversalUDPMuxDefault struct {
 	// since we have a shared socket, for srflx candidates it makes sense to have a shared mapped address across all the agents
 	// stun.XORMappedAddress indexed by the STUN server addr
 	xorMappedMap map[string]*xorMapped
+
+	// the relayed addresses are shared too, a TURN server allows a single allocation per client address
+	relayPool *TURNPool
+	// turnClients are the clients of the allocations indexed by the TURN server addr, they consume the server packets
+	turnMu      sync.Mutex
+	turnClients map[string]*muxTURNClient
 }
 
 // UniversalUDPMuxParams are parameters for UniversalUDPMux server reflexive.
@@ -49,6 +58,8 @@ func NewUniversalUDPMuxDefault(params UniversalUDPMuxParams) *UniversalUDPMuxDef
 	m := &UniversalUDPMuxDefault{
 		params:       params,
 		xorMappedMap: make(map[string]*xorMapped),
+		relayPool:    NewTURNPool(TURNPoolParams{Logger: params.Logger}),
+		turnClients:  make(map[string]*muxTURNClient),
 	}
 
 	// wrap UDP connection, process server reflexive messages
@@ -76,10 +87,118 @@ type udpConn struct {
 	logger logging.LeveledLogger
 }
 
-// GetRelayedAddr creates relayed connection to the given TURN service and returns the relayed addr.
-// Not implemented yet.
-func (m *UniversalUDPMuxDefault) GetRelayedAddr(turnAddr net.Addr, deadline time.Duration) (*net.Addr, error) {
-	return nil, errNotImplemented
+// GetRelayedConn returns the connection of the agent with the given ufrag on the relayed address of the allocation
+// on the TURN server of the URL, with the config of its relay candidate.
+// The allocation is made over the shared UDP connection and shared with the other agents like the ones of the TURNPool.
+// The server allows a single allocation per client address, so an allocation with new credentials replaces the
+// previous one. Blocks until the allocation has been made or the ctx is done.
+// Method is safe for concurrent use.
+func (m *UniversalUDPMuxDefault) GetRelayedConn(ctx context.Context, ufrag string, url URL, serverAddr net.Addr, relayNetwork NetworkType) (net.PacketConn, CandidateRelayConfig, error) {
+	key := turnPoolKey(url, relayNetwork)
+	conn, allocation, err := m.relayPool.getConn(ctx, ufrag, url, relayNetwork, func() (*turnAllocation, error) {
+		return m.allocate(key, url, serverAddr, relayNetwork)
+	})
+	if err != nil {
+		return nil, CandidateRelayConfig{}, err
+	}
+	return conn, allocation.candidateConfig(), nil
+}
+
+// allocate makes the TURN allocation over the UDP connection
+func (m *UniversalUDPMuxDefault) allocate(key string, url URL, serverAddr net.Addr, relayNetwork NetworkType) (*turnAllocation, error) {
+	m.turnMu.Lock()
+	previous, ok := m.turnClients[serverAddr.String()]
+	m.turnMu.Unlock()
+	if ok && previous.key != key {
+		// the credentials have changed, the server wouldn't make another allocation for the client address
+		m.relayPool.release(previous.key)
+	}
+
+	clientServerAddr := serverAddr.String()
+	if udpAddr, ok := serverAddr.(*net.UDPAddr); ok && udpAddr.IP.To4() == nil {
+		// the turn.Client resolves the server as IPv4 only, the turnServerConn sends to the IPv6 address instead
+		clientServerAddr = turnPlaceholderServerAddr
+	}
+	// the turn.Client doesn't read from the connection, the packets of the server are passed in by the udpConn
+	transport := newTURNTransportConn(newTURNServerConn(m.params.UDPConn, serverAddr, relayNetwork, url.Username, url.Password))
+	client, err := turn.NewClient(&turn.ClientConfig{
+		TURNServerAddr: clientServerAddr,
+		Conn:           transport,
+		Username:       url.Username,
+		Password:       url.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	turnClient := &muxTURNClient{key: key, client: client, transport: transport}
+	m.turnMu.Lock()
+	m.turnClients[serverAddr.String()] = turnClient
+	m.turnMu.Unlock()
+	locConn := &muxTURNConn{PacketConn: m.params.UDPConn, mux: m, serverAddr: serverAddr.String(), turnClient: turnClient}
+
+	relayConn, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		_ = locConn.Close()
+		return nil, err
+	}
+	m.params.Logger.Debugf("allocated relayed address %s on TURN server %s", relayConn.LocalAddr(), serverAddr)
+
+	var relAddr string
+	var relPort int
+	if localAddr, ok := m.LocalAddr().(*net.UDPAddr); ok {
+		relAddr = localAddr.IP.String()
+		relPort = localAddr.Port
+	}
+	return &turnAllocation{
+		client:        client,
+		relayConn:     relayConn,
+		locConn:       locConn,
+		relayNetwork:  relayNetwork,
+		relAddr:       relAddr,
+		relPort:       relPort,
+		relayProtocol: udp,
+		transport:     transport,
+	}, nil
+}
+
+// handleTURNPacket passes the packet to the TURN client of the allocation on the server the packet came from.
+// Returns true if the packet was consumed by the client.
+func (m *UniversalUDPMuxDefault) handleTURNPacket(data []byte, addr net.Addr) bool {
+	m.turnMu.Lock()
+	turnClient, ok := m.turnClients[addr.String()]
+	m.turnMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if stun.IsMessage(data) {
+		msg := &stun.Message{Raw: append([]byte{}, data...)}
+		// the binding responses of the same server are server reflexive ones, see GetXORMappedAddr
+		if err := msg.Decode(); err != nil || msg.Type.Method == stun.MethodBinding {
+			return false
+		}
+	}
+
+	turnClient.transport.handleResponse(data)
+	handled, err := turnClient.client.HandleInbound(data, addr)
+	if err != nil {
+		m.params.Logger.Debugf("failed to handle TURN packet from %s: %v", addr, err)
+	}
+	return handled
+}
+
+// RemoveConnByUfrag stops and removes the muxed packet connections of the agent including the relayed ones
+func (m *UniversalUDPMuxDefault) RemoveConnByUfrag(ufrag string) {
+	m.UDPMuxDefault.RemoveConnByUfrag(ufrag)
+	m.relayPool.RemoveConnByUfrag(ufrag)
+}
+
+// Close releases the TURN allocations and closes the mux
+func (m *UniversalUDPMuxDefault) Close() error {
+	_ = m.relayPool.Close()
+	return m.UDPMuxDefault.Close()
 }
 
 // GetConnForURL add uniques to the muxed connection by concatenating ufrag and URL (e.g. STUN URL) to be able to support multiple STUN/TURN servers
@@ -90,10 +209,16 @@ func (m *UniversalUDPMuxDefault) GetConnForURL(ufrag string, url string, addr ne
 
 // ReadFrom is called by UDPMux connWorker and handles packets coming from the STUN server discovering a mapped address.
 // It passes processed packets further to the UDPMux (maybe this is not really necessary).
+// The packets of the TURN servers are consumed by the TURN clients of the relayed addresses.
 func (c *udpConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
-	n, addr, err = c.PacketConn.ReadFrom(p)
-	if err != nil {
-		return
+	for {
+		n, addr, err = c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return
+		}
+		if !c.mux.handleTURNPacket(p[:n], addr) {
+			break
+		}
 	}
 
 	if stun.IsMessage(p[:n]) {
@@ -264,3 +389,28 @@ func (a *xorMapped) SetAddr(addr *stun.XORMappedAddress) {
 	a.addr = addr
 	a.closeWaiters()
 }
+
+// muxTURNClient is the client of an allocation made over the UDP connection with the key of the allocation in the pool
+type muxTURNClient struct {
+	key       string
+	client    *turn.Client
+	transport *turnTransportConn
+}
+
+// muxTURNConn is the transport of an allocation made over the UDP connection, closing it only stops passing
+// the packets of the server to the client of the allocation
+type muxTURNConn struct {
+	net.PacketConn
+	mux        *UniversalUDPMuxDefault
+	serverAddr string
+	turnClient *muxTURNClient
+}
+
+func (c *muxTURNConn) Close() error {
+	c.mux.turnMu.Lock()
+	defer c.mux.turnMu.Unlock()
+	if c.mux.turnClients[c.serverAddr] == c.turnClient {
+		delete(c.mux.turnClients, c.serverAddr)
+	}
+	return nil
+}
@@ -199,6 +199,15 @@ func localInterfaces(vnet *vnet.Net, interfaceFilter func(string) bool, ipFilter
 	return ips, nil
 }
 
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func listenUDPInPortRange(vnet *vnet.Net, log logging.LeveledLogger, portMax, portMin int, network string, lAddr *net.UDPAddr) (vnet.UDPPacketConn, error) {
 	if (lAddr.Port != 0) || ((portMin == 0) && (portMax == 0)) {
 		return vnet.ListenUDP(network, lAddr)
@@ -0,0 +1,71 @@
+package lazyconn
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// activityListener is a local UDP socket installed as a placeholder WireGuard endpoint of a lazy peer.
+// WireGuard sends a handshake initiation to it as soon as there is outbound traffic for the peer,
+// which is the signal that the real connection has to be negotiated.
+type activityListener struct {
+	peerKey    string
+	conn       *net.UDPConn
+	onActivity func(peerKey string)
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// newActivityListener binds a loopback UDP socket and starts waiting for the first packet
+func newActivityListener(peerKey string, onActivity func(peerKey string)) (*activityListener, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &activityListener{
+		peerKey:    peerKey,
+		conn:       conn,
+		onActivity: onActivity,
+		done:       make(chan struct{}),
+	}
+	go l.listen()
+	return l, nil
+}
+
+// Addr returns the address WireGuard should use as the peer endpoint
+func (l *activityListener) Addr() *net.UDPAddr {
+	return l.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (l *activityListener) listen() {
+	buf := make([]byte, 1500)
+	_, _, err := l.conn.ReadFromUDP(buf)
+	select {
+	case <-l.done:
+		// closed by the manager, not an activity
+		return
+	default:
+	}
+	if err != nil {
+		log.Debugf("activity listener of peer %s stopped: %v", l.peerKey, err)
+		return
+	}
+
+	log.Debugf("detected outbound traffic to lazy peer %s", l.peerKey)
+	l.Close()
+	l.onActivity(l.peerKey)
+}
+
+// Close stops the listener without triggering the activity callback
+func (l *activityListener) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err := l.conn.Close()
+		if err != nil {
+			log.Debugf("failed closing activity listener of peer %s: %v", l.peerKey, err)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package lazyconn
+
+import (
+	"time"
+)
+
+// idleTrafficThreshold is the amount of bytes per check interval that is still considered idle.
+// WireGuard keepalives and periodic re-handshakes keep generating a little traffic on a connection nobody uses.
+const idleTrafficThreshold = 512
+
+// inactivityTracker follows WireGuard transfer counters of an active peer to detect when it went idle
+type inactivityTracker struct {
+	lastBytes    int64
+	lastActivity time.Time
+}
+
+func newInactivityTracker(now time.Time) *inactivityTracker {
+	return &inactivityTracker{
+		lastBytes:    -1,
+		lastActivity: now,
+	}
+}
+
+// update records the current transfer counter (received + transmitted bytes) of the peer
+// and returns true when no real traffic has been seen for longer than idleTimeout
+func (t *inactivityTracker) update(bytes int64, now time.Time, idleTimeout time.Duration) bool {
+	if t.lastBytes < 0 || bytes < t.lastBytes || bytes-t.lastBytes > idleTrafficThreshold {
+		t.lastActivity = now
+	}
+	t.lastBytes = bytes
+
+	return now.Sub(t.lastActivity) > idleTimeout
+}
@@ -0,0 +1,245 @@
+package lazyconn
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// inactivityCheckInterval is how often WireGuard transfer counters of active lazy peers are checked
+const inactivityCheckInterval = 30 * time.Second
+
+// wgIface is the part of iface.WGIface used by the Manager
+type wgIface interface {
+	GetName() string
+	UpdatePeer(peerKey string, allowedIps string, keepAlive time.Duration, endpoint *net.UDPAddr, preSharedKey *wgtypes.Key) error
+	RemovePeer(peerKey string) error
+}
+
+// PeerConfig is the WireGuard configuration of a lazy peer
+type PeerConfig struct {
+	PublicKey    string
+	AllowedIPs   string
+	PreSharedKey *wgtypes.Key
+}
+
+// Manager keeps peers installed in WireGuard with a placeholder endpoint until they are needed.
+// The connection to a peer is activated on outbound traffic (reported through onActivity) and
+// deactivated again once the peer has been idle for idleTimeout (reported through onIdle).
+type Manager struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wgInterface wgIface
+	idleTimeout time.Duration
+
+	onActivity func(peerKey string)
+	onIdle     func(peerKey string)
+
+	// transferStats returns received + transmitted bytes per peer key of the WireGuard interface
+	transferStats func() (map[string]int64, error)
+
+	mu sync.Mutex
+	// inactive holds the activity listeners of peers waiting for traffic
+	inactive map[string]*activityListener
+	// active holds inactivity trackers of peers with an activated connection
+	active map[string]*inactivityTracker
+}
+
+// NewManager creates a new lazy connection Manager.
+// An idleTimeout of 0 means that activated connections are never torn down.
+func NewManager(ctx context.Context, wgInterface wgIface, idleTimeout time.Duration, onActivity, onIdle func(peerKey string)) *Manager {
+	mCtx, cancel := context.WithCancel(ctx)
+	m := &Manager{
+		ctx:         mCtx,
+		cancel:      cancel,
+		wgInterface: wgInterface,
+		idleTimeout: idleTimeout,
+		onActivity:  onActivity,
+		onIdle:      onIdle,
+		inactive:    make(map[string]*activityListener),
+		active:      make(map[string]*inactivityTracker),
+	}
+	m.transferStats = m.wgTransferStats
+	return m
+}
+
+// Start starts monitoring activated peers for inactivity
+func (m *Manager) Start() {
+	if m.idleTimeout == 0 {
+		return
+	}
+	go m.monitorInactivity()
+}
+
+// Close stops the Manager and closes all activity listeners.
+// Placeholder WireGuard peers are left to be removed together with the interface.
+func (m *Manager) Close() {
+	m.cancel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, l := range m.inactive {
+		l.Close()
+		delete(m.inactive, key)
+	}
+	m.active = make(map[string]*inactivityTracker)
+}
+
+// AddPeer installs the peer in WireGuard with a local placeholder endpoint and waits for outbound traffic to it
+func (m *Manager) AddPeer(peerCfg PeerConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inactive[peerCfg.PublicKey]; ok {
+		return nil
+	}
+	delete(m.active, peerCfg.PublicKey)
+
+	l, err := newActivityListener(peerCfg.PublicKey, m.handleActivity)
+	if err != nil {
+		return err
+	}
+
+	// no keepalive, otherwise WireGuard itself would wake the peer up
+	err = m.wgInterface.UpdatePeer(peerCfg.PublicKey, peerCfg.AllowedIPs, 0, l.Addr(), peerCfg.PreSharedKey)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	m.inactive[peerCfg.PublicKey] = l
+	log.Debugf("peer %s installed in lazy mode, waiting for traffic on %s", peerCfg.PublicKey, l.Addr())
+	return nil
+}
+
+// RemovePeer stops tracking the peer. The placeholder of a peer that was never activated is removed from WireGuard.
+func (m *Manager) RemovePeer(peerKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.active, peerKey)
+
+	l, ok := m.inactive[peerKey]
+	if !ok {
+		return
+	}
+	l.Close()
+	delete(m.inactive, peerKey)
+
+	err := m.wgInterface.RemovePeer(peerKey)
+	if err != nil {
+		log.Warnf("failed removing lazy peer %s placeholder from WireGuard: %v", peerKey, err)
+	}
+}
+
+// ActivatePeer marks the peer as active, e.g. when a remote offer arrived before any outbound traffic.
+// Returns true if the peer was waiting for activation.
+func (m *Manager) ActivatePeer(peerKey string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.activate(peerKey)
+}
+
+// IsActive returns true if the connection to the peer has been activated
+func (m *Manager) IsActive(peerKey string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.active[peerKey]
+	return ok
+}
+
+// activate assumes the lock is held
+func (m *Manager) activate(peerKey string) bool {
+	l, ok := m.inactive[peerKey]
+	if !ok {
+		return false
+	}
+	l.Close()
+	delete(m.inactive, peerKey)
+	m.active[peerKey] = newInactivityTracker(time.Now())
+	return true
+}
+
+func (m *Manager) handleActivity(peerKey string) {
+	m.mu.Lock()
+	activated := m.activate(peerKey)
+	m.mu.Unlock()
+
+	if activated {
+		m.onActivity(peerKey)
+	}
+}
+
+func (m *Manager) monitorInactivity() {
+	ticker := time.NewTicker(inactivityCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, peerKey := range m.checkInactivity(time.Now()) {
+				log.Infof("peer %s has been idle for %s, closing the connection", peerKey, m.idleTimeout)
+				m.onIdle(peerKey)
+			}
+		}
+	}
+}
+
+// checkInactivity returns peers that have been idle for longer than idleTimeout and stops tracking them
+func (m *Manager) checkInactivity(now time.Time) []string {
+	stats, err := m.transferStats()
+	if err != nil {
+		log.Debugf("failed reading WireGuard transfer stats: %v", err)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var idle []string
+	for peerKey, tracker := range m.active {
+		bytes, ok := stats[peerKey]
+		if !ok {
+			// the connection is still being negotiated, so the peer is not in WireGuard yet
+			tracker.lastActivity = now
+			continue
+		}
+		if tracker.update(bytes, now, m.idleTimeout) {
+			idle = append(idle, peerKey)
+			delete(m.active, peerKey)
+		}
+	}
+	return idle
+}
+
+func (m *Manager) wgTransferStats() (map[string]int64, error) {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := wg.Close()
+		if err != nil {
+			log.Debugf("failed closing wgctrl client: %v", err)
+		}
+	}()
+
+	device, err := wg.Device(m.wgInterface.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int64, len(device.Peers))
+	for _, p := range device.Peers {
+		stats[p.PublicKey.String()] = p.ReceiveBytes + p.TransmitBytes
+	}
+	return stats, nil
+}
@@ -0,0 +1,120 @@
+package lazyconn
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const testPeerKey = "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+
+type mockWgIface struct {
+	mu        sync.Mutex
+	endpoints map[string]*net.UDPAddr
+}
+
+func (m *mockWgIface) GetName() string {
+	return "wt-test"
+}
+
+func (m *mockWgIface) UpdatePeer(peerKey string, _ string, _ time.Duration, endpoint *net.UDPAddr, _ *wgtypes.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.endpoints[peerKey] = endpoint
+	return nil
+}
+
+func (m *mockWgIface) RemovePeer(peerKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.endpoints, peerKey)
+	return nil
+}
+
+func (m *mockWgIface) endpoint(peerKey string) *net.UDPAddr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.endpoints[peerKey]
+}
+
+func TestManager_ActivatesOnTraffic(t *testing.T) {
+	wg := &mockWgIface{endpoints: make(map[string]*net.UDPAddr)}
+	activated := make(chan string, 1)
+	m := NewManager(context.Background(), wg, 0, func(peerKey string) {
+		activated <- peerKey
+	}, func(string) {})
+	defer m.Close()
+
+	err := m.AddPeer(PeerConfig{PublicKey: testPeerKey, AllowedIPs: "100.64.0.2/32"})
+	require.NoError(t, err)
+
+	endpoint := wg.endpoint(testPeerKey)
+	require.NotNil(t, endpoint, "placeholder endpoint should be installed")
+	assert.False(t, m.IsActive(testPeerKey))
+
+	conn, err := net.DialUDP("udp4", nil, endpoint)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("handshake"))
+	require.NoError(t, err)
+
+	select {
+	case peerKey := <-activated:
+		assert.Equal(t, testPeerKey, peerKey)
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer hasn't been activated by outbound traffic")
+	}
+	assert.True(t, m.IsActive(testPeerKey))
+	assert.False(t, m.ActivatePeer(testPeerKey), "already active peer shouldn't be activated twice")
+}
+
+func TestManager_RemovePeer(t *testing.T) {
+	wg := &mockWgIface{endpoints: make(map[string]*net.UDPAddr)}
+	m := NewManager(context.Background(), wg, 0, func(string) {
+		t.Error("removed peer shouldn't be activated")
+	}, func(string) {})
+	defer m.Close()
+
+	err := m.AddPeer(PeerConfig{PublicKey: testPeerKey, AllowedIPs: "100.64.0.2/32"})
+	require.NoError(t, err)
+
+	m.RemovePeer(testPeerKey)
+	assert.Nil(t, wg.endpoint(testPeerKey), "placeholder should be removed from WireGuard")
+	assert.False(t, m.ActivatePeer(testPeerKey))
+}
+
+func TestManager_CheckInactivity(t *testing.T) {
+	wg := &mockWgIface{endpoints: make(map[string]*net.UDPAddr)}
+	m := NewManager(context.Background(), wg, time.Minute, func(string) {}, func(string) {})
+	defer m.Close()
+
+	err := m.AddPeer(PeerConfig{PublicKey: testPeerKey, AllowedIPs: "100.64.0.2/32"})
+	require.NoError(t, err)
+	require.True(t, m.ActivatePeer(testPeerKey))
+
+	var bytes int64
+	m.transferStats = func() (map[string]int64, error) {
+		return map[string]int64{testPeerKey: bytes}, nil
+	}
+
+	now := time.Now()
+	assert.Empty(t, m.checkInactivity(now))
+
+	// keepalives only
+	bytes += 64
+	assert.Empty(t, m.checkInactivity(now.Add(30*time.Second)))
+
+	// real traffic resets the idle period
+	bytes += 10000
+	assert.Empty(t, m.checkInactivity(now.Add(50*time.Second)))
+	assert.Empty(t, m.checkInactivity(now.Add(100*time.Second)))
+
+	assert.Equal(t, []string{testPeerKey}, m.checkInactivity(now.Add(111*time.Second)))
+	assert.False(t, m.IsActive(testPeerKey), "idle peer should not be tracked anymore")
+}
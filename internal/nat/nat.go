@@ -0,0 +1,322 @@
+// Package nat discovers the mapping and filtering behaviour of the NAT in front of this host (RFC 5780),
+// e.g. to tell whether connections fall back to relays because of a symmetric NAT.
+package nat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+const (
+	// defaultRequestTimeout is how long a response to a request is waited for before it is sent again
+	defaultRequestTimeout = 500 * time.Millisecond
+	// defaultRequestAttempts is how many times a request is sent before the server is considered not answering,
+	// the filtering tests expect the responses of some requests to be dropped by the NAT
+	defaultRequestAttempts = 3
+
+	changeIPFlag   = 0x04
+	changePortFlag = 0x02
+)
+
+// ErrNoResponse is returned when none of the STUN servers has answered
+var ErrNoResponse = errors.New("no response from the STUN servers")
+
+// Behavior is the mapping or filtering behaviour of a NAT as defined by RFC 4787
+type Behavior int
+
+const (
+	// BehaviorUnknown is a behaviour that couldn't be determined, e.g. the STUN server doesn't support RFC 5780
+	BehaviorUnknown Behavior = iota
+	// BehaviorEndpointIndependent reuses the mapping or accepts packets for any remote address and port
+	BehaviorEndpointIndependent
+	// BehaviorAddressDependent uses a mapping or accepts packets per remote address
+	BehaviorAddressDependent
+	// BehaviorAddressAndPortDependent uses a mapping or accepts packets per remote address and port
+	BehaviorAddressAndPortDependent
+)
+
+func (b Behavior) String() string {
+	switch b {
+	case BehaviorEndpointIndependent:
+		return "endpoint-independent"
+	case BehaviorAddressDependent:
+		return "address-dependent"
+	case BehaviorAddressAndPortDependent:
+		return "address-and-port-dependent"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the discovered behaviour of the NAT
+type Result struct {
+	// PublicAddr is the address the STUN server has seen our requests coming from
+	PublicAddr *net.UDPAddr
+	// BehindNAT is false if the public address is a local one
+	BehindNAT bool
+	Mapping   Behavior
+	Filtering Behavior
+}
+
+// Symmetric returns true if the NAT maps each remote address to a different public address (a "symmetric NAT"),
+// so the public address learned from a STUN server is of no use to the remote peers
+func (r Result) Symmetric() bool {
+	return r.BehindNAT && (r.Mapping == BehaviorAddressDependent || r.Mapping == BehaviorAddressAndPortDependent)
+}
+
+func (r Result) String() string {
+	if !r.BehindNAT {
+		return fmt.Sprintf("no NAT, public address %s, filtering %s", r.PublicAddr, r.Filtering)
+	}
+	return fmt.Sprintf("public address %s, mapping %s, filtering %s", r.PublicAddr, r.Mapping, r.Filtering)
+}
+
+// Discoverer runs the NAT behaviour discovery tests of RFC 5780
+type Discoverer struct {
+	// RequestTimeout is how long a response is waited for before the request is sent again, 500ms by default
+	RequestTimeout time.Duration
+	// RequestAttempts is how many times a request is sent, 3 by default
+	RequestAttempts int
+}
+
+// Discover runs the NAT behaviour discovery with the default Discoverer
+func Discover(ctx context.Context, conn net.PacketConn, servers []string) (Result, error) {
+	return Discoverer{}.Discover(ctx, conn, servers)
+}
+
+// Discover sends the STUN requests of the discovery tests over conn to the servers (host:port).
+// The first server answering is used for the tests. If it doesn't support RFC 5780 (no OTHER-ADDRESS),
+// the mapping is told from the public addresses seen by two servers and the filtering stays unknown.
+func (d Discoverer) Discover(ctx context.Context, conn net.PacketConn, servers []string) (Result, error) {
+	if d.RequestTimeout == 0 {
+		d.RequestTimeout = defaultRequestTimeout
+	}
+	if d.RequestAttempts == 0 {
+		d.RequestAttempts = defaultRequestAttempts
+	}
+
+	var addrs []*net.UDPAddr
+	for _, server := range servers {
+		addr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	var result Result
+	var primary *net.UDPAddr
+	var other *net.UDPAddr
+	for i, addr := range addrs {
+		response, err := d.request(ctx, conn, addr, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Result{}, ctx.Err()
+			}
+			continue
+		}
+		primary = addr
+		result.PublicAddr = response.mapped
+		other = response.other
+		addrs = addrs[i+1:]
+		break
+	}
+	if primary == nil {
+		return Result{}, ErrNoResponse
+	}
+	result.BehindNAT = !isLocalAddr(result.PublicAddr, conn.LocalAddr())
+
+	if other != nil {
+		// the filtering is tested first, while the NAT has only seen packets to the primary address of the server
+		result.Filtering = d.filteringBehavior(ctx, conn, primary)
+		result.Mapping = d.mappingBehavior(ctx, conn, primary, other, result.PublicAddr)
+	} else {
+		result.Mapping = d.mappingBehaviorOf(ctx, conn, primary, addrs, result.PublicAddr)
+	}
+	if !result.BehindNAT {
+		result.Mapping = BehaviorEndpointIndependent
+	}
+	return result, ctx.Err()
+}
+
+// mappingBehavior runs the mapping tests II and III of RFC 5780 against the other address of the server
+func (d Discoverer) mappingBehavior(ctx context.Context, conn net.PacketConn, primary, other, mapped *net.UDPAddr) Behavior {
+	// test II: the alternate address and the primary port
+	response, err := d.request(ctx, conn, &net.UDPAddr{IP: other.IP, Port: primary.Port}, 0)
+	if err != nil {
+		return BehaviorUnknown
+	}
+	if equalAddr(response.mapped, mapped) {
+		return BehaviorEndpointIndependent
+	}
+
+	// test III: the alternate address and port
+	mappedII := response.mapped
+	response, err = d.request(ctx, conn, other, 0)
+	if err != nil {
+		return BehaviorUnknown
+	}
+	if equalAddr(response.mapped, mappedII) {
+		return BehaviorAddressDependent
+	}
+	return BehaviorAddressAndPortDependent
+}
+
+// mappingBehaviorOf compares the public address seen by another server, without OTHER-ADDRESS the port dependency
+// can't be told apart and a changing mapping is reported as address-dependent
+func (d Discoverer) mappingBehaviorOf(ctx context.Context, conn net.PacketConn, primary *net.UDPAddr, servers []*net.UDPAddr, mapped *net.UDPAddr) Behavior {
+	for _, server := range servers {
+		if server.IP.Equal(primary.IP) {
+			continue
+		}
+		response, err := d.request(ctx, conn, server, 0)
+		if err != nil {
+			continue
+		}
+		if equalAddr(response.mapped, mapped) {
+			return BehaviorEndpointIndependent
+		}
+		return BehaviorAddressDependent
+	}
+	return BehaviorUnknown
+}
+
+// filteringBehavior runs the filtering tests II and III of RFC 5780, asking the server to respond from its other address
+func (d Discoverer) filteringBehavior(ctx context.Context, conn net.PacketConn, primary *net.UDPAddr) Behavior {
+	// test II: the response comes from the alternate address and port
+	_, err := d.request(ctx, conn, primary, changeIPFlag|changePortFlag)
+	if err == nil {
+		return BehaviorEndpointIndependent
+	}
+	if ctx.Err() != nil {
+		return BehaviorUnknown
+	}
+
+	// test III: the response comes from the alternate port
+	_, err = d.request(ctx, conn, primary, changePortFlag)
+	if err == nil {
+		return BehaviorAddressDependent
+	}
+	if ctx.Err() != nil {
+		return BehaviorUnknown
+	}
+	return BehaviorAddressAndPortDependent
+}
+
+// bindingResponse holds the addresses of a Binding success response
+type bindingResponse struct {
+	mapped *net.UDPAddr
+	// other is the OTHER-ADDRESS of the server, nil if it doesn't support RFC 5780
+	other *net.UDPAddr
+}
+
+// changeRequest is the CHANGE-REQUEST attribute asking the server to respond from another address or port
+type changeRequest byte
+
+func (c changeRequest) AddTo(m *stun.Message) error {
+	m.Add(stun.AttrChangeRequest, []byte{0, 0, 0, byte(c)})
+	return nil
+}
+
+// request sends a Binding request to the server and waits for its response, the request is sent again on timeout.
+// Responses to other transactions (e.g. late ones of previous tests) are ignored.
+func (d Discoverer) request(ctx context.Context, conn net.PacketConn, server *net.UDPAddr, change changeRequest) (*bindingResponse, error) {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if change != 0 {
+		setters = append(setters, change)
+	}
+	setters = append(setters, stun.Fingerprint)
+	request, err := stun.Build(setters...)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < d.RequestAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, err = conn.WriteTo(request.Raw, server); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(d.RequestTimeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err = conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, readErr := conn.ReadFrom(buf)
+			if readErr != nil {
+				var netErr net.Error
+				if errors.As(readErr, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, readErr
+			}
+			response, ok := parseResponse(buf[:n], request.TransactionID)
+			if ok {
+				_ = conn.SetReadDeadline(time.Time{})
+				return response, nil
+			}
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return nil, fmt.Errorf("no response from %s", server)
+}
+
+// parseResponse returns the addresses of a Binding success response to the transaction
+func parseResponse(raw []byte, transactionID [stun.TransactionIDSize]byte) (*bindingResponse, bool) {
+	msg := &stun.Message{Raw: append([]byte(nil), raw...)}
+	if err := msg.Decode(); err != nil {
+		return nil, false
+	}
+	if msg.TransactionID != transactionID || msg.Type != stun.BindingSuccess {
+		return nil, false
+	}
+
+	var xorMapped stun.XORMappedAddress
+	if err := xorMapped.GetFrom(msg); err != nil {
+		return nil, false
+	}
+	response := &bindingResponse{mapped: &net.UDPAddr{IP: xorMapped.IP, Port: xorMapped.Port}}
+
+	var other stun.OtherAddress
+	if err := other.GetFrom(msg); err == nil {
+		response.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+	}
+	return response, true
+}
+
+func equalAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// isLocalAddr returns true if the public address is the local address of the socket, i.e. there is no NAT
+func isLocalAddr(public *net.UDPAddr, local net.Addr) bool {
+	localAddr, ok := local.(*net.UDPAddr)
+	if !ok || localAddr.Port != public.Port {
+		return false
+	}
+	if !localAddr.IP.IsUnspecified() {
+		return localAddr.IP.Equal(public.IP)
+	}
+
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range interfaceAddrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(public.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNAT rewrites the source address the stand-in servers see and drops the responses its filtering wouldn't let in.
+// A nil fakeNAT means there is no NAT.
+type fakeNAT struct {
+	mapping   Behavior
+	filtering Behavior
+
+	mu sync.Mutex
+	// ports are the public ports of the mappings
+	ports map[string]int
+	// contacted are the remote addresses the client has sent packets to
+	contacted map[string]bool
+}
+
+var fakePublicIP = net.IPv4(203, 0, 113, 1)
+
+func newFakeNAT(mapping, filtering Behavior) *fakeNAT {
+	return &fakeNAT{
+		mapping:   mapping,
+		filtering: filtering,
+		ports:     make(map[string]int),
+		contacted: make(map[string]bool),
+	}
+}
+
+// outbound returns the public address of a packet sent to dst
+func (n *fakeNAT) outbound(dst *net.UDPAddr) *net.UDPAddr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.contacted[dst.String()] = true
+	n.contacted[dst.IP.String()] = true
+
+	var key string
+	switch n.mapping {
+	case BehaviorAddressDependent:
+		key = dst.IP.String()
+	case BehaviorAddressAndPortDependent:
+		key = dst.String()
+	}
+	port, ok := n.ports[key]
+	if !ok {
+		port = 40000 + len(n.ports)
+		n.ports[key] = port
+	}
+	return &net.UDPAddr{IP: fakePublicIP, Port: port}
+}
+
+// inbound returns true if a packet from src is let in
+func (n *fakeNAT) inbound(src *net.UDPAddr) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.filtering {
+	case BehaviorAddressDependent:
+		return n.contacted[src.IP.String()]
+	case BehaviorAddressAndPortDependent:
+		return n.contacted[src.String()]
+	default:
+		return true
+	}
+}
+
+// stunStandIn is a STUN server listening on two addresses and two ports, answering the RFC 5780 requests
+type stunStandIn struct {
+	// conns are indexed by the address and the port
+	conns [2][2]*net.UDPConn
+	// rfc5780 tells whether the responses carry OTHER-ADDRESS and CHANGE-REQUEST is honoured
+	rfc5780 bool
+	nat     *fakeNAT
+}
+
+// startSTUNStandIn listens on ip and ip+1, the test is skipped if the second loopback address can't be used
+func startSTUNStandIn(t *testing.T, ip net.IP, rfc5780 bool, nat *fakeNAT) *stunStandIn {
+	t.Helper()
+	ip = ip.To4()
+	otherIP := net.IPv4(ip[0], ip[1], ip[2], ip[3]+1)
+
+	s := &stunStandIn{rfc5780: rfc5780, nat: nat}
+	var err error
+	for p := 0; p < 2; p++ {
+		s.conns[0][p], err = net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+		require.NoError(t, err)
+		port := s.conns[0][p].LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+		s.conns[1][p], err = net.ListenUDP("udp4", &net.UDPAddr{IP: otherIP, Port: port})
+		if err != nil {
+			t.Skipf("can't listen on %s: %v", otherIP, err)
+		}
+	}
+	for a := range s.conns {
+		for p := range s.conns[a] {
+			conn := s.conns[a][p]
+			t.Cleanup(func() { _ = conn.Close() })
+			go s.serve(a, p)
+		}
+	}
+	return s
+}
+
+func (s *stunStandIn) addr() string {
+	return s.conns[0][0].LocalAddr().String()
+}
+
+func (s *stunStandIn) serve(a, p int) {
+	conn := s.conns[a][p]
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if request.Decode() != nil || request.Type != stun.BindingRequest {
+			continue
+		}
+
+		mapped := src
+		if s.nat != nil {
+			mapped = s.nat.outbound(conn.LocalAddr().(*net.UDPAddr)) //nolint:forcetypeassert
+		}
+		setters := []stun.Setter{
+			stun.NewTransactionIDSetter(request.TransactionID), stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: mapped.IP, Port: mapped.Port},
+		}
+
+		from := conn
+		if s.rfc5780 {
+			other := s.conns[1][1].LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+			setters = append(setters, &stun.OtherAddress{IP: other.IP, Port: other.Port})
+			if value, err := request.Get(stun.AttrChangeRequest); err == nil && len(value) == 4 {
+				fromA, fromP := a, p
+				if value[3]&changeIPFlag != 0 {
+					fromA ^= 1
+				}
+				if value[3]&changePortFlag != 0 {
+					fromP ^= 1
+				}
+				from = s.conns[fromA][fromP]
+			}
+		}
+		setters = append(setters, stun.Fingerprint)
+
+		if s.nat != nil && !s.nat.inbound(from.LocalAddr().(*net.UDPAddr)) { //nolint:forcetypeassert
+			continue
+		}
+		response, err := stun.Build(setters...)
+		if err != nil {
+			continue
+		}
+		_, _ = from.WriteToUDP(response.Raw, src)
+	}
+}
+
+func listenClient(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+var testDiscoverer = Discoverer{RequestTimeout: 50 * time.Millisecond, RequestAttempts: 2}
+
+func TestDiscover(t *testing.T) {
+	testCases := []struct {
+		name      string
+		nat       *fakeNAT
+		behindNAT bool
+		mapping   Behavior
+		filtering Behavior
+		symmetric bool
+	}{
+		{
+			name:      "no NAT",
+			mapping:   BehaviorEndpointIndependent,
+			filtering: BehaviorEndpointIndependent,
+		},
+		{
+			name:      "full cone",
+			nat:       newFakeNAT(BehaviorEndpointIndependent, BehaviorEndpointIndependent),
+			behindNAT: true,
+			mapping:   BehaviorEndpointIndependent,
+			filtering: BehaviorEndpointIndependent,
+		},
+		{
+			name:      "restricted cone",
+			nat:       newFakeNAT(BehaviorEndpointIndependent, BehaviorAddressDependent),
+			behindNAT: true,
+			mapping:   BehaviorEndpointIndependent,
+			filtering: BehaviorAddressDependent,
+		},
+		{
+			name:      "port restricted cone",
+			nat:       newFakeNAT(BehaviorEndpointIndependent, BehaviorAddressAndPortDependent),
+			behindNAT: true,
+			mapping:   BehaviorEndpointIndependent,
+			filtering: BehaviorAddressAndPortDependent,
+		},
+		{
+			name:      "address-dependent mapping",
+			nat:       newFakeNAT(BehaviorAddressDependent, BehaviorAddressDependent),
+			behindNAT: true,
+			mapping:   BehaviorAddressDependent,
+			filtering: BehaviorAddressDependent,
+			symmetric: true,
+		},
+		{
+			name:      "symmetric",
+			nat:       newFakeNAT(BehaviorAddressAndPortDependent, BehaviorAddressAndPortDependent),
+			behindNAT: true,
+			mapping:   BehaviorAddressAndPortDependent,
+			filtering: BehaviorAddressAndPortDependent,
+			symmetric: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := startSTUNStandIn(t, net.IPv4(127, 0, 0, 1), true, testCase.nat)
+			conn := listenClient(t)
+
+			result, err := testDiscoverer.Discover(context.Background(), conn, []string{"127.0.0.1:1", server.addr()})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.behindNAT, result.BehindNAT)
+			assert.Equal(t, testCase.mapping, result.Mapping, "mapping")
+			assert.Equal(t, testCase.filtering, result.Filtering, "filtering")
+			assert.Equal(t, testCase.symmetric, result.Symmetric())
+			if testCase.behindNAT {
+				assert.True(t, fakePublicIP.Equal(result.PublicAddr.IP))
+			} else {
+				assert.Equal(t, conn.LocalAddr().String(), result.PublicAddr.String())
+			}
+		})
+	}
+}
+
+func TestDiscover_WithoutOtherAddress(t *testing.T) {
+	for _, mapping := range []Behavior{BehaviorEndpointIndependent, BehaviorAddressAndPortDependent} {
+		t.Run(mapping.String(), func(t *testing.T) {
+			nat := newFakeNAT(mapping, BehaviorEndpointIndependent)
+			first := startSTUNStandIn(t, net.IPv4(127, 0, 0, 1), false, nat)
+			second := startSTUNStandIn(t, net.IPv4(127, 0, 0, 3), false, nat)
+
+			result, err := testDiscoverer.Discover(context.Background(), listenClient(t), []string{first.addr(), second.addr()})
+			require.NoError(t, err)
+			assert.True(t, result.BehindNAT)
+			assert.Equal(t, BehaviorUnknown, result.Filtering)
+			if mapping == BehaviorEndpointIndependent {
+				assert.Equal(t, BehaviorEndpointIndependent, result.Mapping)
+			} else {
+				// the port dependency can't be told without OTHER-ADDRESS
+				assert.Equal(t, BehaviorAddressDependent, result.Mapping)
+			}
+		})
+	}
+
+	// a single server without RFC 5780 support leaves the mapping unknown
+	server := startSTUNStandIn(t, net.IPv4(127, 0, 0, 1), false, newFakeNAT(BehaviorEndpointIndependent, BehaviorEndpointIndependent))
+	result, err := testDiscoverer.Discover(context.Background(), listenClient(t), []string{server.addr()})
+	require.NoError(t, err)
+	assert.Equal(t, BehaviorUnknown, result.Mapping)
+	assert.False(t, result.Symmetric())
+}
+
+func TestDiscover_NoResponse(t *testing.T) {
+	conn := listenClient(t)
+	closed, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := closed.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	_ = closed.Close()
+
+	_, err = testDiscoverer.Discover(context.Background(), conn, []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(addr.Port)), "invalid"})
+	assert.ErrorIs(t, err, ErrNoResponse)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = testDiscoverer.Discover(ctx, conn, []string{addr.String()})
+	assert.ErrorIs(t, err, context.Canceled)
+}
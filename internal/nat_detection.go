@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ztnav2client/internal/ice"
+	"ztnav2client/internal/nat"
+)
+
+const (
+	// natDetectionTimeout is how long the NAT behaviour discovery may take
+	natDetectionTimeout = 10 * time.Second
+	// natDetectionInterval is how often the NAT behaviour is discovered again
+	natDetectionInterval = 10 * time.Minute
+	// natResultTTL is how long a discovered NAT behaviour is used when the discoveries after it have failed,
+	// e.g. after the host has moved to a network blocking the STUN servers
+	natResultTTL = 30 * time.Minute
+	// natSymmetricConfirmations is the number of consecutive discoveries finding a symmetric NAT
+	// before the server reflexive candidates are dropped
+	natSymmetricConfirmations = 2
+	// natSymmetricConfirmationDelay is how long after a first discovery finding a symmetric NAT it is confirmed
+	natSymmetricConfirmationDelay = 30 * time.Second
+)
+
+// errNoUDPSTUNServers is returned when there is no STUN server to discover the NAT behaviour with
+var errNoUDPSTUNServers = errors.New("no UDP STUN server configured")
+
+// stunServerAddrs returns the host:port of the UDP STUN servers, the only ones usable for the NAT behaviour discovery
+func stunServerAddrs(stuns []*ice.URL) []string {
+	var addrs []string
+	for _, url := range stuns {
+		if url.Scheme != ice.SchemeTypeSTUN || url.Proto != ice.ProtoTypeUDP {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(url.Host, strconv.Itoa(url.Port)))
+	}
+	return addrs
+}
+
+// DetectNAT discovers the mapping and filtering behaviour of the NAT (RFC 5780) against the STUN servers
+// from a new UDP socket
+func DetectNAT(ctx context.Context, stuns []*ice.URL) (nat.Result, error) {
+	servers := stunServerAddrs(stuns)
+	if len(servers) == 0 {
+		return nat.Result{}, errNoUDPSTUNServers
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nat.Result{}, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(ctx, natDetectionTimeout)
+	defer cancel()
+	return nat.Discover(ctx, conn, servers)
+}
+
+// natDetector discovers the NAT behaviour in the background whenever the STUN servers change
+// and again every natDetectionInterval, as the NAT in front of a roaming host changes with the network
+type natDetector struct {
+	detect func(ctx context.Context, stuns []*ice.URL) (nat.Result, error)
+	// onUpdate is called with the result of every successful discovery
+	onUpdate func(result nat.Result)
+
+	mu    sync.Mutex
+	stuns []*ice.URL
+	// servers identifies the STUN servers of the latest discovery
+	servers string
+	// result is nil until the first discovery has succeeded
+	result     *nat.Result
+	detectedAt time.Time
+	// symmetricDetections is the number of consecutive discoveries that have found a symmetric NAT
+	symmetricDetections int
+
+	// wakeUp starts a discovery right away, e.g. when the servers have changed
+	wakeUp chan struct{}
+}
+
+func newNATDetector(onUpdate func(result nat.Result)) *natDetector {
+	return &natDetector{
+		detect:   DetectNAT,
+		onUpdate: onUpdate,
+		wakeUp:   make(chan struct{}, 1),
+	}
+}
+
+// setServers starts a discovery if the STUN servers have changed
+func (d *natDetector) setServers(stuns []*ice.URL) {
+	servers := strings.Join(stunServerAddrs(stuns), ",")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if servers == d.servers {
+		return
+	}
+	d.servers = servers
+	d.stuns = append([]*ice.URL(nil), stuns...)
+	d.redetect()
+}
+
+// redetect starts a discovery right away, e.g. when the network has changed
+func (d *natDetector) redetect() {
+	select {
+	case d.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// run discovers the NAT behaviour every natDetectionInterval until the context is done.
+// A symmetric NAT is confirmed after natSymmetricConfirmationDelay, before the server reflexive candidates are dropped.
+func (d *natDetector) run(ctx context.Context) {
+	timer := time.NewTimer(natDetectionInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-d.wakeUp:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		next := natDetectionInterval
+		if d.detectOnce(ctx) {
+			next = natSymmetricConfirmationDelay
+		}
+		timer.Reset(next)
+	}
+}
+
+// detectOnce runs a discovery against the current servers and returns true if a symmetric NAT is yet to be confirmed
+func (d *natDetector) detectOnce(ctx context.Context) bool {
+	d.mu.Lock()
+	stuns, servers := d.stuns, d.servers
+	d.mu.Unlock()
+	if len(stuns) == 0 {
+		return false
+	}
+
+	result, err := d.detect(ctx, stuns)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warnf("failed discovering the NAT behaviour: %v", err)
+		}
+		return false
+	}
+
+	d.mu.Lock()
+	if servers != d.servers {
+		// superseded by a discovery against other servers
+		d.mu.Unlock()
+		return false
+	}
+	d.result = &result
+	d.detectedAt = time.Now()
+	if result.Symmetric() {
+		d.symmetricDetections++
+	} else {
+		d.symmetricDetections = 0
+	}
+	unconfirmed := d.symmetricDetections > 0 && d.symmetricDetections < natSymmetricConfirmations
+	d.mu.Unlock()
+
+	if d.onUpdate != nil {
+		d.onUpdate(result)
+	}
+	return unconfirmed
+}
+
+// getResult returns the latest discovered NAT behaviour,
+// false if it hasn't been discovered yet or if it is older than natResultTTL
+func (d *natDetector) getResult() (nat.Result, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.result == nil || time.Since(d.detectedAt) >= natResultTTL {
+		return nat.Result{}, false
+	}
+	return *d.result, true
+}
+
+// symmetric returns true if the discovered NAT maps each remote address to a different public address,
+// the server reflexive candidates learned from the STUN servers are of no use to the remote peers then.
+// A single discovery isn't trusted as a lost STUN response looks like a changed mapping.
+func (d *natDetector) symmetric() bool {
+	result, ok := d.getResult()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ok && result.Symmetric() && d.symmetricDetections >= natSymmetricConfirmations
+}
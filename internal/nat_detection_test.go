@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ztnav2client/internal/ice"
+	"ztnav2client/internal/nat"
+	nbstatus "ztnav2client/status"
+)
+
+func TestStunServerAddrs(t *testing.T) {
+	stuns := []*ice.URL{
+		mustParseURL(t, "stun:stun.example.com:3478"),
+		mustParseURL(t, "stuns:stun.example.com:5349"),
+		mustParseURL(t, "turn:turn.example.com:3478?transport=udp"),
+		mustParseURL(t, "stun:[2001:db8::1]:3478"),
+	}
+	assert.Equal(t, []string{"stun.example.com:3478", "[2001:db8::1]:3478"}, stunServerAddrs(stuns))
+
+	_, err := DetectNAT(context.Background(), stuns[1:3])
+	assert.ErrorIs(t, err, errNoUDPSTUNServers)
+}
+
+func TestNATDetector(t *testing.T) {
+	symmetric := nat.Result{
+		PublicAddr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 40000},
+		BehindNAT:  true,
+		Mapping:    nat.BehaviorAddressAndPortDependent,
+		Filtering:  nat.BehaviorAddressAndPortDependent,
+	}
+	var detections int32
+	updates := make(chan nat.Result, 1)
+	detector := newNATDetector(func(result nat.Result) { updates <- result })
+	detector.detect = func(ctx context.Context, stuns []*ice.URL) (nat.Result, error) {
+		atomic.AddInt32(&detections, 1)
+		return symmetric, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go detector.run(ctx)
+
+	_, ok := detector.getResult()
+	assert.False(t, ok)
+	assert.False(t, detector.symmetric(), "the NAT is not symmetric until discovered")
+
+	stuns := []*ice.URL{mustParseURL(t, "stun:stun.example.com:3478")}
+	detector.setServers(stuns)
+	select {
+	case result := <-updates:
+		assert.Equal(t, symmetric, result)
+	case <-time.After(time.Second):
+		t.Fatal("NAT behaviour hasn't been discovered")
+	}
+	assert.False(t, detector.symmetric(), "a single discovery shouldn't be trusted")
+
+	// the same servers aren't tested again
+	detector.setServers([]*ice.URL{mustParseURL(t, "stun:stun.example.com:3478")})
+	assert.Len(t, detector.wakeUp, 0)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&detections))
+
+	// but the NAT behaviour is discovered again e.g. on a network change
+	detector.redetect()
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("NAT behaviour hasn't been discovered again")
+	}
+	assert.True(t, detector.symmetric())
+}
+
+func TestNATDetector_Expiry(t *testing.T) {
+	symmetric := nat.Result{
+		PublicAddr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 40000},
+		BehindNAT:  true,
+		Mapping:    nat.BehaviorAddressDependent,
+		Filtering:  nat.BehaviorAddressDependent,
+	}
+	detectErr := error(nil)
+	detector := newNATDetector(nil)
+	detector.detect = func(ctx context.Context, stuns []*ice.URL) (nat.Result, error) {
+		if detectErr != nil {
+			return nat.Result{}, detectErr
+		}
+		return symmetric, nil
+	}
+	detector.setServers([]*ice.URL{mustParseURL(t, "stun:stun.example.com:3478")})
+
+	assert.True(t, detector.detectOnce(context.Background()), "the symmetric NAT should be confirmed")
+	assert.False(t, detector.detectOnce(context.Background()))
+	assert.True(t, detector.symmetric())
+
+	// a failed discovery keeps the previous result until it expires
+	detectErr = errors.New("timeout")
+	assert.False(t, detector.detectOnce(context.Background()))
+	assert.True(t, detector.symmetric())
+
+	detector.mu.Lock()
+	detector.detectedAt = time.Now().Add(-natResultTTL)
+	detector.mu.Unlock()
+	_, ok := detector.getResult()
+	assert.False(t, ok)
+	assert.False(t, detector.symmetric(), "an expired result shouldn't be used")
+
+	// a NAT found not symmetric anymore is trusted right away
+	detectErr = nil
+	symmetric.Mapping = nat.BehaviorEndpointIndependent
+	assert.False(t, detector.detectOnce(context.Background()))
+	assert.False(t, detector.symmetric())
+}
+
+func TestEngine_StunTurnServersBehindSymmetricNAT(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statusRecorder := nbstatus.NewRecorder()
+	engine := NewEngine(ctx, cancel, nil, &EngineConfig{}, statusRecorder)
+	stun := mustParseURL(t, "stun:stun.example.com:3478")
+	turn := mustParseURL(t, "turn:turn.example.com:3478?transport=udp")
+	engine.STUNs = []*ice.URL{stun}
+	engine.TURNs = []*ice.URL{turn}
+	assert.Equal(t, []*ice.URL{stun, turn}, engine.stunTurnServers())
+
+	result := nat.Result{
+		PublicAddr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 40000},
+		BehindNAT:  true,
+		Mapping:    nat.BehaviorAddressDependent,
+		Filtering:  nat.BehaviorAddressDependent,
+	}
+	engine.natDetector.result = &result
+	engine.natDetector.detectedAt = time.Now()
+	engine.natDetector.symmetricDetections = natSymmetricConfirmations
+	engine.onNATDetected(result)
+	assert.Equal(t, []*ice.URL{turn}, engine.stunTurnServers(), "server reflexive candidates are useless behind a symmetric NAT")
+
+	localPeer := statusRecorder.GetFullStatus().LocalPeerState
+	require.Equal(t, "address-dependent", localPeer.NATMapping)
+	assert.Equal(t, "address-dependent", localPeer.NATFiltering)
+	assert.Equal(t, "203.0.113.1:40000", localPeer.PublicAddress)
+}
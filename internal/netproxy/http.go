@@ -0,0 +1,142 @@
+package netproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpConnectTimeout limits the CONNECT handshake if the context has no deadline
+const httpConnectTimeout = 30 * time.Second
+
+// httpConnectDialer tunnels TCP connections through an HTTP proxy with the CONNECT method
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("network %s is not supported by HTTP proxies", network)
+	}
+
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if strings.EqualFold(d.proxyURL.Scheme, "https") {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed dialing HTTP proxy %s: %w", proxyAddr, err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(httpConnectTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	// the handshake is aborted once the context is done
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	tunnel, err := d.handshake(conn, addr)
+	close(stop)
+	<-stopped
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// handshake establishes the TLS session with an https proxy and opens the tunnel to addr
+func (d *httpConnectDialer) handshake(conn net.Conn, addr string) (net.Conn, error) {
+	if strings.EqualFold(d.proxyURL.Scheme, "https") {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname()}) //nolint:gosec
+		err := tlsConn.Handshake()
+		if err != nil {
+			return nil, fmt.Errorf("failed TLS handshake with HTTP proxy %s: %w", d.proxyURL.Host, err)
+		}
+		conn = tlsConn
+	}
+	return d.connect(conn, addr)
+}
+
+// connect asks the proxy to open a tunnel to addr over conn
+func (d *httpConnectDialer) connect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.proxyURL.User != nil {
+		password, _ := d.proxyURL.User.Password()
+		credentials := d.proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed sending CONNECT request to HTTP proxy: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CONNECT response of HTTP proxy: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP proxy refused tunnel to %s: %s", addr, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		// the destination has already sent data along with the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data already read into a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,84 @@
+package peer
+
+import "sort"
+
+// Capability is a feature of the agent that is used only if both peers of a connection support it
+type Capability string
+
+const (
+	// CapabilityDirectMode a direct WireGuard connection without the local proxy
+	CapabilityDirectMode Capability = "direct-mode"
+	// CapabilityTCPCandidates ICE candidates over TCP
+	CapabilityTCPCandidates Capability = "tcp-candidates"
+	// CapabilityEndOfCandidates signaling the end of the local candidates, so the remote agent fails early when no pair works
+	CapabilityEndOfCandidates Capability = "end-of-candidates"
+	// CapabilityRenomination moving an established connection to a better candidate pair (NOMINATION attribute)
+	CapabilityRenomination Capability = "renomination"
+	// CapabilitySealedCandidates candidates sealed to the WireGuard key of the remote peer, plaintext ones are rejected
+	CapabilitySealedCandidates Capability = "sealed-candidates"
+)
+
+// legacyCapabilities are the capabilities of the agents not taking part in the negotiation
+var legacyCapabilities = Capabilities{CapabilityDirectMode}
+
+// Capabilities is a set of capabilities
+type Capabilities []Capability
+
+// LocalCapabilities returns the capabilities supported by this agent
+func LocalCapabilities() Capabilities {
+	return Capabilities{CapabilityDirectMode, CapabilityTCPCandidates, CapabilityEndOfCandidates, CapabilityRenomination,
+		CapabilitySealedCandidates}
+}
+
+// ParseCapabilities converts the capabilities received from the remote peer, unknown ones are kept.
+// Returns nil if the remote peer doesn't negotiate capabilities.
+func ParseCapabilities(capabilities []string) Capabilities {
+	if capabilities == nil {
+		return nil
+	}
+	parsed := make(Capabilities, 0, len(capabilities))
+	for _, c := range capabilities {
+		parsed = append(parsed, Capability(c))
+	}
+	return parsed
+}
+
+// negotiateCapabilities returns the capabilities supported by both peers.
+// The remote peer not negotiating capabilities (nil) is assumed to support the legacy ones.
+func negotiateCapabilities(local, remote Capabilities) Capabilities {
+	if remote == nil {
+		remote = legacyCapabilities
+	}
+	return local.Intersect(remote)
+}
+
+// Has returns true if the capability is in the set
+func (c Capabilities) Has(capability Capability) bool {
+	for _, other := range c {
+		if other == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the capabilities supported by both sets
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	var common Capabilities
+	for _, capability := range c {
+		if other.Has(capability) && !common.Has(capability) {
+			common = append(common, capability)
+		}
+	}
+	return common
+}
+
+// Strings returns the sorted names of the capabilities
+func (c Capabilities) Strings() []string {
+	names := make([]string, 0, len(c))
+	for _, capability := range c {
+		names = append(names, string(capability))
+	}
+	sort.Strings(names)
+	return names
+}
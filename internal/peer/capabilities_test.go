@@ -0,0 +1,39 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	local := Capabilities{CapabilityDirectMode, CapabilityTCPCandidates}
+
+	testCases := []struct {
+		name     string
+		remote   Capabilities
+		expected []string
+	}{
+		{
+			name:     "legacy remote peer",
+			remote:   nil,
+			expected: []string{string(CapabilityDirectMode)},
+		},
+		{
+			name:     "remote peer without capabilities",
+			remote:   Capabilities{},
+			expected: []string{},
+		},
+		{
+			name:     "common capabilities only",
+			remote:   ParseCapabilities([]string{"tcp-candidates", "unknown-feature"}),
+			expected: []string{string(CapabilityTCPCandidates)},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, negotiateCapabilities(local, testCase.remote).Strings())
+		})
+	}
+}
@@ -3,19 +3,37 @@ package peer
 import (
 	"context"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"ztnav2client/internal/candidatefilter"
 	ice "ztnav2client/internal/ice"
+	"ztnav2client/internal/netproxy"
 
 	"github.com/netbirdio/netbird/iface"
 	log "github.com/sirupsen/logrus"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"ztnav2client/internal/proxy"
+	"ztnav2client/internal/signaling"
 	nbStatus "ztnav2client/status"
 	"ztnav2client/system"
 )
 
+const (
+	// closeTimeout is how long Close waits for a running Open to clean up
+	closeTimeout = 10 * time.Second
+
+	// iceConsentCheckInterval is how often the remote peer confirms that it still accepts our traffic (RFC 7675)
+	iceConsentCheckInterval = 5 * time.Second
+	// iceConsentTimeout is how long the connection is kept without a confirmation before it is torn down,
+	// shorter than the 30 seconds of RFC 7675 as the connection is re-established with a new offer anyway
+	iceConsentTimeout = 15 * time.Second
+	// iceRenominationInterval is how often the controlling peer looks for a better candidate pair than the selected one
+	iceRenominationInterval = 10 * time.Second
+	// iceStatsSampleInterval is how often the latency and traffic of the selected candidate pair are published in the peer status
+	iceStatsSampleInterval = 10 * time.Second
+)
+
 // ConnConfig is a peer Connection configuration
 type ConnConfig struct {
 
@@ -32,16 +50,33 @@ type ConnConfig struct {
 	InterfaceBlackList   []string
 	DisableIPv6Discovery bool
 
+	// CandidateFilter holds the interface and IP rules of the candidate gathering, all are allowed if nil
+	CandidateFilter *candidatefilter.Filter
+
 	Timeout time.Duration
 
 	ProxyConfig proxy.Config
 
 	UDPMux      ice.UDPMux
 	UDPMuxSrflx ice.UniversalUDPMux
+	// UDPMuxMappedAddrs are the external addresses the gateway forwards to the UDPMux port, see portmap.Mapper
+	UDPMuxMappedAddrs []*net.UDPAddr
+	// TCPMux accepts the ICE-TCP connections of the passive TCP host candidates, ICE-TCP is disabled if nil
+	TCPMux ice.TCPMux
+	// TURNPool shares the TURN allocations with the other connections, each connection allocates its own if nil
+	TURNPool *ice.TURNPool
+	// ProxyDialer reaches the TURN servers over TCP/TLS through a proxy, the servers are dialed directly if nil
+	ProxyDialer netproxy.Dialer
 
 	LocalWgPort int
 
 	NATExternalIPs []string
+
+	// MulticastDNSMode is the use of mDNS for the host candidates, ice.MulticastDNSModeDisabled if 0
+	MulticastDNSMode ice.MulticastDNSMode
+
+	// Capabilities the local agent offers to the remote peer, LocalCapabilities if nil
+	Capabilities Capabilities
 }
 
 // OfferAnswer represents a session establishment offer or answer
@@ -54,6 +89,15 @@ type OfferAnswer struct {
 
 	// Version of NetBird Agent
 	Version string
+	// Capabilities of the sender, nil if the sender doesn't negotiate capabilities
+	Capabilities Capabilities
+
+	// SessionID identifies the sender's connection attempt, 0 if the remote peer doesn't support sessions
+	SessionID uint64
+	// ReplyToSessionID is the session of the offer an answer replies to
+	ReplyToSessionID uint64
+	// Counter is the sender's message counter, a higher counter means a more recent message
+	Counter uint64
 }
 
 // IceCredentials ICE protocol credentials struct
@@ -67,54 +111,181 @@ type Conn struct {
 	mu     sync.Mutex
 
 	// signalCandidate is a handler function to signal remote peer about local connection candidate
-	signalCandidate func(candidate ice.Candidate) error
+	signalCandidate func(candidate ice.Candidate, sessionID uint64) error
+	// signalEndOfCandidates is an optional handler function to signal remote peer that all local candidates have been signaled
+	signalEndOfCandidates func(sessionID uint64) error
 	// signalOffer is a handler function to signal remote peer our connection offer (credentials)
 	signalOffer  func(OfferAnswer) error
 	signalAnswer func(OfferAnswer) error
+	// onConnected is an optional handler function called once the connection has been established
+	onConnected func()
+	// onGatheringStart is an optional handler function called before the local candidates are gathered,
+	// the attempt fails with its error
+	onGatheringStart func(ctx context.Context) error
+	// onGatheringDone is an optional handler function called once the local candidates have been gathered
+	onGatheringDone func()
+
+	// signalBuffer keeps the remote offer/answer and candidates until Open is ready to use them
+	signalBuffer signalBuffer
+	// remoteOfferAnswerCh notifies Open that a remote offer or answer has been buffered
+	remoteOfferAnswerCh chan struct{}
+	// iceStatsCh asks the running stats sampler to publish the stats of a new selected candidate pair right away
+	iceStatsCh chan struct{}
+	// remoteCandidatesMu guards remoteCandidatesTail, which is closed once the last queued remote candidate
+	// or end of candidates has been handled
+	remoteCandidatesMu   sync.Mutex
+	remoteCandidatesTail chan struct{}
+
+	// closeCtx is cancelled once Close has been called, it is the parent of all the Open contexts
+	closeCtx    context.Context
+	notifyClose context.CancelFunc
+	// openDone is closed once the last Open call has returned, nil if Open has never been called
+	openDone chan struct{}
 
-	// remoteOffersCh is a channel used to wait for remote credentials to proceed with the connection
-	remoteOffersCh chan OfferAnswer
-	// remoteAnswerCh is a channel used to wait for remote credentials answer (confirmation of our offer) to proceed with the connection
-	remoteAnswerCh     chan OfferAnswer
-	closeCh            chan struct{}
 	ctx                context.Context
 	notifyDisconnected context.CancelFunc
 
-	agent  *ice.Agent
-	status ConnStatus
+	agent *ice.Agent
+	state ConnState
+
+	// localSessionID identifies the current connection attempt in the signaling messages sent to the remote peer
+	localSessionID uint64
+	// remoteSessionID is the session of the remote peer used by the current attempt, 0 if unknown or not supported
+	remoteSessionID uint64
+	// capabilities are supported by both peers in the current attempt
+	capabilities Capabilities
+	// sealedCandidates is true if the last remote offer or answer negotiated CapabilitySealedCandidates,
+	// unlike the capabilities it is kept between the attempts
+	sealedCandidates bool
 
 	statusRecorder *nbStatus.Status
 
 	proxy proxy.Proxy
+	// proxyPair is the candidate pair the proxy has been started for
+	proxyPair *ice.CandidatePair
+	// remoteConn and remoteWgPort are kept to restart the proxy when the selected candidate pair changes
+	remoteConn   net.Conn
+	remoteWgPort int
 }
 
 // GetConf returns the connection config
 func (conn *Conn) GetConf() ConnConfig {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
 	return conn.config
 }
 
 // UpdateConf updates the connection config
 func (conn *Conn) UpdateConf(conf ConnConfig) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
 	conn.config = conf
 }
 
+// Capabilities returns the capabilities supported by both peers in the current connection attempt,
+// nil if the remote offer or answer hasn't been received yet
+func (conn *Conn) Capabilities() Capabilities {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.capabilities
+}
+
+// SealedCandidates returns true if the candidates exchanged with the remote peer are sealed, as negotiated by
+// the last remote offer or answer. It is kept between the attempts, so the candidates of a peer sealing them
+// arriving before its next offer or answer must still be sealed. False until the first offer or answer.
+func (conn *Conn) SealedCandidates() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.sealedCandidates
+}
+
+// localCapabilities returns the capabilities offered to the remote peer
+func (conn *Conn) localCapabilities() Capabilities {
+	capabilities := conn.config.Capabilities
+	if capabilities == nil {
+		capabilities = LocalCapabilities()
+	}
+	if conn.config.TCPMux != nil {
+		return capabilities
+	}
+	// there are no TCP candidates to offer without the TCP mux
+	var filtered Capabilities
+	for _, capability := range capabilities {
+		if capability != CapabilityTCPCandidates {
+			filtered = append(filtered, capability)
+		}
+	}
+	return filtered
+}
+
+// isCandidateAllowed returns false for the candidates that must not be used in the current attempt,
+// e.g. TCP candidates if the remote peer doesn't support them. The caller must hold the lock.
+func (conn *Conn) isCandidateAllowed(candidate ice.Candidate) bool {
+	if candidate.NetworkType().IsTCP() && !conn.capabilities.Has(CapabilityTCPCandidates) {
+		return false
+	}
+	return true
+}
+
+// UpdateStunTurn updates the STUN and TURN servers used by the next connection attempt.
+// Unlike UpdateConf it doesn't touch the rest of the config that the agent callbacks of a previous attempt may still read.
+func (conn *Conn) UpdateStunTurn(stunTurn []*ice.URL) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.config.StunTurn = stunTurn
+}
+
+// UpdateUDPMuxMappedAddrs updates the external addresses of the UDPMux port used by the next connection attempt
+func (conn *Conn) UpdateUDPMuxMappedAddrs(addrs []*net.UDPAddr) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.config.UDPMuxMappedAddrs = addrs
+}
+
 // NewConn creates a new not opened Conn to the remote peer.
 // To establish a connection run Conn.Open
 func NewConn(config ConnConfig, statusRecorder *nbStatus.Status) (*Conn, error) {
+	closeCtx, notifyClose := context.WithCancel(context.Background())
 	return &Conn{
-		config:         config,
-		mu:             sync.Mutex{},
-		status:         StatusDisconnected,
-		closeCh:        make(chan struct{}),
-		remoteOffersCh: make(chan OfferAnswer),
-		remoteAnswerCh: make(chan OfferAnswer),
-		statusRecorder: statusRecorder,
+		config:              config,
+		mu:                  sync.Mutex{},
+		state:               StateIdle,
+		closeCtx:            closeCtx,
+		notifyClose:         notifyClose,
+		remoteOfferAnswerCh: make(chan struct{}, 1),
+		iceStatsCh:          make(chan struct{}, 1),
+		statusRecorder:      statusRecorder,
 	}, nil
 }
 
-// interfaceFilter is a function passed to ICE Agent to filter out not allowed interfaces
+// setState moves the Conn to a new state if the transition is allowed, returns false otherwise.
+// Note: the caller should hold the lock.
+func (conn *Conn) setState(newState ConnState) bool {
+	if !canTransition(conn.state, newState) {
+		log.Debugf("peer %s connection can't move from state %s to %s", conn.config.Key, conn.state, newState)
+		return false
+	}
+	log.Tracef("peer %s connection moved from state %s to %s", conn.config.Key, conn.state, newState)
+	conn.state = newState
+	return true
+}
+
+// updateStatusRecorder publishes the current connection status of the peer
+func (conn *Conn) updateStatusRecorder(peerState nbStatus.PeerState) {
+	peerState.PubKey = conn.config.Key
+	peerState.ConnStatus = conn.Status().String()
+	peerState.ConnStatusUpdate = time.Now()
+
+	err := conn.statusRecorder.UpdatePeerState(peerState)
+	if err != nil {
+		// pretty common error because by that time Engine can already remove the peer and status won't be available.
+		log.Debugf("error while updating the state of peer %s, err: %v", conn.config.Key, err)
+	}
+}
+
+// InterfaceFilter is a function passed to ICE Agent to filter out not allowed interfaces
 // to avoid building tunnel over them
-func interfaceFilter(blackList []string) func(string) bool {
+func InterfaceFilter(blackList []string, filter *candidatefilter.Filter) func(string) bool {
 
 	return func(iFace string) bool {
 		for _, s := range blackList {
@@ -123,20 +294,11 @@ func interfaceFilter(blackList []string) func(string) bool {
 				return false
 			}
 		}
-		// look for unlisted WireGuard interfaces
-		wg, err := wgctrl.New()
-		if err != nil {
-			log.Debugf("trying to create a wgctrl client failed with: %v", err)
+		if filter != nil && !filter.InterfaceAllowed(iFace) {
+			return false
 		}
-		defer func() {
-			err := wg.Close()
-			if err != nil {
-				return
-			}
-		}()
-
-		_, err = wg.Device(iFace)
-		return err != nil
+		// look for unlisted WireGuard interfaces
+		return !candidatefilter.IsWireGuard(iFace)
 	}
 }
 
@@ -145,7 +307,8 @@ func (conn *Conn) reCreateAgent() error {
 	defer conn.mu.Unlock()
 
 	failedTimeout := 6 * time.Second
-	//keepAlive := 10 * time.Second
+	consentCheckInterval := iceConsentCheckInterval
+	consentTimeout := iceConsentTimeout
 	var err error
 	agentConfig := &ice.AgentConfig{
 		MulticastDNSMode: ice.MulticastDNSModeDisabled,
@@ -153,24 +316,64 @@ func (conn *Conn) reCreateAgent() error {
 		Urls:             conn.config.StunTurn,
 		CandidateTypes:   []ice.CandidateType{ice.CandidateTypeHost, ice.CandidateTypeServerReflexive, ice.CandidateTypeRelay},
 		FailedTimeout:    &failedTimeout,
-		InterfaceFilter:  interfaceFilter(conn.config.InterfaceBlackList),
+		InterfaceFilter:  InterfaceFilter(conn.config.InterfaceBlackList, conn.config.CandidateFilter),
 		UDPMux:           conn.config.UDPMux,
 		UDPMuxSrflx:      conn.config.UDPMuxSrflx,
+		TCPMux:           conn.config.TCPMux,
+		TURNPool:         conn.config.TURNPool,
 		NAT1To1IPs:       conn.config.NATExternalIPs,
-		//KeepaliveInterval: &keepAlive,
+
+		ConsentCheckInterval: &consentCheckInterval,
+		ConsentTimeout:       &consentTimeout,
 	}
 
 	if conn.config.DisableIPv6Discovery {
 		agentConfig.NetworkTypes = []ice.NetworkType{ice.NetworkTypeUDP4}
 	}
 
+	if conn.config.CandidateFilter != nil {
+		agentConfig.IPFilter = conn.config.CandidateFilter.IPAllowed
+	}
+
+	if conn.config.MulticastDNSMode != 0 {
+		agentConfig.MulticastDNSMode = conn.config.MulticastDNSMode
+	}
+
+	if conn.config.ProxyDialer != nil {
+		agentConfig.ProxyDialer = conn.config.ProxyDialer
+	}
+
+	if len(conn.config.UDPMuxMappedAddrs) > 0 {
+		agentConfig.UDPMuxMappedAddrs = conn.config.UDPMuxMappedAddrs
+	}
+
+	if conn.config.TCPMux != nil {
+		// passive TCP host candidates accept the connections of the remote peer on the TCP mux,
+		// active and simultaneous-open ones connect to the remote TCP candidates
+		agentConfig.TCPTypes = []ice.TCPType{ice.TCPTypePassive, ice.TCPTypeActive, ice.TCPTypeSimultaneousOpen}
+		agentConfig.NetworkTypes = append(agentConfig.NetworkTypes, ice.NetworkTypeTCP4)
+		if !conn.config.DisableIPv6Discovery {
+			agentConfig.NetworkTypes = append(agentConfig.NetworkTypes, ice.NetworkTypeTCP6)
+		}
+	}
+
+	// every attempt is a new session, a remote answer is only valid for the offer of the previous one
+	conn.localSessionID = signaling.NewSessionID()
+	conn.remoteSessionID = 0
+	conn.signalBuffer.discardAnswer()
+
 	conn.agent, err = ice.NewAgent(agentConfig)
 
 	if err != nil {
 		return err
 	}
 
-	err = conn.agent.OnCandidate(conn.onICECandidate)
+	sessionID := conn.localSessionID
+	// the end of candidates is signaled after all the candidates of the session
+	pendingCandidates := &sync.WaitGroup{}
+	err = conn.agent.OnCandidate(func(candidate ice.Candidate) {
+		conn.onICECandidate(candidate, sessionID, pendingCandidates)
+	})
 	if err != nil {
 		return err
 	}
@@ -190,20 +393,31 @@ func (conn *Conn) reCreateAgent() error {
 
 // Open opens connection to the remote peer starting ICE candidate gathering process.
 // Blocks until connection has been closed or connection timeout.
-// ConnStatus will be set accordingly
+// Open can be called again after it returned unless the Conn has been closed.
 func (conn *Conn) Open() error {
 	log.Debugf("trying to connect to peer %s", conn.config.Key)
 
-	peerState := nbStatus.PeerState{PubKey: conn.config.Key}
+	conn.mu.Lock()
+	switch conn.state {
+	case StateClosing, StateClosed:
+		conn.mu.Unlock()
+		return NewConnectionClosedError(conn.config.Key)
+	case StateIdle:
+	default:
+		conn.mu.Unlock()
+		return NewConnectionAlreadyOpenError(conn.config.Key)
+	}
+	conn.setState(StateNegotiating)
+	openDone := make(chan struct{})
+	conn.openDone = openDone
+	conn.mu.Unlock()
 
-	peerState.IP = strings.Split(conn.config.ProxyConfig.AllowedIps, "/")[0]
-	peerState.ConnStatusUpdate = time.Now()
-	peerState.ConnStatus = conn.status.String()
+	// runs after the cleanup so that Close can wait until all the resources have been released
+	defer close(openDone)
 
-	err := conn.statusRecorder.UpdatePeerState(peerState)
-	if err != nil {
-		log.Warnf("erro while updating the state of peer %s,err: %v", conn.config.Key, err)
-	}
+	conn.updateStatusRecorder(nbStatus.PeerState{
+		IP: strings.Split(conn.config.ProxyConfig.AllowedIps, "/")[0],
+	})
 
 	defer func() {
 		err := conn.cleanup()
@@ -213,7 +427,7 @@ func (conn *Conn) Open() error {
 		}
 	}()
 
-	err = conn.reCreateAgent()
+	err := conn.reCreateAgent()
 	if err != nil {
 		return err
 	}
@@ -225,42 +439,66 @@ func (conn *Conn) Open() error {
 
 	log.Debugf("connection offer sent to peer %s, waiting for the confirmation", conn.config.Key)
 
-	// Only continue once we got a connection confirmation from the remote peer.
-	// The connection timeout could have happened before a confirmation received from the remote.
-	// The connection could have also been closed externally (e.g. when we received an update from the management that peer shouldn't be connected)
-	var remoteOfferAnswer OfferAnswer
-	select {
-	case remoteOfferAnswer = <-conn.remoteOffersCh:
-		// received confirmation from the remote peer -> ready to proceed
-		err = conn.sendAnswer()
-		if err != nil {
-			return err
-		}
-	case remoteOfferAnswer = <-conn.remoteAnswerCh:
-	case <-time.After(conn.config.Timeout):
-		return NewConnectionTimeoutError(conn.config.Key, conn.config.Timeout)
-	case <-conn.closeCh:
-		// closed externally
-		return NewConnectionClosedError(conn.config.Key)
+	remoteOfferAnswer, err := conn.waitRemoteOfferAnswer()
+	if err != nil {
+		return err
 	}
 
-	log.Debugf("received connection confirmation from peer %s running version %s and with remote WireGuard listen port %d",
-		conn.config.Key, remoteOfferAnswer.Version, remoteOfferAnswer.WgListenPort)
+	log.Debugf("received connection confirmation from peer %s running version %s with capabilities %v and with remote WireGuard listen port %d",
+		conn.config.Key, remoteOfferAnswer.Version, remoteOfferAnswer.Capabilities.Strings(), remoteOfferAnswer.WgListenPort)
+
+	err = conn.statusRecorder.UpdatePeerCapabilities(conn.config.Key, remoteOfferAnswer.Version, remoteOfferAnswer.Capabilities.Strings())
+	if err != nil {
+		log.Debugf("error while updating peer's %s capabilities, err: %v", conn.config.Key, err)
+	}
 
 	// at this point we received offer/answer and we are ready to gather candidates
 	conn.mu.Lock()
-	conn.status = StatusConnecting
-	conn.ctx, conn.notifyDisconnected = context.WithCancel(context.Background())
-	defer conn.notifyDisconnected()
+	if !conn.setState(StateConnecting) {
+		conn.mu.Unlock()
+		return NewConnectionClosedError(conn.config.Key)
+	}
+	// the attempt is also cancelled when the Conn gets closed
+	conn.ctx, conn.notifyDisconnected = context.WithCancel(conn.closeCtx)
+	ctx := conn.ctx
+	conn.remoteSessionID = remoteOfferAnswer.SessionID
+	conn.capabilities = negotiateCapabilities(conn.localCapabilities(), remoteOfferAnswer.Capabilities)
+	conn.sealedCandidates = conn.capabilities.Has(CapabilitySealedCandidates)
+	// candidates of this remote session could have arrived before Open was ready for them
+	for _, buffered := range conn.signalBuffer.takeCandidates(sessionKey(remoteOfferAnswer), time.Now()) {
+		if conn.sealedCandidates && !buffered.sealed {
+			log.Warnf("dropping buffered remote candidate %s of peer %s, it is not sealed", buffered.candidate.String(), conn.config.Key)
+			continue
+		}
+		if !conn.isCandidateAllowed(buffered.candidate) {
+			continue
+		}
+		err = conn.agent.AddRemoteCandidate(buffered.candidate)
+		if err != nil {
+			log.Errorf("error while replaying buffered remote candidate from peer %s: %v", conn.config.Key, err)
+		}
+	}
+	if conn.signalBuffer.takeEndOfCandidates(sessionKey(remoteOfferAnswer), time.Now()) {
+		conn.setRemoteGatheringComplete()
+	}
+	if conn.capabilities.Has(CapabilityRenomination) {
+		err = conn.agent.SetRenominationInterval(iceRenominationInterval)
+		if err != nil {
+			log.Warnf("failed enabling the renomination with peer %s: %v", conn.config.Key, err)
+		}
+	}
 	conn.mu.Unlock()
 
-	peerState = nbStatus.PeerState{PubKey: conn.config.Key}
+	conn.updateStatusRecorder(nbStatus.PeerState{})
 
-	peerState.ConnStatus = conn.status.String()
-	peerState.ConnStatusUpdate = time.Now()
-	err = conn.statusRecorder.UpdatePeerState(peerState)
-	if err != nil {
-		log.Warnf("erro while updating the state of peer %s,err: %v", conn.config.Key, err)
+	if conn.onGatheringStart != nil {
+		err = conn.onGatheringStart(ctx)
+		if err != nil {
+			if conn.closeCtx.Err() != nil {
+				return NewConnectionClosedError(conn.config.Key)
+			}
+			return err
+		}
 	}
 
 	err = conn.agent.GatherCandidates()
@@ -274,11 +512,14 @@ func (conn *Conn) Open() error {
 	isControlling := conn.config.LocalKey > conn.config.Key
 	var remoteConn *ice.Conn
 	if isControlling {
-		remoteConn, err = conn.agent.Dial(conn.ctx, remoteOfferAnswer.IceCredentials.UFrag, remoteOfferAnswer.IceCredentials.Pwd)
+		remoteConn, err = conn.agent.Dial(ctx, remoteOfferAnswer.IceCredentials.UFrag, remoteOfferAnswer.IceCredentials.Pwd)
 	} else {
-		remoteConn, err = conn.agent.Accept(conn.ctx, remoteOfferAnswer.IceCredentials.UFrag, remoteOfferAnswer.IceCredentials.Pwd)
+		remoteConn, err = conn.agent.Accept(ctx, remoteOfferAnswer.IceCredentials.UFrag, remoteOfferAnswer.IceCredentials.Pwd)
 	}
 	if err != nil {
+		if conn.closeCtx.Err() != nil {
+			return NewConnectionClosedError(conn.config.Key)
+		}
 		return err
 	}
 
@@ -293,6 +534,16 @@ func (conn *Conn) Open() error {
 		return err
 	}
 
+	if conn.onConnected != nil {
+		conn.onConnected()
+	}
+
+	statsDone := make(chan struct{})
+	go func(agent *ice.Agent) {
+		defer close(statsDone)
+		conn.sampleICEStats(ctx, agent)
+	}(conn.agent)
+
 	if conn.proxy.Type() == proxy.TypeNoProxy {
 		host, _, _ := net.SplitHostPort(remoteConn.LocalAddr().String())
 		rhost, _, _ := net.SplitHostPort(remoteConn.RemoteAddr().String())
@@ -303,13 +554,46 @@ func (conn *Conn) Open() error {
 	}
 
 	// wait until connection disconnected or has been closed externally (upper layer, e.g. engine)
-	select {
-	case <-conn.closeCh:
+	<-ctx.Done()
+	// the stats mustn't be published after the cleanup has reset the peer status
+	<-statsDone
+	if conn.closeCtx.Err() != nil {
 		// closed externally
 		return NewConnectionClosedError(conn.config.Key)
-	case <-conn.ctx.Done():
-		// disconnected from the remote peer
-		return NewConnectionDisconnectedError(conn.config.Key)
+	}
+	// disconnected from the remote peer
+	return NewConnectionDisconnectedError(conn.config.Key)
+}
+
+// waitRemoteOfferAnswer returns the remote offer or answer, either one buffered before Open was ready or the next one received.
+// Only continue once we got a connection confirmation from the remote peer.
+// The connection timeout could have happened before a confirmation received from the remote.
+// The connection could have also been closed externally (e.g. when we received an update from the management that peer shouldn't be connected)
+func (conn *Conn) waitRemoteOfferAnswer() (OfferAnswer, error) {
+	timeout := time.NewTimer(conn.config.Timeout)
+	defer timeout.Stop()
+
+	for {
+		remoteOfferAnswer, isAnswer, ok := conn.signalBuffer.takeOfferAnswer(time.Now())
+		if ok {
+			if !isAnswer {
+				// received confirmation from the remote peer -> ready to proceed
+				err := conn.sendAnswer(remoteOfferAnswer.SessionID)
+				if err != nil {
+					return OfferAnswer{}, err
+				}
+			}
+			return remoteOfferAnswer, nil
+		}
+
+		select {
+		case <-conn.remoteOfferAnswerCh:
+		case <-timeout.C:
+			return OfferAnswer{}, NewConnectionTimeoutError(conn.config.Key, conn.config.Timeout)
+		case <-conn.closeCtx.Done():
+			// closed externally
+			return OfferAnswer{}, NewConnectionClosedError(conn.config.Key)
+		}
 	}
 }
 
@@ -326,6 +610,11 @@ func shouldUseProxy(pair *ice.CandidatePair) bool {
 		return true
 	}
 
+	// WireGuard can't talk over TCP, the proxy carries its packets over the TCP pair
+	if pair.Local.NetworkType().IsTCP() {
+		return true
+	}
+
 	//one of the hosts has a public IP
 	if remoteIsPublic && pair.Remote.Type() == ice.CandidateTypeHost {
 		return false
@@ -346,7 +635,8 @@ func shouldUseProxy(pair *ice.CandidatePair) bool {
 
 // IsPublicIP indicates whether IP is public or not.
 func IsPublicIP(ip net.IP) bool {
-	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() {
+	// a nil IP is e.g. the mDNS name of a host candidate, which stands for a local address
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() {
 		return false
 	}
 	return true
@@ -363,25 +653,49 @@ func (conn *Conn) startProxy(remoteConn net.Conn, remoteWgPort int) error {
 		return err
 	}
 
-	peerState := nbStatus.PeerState{PubKey: conn.config.Key}
-	useProxy := shouldUseProxy(pair)
+	if !conn.setState(StateConnected) {
+		return NewConnectionClosedError(conn.config.Key)
+	}
+
+	conn.remoteConn = remoteConn
+	conn.remoteWgPort = remoteWgPort
+	return conn.startPairProxy(pair)
+}
+
+// useProxy returns true if the WireGuard traffic of the pair has to go through the proxy
+// Note: the caller should hold the lock.
+func (conn *Conn) useProxy(pair *ice.CandidatePair) bool {
+	// an older remote agent may expect its traffic to come through the proxy
+	return shouldUseProxy(pair) || !conn.capabilities.Has(CapabilityDirectMode)
+}
+
+// startPairProxy starts the proxy for the selected candidate pair and updates the peer status
+// Note: the caller should hold the lock.
+func (conn *Conn) startPairProxy(pair *ice.CandidatePair) error {
 	var p proxy.Proxy
-	if useProxy {
+	if conn.useProxy(pair) {
 		p = proxy.NewWireguardProxy(conn.config.ProxyConfig)
-		peerState.Direct = false
 	} else {
-		p = proxy.NewNoProxy(conn.config.ProxyConfig, remoteWgPort)
-		peerState.Direct = true
+		p = proxy.NewNoProxy(conn.config.ProxyConfig, conn.remoteWgPort)
 	}
 	conn.proxy = p
-	err = p.Start(remoteConn)
+	conn.proxyPair = pair
+	err := p.Start(conn.remoteConn)
 	if err != nil {
 		return err
 	}
 
-	conn.status = StatusConnected
+	conn.updatePairStatus(pair)
+	return nil
+}
 
-	peerState.ConnStatus = conn.status.String()
+// updatePairStatus records the selected candidate pair in the peer status
+// Note: the caller should hold the lock.
+func (conn *Conn) updatePairStatus(pair *ice.CandidatePair) {
+	peerState := nbStatus.PeerState{}
+	peerState.Direct = conn.proxy.Type() == proxy.TypeNoProxy
+	peerState.PubKey = conn.config.Key
+	peerState.ConnStatus = conn.state.toConnStatus().String()
 	peerState.ConnStatusUpdate = time.Now()
 	peerState.LocalIceCandidateType = pair.Local.Type().String()
 	peerState.RemoteIceCandidateType = pair.Remote.Type().String()
@@ -389,45 +703,122 @@ func (conn *Conn) startProxy(remoteConn net.Conn, remoteWgPort int) error {
 		peerState.Relayed = true
 	}
 
-	err = conn.statusRecorder.UpdatePeerState(peerState)
+	err := conn.statusRecorder.UpdatePeerState(peerState)
 	if err != nil {
 		log.Warnf("unable to save peer's state, got error: %v", err)
 	}
+}
 
+// updateProxy moves the WireGuard traffic of an established connection to a new selected candidate pair.
+// The proxy sends over the selected pair on its own, a direct connection gets the endpoint of the new pair
+// and the proxy is replaced if the new pair needs the other mode.
+// Note: the caller should hold the lock.
+func (conn *Conn) updateProxy(pair *ice.CandidatePair) error {
+	useProxy := conn.useProxy(pair)
+	switch {
+	case useProxy && conn.proxy.Type() == proxy.TypeWireguard:
+		conn.proxyPair = pair
+	case !useProxy && conn.proxy.Type() == proxy.TypeNoProxy:
+		// updates the endpoint of the WireGuard peer
+		conn.proxyPair = pair
+		err := conn.proxy.Start(conn.remoteConn)
+		if err != nil {
+			return err
+		}
+	default:
+		err := conn.proxy.Close()
+		if err != nil {
+			log.Debugf("error while closing the proxy of peer %s: %v", conn.config.Key, err)
+		}
+		return conn.startPairProxy(pair)
+	}
+
+	conn.updatePairStatus(pair)
 	return nil
 }
 
+// sampleICEStats publishes the stats of the selected candidate pair in the peer status until the context is done
+func (conn *Conn) sampleICEStats(ctx context.Context, agent *ice.Agent) {
+	ticker := time.NewTicker(iceStatsSampleInterval)
+	defer ticker.Stop()
+	for {
+		conn.publishICEStats(agent)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-conn.iceStatsCh:
+		}
+	}
+}
+
+// publishICEStats records the addresses, round trip time and traffic of the selected candidate pair in the peer status
+func (conn *Conn) publishICEStats(agent *ice.Agent) {
+	pair, err := agent.GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return
+	}
+	stats, ok := agent.GetSelectedCandidatePairStats()
+	if !ok {
+		return
+	}
+
+	err = conn.statusRecorder.UpdatePeerICEStats(nbStatus.PeerState{
+		PubKey:                     conn.config.Key,
+		LocalIceCandidateEndpoint:  net.JoinHostPort(pair.Local.Address(), strconv.Itoa(pair.Local.Port())),
+		RemoteIceCandidateEndpoint: net.JoinHostPort(pair.Remote.Address(), strconv.Itoa(pair.Remote.Port())),
+		Latency:                    time.Duration(stats.CurrentRoundTripTime * float64(time.Second)),
+		PacketsSent:                uint64(stats.PacketsSent),
+		PacketsReceived:            uint64(stats.PacketsReceived),
+		BytesSent:                  stats.BytesSent,
+		BytesReceived:              stats.BytesReceived,
+	})
+	if err != nil {
+		log.Debugf("error while updating the ICE stats of peer %s, err: %v", conn.config.Key, err)
+	}
+}
+
 // cleanup closes all open resources and sets status to StatusDisconnected
 func (conn *Conn) cleanup() error {
 	log.Debugf("trying to cleanup %s", conn.config.Key)
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
 
+	// release everything and only then report the first error, a failing agent must not leave the proxy running
+	var cleanupErr error
 	if conn.agent != nil {
 		err := conn.agent.Close()
 		if err != nil {
-			return err
+			cleanupErr = err
 		}
 		conn.agent = nil
 	}
 
 	if conn.proxy != nil {
 		err := conn.proxy.Close()
-		if err != nil {
-			return err
+		if err != nil && cleanupErr == nil {
+			cleanupErr = err
 		}
 		conn.proxy = nil
 	}
+	conn.proxyPair = nil
+	conn.remoteConn = nil
 
 	if conn.notifyDisconnected != nil {
 		conn.notifyDisconnected()
 		conn.notifyDisconnected = nil
 	}
 
-	conn.status = StatusDisconnected
+	conn.capabilities = nil
+
+	if conn.state == StateClosing {
+		conn.setState(StateClosed)
+	} else {
+		conn.setState(StateIdle)
+	}
 
 	peerState := nbStatus.PeerState{PubKey: conn.config.Key}
-	peerState.ConnStatus = conn.status.String()
+	peerState.ConnStatus = conn.state.toConnStatus().String()
 	peerState.ConnStatusUpdate = time.Now()
 
 	err := conn.statusRecorder.UpdatePeerState(peerState)
@@ -439,7 +830,7 @@ func (conn *Conn) cleanup() error {
 
 	log.Debugf("cleaned up connection to peer %s", conn.config.Key)
 
-	return nil
+	return cleanupErr
 }
 
 // SetSignalOffer sets a handler function to be triggered by Conn when a new connection offer has to be signalled to the remote peer
@@ -452,40 +843,130 @@ func (conn *Conn) SetSignalAnswer(handler func(answer OfferAnswer) error) {
 	conn.signalAnswer = handler
 }
 
+// SetOnConnected sets a handler function to be triggered by Conn when a connection to the remote peer has been established
+func (conn *Conn) SetOnConnected(handler func()) {
+	conn.onConnected = handler
+}
+
+// SetOnGatheringStart sets a handler function to be triggered by Conn before the local candidates of an attempt are gathered.
+// The handler may block, e.g. until the gathering is allowed, and fails the attempt by returning an error.
+func (conn *Conn) SetOnGatheringStart(handler func(ctx context.Context) error) {
+	conn.onGatheringStart = handler
+}
+
+// SetOnGatheringDone sets a handler function to be triggered by Conn once the local candidates of an attempt have been gathered
+func (conn *Conn) SetOnGatheringDone(handler func()) {
+	conn.onGatheringDone = handler
+}
+
 // SetSignalCandidate sets a handler function to be triggered by Conn when a new ICE local connection candidate has to be signalled to the remote peer
-func (conn *Conn) SetSignalCandidate(handler func(candidate ice.Candidate) error) {
+func (conn *Conn) SetSignalCandidate(handler func(candidate ice.Candidate, sessionID uint64) error) {
 	conn.signalCandidate = handler
 }
 
+// SetSignalEndOfCandidates sets a handler function to be triggered by Conn when all the local candidates of a session have been signalled to the remote peer
+func (conn *Conn) SetSignalEndOfCandidates(handler func(sessionID uint64) error) {
+	conn.signalEndOfCandidates = handler
+}
+
 // onICECandidate is a callback attached to an ICE Agent to receive new local connection candidates
-// and then signals them to the remote peer
-func (conn *Conn) onICECandidate(candidate ice.Candidate) {
-	if candidate != nil {
-		// TODO: reported port is incorrect for CandidateTypeHost, makes understanding ICE use via logs confusing as port is ignored
-		log.Debugf("discovered local candidate %s", candidate.String())
+// and then signals them to the remote peer. A nil candidate ends the gathering, the end of candidates is signaled
+// once the pending candidates have been signaled.
+func (conn *Conn) onICECandidate(candidate ice.Candidate, sessionID uint64, pending *sync.WaitGroup) {
+	if candidate == nil {
 		go func() {
-			err := conn.signalCandidate(candidate)
+			conn.mu.Lock()
+			current := conn.localSessionID == sessionID
+			conn.mu.Unlock()
+			if current && conn.onGatheringDone != nil {
+				conn.onGatheringDone()
+			}
+
+			pending.Wait()
+			conn.mu.Lock()
+			supported := conn.capabilities.Has(CapabilityEndOfCandidates) && conn.localSessionID == sessionID
+			conn.mu.Unlock()
+			if !supported || conn.signalEndOfCandidates == nil {
+				return
+			}
+			log.Debugf("signaling the end of candidates to peer %s", conn.config.Key)
+			err := conn.signalEndOfCandidates(sessionID)
 			if err != nil {
-				log.Errorf("failed signaling candidate to the remote peer %s %s", conn.config.Key, err)
+				log.Errorf("failed signaling the end of candidates to the remote peer %s %s", conn.config.Key, err)
 			}
 		}()
+		return
 	}
+
+	// TODO: reported port is incorrect for CandidateTypeHost, makes understanding ICE use via logs confusing as port is ignored
+	log.Debugf("discovered local candidate %s", candidate.String())
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		conn.mu.Lock()
+		allowed := conn.isCandidateAllowed(candidate)
+		conn.mu.Unlock()
+		if !allowed {
+			log.Debugf("not signaling local candidate %s, peer %s doesn't support it", candidate.String(), conn.config.Key)
+			return
+		}
+		err := conn.signalCandidate(candidate, sessionID)
+		if err != nil {
+			log.Errorf("failed signaling candidate to the remote peer %s %s", conn.config.Key, err)
+		}
+	}()
 }
 
+// onICESelectedCandidatePair is a callback attached to an ICE Agent to track the selected candidate pair.
+// The first pair is handled by Open, a later one is a renomination of the established connection.
 func (conn *Conn) onICESelectedCandidatePair(c1 ice.Candidate, c2 ice.Candidate) {
 	log.Debugf("selected candidate pair [local <-> remote] -> [%s <-> %s], peer %s", c1.String(), c2.String(),
 		conn.config.Key)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.state != StateConnected || conn.proxy == nil {
+		return
+	}
+	if conn.proxyPair != nil && conn.proxyPair.Local.Equal(c1) && conn.proxyPair.Remote.Equal(c2) {
+		return
+	}
+
+	log.Infof("connection to peer %s moved to candidate pair [%s <-> %s]", conn.config.Key, c1.String(), c2.String())
+	err := conn.updateProxy(&ice.CandidatePair{Local: c1, Remote: c2})
+	if err != nil {
+		log.Errorf("failed updating the proxy of peer %s to the new candidate pair: %v", conn.config.Key, err)
+		if conn.notifyDisconnected != nil {
+			conn.notifyDisconnected()
+		}
+		return
+	}
+
+	select {
+	case conn.iceStatsCh <- struct{}{}:
+	default:
+	}
 }
 
 // onICEConnectionStateChange registers callback of an ICE Agent to track connection state
 func (conn *Conn) onICEConnectionStateChange(state ice.ConnectionState) {
 	log.Debugf("peer %s ICE ConnectionState has changed to %s", conn.config.Key, state.String())
-	if state == ice.ConnectionStateFailed || state == ice.ConnectionStateDisconnected {
-		conn.notifyDisconnected()
+	if state == ice.ConnectionStateConsentLost {
+		log.Warnf("peer %s has stopped confirming its consent to receive our traffic, closing the connection", conn.config.Key)
+	}
+	if state == ice.ConnectionStateFailed || state == ice.ConnectionStateDisconnected || state == ice.ConnectionStateConsentLost {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		// the handler can fire after the attempt has been already cleaned up
+		if conn.notifyDisconnected != nil {
+			conn.notifyDisconnected()
+		}
 	}
 }
 
-func (conn *Conn) sendAnswer() error {
+// sendAnswer signals local user credentials to the remote peer as a reply to the offer of its replyTo session
+func (conn *Conn) sendAnswer(replyTo uint64) error {
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
 
@@ -496,9 +977,12 @@ func (conn *Conn) sendAnswer() error {
 
 	log.Debugf("sending answer to %s", conn.config.Key)
 	err = conn.signalAnswer(OfferAnswer{
-		IceCredentials: IceCredentials{localUFrag, localPwd},
-		WgListenPort:   conn.config.LocalWgPort,
-		Version:        system.NetbirdVersion(),
+		IceCredentials:   IceCredentials{localUFrag, localPwd},
+		WgListenPort:     conn.config.LocalWgPort,
+		Version:          system.NetbirdVersion(),
+		Capabilities:     conn.localCapabilities(),
+		SessionID:        conn.localSessionID,
+		ReplyToSessionID: replyTo,
 	})
 	if err != nil {
 		return err
@@ -520,6 +1004,8 @@ func (conn *Conn) sendOffer() error {
 		IceCredentials: IceCredentials{localUFrag, localPwd},
 		WgListenPort:   conn.config.LocalWgPort,
 		Version:        system.NetbirdVersion(),
+		Capabilities:   conn.localCapabilities(),
+		SessionID:      conn.localSessionID,
 	})
 	if err != nil {
 		return err
@@ -527,76 +1013,125 @@ func (conn *Conn) sendOffer() error {
 	return nil
 }
 
-// Close closes this peer Conn issuing a close event to the Conn closeCh
+// Close closes this peer Conn. It is safe to call Close multiple times and at any state,
+// also before Open has been called. A running Open returns a ConnectionClosedError and
+// Close waits (up to closeTimeout) until it has released all the resources.
+// A closed Conn can't be opened again.
 func (conn *Conn) Close() error {
 	conn.mu.Lock()
-	defer conn.mu.Unlock()
-	select {
-	case conn.closeCh <- struct{}{}:
+	switch conn.state {
+	case StateClosing, StateClosed:
+		conn.mu.Unlock()
 		return nil
+	case StateIdle:
+		conn.setState(StateClosed)
 	default:
-		// probably could happen when peer has been added and removed right after not even starting to connect
-		// todo further investigate
-		// this really happens due to unordered messages coming from management
-		// more importantly it causes inconsistency -> 2 Conn objects for the same peer
-		// e.g. this flow:
-		// update from management has peers: [1,2,3,4]
-		// engine creates a Conn for peers:  [1,2,3,4] and schedules Open in ~1sec
-		// before conn.Open() another update from management arrives with peers: [1,2,3]
-		// engine removes peer 4 and calls conn.Close() which does nothing (this default clause)
-		// before conn.Open() another update from management arrives with peers: [1,2,3,4,5]
-		// engine adds a new Conn for 4 and 5
-		// therefore peer 4 has 2 Conn objects
-		log.Warnf("connection has been already closed or attempted closing not started coonection %s", conn.config.Key)
-		return NewConnectionAlreadyClosed(conn.config.Key)
+		conn.setState(StateClosing)
+	}
+	openDone := conn.openDone
+	conn.notifyClose()
+	conn.mu.Unlock()
+
+	if openDone == nil {
+		return nil
+	}
+
+	select {
+	case <-openDone:
+	case <-time.After(closeTimeout):
+		log.Warnf("peer %s connection hasn't been cleaned up within %s after closing", conn.config.Key, closeTimeout)
 	}
+	return nil
 }
 
 // Status returns current status of the Conn
 func (conn *Conn) Status() ConnStatus {
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
-	return conn.status
+	return conn.state.toConnStatus()
 }
 
-// OnRemoteOffer handles an offer from the remote peer and returns true if the message was accepted, false otherwise
-// doesn't block, discards the message if connection wasn't ready
+// State returns current lifecycle state of the Conn
+func (conn *Conn) State() ConnState {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.state
+}
+
+// OnRemoteOffer handles an offer from the remote peer and returns true if the message was accepted, false otherwise.
+// Doesn't block, the offer is buffered until Open is ready to use it
 func (conn *Conn) OnRemoteOffer(offer OfferAnswer) bool {
-	log.Debugf("OnRemoteOffer from peer %s on status %s", conn.config.Key, conn.status.String())
+	log.Debugf("OnRemoteOffer from peer %s on state %s", conn.config.Key, conn.State())
+	return conn.onRemoteOfferAnswer(offer, false)
+}
 
-	select {
-	case conn.remoteOffersCh <- offer:
-		return true
-	default:
-		log.Debugf("OnRemoteOffer skipping message from peer %s on status %s because is not ready", conn.config.Key, conn.status.String())
-		// connection might not be ready yet to receive so we ignore the message
+// OnRemoteAnswer handles an answer from the remote peer and returns true if the message was accepted, false otherwise.
+// Doesn't block, the answer is buffered until Open is ready to use it
+func (conn *Conn) OnRemoteAnswer(answer OfferAnswer) bool {
+	log.Debugf("OnRemoteAnswer from peer %s on state %s", conn.config.Key, conn.State())
+	return conn.onRemoteOfferAnswer(answer, true)
+}
+
+func (conn *Conn) onRemoteOfferAnswer(offerAnswer OfferAnswer, isAnswer bool) bool {
+	if conn.closeCtx.Err() != nil {
+		log.Debugf("skipping offer/answer from peer %s because the connection has been closed", conn.config.Key)
 		return false
 	}
-}
 
-// OnRemoteAnswer handles an offer from the remote peer and returns true if the message was accepted, false otherwise
-// doesn't block, discards the message if connection wasn't ready
-func (conn *Conn) OnRemoteAnswer(answer OfferAnswer) bool {
-	log.Debugf("OnRemoteAnswer from peer %s on status %s", conn.config.Key, conn.status.String())
+	if isAnswer && offerAnswer.ReplyToSessionID != 0 {
+		conn.mu.Lock()
+		localSessionID := conn.localSessionID
+		conn.mu.Unlock()
+		if offerAnswer.ReplyToSessionID != localSessionID {
+			log.Debugf("skipping answer from peer %s to an old offer", conn.config.Key)
+			return false
+		}
+	}
+
+	if !conn.signalBuffer.storeOfferAnswer(offerAnswer, isAnswer, time.Now()) {
+		log.Debugf("skipping offer/answer from peer %s older than the last one received", conn.config.Key)
+		return false
+	}
 
 	select {
-	case conn.remoteAnswerCh <- answer:
-		return true
+	case conn.remoteOfferAnswerCh <- struct{}{}:
 	default:
-		// connection might not be ready yet to receive so we ignore the message
-		log.Debugf("OnRemoteAnswer skipping message from peer %s on status %s because is not ready", conn.config.Key, conn.status.String())
-		return false
+		// Open has been notified already
 	}
+	return true
 }
 
 // OnRemoteCandidate Handles ICE connection Candidate provided by the remote peer.
-func (conn *Conn) OnRemoteCandidate(candidate ice.Candidate) {
+// sessionID is the remote session the candidate belongs to, 0 if the remote peer doesn't support sessions.
+// Candidates that arrive before Open knows the remote session are buffered, candidates of other sessions are ignored.
+// sealed tells whether the remote peer has sealed the candidate, unsealed ones are dropped once the sealing is negotiated.
+func (conn *Conn) OnRemoteCandidate(candidate ice.Candidate, sessionID uint64, sealed bool) {
 	log.Debugf("OnRemoteCandidate from peer %s -> %s", conn.config.Key, candidate.String())
-	go func() {
+	conn.queueRemoteCandidates(func() {
 		conn.mu.Lock()
 		defer conn.mu.Unlock()
 
-		if conn.agent == nil {
+		switch conn.state {
+		case StateClosing, StateClosed:
+			return
+		case StateConnecting, StateConnected:
+		default:
+			conn.signalBuffer.storeCandidate(candidate, sessionID, sealed)
+			return
+		}
+
+		if conn.sealedCandidates && !sealed {
+			log.Warnf("skipping remote candidate %s of peer %s, it is not sealed", candidate.String(), conn.config.Key)
+			return
+		}
+
+		if sessionID != 0 && conn.remoteSessionID != 0 && sessionID != conn.remoteSessionID {
+			log.Debugf("skipping remote candidate of an old session of peer %s", conn.config.Key)
+			return
+		}
+
+		if !conn.isCandidateAllowed(candidate) {
+			log.Debugf("skipping remote candidate %s, not supported with peer %s", candidate.String(), conn.config.Key)
 			return
 		}
 
@@ -605,9 +1140,64 @@ func (conn *Conn) OnRemoteCandidate(candidate ice.Candidate) {
 			log.Errorf("error while handling remote candidate from peer %s", conn.config.Key)
 			return
 		}
+	})
+}
+
+// OnRemoteEndOfCandidates handles the end of candidates of the remote peer: it has signaled all the candidates of the session.
+// sessionID is the remote session, an end of candidates arriving before Open knows the remote session is buffered.
+func (conn *Conn) OnRemoteEndOfCandidates(sessionID uint64) {
+	log.Debugf("OnRemoteEndOfCandidates from peer %s", conn.config.Key)
+	conn.queueRemoteCandidates(func() {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+
+		switch conn.state {
+		case StateClosing, StateClosed:
+			return
+		case StateConnecting, StateConnected:
+		default:
+			conn.signalBuffer.storeEndOfCandidates(sessionID)
+			return
+		}
+
+		if sessionID != conn.remoteSessionID {
+			log.Debugf("skipping end of candidates of an old session of peer %s", conn.config.Key)
+			return
+		}
+		conn.setRemoteGatheringComplete()
+	})
+}
+
+// queueRemoteCandidates handles the remote candidates and the end of candidates in the background, in the order
+// they have been received, so that the end of candidates can't overtake the candidates signaled before it
+func (conn *Conn) queueRemoteCandidates(handle func()) {
+	conn.remoteCandidatesMu.Lock()
+	previous := conn.remoteCandidatesTail
+	done := make(chan struct{})
+	conn.remoteCandidatesTail = done
+	conn.remoteCandidatesMu.Unlock()
+
+	go func() {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		handle()
 	}()
 }
 
+// setRemoteGatheringComplete lets the agent fail early once no candidate pair works.
+// Note: the caller should hold the lock.
+func (conn *Conn) setRemoteGatheringComplete() {
+	if !conn.capabilities.Has(CapabilityEndOfCandidates) {
+		return
+	}
+	err := conn.agent.SetRemoteGatheringComplete()
+	if err != nil {
+		log.Errorf("error while handling the end of candidates of peer %s: %v", conn.config.Key, err)
+	}
+}
+
 func (conn *Conn) GetKey() string {
 	return conn.config.Key
 }
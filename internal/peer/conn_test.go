@@ -0,0 +1,393 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"ztnav2client/internal/candidatefilter"
+	ice "ztnav2client/internal/ice"
+	"ztnav2client/internal/proxy"
+	nbStatus "ztnav2client/status"
+)
+
+var connConf = ConnConfig{
+	Key:         "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+	LocalKey:    "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+	Timeout:     time.Second,
+	ProxyConfig: proxy.Config{AllowedIps: "100.64.0.2/32"},
+}
+
+func newTestConn(t *testing.T, conf ConnConfig) *Conn {
+	t.Helper()
+	conn, err := NewConn(conf, nbStatus.NewRecorder())
+	require.NoError(t, err)
+	conn.SetSignalOffer(func(OfferAnswer) error { return nil })
+	conn.SetSignalAnswer(func(OfferAnswer) error { return nil })
+	conn.SetSignalCandidate(func(ice.Candidate, uint64) error { return nil })
+	return conn
+}
+
+func TestConn_CloseBeforeOpen(t *testing.T) {
+	conn := newTestConn(t, connConf)
+	assert.Equal(t, StateIdle, conn.State())
+
+	require.NoError(t, conn.Close())
+	assert.Equal(t, StateClosed, conn.State())
+	assert.Equal(t, StatusDisconnected, conn.Status())
+
+	err := conn.Open()
+	assert.IsType(t, &ConnectionClosedError{}, err, "closed connection can't be opened")
+}
+
+func TestConn_CloseIsIdempotent(t *testing.T) {
+	conn := newTestConn(t, connConf)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, conn.Close())
+	}
+	assert.Equal(t, StateClosed, conn.State())
+}
+
+func TestConn_CloseWhileNegotiating(t *testing.T) {
+	conf := connConf
+	conf.Timeout = time.Minute
+	conn := newTestConn(t, conf)
+
+	openErr := make(chan error, 1)
+	go func() {
+		openErr <- conn.Open()
+	}()
+
+	require.Eventually(t, func() bool {
+		return conn.State() == StateNegotiating
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, StatusConnecting, conn.Status())
+
+	require.NoError(t, conn.Close())
+	// Close waits for the cleanup so the state is final right away
+	assert.Equal(t, StateClosed, conn.State())
+
+	select {
+	case err := <-openErr:
+		assert.IsType(t, &ConnectionClosedError{}, err)
+	case <-time.After(time.Second):
+		t.Fatal("Open should return once the connection has been closed")
+	}
+
+	assert.NoError(t, conn.Close())
+	assert.False(t, conn.OnRemoteOffer(OfferAnswer{}), "closed connection shouldn't accept offers")
+}
+
+func TestConn_OpenAfterTimeout(t *testing.T) {
+	conf := connConf
+	conf.Timeout = 100 * time.Millisecond
+	conn := newTestConn(t, conf)
+
+	err := conn.Open()
+	assert.IsType(t, &ConnectionTimeoutError{}, err)
+	assert.Equal(t, StateIdle, conn.State(), "connection should be ready for another attempt")
+
+	// retry
+	err = conn.Open()
+	assert.IsType(t, &ConnectionTimeoutError{}, err)
+	require.NoError(t, conn.Close())
+	assert.Equal(t, StateClosed, conn.State())
+}
+
+func TestConn_BufferedOfferIsReplayed(t *testing.T) {
+	conf := connConf
+	conf.Timeout = time.Minute
+	conn := newTestConn(t, conf)
+
+	answered := make(chan struct{}, 1)
+	conn.SetSignalAnswer(func(OfferAnswer) error {
+		answered <- struct{}{}
+		return nil
+	})
+
+	// the remote peer is faster and sends its offer before we are ready
+	offer := OfferAnswer{IceCredentials: IceCredentials{UFrag: "remoteUfrag", Pwd: "remotePwd1234567890123"}}
+	require.True(t, conn.OnRemoteOffer(offer))
+
+	go func() {
+		_ = conn.Open()
+	}()
+
+	select {
+	case <-answered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered offer should have been answered")
+	}
+	require.Eventually(t, func() bool {
+		return conn.State() == StateConnecting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conn.Close())
+}
+
+func TestConn_BufferedUnsealedCandidateIsDropped(t *testing.T) {
+	conf := connConf
+	conf.Timeout = time.Minute
+	conn := newTestConn(t, conf)
+	defer conn.Close() //nolint:errcheck
+
+	// the offer and the candidates arrive before we are ready, the sealing isn't negotiated yet
+	offer := OfferAnswer{
+		IceCredentials: IceCredentials{UFrag: "remoteUfrag", Pwd: "remotePwd1234567890123"},
+		Capabilities:   LocalCapabilities(),
+		SessionID:      7,
+	}
+	require.True(t, conn.OnRemoteOffer(offer))
+	conn.OnRemoteCandidate(newTestCandidate(t, 10001), 7, false)
+	conn.OnRemoteCandidate(newTestCandidate(t, 10002), 7, true)
+	buffered := make(chan struct{})
+	conn.queueRemoteCandidates(func() {
+		close(buffered)
+	})
+	<-buffered
+
+	go func() {
+		_ = conn.Open()
+	}()
+	require.Eventually(t, func() bool {
+		return conn.State() == StateConnecting
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, conn.SealedCandidates())
+
+	conn.mu.Lock()
+	agent := conn.agent
+	conn.mu.Unlock()
+	var ports []int
+	for _, stats := range agent.GetRemoteCandidatesStats() {
+		ports = append(ports, stats.Port)
+	}
+	assert.Equal(t, []int{10002}, ports, "only the sealed candidate should be replayed")
+}
+
+func TestConn_IgnoresAnswerToOldOffer(t *testing.T) {
+	conf := connConf
+	conf.Timeout = time.Minute
+	conn := newTestConn(t, conf)
+
+	offers := make(chan OfferAnswer, 1)
+	conn.SetSignalOffer(func(offer OfferAnswer) error {
+		offers <- offer
+		return nil
+	})
+
+	go func() {
+		_ = conn.Open()
+	}()
+	defer conn.Close()
+
+	var offer OfferAnswer
+	select {
+	case offer = <-offers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("offer hasn't been sent")
+	}
+	require.NotZero(t, offer.SessionID)
+
+	answer := OfferAnswer{
+		IceCredentials:   IceCredentials{UFrag: "remoteUfrag", Pwd: "remotePwd1234567890123"},
+		SessionID:        100,
+		ReplyToSessionID: offer.SessionID + 1,
+	}
+	assert.False(t, conn.OnRemoteAnswer(answer), "answer to another offer should be ignored")
+	assert.Equal(t, StateNegotiating, conn.State())
+
+	answer.ReplyToSessionID = offer.SessionID
+	assert.True(t, conn.OnRemoteAnswer(answer))
+	require.Eventually(t, func() bool {
+		return conn.State() == StateConnecting
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func newTestTCPMux(t *testing.T) *ice.TCPMuxDefault {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tcpMux := ice.NewTCPMuxDefault(ice.TCPMuxParams{Listener: listener, ReadBufferSize: 8})
+	t.Cleanup(func() {
+		_ = tcpMux.Close()
+	})
+	return tcpMux
+}
+
+func TestConn_TCPCandidates(t *testing.T) {
+	tcpCandidate, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network:   "tcp",
+		Address:   "10.0.0.1",
+		Port:      10001,
+		Component: 1,
+		TCPType:   ice.TCPTypePassive,
+	})
+	require.NoError(t, err)
+	udpCandidate := newTestCandidate(t, 10002)
+
+	conn := newTestConn(t, connConf)
+	assert.False(t, conn.localCapabilities().Has(CapabilityTCPCandidates), "shouldn't offer TCP candidates without the TCP mux")
+
+	conf := connConf
+	conf.TCPMux = newTestTCPMux(t)
+	conn = newTestConn(t, conf)
+	assert.True(t, conn.localCapabilities().Has(CapabilityTCPCandidates))
+
+	// legacy remote peer
+	conn.capabilities = negotiateCapabilities(conn.localCapabilities(), nil)
+	assert.False(t, conn.isCandidateAllowed(tcpCandidate))
+	assert.True(t, conn.isCandidateAllowed(udpCandidate))
+
+	conn.capabilities = negotiateCapabilities(conn.localCapabilities(), Capabilities{CapabilityTCPCandidates})
+	assert.True(t, conn.isCandidateAllowed(tcpCandidate))
+	assert.True(t, conn.isCandidateAllowed(udpCandidate))
+}
+
+func TestConn_SelectedCandidatePairChange(t *testing.T) {
+	conn := newTestConn(t, connConf)
+	first := &ice.CandidatePair{Local: newTestCandidate(t, 10001), Remote: newTestCandidate(t, 10002)}
+	second := &ice.CandidatePair{Local: newTestCandidate(t, 10003), Remote: newTestCandidate(t, 10004)}
+
+	// the first pair is handled by Open
+	conn.onICESelectedCandidatePair(first.Local, first.Remote)
+	assert.Nil(t, conn.proxyPair)
+
+	// established through the proxy, the proxy follows the selected pair
+	conn.state = StateConnected
+	conn.proxy = proxy.NewWireguardProxy(conn.config.ProxyConfig)
+	conn.proxyPair = first
+
+	conn.onICESelectedCandidatePair(second.Local, second.Remote)
+	require.NotNil(t, conn.proxyPair)
+	assert.True(t, conn.proxyPair.Local.Equal(second.Local))
+	assert.True(t, conn.proxyPair.Remote.Equal(second.Remote))
+	assert.Equal(t, proxy.TypeWireguard, conn.proxy.Type())
+	assert.Len(t, conn.iceStatsCh, 1, "the stats of the new pair should be published")
+}
+
+func TestConn_RemoteCandidatesKeepTheirOrder(t *testing.T) {
+	conn := newTestConn(t, connConf)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var handled []int
+	for i := 0; i < 20; i++ {
+		i := i
+		conn.queueRemoteCandidates(func() {
+			if i == 0 {
+				// e.g. a candidate waiting for the Conn lock
+				<-release
+			}
+			mu.Lock()
+			handled = append(handled, i)
+			mu.Unlock()
+		})
+	}
+	// the end of candidates is queued behind the candidates
+	endOfCandidates := make(chan struct{})
+	conn.queueRemoteCandidates(func() {
+		close(endOfCandidates)
+	})
+
+	select {
+	case <-endOfCandidates:
+		t.Fatal("the end of candidates overtook a pending candidate")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-endOfCandidates
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, handled, 20)
+	for i, index := range handled {
+		assert.Equal(t, i, index)
+	}
+}
+
+func TestInterfaceFilter(t *testing.T) {
+	filter, err := candidatefilter.New(candidatefilter.Rules{
+		AllowInterfaces: []string{"eth*", "wlan*"},
+		DenyInterfaces:  []string{"eth1"},
+	})
+	require.NoError(t, err)
+
+	allowed := InterfaceFilter([]string{"wlan"}, filter)
+	assert.True(t, allowed("eth0"))
+	assert.False(t, allowed("eth1"), "denied by the rules")
+	assert.False(t, allowed("wlan0"), "blacklisted")
+	assert.False(t, allowed("docker0"), "not allowed by the rules")
+}
+
+func TestShouldUseProxy_TCPPair(t *testing.T) {
+	newCandidate := func(network, address string) ice.Candidate {
+		tcpType := ice.TCPTypeUnspecified
+		if network == "tcp" {
+			tcpType = ice.TCPTypePassive
+		}
+		candidate, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+			Network:   network,
+			Address:   address,
+			Port:      10001,
+			Component: 1,
+			TCPType:   tcpType,
+		})
+		require.NoError(t, err)
+		return candidate
+	}
+
+	// hosts of the same private network connect directly over UDP, but WireGuard can't use a TCP pair
+	udpPair := &ice.CandidatePair{Local: newCandidate("udp", "10.0.0.1"), Remote: newCandidate("udp", "10.0.0.2")}
+	assert.False(t, shouldUseProxy(udpPair))
+	tcpPair := &ice.CandidatePair{Local: newCandidate("tcp", "10.0.0.1"), Remote: newCandidate("tcp", "10.0.0.2")}
+	assert.True(t, shouldUseProxy(tcpPair))
+}
+
+func TestShouldUseProxy_MulticastDNSHost(t *testing.T) {
+	local, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network:   "udp",
+		Address:   "e3b1b4f5-8c1c-4fd3-9b0c-6b9d7b3f0a2e.local",
+		Port:      10001,
+		Component: 1,
+	})
+	require.NoError(t, err)
+	remote, err := ice.NewCandidateServerReflexive(&ice.CandidateServerReflexiveConfig{
+		Network:   "udp",
+		Address:   "198.51.100.1",
+		Port:      10001,
+		Component: 1,
+	})
+	require.NoError(t, err)
+
+	// the mDNS name hides a local address, it mustn't be taken for a public one
+	assert.True(t, shouldUseProxy(&ice.CandidatePair{Local: local, Remote: remote}))
+}
+
+func TestConnState_Transitions(t *testing.T) {
+	tests := []struct {
+		from, to ConnState
+		valid    bool
+	}{
+		{StateIdle, StateNegotiating, true},
+		{StateIdle, StateConnected, false},
+		{StateIdle, StateClosed, true},
+		{StateNegotiating, StateConnecting, true},
+		{StateNegotiating, StateConnected, false},
+		{StateConnecting, StateConnected, true},
+		{StateConnected, StateIdle, true},
+		{StateConnected, StateClosing, true},
+		{StateConnected, StateClosed, false},
+		{StateClosing, StateClosed, true},
+		{StateClosing, StateIdle, false},
+		{StateClosed, StateIdle, false},
+		{StateClosed, StateNegotiating, false},
+	}
+	for _, test := range tests {
+		t.Run(test.from.String()+"->"+test.to.String(), func(t *testing.T) {
+			assert.Equal(t, test.valid, canTransition(test.from, test.to))
+		})
+	}
+}
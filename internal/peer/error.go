@@ -70,3 +70,19 @@ func NewConnectionAlreadyClosed(peer string) error {
 		peer: peer,
 	}
 }
+
+// ConnectionAlreadyOpenError is an error indicating that Open has been called on a Conn that is already trying to connect
+type ConnectionAlreadyOpenError struct {
+	peer string
+}
+
+func (e *ConnectionAlreadyOpenError) Error() string {
+	return fmt.Sprintf("connection to peer %s is already open", e.peer)
+}
+
+// NewConnectionAlreadyOpenError creates a new ConnectionAlreadyOpenError error
+func NewConnectionAlreadyOpenError(peer string) error {
+	return &ConnectionAlreadyOpenError{
+		peer: peer,
+	}
+}
@@ -0,0 +1,170 @@
+package peer
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	ice "ztnav2client/internal/ice"
+)
+
+const (
+	// bufferedSessionTTL is how long a buffered remote offer and its candidates are kept.
+	// The remote peer gives up on its attempt after PeerConnectionTimeoutMin at the latest.
+	bufferedSessionTTL = 30 * time.Second
+	// maxBufferedCandidates caps the candidates buffered for a single remote session
+	maxBufferedCandidates = 64
+)
+
+// signalBuffer keeps the signaling messages of the remote peer that arrived while the Conn wasn't ready to handle them,
+// e.g. an offer that arrived before Open started waiting for it or candidates that arrived before the agent was created.
+// Messages are grouped by the remote session, see sessionKey.
+// A message of a new session discards the buffered messages of the previous one.
+type signalBuffer struct {
+	mu sync.Mutex
+
+	// session is the key of the latest offer/answer received from the remote peer
+	session        string
+	sessionAt      time.Time
+	sessionCounter uint64
+
+	offerAnswer *OfferAnswer
+	isAnswer    bool
+
+	candidates []bufferedCandidate
+	// endOfCandidates is set once the remote peer has signaled all the candidates of the session
+	endOfCandidates bool
+}
+
+// bufferedCandidate is a remote candidate and whether it was sealed by the remote peer.
+// The sealing is negotiated by the offer/answer, so it is known only once the buffered candidates are replayed.
+type bufferedCandidate struct {
+	candidate ice.Candidate
+	sealed    bool
+}
+
+// sessionKey identifies the remote session of an offer/answer. Remote peers not supporting sessions are identified by their ufrag.
+func sessionKey(offerAnswer OfferAnswer) string {
+	if offerAnswer.SessionID != 0 {
+		return "session:" + strconv.FormatUint(offerAnswer.SessionID, 10)
+	}
+	return "ufrag:" + offerAnswer.IceCredentials.UFrag
+}
+
+// startSessionLocked records a remote offer/answer and discards the messages of previous remote sessions.
+// Note: the caller should hold the lock.
+func (b *signalBuffer) startSessionLocked(offerAnswer OfferAnswer, now time.Time) {
+	key := sessionKey(offerAnswer)
+	if key != b.session {
+		b.candidates = nil
+		b.endOfCandidates = false
+		b.offerAnswer = nil
+	}
+	b.session = key
+	b.sessionAt = now
+	b.sessionCounter = offerAnswer.Counter
+}
+
+// storeOfferAnswer buffers a remote offer or answer until Open takes it, replacing a previously buffered one.
+// A message older than the latest one received is ignored, returns false in that case.
+func (b *signalBuffer) storeOfferAnswer(offerAnswer OfferAnswer, isAnswer bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offerAnswer.Counter != 0 && offerAnswer.Counter < b.sessionCounter {
+		return false
+	}
+	b.startSessionLocked(offerAnswer, now)
+	b.offerAnswer = &offerAnswer
+	b.isAnswer = isAnswer
+	return true
+}
+
+// takeOfferAnswer returns the buffered remote offer or answer, if it isn't stale, and removes it from the buffer
+func (b *signalBuffer) takeOfferAnswer(now time.Time) (offerAnswer OfferAnswer, isAnswer bool, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.offerAnswer == nil {
+		return OfferAnswer{}, false, false
+	}
+	offerAnswer, isAnswer = *b.offerAnswer, b.isAnswer
+	b.offerAnswer = nil
+
+	if b.staleLocked(now) {
+		b.candidates = nil
+		b.endOfCandidates = false
+		return OfferAnswer{}, false, false
+	}
+	return offerAnswer, isAnswer, true
+}
+
+// discardAnswer removes a buffered answer. An answer confirms a particular local offer,
+// so it becomes stale once a new local session has started.
+func (b *signalBuffer) discardAnswer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.offerAnswer != nil && b.isAnswer {
+		b.offerAnswer = nil
+	}
+}
+
+// storeCandidate buffers a remote candidate of the current remote session.
+// sessionID is the session of the candidate, 0 if the remote peer doesn't support sessions.
+// sealed tells whether the remote peer has sealed the candidate.
+func (b *signalBuffer) storeCandidate(candidate ice.Candidate, sessionID uint64, sealed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sessionID != 0 && sessionKey(OfferAnswer{SessionID: sessionID}) != b.session {
+		// the offer/answer of the candidate's session is unknown or has been replaced by a newer one
+		return
+	}
+	if len(b.candidates) >= maxBufferedCandidates {
+		return
+	}
+	b.candidates = append(b.candidates, bufferedCandidate{candidate: candidate, sealed: sealed})
+}
+
+// takeCandidates returns the buffered candidates of the given remote session and empties the buffer.
+// Candidates of other or stale sessions are discarded.
+func (b *signalBuffer) takeCandidates(session string, now time.Time) []bufferedCandidate {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candidates := b.candidates
+	b.candidates = nil
+	if session != b.session || b.staleLocked(now) {
+		return nil
+	}
+	return candidates
+}
+
+// storeEndOfCandidates buffers the end of candidates of the current remote session.
+// sessionID is the session of the end of candidates, only remote peers supporting sessions signal it.
+func (b *signalBuffer) storeEndOfCandidates(sessionID uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sessionKey(OfferAnswer{SessionID: sessionID}) != b.session {
+		return
+	}
+	b.endOfCandidates = true
+}
+
+// takeEndOfCandidates returns true if the end of candidates of the given remote session has been buffered and clears it
+func (b *signalBuffer) takeEndOfCandidates(session string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	endOfCandidates := b.endOfCandidates
+	b.endOfCandidates = false
+	return endOfCandidates && session == b.session && !b.staleLocked(now)
+}
+
+// staleLocked returns true if the current remote session is too old to be replayed.
+// Note: the caller should hold the lock.
+func (b *signalBuffer) staleLocked(now time.Time) bool {
+	return now.Sub(b.sessionAt) > bufferedSessionTTL
+}
@@ -0,0 +1,115 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ice "ztnav2client/internal/ice"
+)
+
+func newTestCandidate(t *testing.T, port int) ice.Candidate {
+	t.Helper()
+	candidate, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network:   "udp",
+		Address:   "10.0.0.1",
+		Port:      port,
+		Component: 1,
+	})
+	require.NoError(t, err)
+	return candidate
+}
+
+func TestSignalBuffer_ReplaysSession(t *testing.T) {
+	var b signalBuffer
+	now := time.Now()
+	offer := OfferAnswer{IceCredentials: IceCredentials{UFrag: "session1"}}
+
+	require.True(t, b.storeOfferAnswer(offer, false, now))
+	b.storeCandidate(newTestCandidate(t, 10001), 0, false)
+	b.storeCandidate(newTestCandidate(t, 10002), 0, false)
+
+	got, isAnswer, ok := b.takeOfferAnswer(now.Add(time.Second))
+	require.True(t, ok)
+	assert.False(t, isAnswer)
+	assert.Equal(t, offer, got)
+
+	_, _, ok = b.takeOfferAnswer(now.Add(time.Second))
+	assert.False(t, ok, "offer should be replayed only once")
+
+	assert.Len(t, b.takeCandidates(sessionKey(offer), now.Add(time.Second)), 2)
+	assert.Empty(t, b.takeCandidates(sessionKey(offer), now.Add(time.Second)))
+}
+
+func TestSignalBuffer_DiscardsStale(t *testing.T) {
+	var b signalBuffer
+	now := time.Now()
+
+	// a new remote session replaces the previous one with its candidates
+	b.storeOfferAnswer(OfferAnswer{IceCredentials: IceCredentials{UFrag: "session1"}}, false, now)
+	b.storeCandidate(newTestCandidate(t, 10001), 0, false)
+	b.storeOfferAnswer(OfferAnswer{IceCredentials: IceCredentials{UFrag: "session2"}}, false, now)
+	b.storeCandidate(newTestCandidate(t, 10002), 0, false)
+
+	got, _, ok := b.takeOfferAnswer(now)
+	require.True(t, ok)
+	assert.Equal(t, "session2", got.IceCredentials.UFrag)
+	assert.Empty(t, b.takeCandidates(sessionKey(OfferAnswer{IceCredentials: IceCredentials{UFrag: "session1"}}), now),
+		"candidates of another session shouldn't be replayed")
+
+	// too old
+	session3 := OfferAnswer{IceCredentials: IceCredentials{UFrag: "session3"}}
+	b.storeOfferAnswer(session3, false, now)
+	b.storeCandidate(newTestCandidate(t, 10003), 0, false)
+	_, _, ok = b.takeOfferAnswer(now.Add(bufferedSessionTTL + time.Second))
+	assert.False(t, ok)
+	assert.Empty(t, b.takeCandidates(sessionKey(session3), now.Add(bufferedSessionTTL+time.Second)))
+
+	// an answer belongs to the previous local offer
+	b.storeOfferAnswer(OfferAnswer{IceCredentials: IceCredentials{UFrag: "session4"}}, true, now)
+	b.discardAnswer()
+	_, _, ok = b.takeOfferAnswer(now)
+	assert.False(t, ok)
+}
+
+func TestSignalBuffer_Sessions(t *testing.T) {
+	var b signalBuffer
+	now := time.Now()
+
+	newer := OfferAnswer{IceCredentials: IceCredentials{UFrag: "newer"}, SessionID: 2, Counter: 20}
+	older := OfferAnswer{IceCredentials: IceCredentials{UFrag: "older"}, SessionID: 1, Counter: 10}
+
+	require.True(t, b.storeOfferAnswer(newer, false, now))
+	assert.False(t, b.storeOfferAnswer(older, false, now), "reordered older offer shouldn't replace the newer one")
+
+	b.storeCandidate(newTestCandidate(t, 10001), 2, false)
+	// candidate of the older session
+	b.storeCandidate(newTestCandidate(t, 10002), 1, false)
+
+	got, _, ok := b.takeOfferAnswer(now)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), got.SessionID)
+	assert.Len(t, b.takeCandidates(sessionKey(got), now), 1)
+}
+
+func TestSignalBuffer_EndOfCandidates(t *testing.T) {
+	var b signalBuffer
+	now := time.Now()
+
+	offer := OfferAnswer{IceCredentials: IceCredentials{UFrag: "ufrag"}, SessionID: 2, Counter: 20}
+	require.True(t, b.storeOfferAnswer(offer, false, now))
+
+	// end of candidates of another session
+	b.storeEndOfCandidates(1)
+	assert.False(t, b.takeEndOfCandidates(sessionKey(offer), now))
+
+	b.storeEndOfCandidates(2)
+	assert.True(t, b.takeEndOfCandidates(sessionKey(offer), now))
+	assert.False(t, b.takeEndOfCandidates(sessionKey(offer), now), "end of candidates is taken once")
+
+	// a new session discards the end of candidates of the previous one
+	b.storeEndOfCandidates(2)
+	require.True(t, b.storeOfferAnswer(OfferAnswer{SessionID: 3, Counter: 30}, false, now))
+	assert.False(t, b.takeEndOfCandidates(sessionKey(OfferAnswer{SessionID: 3}), now))
+}
@@ -0,0 +1,73 @@
+package peer
+
+import log "github.com/sirupsen/logrus"
+
+// ConnState is the lifecycle state of a peer Conn
+type ConnState int
+
+const (
+	// StateIdle Conn is not trying to connect, e.g. before Open or between connection attempts
+	StateIdle ConnState = iota
+	// StateNegotiating offer has been sent and Conn waits for the remote credentials
+	StateNegotiating
+	// StateConnecting ICE candidates are being gathered and checked
+	StateConnecting
+	// StateConnected connection is established and traffic is proxied
+	StateConnected
+	// StateClosing Close has been called and Open is cleaning up
+	StateClosing
+	// StateClosed Conn is closed and can't be opened anymore
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateNegotiating:
+		return "Negotiating"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateClosing:
+		return "Closing"
+	case StateClosed:
+		return "Closed"
+	default:
+		log.Errorf("unknown state: %d", s)
+		return "INVALID_PEER_CONNECTION_STATE"
+	}
+}
+
+// validTransitions lists the states each state can move to
+var validTransitions = map[ConnState][]ConnState{
+	StateIdle:        {StateNegotiating, StateClosed},
+	StateNegotiating: {StateConnecting, StateIdle, StateClosing},
+	StateConnecting:  {StateConnected, StateIdle, StateClosing},
+	StateConnected:   {StateIdle, StateClosing},
+	StateClosing:     {StateClosed},
+	StateClosed:      {},
+}
+
+// canTransition returns true if the state can move from one state to the other
+func canTransition(from, to ConnState) bool {
+	for _, s := range validTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// toConnStatus maps a lifecycle state to the coarse status reported to the engine and the status recorder
+func (s ConnState) toConnStatus() ConnStatus {
+	switch s {
+	case StateConnected:
+		return StatusConnected
+	case StateNegotiating, StateConnecting:
+		return StatusConnecting
+	default:
+		return StatusDisconnected
+	}
+}
@@ -0,0 +1,248 @@
+package portmap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	natPMPVersion = 0
+	pcpVersion    = 2
+
+	natPMPOpExternalAddress = 0
+	natPMPOpMapUDP          = 1
+	pcpOpMap                = 1
+	// responseFlag is set in the opcode of the responses
+	responseFlag = 0x80
+
+	natPMPResultSuccess     = 0
+	natPMPResultUnsupported = 1
+	pcpResultSuccess        = 0
+	pcpResultUnsuppVersion  = 1
+
+	protocolUDP = 17
+
+	natPMPExternalAddressSize = 12
+	natPMPMapResponseSize     = 16
+	pcpMapSize                = 60
+)
+
+// pmpRequest sends the request to the PCP/NAT-PMP server until a response passing accept is received.
+// The server answers from the port it has been sent to, so a connected socket is used and an ICMP port unreachable
+// of a gateway without a server fails the request right away.
+func pmpRequest(ctx context.Context, gateway *net.UDPAddr, request []byte, timeout time.Duration, accept func(response []byte) bool) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	buf := make([]byte, 1100)
+	for attempt := 0; attempt < defaultRequestAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout << attempt)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err = conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, readErr := conn.Read(buf)
+			if readErr != nil {
+				var netErr net.Error
+				if errors.As(readErr, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, readErr
+			}
+			if accept(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from %s", gateway)
+}
+
+// natPMPClient requests the mappings with NAT-PMP (RFC 6886)
+type natPMPClient struct {
+	gateway        *net.UDPAddr
+	requestTimeout time.Duration
+}
+
+func (c *natPMPClient) protocol() Protocol {
+	return ProtocolNATPMP
+}
+
+func (c *natPMPClient) mapPort(ctx context.Context, internalPort int, previous *Mapping, lifetime time.Duration) (*Mapping, error) {
+	externalIP, err := c.externalAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkExternalIP(externalIP); err != nil {
+		return nil, err
+	}
+
+	suggestedPort := internalPort
+	if previous != nil {
+		suggestedPort = previous.External.Port
+	}
+	response, err := c.request(ctx, internalPort, suggestedPort, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		Protocol:     ProtocolNATPMP,
+		InternalPort: internalPort,
+		External:     &net.UDPAddr{IP: externalIP, Port: int(binary.BigEndian.Uint16(response[10:12]))},
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(response[12:16])) * time.Second,
+	}, nil
+}
+
+func (c *natPMPClient) unmapPort(ctx context.Context, mapping *Mapping) error {
+	// a lifetime of 0 and an external port of 0 delete the mapping
+	_, err := c.request(ctx, mapping.InternalPort, 0, 0)
+	return err
+}
+
+// externalAddress asks the gateway for its external address
+func (c *natPMPClient) externalAddress(ctx context.Context) (net.IP, error) {
+	response, err := pmpRequest(ctx, c.gateway, []byte{natPMPVersion, natPMPOpExternalAddress}, c.requestTimeout, func(response []byte) bool {
+		return len(response) >= natPMPExternalAddressSize && response[0] == natPMPVersion &&
+			response[1] == responseFlag|natPMPOpExternalAddress
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = natPMPResult(response); err != nil {
+		return nil, err
+	}
+	return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// request sends a mapping request, the lifetime is rounded to seconds
+func (c *natPMPClient) request(ctx context.Context, internalPort, suggestedPort int, lifetime time.Duration) ([]byte, error) {
+	request := make([]byte, 12)
+	request[0] = natPMPVersion
+	request[1] = natPMPOpMapUDP
+	binary.BigEndian.PutUint16(request[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(request[6:8], uint16(suggestedPort))
+	binary.BigEndian.PutUint32(request[8:12], uint32(lifetime/time.Second))
+
+	response, err := pmpRequest(ctx, c.gateway, request, c.requestTimeout, func(response []byte) bool {
+		return len(response) >= natPMPMapResponseSize && response[0] == natPMPVersion &&
+			response[1] == responseFlag|natPMPOpMapUDP && binary.BigEndian.Uint16(response[8:10]) == uint16(internalPort)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, natPMPResult(response)
+}
+
+func natPMPResult(response []byte) error {
+	switch code := binary.BigEndian.Uint16(response[2:4]); code {
+	case natPMPResultSuccess:
+		return nil
+	case natPMPResultUnsupported:
+		return errUnsupported
+	default:
+		return fmt.Errorf("NAT-PMP request failed with result code %d", code)
+	}
+}
+
+// pcpClient requests the mappings with the MAP opcode of PCP (RFC 6887)
+type pcpClient struct {
+	gateway        *net.UDPAddr
+	requestTimeout time.Duration
+}
+
+func (c *pcpClient) protocol() Protocol {
+	return ProtocolPCP
+}
+
+func (c *pcpClient) mapPort(ctx context.Context, internalPort int, previous *Mapping, lifetime time.Duration) (*Mapping, error) {
+	mapping := &Mapping{Protocol: ProtocolPCP, InternalPort: internalPort}
+	suggested := &net.UDPAddr{IP: net.IPv4zero}
+	if previous != nil {
+		// the renewal of a mapping has to use its nonce
+		mapping.nonce = previous.nonce
+		suggested = previous.External
+	} else if _, err := rand.Read(mapping.nonce[:]); err != nil {
+		return nil, err
+	}
+
+	response, err := c.request(ctx, mapping, suggested, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	mapping.External = &net.UDPAddr{
+		IP:   net.IP(append([]byte(nil), response[44:60]...)).To16(),
+		Port: int(binary.BigEndian.Uint16(response[42:44])),
+	}
+	if ip4 := mapping.External.IP.To4(); ip4 != nil {
+		mapping.External.IP = ip4
+	}
+	mapping.Lifetime = time.Duration(binary.BigEndian.Uint32(response[4:8])) * time.Second
+	if err = checkExternalIP(mapping.External.IP); err != nil {
+		_ = c.unmapPort(ctx, mapping)
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func (c *pcpClient) unmapPort(ctx context.Context, mapping *Mapping) error {
+	_, err := c.request(ctx, mapping, &net.UDPAddr{IP: net.IPv4zero}, 0)
+	return err
+}
+
+func (c *pcpClient) request(ctx context.Context, mapping *Mapping, suggested *net.UDPAddr, lifetime time.Duration) ([]byte, error) {
+	clientIP, err := localIPFor(c.gateway.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	request := make([]byte, pcpMapSize)
+	request[0] = pcpVersion
+	request[1] = pcpOpMap
+	binary.BigEndian.PutUint32(request[4:8], uint32(lifetime/time.Second))
+	copy(request[8:24], clientIP.To16())
+	copy(request[24:36], mapping.nonce[:])
+	request[36] = protocolUDP
+	binary.BigEndian.PutUint16(request[40:42], uint16(mapping.InternalPort))
+	binary.BigEndian.PutUint16(request[42:44], uint16(suggested.Port))
+	copy(request[44:60], suggested.IP.To16())
+
+	response, err := pmpRequest(ctx, c.gateway, request, c.requestTimeout, func(response []byte) bool {
+		if len(response) >= 4 && response[0] == natPMPVersion {
+			// a NAT-PMP server answers with its own version
+			return true
+		}
+		return len(response) >= pcpMapSize && response[0] == pcpVersion && response[1] == responseFlag|pcpOpMap &&
+			string(response[24:36]) == string(mapping.nonce[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response[0] == natPMPVersion {
+		return nil, errUnsupported
+	}
+	switch code := response[3]; code {
+	case pcpResultSuccess:
+		return response, nil
+	case pcpResultUnsuppVersion:
+		return nil, errUnsupported
+	default:
+		return nil, fmt.Errorf("PCP request failed with result code %d", code)
+	}
+}
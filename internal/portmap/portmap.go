@@ -0,0 +1,326 @@
+// Package portmap requests UDP port mappings from the gateway with PCP (RFC 6887), NAT-PMP (RFC 6886)
+// or UPnP-IGD, so that the remote peers can reach a local port directly through the NAT.
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-netroute"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultLifetime is the lifetime requested for the mappings, they are renewed after half of it
+	DefaultLifetime = 2 * time.Hour
+	// defaultRetryInterval is how long the Mapper waits before trying again after a failed mapping request
+	defaultRetryInterval = 5 * time.Minute
+	// defaultRequestTimeout is how long a response of the gateway is waited for before the request is sent again
+	defaultRequestTimeout = 250 * time.Millisecond
+	// defaultRequestAttempts is how many times a PCP or NAT-PMP request is sent, the gateway is local so a few are enough
+	defaultRequestAttempts = 3
+	// deleteTimeout bounds the deletion of the mapping on Close
+	deleteTimeout = 2 * time.Second
+
+	// pmpPort is the port of the PCP and NAT-PMP servers of the gateway
+	pmpPort = 5351
+	// defaultDescription is the description of the UPnP-IGD mappings shown in the router's UI
+	defaultDescription = "ztna wireguard"
+)
+
+var (
+	// ErrNoGateway is returned when none of the protocols is supported by the gateway
+	ErrNoGateway = errors.New("no gateway supporting PCP, NAT-PMP or UPnP-IGD found")
+	// errUnsupported is returned by a protocol client when the gateway doesn't speak the protocol
+	errUnsupported = errors.New("protocol not supported by the gateway")
+	// errPrivateExternalIP is returned when the gateway is itself behind a NAT (e.g. a carrier-grade NAT),
+	// its mappings are of no use to the remote peers
+	errPrivateExternalIP = errors.New("the external address of the gateway is not a public one")
+)
+
+// Protocol is the protocol a mapping has been made with
+type Protocol string
+
+const (
+	ProtocolPCP    Protocol = "pcp"
+	ProtocolNATPMP Protocol = "nat-pmp"
+	ProtocolUPnP   Protocol = "upnp"
+)
+
+// Mapping is a UDP port mapping of the gateway
+type Mapping struct {
+	Protocol     Protocol
+	InternalPort int
+	// External is the address the gateway forwards to the internal port
+	External *net.UDPAddr
+	// Lifetime is the lifetime granted by the gateway, 0 for a permanent UPnP-IGD mapping
+	Lifetime time.Duration
+
+	// nonce identifies a PCP mapping for its renewal and deletion
+	nonce [12]byte
+}
+
+func (m *Mapping) String() string {
+	return fmt.Sprintf("%s %s -> :%d", m.Protocol, m.External, m.InternalPort)
+}
+
+// client requests mappings with one protocol
+type client interface {
+	protocol() Protocol
+	// mapPort requests a mapping of the internal port, an existing mapping is renewed if previous isn't nil
+	mapPort(ctx context.Context, internalPort int, previous *Mapping, lifetime time.Duration) (*Mapping, error)
+	// unmapPort deletes the mapping
+	unmapPort(ctx context.Context, mapping *Mapping) error
+}
+
+// Config configures the Mapper, the zero value discovers the gateway
+type Config struct {
+	// Gateway is the address of the PCP and NAT-PMP server, the default gateway if nil
+	Gateway *net.UDPAddr
+	// SSDPAddr is where the UPnP-IGD devices are searched, the SSDP multicast address if nil
+	SSDPAddr *net.UDPAddr
+	// Lifetime is the requested lifetime of the mappings, DefaultLifetime if 0
+	Lifetime time.Duration
+	// RetryInterval is how long to wait after a failed mapping request, 5 minutes if 0
+	RetryInterval time.Duration
+	// RequestTimeout is how long a response of the gateway is waited for, 250ms if 0
+	RequestTimeout time.Duration
+	// Description is the description of the UPnP-IGD mappings
+	Description string
+}
+
+func (c *Config) setDefaults() {
+	if c.Lifetime == 0 {
+		c.Lifetime = DefaultLifetime
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = defaultRequestTimeout
+	}
+	if c.SSDPAddr == nil {
+		c.SSDPAddr = ssdpMulticastAddr
+	}
+	if c.Description == "" {
+		c.Description = defaultDescription
+	}
+}
+
+// Mapper keeps a mapping of a local UDP port on the gateway: it renews the mapping before it expires,
+// tries again after failures and deletes the mapping when closed
+type Mapper struct {
+	config Config
+	// onChange is called with the external address whenever it changes, nil when the mapping has been lost
+	onChange func(external *net.UDPAddr)
+
+	mu      sync.Mutex
+	mapping *Mapping
+	client  client
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMapper returns a Mapper reporting the external address of the mapping to onChange
+func NewMapper(config Config, onChange func(external *net.UDPAddr)) *Mapper {
+	config.setDefaults()
+	return &Mapper{
+		config:   config,
+		onChange: onChange,
+	}
+}
+
+// Start requests a mapping of the internal port in the background
+func (m *Mapper) Start(internalPort int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, internalPort)
+}
+
+// Close stops renewing the mapping and deletes it from the gateway
+func (m *Mapper) Close() error {
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+
+	m.mu.Lock()
+	mapping, mappingClient := m.mapping, m.client
+	m.mapping = nil
+	m.mu.Unlock()
+	if mapping == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+	defer cancel()
+	if err := mappingClient.unmapPort(ctx, mapping); err != nil {
+		return fmt.Errorf("delete port mapping %s: %w", mapping, err)
+	}
+	log.Infof("deleted port mapping %s", mapping)
+	return nil
+}
+
+// External returns the external address of the current mapping, nil if there is none
+func (m *Mapper) External() *net.UDPAddr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mapping == nil {
+		return nil
+	}
+	return m.mapping.External
+}
+
+func (m *Mapper) run(ctx context.Context, internalPort int) {
+	defer close(m.done)
+	for {
+		wait := m.config.RetryInterval
+		mapping, err := m.refresh(ctx, internalPort)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Infof("no port mapping of UDP port %d: %v", internalPort, err)
+		} else if mapping.Lifetime > 0 {
+			wait = mapping.Lifetime / 2
+		} else {
+			// a permanent mapping is still checked, e.g. the router could have been restarted
+			wait = m.config.Lifetime / 2
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refresh renews the current mapping or requests a new one from the first protocol the gateway supports
+func (m *Mapper) refresh(ctx context.Context, internalPort int) (*Mapping, error) {
+	m.mu.Lock()
+	previous, previousClient := m.mapping, m.client
+	m.mu.Unlock()
+
+	var mapping *Mapping
+	var mappingClient client
+	var err error
+	if previous != nil {
+		mapping, err = previousClient.mapPort(ctx, internalPort, previous, m.config.Lifetime)
+		mappingClient = previousClient
+	}
+	if previous == nil || err != nil {
+		mapping, mappingClient, err = m.discover(ctx, internalPort)
+	}
+	if err != nil && ctx.Err() != nil {
+		// closing, the previous mapping is kept to be deleted
+		return nil, ctx.Err()
+	}
+
+	m.mu.Lock()
+	if err != nil {
+		m.mapping, m.client = nil, nil
+	} else {
+		m.mapping, m.client = mapping, mappingClient
+	}
+	m.mu.Unlock()
+
+	switch {
+	case err != nil && previous != nil:
+		log.Warnf("lost port mapping %s: %v", previous, err)
+		m.notify(nil)
+	case err == nil && (previous == nil || !equalAddr(previous.External, mapping.External)):
+		log.Infof("mapped UDP port %d with %s, external address %s, lifetime %s",
+			internalPort, mapping.Protocol, mapping.External, mapping.Lifetime)
+		m.notify(mapping.External)
+	}
+	return mapping, err
+}
+
+func (m *Mapper) notify(external *net.UDPAddr) {
+	if m.onChange != nil {
+		m.onChange(external)
+	}
+}
+
+// discover tries the protocols in the order of preference: PCP, NAT-PMP and UPnP-IGD
+func (m *Mapper) discover(ctx context.Context, internalPort int) (*Mapping, client, error) {
+	var clients []client
+	gateway, err := m.gateway()
+	if err != nil {
+		log.Debugf("no default gateway for PCP and NAT-PMP: %v", err)
+	} else {
+		clients = append(clients,
+			&pcpClient{gateway: gateway, requestTimeout: m.config.RequestTimeout},
+			&natPMPClient{gateway: gateway, requestTimeout: m.config.RequestTimeout})
+	}
+	clients = append(clients, &upnpClient{
+		ssdpAddr:    m.config.SSDPAddr,
+		timeout:     4 * m.config.RequestTimeout,
+		description: m.config.Description,
+	})
+
+	for _, c := range clients {
+		mapping, err := c.mapPort(ctx, internalPort, nil, m.config.Lifetime)
+		if err == nil {
+			return mapping, c, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		log.Debugf("port mapping with %s failed: %v", c.protocol(), err)
+	}
+	return nil, nil, ErrNoGateway
+}
+
+// gateway returns the address of the PCP and NAT-PMP server of the gateway
+func (m *Mapper) gateway() (*net.UDPAddr, error) {
+	if m.config.Gateway != nil {
+		return m.config.Gateway, nil
+	}
+	router, err := netroute.New()
+	if err != nil {
+		return nil, err
+	}
+	_, gateway, _, err := router.Route(net.IPv4zero)
+	if err != nil {
+		return nil, err
+	}
+	if gateway == nil {
+		return nil, errors.New("no default route via a gateway")
+	}
+	return &net.UDPAddr{IP: gateway, Port: pmpPort}, nil
+}
+
+// localIPFor returns the local address the packets to the remote address are sent from
+func localIPFor(remote net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: remote, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()                             //nolint:errcheck
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil //nolint:forcetypeassert
+}
+
+// sharedAddressSpace is the range of the carrier-grade NATs (RFC 6598)
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkExternalIP rejects the external addresses the remote peers can't reach
+func checkExternalIP(ip net.IP) error {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", errPrivateExternalIP, ip)
+	}
+	return nil
+}
+
+func equalAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
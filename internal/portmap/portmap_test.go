@@ -0,0 +1,357 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fakeExternalIP = net.IPv4(203, 0, 113, 7)
+
+const fakeExternalPort = 40001
+
+// fakePMPGateway is a PCP and NAT-PMP server, it answers PCP requests like a NAT-PMP only server if pcp is false
+type fakePMPGateway struct {
+	conn       *net.UDPConn
+	pcp        bool
+	externalIP net.IP
+	// lifetime is the lifetime granted to the mappings
+	lifetime uint32
+
+	mu       sync.Mutex
+	requests int
+	// mapped holds the mapped internal ports
+	mapped map[uint16]bool
+}
+
+func startFakePMPGateway(t *testing.T, pcp bool) *fakePMPGateway {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	g := &fakePMPGateway{conn: conn, pcp: pcp, externalIP: fakeExternalIP, lifetime: 7200, mapped: make(map[uint16]bool)}
+	t.Cleanup(func() { _ = conn.Close() })
+	go g.serve()
+	return g
+}
+
+func (g *fakePMPGateway) addr() *net.UDPAddr {
+	return g.conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+}
+
+func (g *fakePMPGateway) isMapped(internalPort int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.mapped[uint16(internalPort)]
+}
+
+func (g *fakePMPGateway) requestCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.requests
+}
+
+func (g *fakePMPGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		response := g.handle(buf[:n])
+		if response != nil {
+			_, _ = g.conn.WriteToUDP(response, addr)
+		}
+	}
+}
+
+func (g *fakePMPGateway) handle(request []byte) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests++
+
+	switch {
+	case request[0] == pcpVersion && !g.pcp:
+		return []byte{natPMPVersion, responseFlag | request[1], 0, natPMPResultUnsupported, 0, 0, 0, 0}
+	case request[0] == pcpVersion && len(request) == pcpMapSize:
+		response := make([]byte, pcpMapSize)
+		copy(response, request)
+		response[1] = responseFlag | pcpOpMap
+		response[2], response[3] = 0, pcpResultSuccess
+		internalPort := binary.BigEndian.Uint16(request[40:42])
+		if binary.BigEndian.Uint32(request[4:8]) == 0 {
+			delete(g.mapped, internalPort)
+		} else {
+			g.mapped[internalPort] = true
+			binary.BigEndian.PutUint32(response[4:8], g.lifetime)
+		}
+		binary.BigEndian.PutUint16(response[42:44], fakeExternalPort)
+		copy(response[44:60], g.externalIP.To16())
+		return response
+	case request[0] == natPMPVersion && request[1] == natPMPOpExternalAddress:
+		response := make([]byte, natPMPExternalAddressSize)
+		response[1] = responseFlag | natPMPOpExternalAddress
+		copy(response[8:12], g.externalIP.To4())
+		return response
+	case request[0] == natPMPVersion && request[1] == natPMPOpMapUDP && len(request) == 12:
+		response := make([]byte, natPMPMapResponseSize)
+		response[1] = responseFlag | natPMPOpMapUDP
+		internalPort := binary.BigEndian.Uint16(request[4:6])
+		copy(response[8:10], request[4:6])
+		if binary.BigEndian.Uint32(request[8:12]) == 0 {
+			delete(g.mapped, internalPort)
+		} else {
+			g.mapped[internalPort] = true
+			binary.BigEndian.PutUint16(response[10:12], fakeExternalPort)
+			binary.BigEndian.PutUint32(response[12:16], g.lifetime)
+		}
+		return response
+	}
+	return nil
+}
+
+// fakeIGD is a UPnP Internet Gateway Device only supporting permanent mappings, like many IGDv1 routers
+type fakeIGD struct {
+	ssdp   *net.UDPConn
+	server *httptest.Server
+
+	mu sync.Mutex
+	// mappings are the internal clients and ports by external port
+	mappings map[string]string
+}
+
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func startFakeIGD(t *testing.T) *fakeIGD {
+	t.Helper()
+	igd := &fakeIGD{mappings: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, fakeIGDDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", igd.handleSOAP)
+	igd.server = httptest.NewServer(mux)
+	t.Cleanup(igd.server.Close)
+
+	var err error
+	igd.ssdp, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = igd.ssdp.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := igd.ssdp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") || !strings.Contains(string(buf[:n]), ssdpSearchTarget) {
+				continue
+			}
+			response := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: " + ssdpSearchTarget + "\r\n" +
+				"LOCATION: " + igd.server.URL + "/rootDesc.xml\r\n\r\n"
+			_, _ = igd.ssdp.WriteToUDP([]byte(response), addr)
+		}
+	}()
+	return igd
+}
+
+func (igd *fakeIGD) handleSOAP(w http.ResponseWriter, r *http.Request) {
+	var envelope struct {
+		Body struct {
+			Action struct {
+				XMLName        xml.Name
+				ExternalPort   string `xml:"NewExternalPort"`
+				InternalPort   string `xml:"NewInternalPort"`
+				InternalClient string `xml:"NewInternalClient"`
+				LeaseDuration  string `xml:"NewLeaseDuration"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := envelope.Body.Action
+	if !strings.HasSuffix(r.Header.Get("SOAPAction"), "#"+action.XMLName.Local+`"`) {
+		http.Error(w, "SOAPAction mismatch", http.StatusBadRequest)
+		return
+	}
+
+	igd.mu.Lock()
+	defer igd.mu.Unlock()
+	switch action.XMLName.Local {
+	case "GetExternalIPAddress":
+		_, _ = fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">`+
+			`<NewExternalIPAddress>%s</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`, fakeExternalIP)
+	case "AddPortMapping":
+		if action.LeaseDuration != "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+				`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode>`+
+				`<errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		igd.mappings[action.ExternalPort] = net.JoinHostPort(action.InternalClient, action.InternalPort)
+	case "DeletePortMapping":
+		delete(igd.mappings, action.ExternalPort)
+	default:
+		http.Error(w, "unknown action", http.StatusInternalServerError)
+	}
+}
+
+func (igd *fakeIGD) mapping(externalPort string) (string, bool) {
+	igd.mu.Lock()
+	defer igd.mu.Unlock()
+	internal, ok := igd.mappings[externalPort]
+	return internal, ok
+}
+
+// closedUDPAddr returns an address nothing listens on
+func closedUDPAddr(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	_ = conn.Close()
+	return addr
+}
+
+// startMapper maps the internal port and waits for the external address
+func startMapper(t *testing.T, config Config, internalPort int) (*Mapper, chan *net.UDPAddr) {
+	t.Helper()
+	config.RequestTimeout = 20 * time.Millisecond
+	changes := make(chan *net.UDPAddr, 10)
+	mapper := NewMapper(config, func(external *net.UDPAddr) { changes <- external })
+	mapper.Start(internalPort)
+	t.Cleanup(func() { _ = mapper.Close() })
+	return mapper, changes
+}
+
+func waitExternal(t *testing.T, changes chan *net.UDPAddr) *net.UDPAddr {
+	t.Helper()
+	select {
+	case external := <-changes:
+		return external
+	case <-time.After(5 * time.Second):
+		t.Fatal("the external address hasn't been reported")
+		return nil
+	}
+}
+
+func TestMapper_PMP(t *testing.T) {
+	for _, pcp := range []bool{true, false} {
+		name := "nat-pmp"
+		if pcp {
+			name = "pcp"
+		}
+		t.Run(name, func(t *testing.T) {
+			gateway := startFakePMPGateway(t, pcp)
+			mapper, changes := startMapper(t, Config{Gateway: gateway.addr(), SSDPAddr: closedUDPAddr(t)}, 51820)
+
+			external := waitExternal(t, changes)
+			assert.Equal(t, (&net.UDPAddr{IP: fakeExternalIP, Port: fakeExternalPort}).String(), external.String())
+			assert.Equal(t, external, mapper.External())
+			assert.True(t, gateway.isMapped(51820))
+			mapper.mu.Lock()
+			protocol, lifetime := mapper.mapping.Protocol, mapper.mapping.Lifetime
+			mapper.mu.Unlock()
+			if pcp {
+				assert.Equal(t, ProtocolPCP, protocol)
+			} else {
+				assert.Equal(t, ProtocolNATPMP, protocol)
+			}
+			assert.Equal(t, 2*time.Hour, lifetime)
+
+			require.NoError(t, mapper.Close())
+			assert.False(t, gateway.isMapped(51820), "the mapping should be deleted on close")
+			assert.Nil(t, mapper.External())
+		})
+	}
+}
+
+func TestMapper_Renewal(t *testing.T) {
+	gateway := startFakePMPGateway(t, true)
+	gateway.lifetime = 1
+	_, changes := startMapper(t, Config{Gateway: gateway.addr(), SSDPAddr: closedUDPAddr(t), RetryInterval: time.Hour}, 51820)
+	waitExternal(t, changes)
+	requests := gateway.requestCount()
+
+	// renewed after half of the lifetime, the unchanged external address isn't reported again
+	assert.Eventually(t, func() bool { return gateway.requestCount() > requests }, 3*time.Second, 50*time.Millisecond)
+	assert.Len(t, changes, 0)
+
+	// the gateway stops answering, the mapping is lost
+	_ = gateway.conn.Close()
+	assert.Nil(t, waitExternal(t, changes))
+}
+
+func TestMapper_PrivateExternalIP(t *testing.T) {
+	gateway := startFakePMPGateway(t, false)
+	gateway.externalIP = net.IPv4(100, 64, 1, 1)
+	mapper, changes := startMapper(t, Config{Gateway: gateway.addr(), SSDPAddr: closedUDPAddr(t), RetryInterval: time.Hour}, 51820)
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, changes, 0, "a mapping behind a carrier-grade NAT is of no use")
+	assert.Nil(t, mapper.External())
+}
+
+func TestMapper_UPnP(t *testing.T) {
+	igd := startFakeIGD(t)
+	mapper, changes := startMapper(t, Config{Gateway: closedUDPAddr(t), SSDPAddr: igd.ssdp.LocalAddr().(*net.UDPAddr)}, 51820) //nolint:forcetypeassert
+
+	external := waitExternal(t, changes)
+	assert.True(t, fakeExternalIP.Equal(external.IP))
+	assert.Equal(t, 51820, external.Port)
+	internal, ok := igd.mapping("51820")
+	require.True(t, ok)
+	assert.Equal(t, "127.0.0.1:51820", internal)
+	mapper.mu.Lock()
+	assert.Equal(t, ProtocolUPnP, mapper.mapping.Protocol)
+	assert.Equal(t, time.Duration(0), mapper.mapping.Lifetime, "the device only supports permanent mappings")
+	mapper.mu.Unlock()
+
+	require.NoError(t, mapper.Close())
+	_, ok = igd.mapping("51820")
+	assert.False(t, ok, "the mapping should be deleted on close")
+}
+
+func TestMapper_NoGateway(t *testing.T) {
+	mapper := NewMapper(Config{Gateway: closedUDPAddr(t), SSDPAddr: closedUDPAddr(t), RequestTimeout: 20 * time.Millisecond}, nil)
+	_, _, err := mapper.discover(context.Background(), 51820)
+	assert.ErrorIs(t, err, ErrNoGateway)
+}
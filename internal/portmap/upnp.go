@@ -0,0 +1,357 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpSearchTarget = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// the UPnP error codes worth another AddPortMapping request
+	upnpErrConflictInMappingEntry       = 718
+	upnpErrOnlyPermanentLeasesSupported = 725
+
+	// upnpPortAttempts is how many external ports are tried when the internal one is taken
+	upnpPortAttempts = 3
+)
+
+var ssdpMulticastAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// upnpHTTPClient talks to the gateway on the LAN, never through the proxy of the environment
+var upnpHTTPClient = &http.Client{Transport: &http.Transport{Proxy: nil}}
+
+// upnpServiceTypes are the WAN connection services having the port mapping actions, in the order of preference
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpClient requests the mappings with the WANIPConnection service of a UPnP Internet Gateway Device
+type upnpClient struct {
+	ssdpAddr *net.UDPAddr
+	// timeout is how long the SSDP responses and the SOAP requests are waited for
+	timeout     time.Duration
+	description string
+
+	// the service found by the SSDP search
+	controlURL  string
+	serviceType string
+}
+
+func (c *upnpClient) protocol() Protocol {
+	return ProtocolUPnP
+}
+
+func (c *upnpClient) mapPort(ctx context.Context, internalPort int, previous *Mapping, lifetime time.Duration) (*Mapping, error) {
+	if c.controlURL == "" {
+		if err := c.discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	externalIP, err := c.externalIPAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkExternalIP(externalIP); err != nil {
+		return nil, err
+	}
+
+	deviceIP, err := c.deviceIP()
+	if err != nil {
+		return nil, err
+	}
+	// the mapping forwards to the address we reach the device from
+	internalClient, err := localIPFor(deviceIP)
+	if err != nil {
+		return nil, err
+	}
+
+	externalPort := internalPort
+	if previous != nil {
+		externalPort = previous.External.Port
+	}
+	for attempt := 0; ; attempt++ {
+		err = c.addPortMapping(ctx, externalPort, internalPort, internalClient, lifetime)
+		var upnpErr *upnpError
+		switch {
+		case err == nil:
+			return &Mapping{
+				Protocol:     ProtocolUPnP,
+				InternalPort: internalPort,
+				External:     &net.UDPAddr{IP: externalIP, Port: externalPort},
+				Lifetime:     lifetime,
+			}, nil
+		case errors.As(err, &upnpErr) && upnpErr.code == upnpErrOnlyPermanentLeasesSupported && lifetime != 0:
+			// IGDv1 devices may only support permanent mappings, they are deleted on Close
+			lifetime = 0
+		case errors.As(err, &upnpErr) && upnpErr.code == upnpErrConflictInMappingEntry && attempt < upnpPortAttempts:
+			// the external port is mapped to another host
+			externalPort = 1024 + rand.Intn(65535-1024) //nolint:gosec
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (c *upnpClient) unmapPort(ctx context.Context, mapping *Mapping) error {
+	_, err := c.soapRequest(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(mapping.External.Port)},
+		{"NewProtocol", "UDP"},
+	})
+	return err
+}
+
+func (c *upnpClient) addPortMapping(ctx context.Context, externalPort, internalPort int, internalClient net.IP, lifetime time.Duration) error {
+	_, err := c.soapRequest(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", "UDP"},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", internalClient.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", c.description},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	return err
+}
+
+func (c *upnpClient) externalIPAddress(ctx context.Context) (net.IP, error) {
+	response, err := c.soapRequest(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := xmlElementText(response, "NewExternalIPAddress")
+	if !ok {
+		return nil, errors.New("no NewExternalIPAddress in the GetExternalIPAddress response")
+	}
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external IP address %q", value)
+	}
+	return ip, nil
+}
+
+// discover searches an Internet Gateway Device with SSDP and finds its WAN connection service
+func (c *upnpClient) discover(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpMulticastAddr.String() + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + ssdpSearchTarget + "\r\n\r\n"
+	if _, err = conn.WriteTo([]byte(search), c.ssdpAddr); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err = conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return errUnsupported
+			}
+			return err
+		}
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || response.StatusCode != http.StatusOK {
+			continue
+		}
+		location := response.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		if err = c.findService(ctx, location); err != nil {
+			continue
+		}
+		return nil
+	}
+}
+
+// upnpDevice is a device of a UPnP device description, the services can be in an embedded device
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService fetches the device description and finds the WAN connection service in it
+func (c *upnpClient) findService(ctx context.Context, location string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	response, err := upnpHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close() //nolint:errcheck
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("device description request failed with %s", response.Status)
+	}
+
+	var description struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err = xml.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&description); err != nil {
+		return err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	if description.URLBase != "" {
+		if base, err = url.Parse(description.URLBase); err != nil {
+			return err
+		}
+	}
+
+	services := make(map[string]string)
+	devices := []upnpDevice{description.Device}
+	for len(devices) > 0 {
+		device := devices[0]
+		devices = append(devices[1:], device.Devices...)
+		for _, service := range device.Services {
+			if _, ok := services[service.ServiceType]; !ok {
+				services[service.ServiceType] = service.ControlURL
+			}
+		}
+	}
+	for _, serviceType := range upnpServiceTypes {
+		controlURL, ok := services[serviceType]
+		if !ok {
+			continue
+		}
+		ref, err := url.Parse(strings.TrimSpace(controlURL))
+		if err != nil {
+			return err
+		}
+		c.controlURL = base.ResolveReference(ref).String()
+		c.serviceType = serviceType
+		return nil
+	}
+	return fmt.Errorf("no WAN connection service in the device description of %s", location)
+}
+
+// upnpError is the UPnPError of a failed SOAP request
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.code, e.description)
+}
+
+// soapRequest calls the action of the WAN connection service with the arguments in their order
+func (c *upnpClient) soapRequest(ctx context.Context, action string, args [][2]string) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + c.serviceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		if err := xml.EscapeText(&body, []byte(arg[1])); err != nil {
+			return nil, err
+		}
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+c.serviceType+"#"+action+`"`)
+
+	response, err := upnpHTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close() //nolint:errcheck
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		if code, ok := xmlElementText(responseBody, "errorCode"); ok {
+			description, _ := xmlElementText(responseBody, "errorDescription")
+			if errorCode, err := strconv.Atoi(strings.TrimSpace(code)); err == nil {
+				return nil, &upnpError{code: errorCode, description: description}
+			}
+		}
+		return nil, fmt.Errorf("%s request failed with %s", action, response.Status)
+	}
+	return responseBody, nil
+}
+
+// xmlElementText returns the text of the first element with the local name
+func xmlElementText(document []byte, name string) (string, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != name {
+			continue
+		}
+		var text string
+		if err = decoder.DecodeElement(&text, &start); err != nil {
+			return "", false
+		}
+		return text, true
+	}
+}
+
+// deviceIP returns the address of the gateway device serving the control URL
+func (c *upnpClient) deviceIP() (net.IP, error) {
+	u, err := url.Parse(c.controlURL)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveIPAddr("ip4", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
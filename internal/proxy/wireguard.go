@@ -14,6 +14,10 @@ import (
 	"ztnav2client/util"
 )
 
+// maxPacketSize is the largest packet the proxy passes. Over an ICE-TCP pair every packet travels in its own
+// RFC 4571 frame with a 16-bit length, so a single Read of the remote connection returns a whole WireGuard packet.
+const maxPacketSize = 65535
+
 // WireguardProxy proxies
 type WireguardProxy struct {
 	ctx    context.Context
@@ -23,6 +27,9 @@ type WireguardProxy struct {
 
 	remoteConn net.Conn
 	localConn  net.Conn
+
+	// debugListener serves the connection debug endpoints, nil if it couldn't be started
+	debugListener net.Listener
 }
 
 func NewWireguardProxy(config Config) *WireguardProxy {
@@ -71,10 +78,19 @@ func (p *WireguardProxy) Start(remoteConn net.Conn) error {
 	router := gin.New()
 	http.NewHandler(router, remoteConn)
 
+	// the debug endpoints are optional, the proxy works without them
 	randPort := rand.Intn(8050-8010) + 8010
-	if err := router.Run("0.0.0.0:" + strconv.Itoa(randPort)); err != nil {
-		panic(err.Error())
+	p.debugListener, err = net.Listen("tcp", "0.0.0.0:"+strconv.Itoa(randPort))
+	if err != nil {
+		log.Warnf("failed starting connection debug proxy for peer %s: %v", p.config.RemoteKey, err)
+		return nil
 	}
+	go func(listener net.Listener) {
+		err := router.RunListener(listener)
+		if err != nil && p.ctx.Err() == nil {
+			log.Warnf("connection debug proxy for peer %s stopped: %v", p.config.RemoteKey, err)
+		}
+	}(p.debugListener)
 
 	log.Debugf("Running connection debug proxy at http://127.0.0.1:%d", randPort)
 
@@ -83,6 +99,9 @@ func (p *WireguardProxy) Start(remoteConn net.Conn) error {
 
 func (p *WireguardProxy) Close() error {
 	p.cancel()
+	if l := p.debugListener; l != nil {
+		_ = l.Close()
+	}
 	if c := p.localConn; c != nil {
 		err := p.localConn.Close()
 		if err != nil {
@@ -100,7 +119,7 @@ func (p *WireguardProxy) Close() error {
 // blocks
 func (p *WireguardProxy) proxyToRemote() {
 
-	buf := make([]byte, 1500)
+	buf := make([]byte, maxPacketSize)
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -125,7 +144,7 @@ func (p *WireguardProxy) proxyToRemote() {
 // blocks
 func (p *WireguardProxy) proxyToLocal() {
 
-	buf := make([]byte, 1500)
+	buf := make([]byte, maxPacketSize)
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	log "github.com/sirupsen/logrus"
+
+	"ztnav2client/internal/ice"
+	"ztnav2client/internal/netproxy"
+	nbstatus "ztnav2client/status"
+)
+
+const (
+	// serverProbeInterval is how often the STUN and TURN servers are probed
+	serverProbeInterval = 30 * time.Second
+	// serverProbeTimeout is how long a server has to answer a probe
+	serverProbeTimeout = 5 * time.Second
+	// serverProbeMaxFailures is the number of consecutive failed probes after which a server isn't used anymore,
+	// a server that has failed fewer probes is only demoted as a single probe could have been lost
+	serverProbeMaxFailures = 2
+
+	// stunHeaderSize is the size of the STUN message header holding the length of the attributes
+	stunHeaderSize = 20
+)
+
+// errProbeUnsupported is returned for the servers that can't be probed, they are used as if they were healthy
+var errProbeUnsupported = errors.New("probing is not supported for this server")
+
+// probeFunc measures the round trip time to a STUN or TURN server
+type probeFunc func(ctx context.Context, url *ice.URL) (time.Duration, error)
+
+// serverHealth is the result of the latest probes of a server
+type serverHealth struct {
+	probed   bool
+	rtt      time.Duration
+	failures int
+	lastErr  error
+}
+
+func (h *serverHealth) healthy() bool {
+	return h.probed && h.failures == 0
+}
+
+func (h *serverHealth) failed() bool {
+	return h.failures >= serverProbeMaxFailures
+}
+
+// serverProber probes the reachability and the latency of the STUN and TURN servers in the background,
+// so that the connection attempts prefer fast servers and skip the dead ones instead of waiting for their timeout
+type serverProber struct {
+	probe probeFunc
+	// onUpdate is called with the health of all the servers after every round of probes
+	onUpdate func(relays []nbstatus.RelayState)
+
+	mu      sync.Mutex
+	servers []*ice.URL
+	// health is indexed by serverKey
+	health map[string]*serverHealth
+
+	// wakeUp starts a round of probes right away, e.g. when the servers have changed
+	wakeUp chan struct{}
+}
+
+func newServerProber(probe probeFunc, onUpdate func(relays []nbstatus.RelayState)) *serverProber {
+	return &serverProber{
+		probe:    probe,
+		onUpdate: onUpdate,
+		health:   make(map[string]*serverHealth),
+		wakeUp:   make(chan struct{}, 1),
+	}
+}
+
+func serverKey(url *ice.URL) string {
+	return url.String()
+}
+
+// setServers replaces the servers to probe, the health of the known servers is kept
+func (p *serverProber) setServers(servers []*ice.URL) {
+	p.mu.Lock()
+	p.servers = append([]*ice.URL(nil), servers...)
+	health := make(map[string]*serverHealth, len(servers))
+	for _, server := range servers {
+		key := serverKey(server)
+		h, ok := p.health[key]
+		if !ok {
+			h = &serverHealth{}
+		}
+		health[key] = h
+	}
+	p.health = health
+	p.mu.Unlock()
+
+	select {
+	case p.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// run probes the servers every serverProbeInterval until the context is done
+func (p *serverProber) run(ctx context.Context) {
+	ticker := time.NewTicker(serverProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wakeUp:
+		}
+		p.probeAll(ctx)
+	}
+}
+
+// probeAll probes all the servers concurrently and publishes their health
+func (p *serverProber) probeAll(ctx context.Context) {
+	p.mu.Lock()
+	servers := p.servers
+	p.mu.Unlock()
+
+	type result struct {
+		key string
+		rtt time.Duration
+		err error
+	}
+	results := make([]result, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *ice.URL) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, serverProbeTimeout)
+			defer cancel()
+			rtt, err := p.probe(probeCtx, server)
+			results[i] = result{key: serverKey(server), rtt: rtt, err: err}
+		}(i, server)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	for _, r := range results {
+		h, ok := p.health[r.key]
+		if !ok || errors.Is(r.err, errProbeUnsupported) {
+			// removed in the meantime or can't be probed
+			continue
+		}
+		h.probed = true
+		if r.err != nil {
+			h.failures++
+			h.lastErr = r.err
+			if h.failures == serverProbeMaxFailures {
+				log.Warnf("STUN/TURN server %s is unreachable, it won't be used until it answers again: %v", r.key, r.err)
+			}
+			continue
+		}
+		if h.failed() {
+			log.Infof("STUN/TURN server %s is reachable again", r.key)
+		}
+		h.failures = 0
+		h.lastErr = nil
+		h.rtt = r.rtt
+	}
+	relays := p.relayStates()
+	p.mu.Unlock()
+
+	if p.onUpdate != nil {
+		p.onUpdate(relays)
+	}
+}
+
+// relayStates returns the health of the servers for the status.
+// Note: the caller should hold the lock.
+func (p *serverProber) relayStates() []nbstatus.RelayState {
+	relays := make([]nbstatus.RelayState, 0, len(p.servers))
+	for _, server := range p.servers {
+		key := serverKey(server)
+		h := p.health[key]
+		relays = append(relays, nbstatus.RelayState{
+			URI:       key,
+			Available: !h.probed || !h.failed(),
+			Latency:   h.rtt,
+			Err:       h.lastErr,
+		})
+	}
+	return relays
+}
+
+// rank orders the servers for a connection attempt: the healthy servers by their latency, then the servers
+// that haven't been probed yet and then the ones that have failed their last probe. The servers that have failed
+// serverProbeMaxFailures probes in a row are dropped, unless none would be left.
+func (p *serverProber) rank(servers []*ice.URL) []*ice.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	const (
+		groupHealthy = iota
+		groupUnknown
+		groupFailing
+	)
+	group := func(h *serverHealth) int {
+		switch {
+		case h == nil || !h.probed:
+			return groupUnknown
+		case h.healthy():
+			return groupHealthy
+		default:
+			return groupFailing
+		}
+	}
+
+	ranked := make([]*ice.URL, 0, len(servers))
+	for _, server := range servers {
+		h := p.health[serverKey(server)]
+		if h != nil && h.failed() {
+			continue
+		}
+		ranked = append(ranked, server)
+	}
+	if len(ranked) == 0 {
+		// better to try the failed servers than none at all
+		return servers
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		hi, hj := p.health[serverKey(ranked[i])], p.health[serverKey(ranked[j])]
+		gi, gj := group(hi), group(hj)
+		if gi != gj {
+			return gi < gj
+		}
+		if gi == groupHealthy {
+			return hi.rtt < hj.rtt
+		}
+		return false
+	})
+	return ranked
+}
+
+// newServerProbe returns a probeFunc sending a STUN Binding request to the server,
+// the TCP connections are made through the proxyDialer if it isn't nil
+func newServerProbe(proxyDialer netproxy.Dialer) probeFunc {
+	return func(ctx context.Context, url *ice.URL) (time.Duration, error) {
+		return probeServer(ctx, url, proxyDialer)
+	}
+}
+
+// probeServer measures the round trip time of a STUN Binding request to the server, that STUN and TURN servers answer
+func probeServer(ctx context.Context, url *ice.URL, proxyDialer netproxy.Dialer) (time.Duration, error) {
+	addr := net.JoinHostPort(url.Host, strconv.Itoa(url.Port))
+
+	var conn net.Conn
+	var err error
+	switch {
+	case url.Proto == ice.ProtoTypeUDP && (url.Scheme == ice.SchemeTypeSTUN || url.Scheme == ice.SchemeTypeTURN):
+		conn, err = (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	case url.Proto == ice.ProtoTypeTCP:
+		var dialer netproxy.Dialer = &net.Dialer{}
+		if proxyDialer != nil {
+			dialer = proxyDialer
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+		if err == nil && (url.Scheme == ice.SchemeTypeTURNS || url.Scheme == ice.SchemeTypeSTUNS) {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: url.Host}) //nolint:gosec
+			if err = tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return 0, err
+			}
+			conn = tlsConn
+		}
+	default:
+		// DTLS servers
+		return 0, errProbeUnsupported
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err = conn.Write(request.Raw); err != nil {
+		return 0, err
+	}
+
+	response, err := readSTUNMessage(conn, url.Proto == ice.ProtoTypeTCP)
+	if err != nil {
+		return 0, err
+	}
+	if response.TransactionID != request.TransactionID {
+		return 0, fmt.Errorf("unexpected STUN transaction %x", response.TransactionID)
+	}
+	// an error response is still an answer of a running server
+	return time.Since(start), nil
+}
+
+// readSTUNMessage reads a STUN message from the connection, the messages over TCP are framed by their length
+func readSTUNMessage(conn net.Conn, stream bool) (*stun.Message, error) {
+	buf := make([]byte, 1500)
+	var n int
+	var err error
+	if stream {
+		if _, err = io.ReadFull(conn, buf[:stunHeaderSize]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+		if stunHeaderSize+length > len(buf) {
+			return nil, fmt.Errorf("STUN message of %d bytes is too long", length)
+		}
+		if _, err = io.ReadFull(conn, buf[stunHeaderSize:stunHeaderSize+length]); err != nil {
+			return nil, err
+		}
+		n = stunHeaderSize + length
+	} else if n, err = conn.Read(buf); err != nil {
+		return nil, err
+	}
+
+	msg := &stun.Message{Raw: buf[:n]}
+	if err = msg.Decode(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ztnav2client/internal/ice"
+	nbstatus "ztnav2client/status"
+)
+
+func mustParseURL(t *testing.T, raw string) *ice.URL {
+	t.Helper()
+	url, err := ice.ParseURL(raw)
+	require.NoError(t, err)
+	return url
+}
+
+func TestServerProber_Rank(t *testing.T) {
+	fast := mustParseURL(t, "stun:fast.example.com:3478")
+	slow := mustParseURL(t, "stun:slow.example.com:3478")
+	flaky := mustParseURL(t, "stun:flaky.example.com:3478")
+	dead := mustParseURL(t, "stun:dead.example.com:3478")
+	servers := []*ice.URL{dead, flaky, slow, fast}
+
+	var mu sync.Mutex
+	rtts := map[string]time.Duration{fast.Host: 10 * time.Millisecond, slow.Host: 100 * time.Millisecond}
+	probe := func(ctx context.Context, url *ice.URL) (time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		rtt, ok := rtts[url.Host]
+		if !ok {
+			return 0, errors.New("timeout")
+		}
+		return rtt, nil
+	}
+	var relays []nbstatus.RelayState
+	prober := newServerProber(probe, func(states []nbstatus.RelayState) {
+		relays = states
+	})
+	prober.setServers(servers)
+
+	assert.Equal(t, servers, prober.rank(servers), "servers are kept as they are until probed")
+
+	prober.probeAll(context.Background())
+	assert.Equal(t, []*ice.URL{fast, slow, dead, flaky}, prober.rank(servers),
+		"servers failing their first probe are demoted")
+
+	prober.probeAll(context.Background())
+	assert.Equal(t, []*ice.URL{fast, slow}, prober.rank(servers), "servers failing repeatedly are dropped")
+	require.Len(t, relays, 4)
+	assert.False(t, relays[0].Available)
+	assert.Error(t, relays[0].Err)
+	assert.True(t, relays[3].Available)
+	assert.Equal(t, 10*time.Millisecond, relays[3].Latency)
+
+	assert.Equal(t, []*ice.URL{dead, flaky}, prober.rank([]*ice.URL{dead, flaky}),
+		"failed servers are used if there are no others")
+
+	// a server answering again is used again
+	mu.Lock()
+	rtts[flaky.Host] = 50 * time.Millisecond
+	mu.Unlock()
+	prober.probeAll(context.Background())
+	assert.Equal(t, []*ice.URL{fast, flaky, slow}, prober.rank(servers))
+
+	// a new list of servers keeps the health of the known ones
+	prober.setServers([]*ice.URL{fast, dead})
+	assert.Equal(t, []*ice.URL{fast}, prober.rank([]*ice.URL{dead, fast}))
+}
+
+func TestProbeServer(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	// answers the Binding requests like a STUN server
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if request.Decode() != nil {
+				continue
+			}
+			response, err := stun.Build(stun.NewTransactionIDSetter(request.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: addr.(*net.UDPAddr).IP, Port: addr.(*net.UDPAddr).Port}) //nolint:forcetypeassert
+			if err != nil {
+				continue
+			}
+			_, _ = server.WriteTo(response.Raw, addr)
+		}
+	}()
+
+	url := &ice.URL{Scheme: ice.SchemeTypeSTUN, Host: "127.0.0.1", Port: server.LocalAddr().(*net.UDPAddr).Port, Proto: ice.ProtoTypeUDP} //nolint:forcetypeassert
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rtt, err := probeServer(ctx, url, nil)
+	require.NoError(t, err)
+	assert.Greater(t, rtt, time.Duration(0))
+
+	// nothing answers on the closed port
+	_ = server.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = probeServer(ctx, url, nil)
+	assert.Error(t, err)
+
+	_, err = probeServer(context.Background(), &ice.URL{Scheme: ice.SchemeTypeTURNS, Host: "127.0.0.1", Port: 5349, Proto: ice.ProtoTypeUDP}, nil)
+	assert.ErrorIs(t, err, errProbeUnsupported)
+}
@@ -0,0 +1,90 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/netbirdio/netbird/encryption"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// sealedCandidatePrefix marks a candidate payload sealed to the remote peer's WireGuard key
+const sealedCandidatePrefix = "sealed1:"
+
+// candidateEnvelope is the content of a sealed candidate payload
+type candidateEnvelope struct {
+	// Candidate is the marshalled ICE candidate
+	Candidate string `json:"candidate"`
+	// SessionID is the connection attempt of the sender the candidate belongs to
+	SessionID uint64 `json:"session_id,omitempty"`
+	// Counter is the sender's message counter, see Session.Counter
+	Counter uint64 `json:"counter,omitempty"`
+}
+
+// SealCandidate encrypts a marshalled ICE candidate to the remote peer's WireGuard public key and authenticates it
+// with our private key, so that neither the signaling server nor anyone injecting messages can read or forge it
+func SealCandidate(candidate string, session Session, remoteKey wgtypes.Key, ourPrivateKey wgtypes.Key) (string, error) {
+	b, err := json.Marshal(candidateEnvelope{
+		Candidate: candidate,
+		SessionID: session.ID,
+		Counter:   session.Counter,
+	})
+	if err != nil {
+		return "", err
+	}
+	sealed, err := encryption.Encrypt(b, remoteKey, ourPrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return sealedCandidatePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// SealEndOfCandidates seals the end-of-candidates of the session: the sender has signaled all its candidates of the session.
+// It is a sealed candidate without a candidate, only peers negotiating the end-of-candidates understand it.
+func SealEndOfCandidates(session Session, remoteKey wgtypes.Key, ourPrivateKey wgtypes.Key) (string, error) {
+	return SealCandidate("", session, remoteKey, ourPrivateKey)
+}
+
+// IsEndOfCandidates returns true if the candidate opened by OpenCandidate is an end-of-candidates
+func IsEndOfCandidates(candidate string) bool {
+	return candidate == ""
+}
+
+// IsSealedCandidate returns true if the candidate payload has been sealed by the remote peer, an end-of-candidates is always sealed
+func IsSealedCandidate(payload string) bool {
+	return strings.HasPrefix(payload, sealedCandidatePrefix)
+}
+
+// OpenCandidate verifies that a candidate payload has been sealed to us by the owner of senderKey and returns the marshalled ICE candidate
+// with the session it belongs to, see IsEndOfCandidates for an end-of-candidates.
+// Plaintext candidates of the peers not sealing them are returned as they are without a session, they are rejected
+// if requireSealed is set.
+func OpenCandidate(payload string, senderKey wgtypes.Key, ourPrivateKey wgtypes.Key, requireSealed bool) (string, Session, error) {
+	if !IsSealedCandidate(payload) {
+		if requireSealed {
+			return "", Session{}, fmt.Errorf("candidate of peer %s is not sealed", senderKey)
+		}
+		if payload == "" {
+			// only a sealed candidate can be an end-of-candidates
+			return "", Session{}, fmt.Errorf("empty candidate of peer %s", senderKey)
+		}
+		return payload, Session{}, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(payload, sealedCandidatePrefix))
+	if err != nil {
+		return "", Session{}, fmt.Errorf("malformed sealed candidate of peer %s: %w", senderKey, err)
+	}
+	b, err := encryption.Decrypt(sealed, senderKey, ourPrivateKey)
+	if err != nil {
+		return "", Session{}, fmt.Errorf("failed verifying candidate of peer %s: %w", senderKey, err)
+	}
+
+	envelope := candidateEnvelope{}
+	err = json.Unmarshal(b, &envelope)
+	if err != nil {
+		return "", Session{}, fmt.Errorf("malformed sealed candidate of peer %s: %w", senderKey, err)
+	}
+	return envelope.Candidate, Session{ID: envelope.SessionID, Counter: envelope.Counter}, nil
+}
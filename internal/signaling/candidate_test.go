@@ -0,0 +1,88 @@
+package signaling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const testCandidate = "candidate:1 1 udp 2130706431 192.168.1.10 51820 typ host"
+
+func TestSealCandidate(t *testing.T) {
+	aliceKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	malloryKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	payload, err := SealCandidate(testCandidate, Session{ID: 7, Counter: 42}, bobKey.PublicKey(), aliceKey)
+	require.NoError(t, err)
+	assert.NotContains(t, payload, "192.168.1.10", "candidate address shouldn't be readable")
+
+	candidate, session, err := OpenCandidate(payload, aliceKey.PublicKey(), bobKey, true)
+	require.NoError(t, err)
+	assert.Equal(t, testCandidate, candidate)
+	assert.Equal(t, Session{ID: 7, Counter: 42}, session)
+
+	// sealed by someone else than the claimed sender
+	forged, err := SealCandidate(testCandidate, Session{}, bobKey.PublicKey(), malloryKey)
+	require.NoError(t, err)
+	_, _, err = OpenCandidate(forged, aliceKey.PublicKey(), bobKey, true)
+	assert.Error(t, err)
+
+	// sealed to someone else
+	_, _, err = OpenCandidate(payload, aliceKey.PublicKey(), malloryKey, true)
+	assert.Error(t, err)
+
+	// tampered
+	tampered := payload[:len(payload)-4] + "AAA="
+	_, _, err = OpenCandidate(tampered, aliceKey.PublicKey(), bobKey, true)
+	assert.Error(t, err)
+
+	// plaintext
+	_, _, err = OpenCandidate(testCandidate, aliceKey.PublicKey(), bobKey, true)
+	assert.Error(t, err)
+}
+
+func TestOpenCandidate_Plaintext(t *testing.T) {
+	aliceKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// a peer not sealing its candidates
+	candidate, session, err := OpenCandidate(testCandidate, aliceKey.PublicKey(), bobKey, false)
+	require.NoError(t, err)
+	assert.Equal(t, testCandidate, candidate)
+	assert.Equal(t, Session{}, session)
+
+	_, _, err = OpenCandidate("", aliceKey.PublicKey(), bobKey, false)
+	assert.Error(t, err, "a plaintext candidate can't be an end-of-candidates")
+
+	// sealed candidates are opened regardless
+	payload, err := SealCandidate(testCandidate, Session{ID: 7, Counter: 42}, bobKey.PublicKey(), aliceKey)
+	require.NoError(t, err)
+	candidate, _, err = OpenCandidate(payload, aliceKey.PublicKey(), bobKey, false)
+	require.NoError(t, err)
+	assert.Equal(t, testCandidate, candidate)
+}
+
+func TestSealEndOfCandidates(t *testing.T) {
+	aliceKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	payload, err := SealEndOfCandidates(Session{ID: 7, Counter: 43}, bobKey.PublicKey(), aliceKey)
+	require.NoError(t, err)
+
+	candidate, session, err := OpenCandidate(payload, aliceKey.PublicKey(), bobKey, true)
+	require.NoError(t, err)
+	assert.True(t, IsEndOfCandidates(candidate))
+	assert.Equal(t, Session{ID: 7, Counter: 43}, session)
+
+	assert.False(t, IsEndOfCandidates(testCandidate))
+}
@@ -0,0 +1,50 @@
+package signaling
+
+import (
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// bodyCapabilityField carries the capabilities of the sender of an offer or answer, one field per capability
+const bodyCapabilityField protowire.Number = 1004
+
+// SetCapabilities adds the capabilities of the sender to an offer or answer body
+func SetCapabilities(body *sProto.Body, capabilities []string) {
+	var b []byte
+	for _, capability := range capabilities {
+		b = protowire.AppendTag(b, bodyCapabilityField, protowire.BytesType)
+		b = protowire.AppendString(b, capability)
+	}
+	replaceUnknownFields(body, b, bodyCapabilityField)
+}
+
+// GetCapabilities reads the capabilities of the sender of an offer or answer body.
+// Returns nil if the sender doesn't support the capability negotiation.
+func GetCapabilities(body *sProto.Body) []string {
+	var capabilities []string
+	b := body.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+
+		if num != bodyCapabilityField || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil
+			}
+			b = b[n:]
+			continue
+		}
+
+		capability, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities
+}
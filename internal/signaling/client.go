@@ -0,0 +1,52 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"ztnav2client/internal/netproxy"
+)
+
+const (
+	// TransportGRPC is the Signal Exchange gRPC stream, the default transport
+	TransportGRPC = "grpc"
+	// TransportLongPoll is HTTP long-polling, for networks where gRPC streams don't pass through
+	TransportLongPoll = "longpoll"
+)
+
+// Client is a transport used to exchange connection offers, answers and candidates with the remote peers.
+// Implementations that pass messages through a server encrypt the message body with the WireGuard keys of both peers.
+type Client interface {
+	io.Closer
+	// Send sends a message to the remote peer identified by msg.RemoteKey
+	Send(msg *sProto.Message) error
+	// Receive starts receiving messages addressed to this peer and passes them to msgHandler.
+	// Blocks and reconnects on errors, returns an error once the transport gave up.
+	Receive(msgHandler func(msg *sProto.Message) error) error
+	// Ready indicates whether the transport can be used to send messages
+	Ready() bool
+	// StreamConnected indicates whether the client is currently receiving messages
+	StreamConnected() bool
+	// WaitStreamConnected blocks until the client is receiving messages or the client's context is done
+	WaitStreamConnected()
+}
+
+// NewClient creates a signaling Client of the given transport.
+// protocol is either http or https and addr is the address of the signaling server.
+// The server is reached through the dialer if it isn't nil.
+func NewClient(ctx context.Context, transport string, protocol string, addr string, key wgtypes.Key, dialer netproxy.Dialer) (Client, error) {
+	tlsEnabled := protocol == "https"
+
+	switch transport {
+	case "", TransportGRPC:
+		return NewGrpcClient(ctx, addr, key, tlsEnabled, dialer)
+	case TransportLongPoll:
+		return NewLongPollClient(ctx, protocol+"://"+addr, key, dialer)
+	default:
+		return nil, fmt.Errorf("unsupported signaling transport %q", transport)
+	}
+}
@@ -0,0 +1,34 @@
+package signaling
+
+import (
+	"context"
+
+	signal "github.com/netbirdio/netbird/signal/client"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"ztnav2client/internal/netproxy"
+)
+
+// the Signal Exchange gRPC client and its mock are used as they are
+var (
+	_ Client = (*signal.GrpcClient)(nil)
+	_ Client = (*signal.MockClient)(nil)
+	_ Client = (*proxiedGrpcClient)(nil)
+)
+
+// NewGrpcClient connects to the Signal Exchange gRPC service.
+// The connection is made through the dialer if it isn't nil, e.g. a netproxy.Dialer of a corporate proxy.
+func NewGrpcClient(ctx context.Context, addr string, key wgtypes.Key, tlsEnabled bool, dialer netproxy.Dialer) (Client, error) {
+	if dialer != nil {
+		client, err := newProxiedGrpcClient(ctx, addr, key, tlsEnabled, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	client, err := signal.NewClient(ctx, addr, key, tlsEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,171 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/netbirdio/netbird/signal/proto"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/resolver"
+
+	"ztnav2client/internal/netproxy"
+)
+
+// The upstream Signal Exchange client doesn't take dial options, so to reach the Signal Service through a proxy
+// it dials a loopback forwarder tunneling its connections through the proxy instead.
+// The forwarder is resolved by the proxiedScheme resolver from a target of the form
+// proxied-signal://<forwarder id>/<Signal Service address>, so that the gRPC authority and the TLS server name
+// stay the ones of the Signal Service.
+const proxiedScheme = "proxied-signal"
+
+// forwarderDialTimeout is the timeout of a connection to the Signal Service through the proxy
+const forwarderDialTimeout = 30 * time.Second
+
+var (
+	forwardersMu  sync.Mutex
+	forwarders    = make(map[string]*forwarder)
+	lastForwarder uint64
+)
+
+func init() {
+	resolver.Register(proxiedResolverBuilder{})
+}
+
+// proxiedGrpcClient is the upstream Signal Exchange gRPC client reaching the service through a forwarder
+type proxiedGrpcClient struct {
+	*signal.GrpcClient
+	forwarder *forwarder
+}
+
+func newProxiedGrpcClient(ctx context.Context, addr string, key wgtypes.Key, tlsEnabled bool, dialer netproxy.Dialer) (*proxiedGrpcClient, error) {
+	forwarder, err := newForwarder(addr, dialer)
+	if err != nil {
+		return nil, err
+	}
+	client, err := signal.NewClient(ctx, forwarder.target(), key, tlsEnabled)
+	if err != nil {
+		forwarder.close()
+		return nil, err
+	}
+	return &proxiedGrpcClient{GrpcClient: client, forwarder: forwarder}, nil
+}
+
+// Receive skips the messages the upstream client fails to decrypt instead of passing them to the handler as nil
+func (c *proxiedGrpcClient) Receive(msgHandler func(msg *proto.Message) error) error {
+	return c.GrpcClient.Receive(func(msg *proto.Message) error {
+		if msg == nil {
+			return nil
+		}
+		return msgHandler(msg)
+	})
+}
+
+// Close closes the client and stops the forwarder
+func (c *proxiedGrpcClient) Close() error {
+	defer c.forwarder.close()
+	return c.GrpcClient.Close()
+}
+
+// forwarder forwards the connections accepted on a loopback address to the Signal Service through the dialer
+type forwarder struct {
+	id       string
+	addr     string
+	dialer   netproxy.Dialer
+	listener net.Listener
+}
+
+func newForwarder(addr string, dialer netproxy.Dialer) (*forwarder, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed listening for the Signal Service connections: %w", err)
+	}
+
+	forwardersMu.Lock()
+	lastForwarder++
+	f := &forwarder{
+		id:       fmt.Sprintf("forwarder-%d", lastForwarder),
+		addr:     addr,
+		dialer:   dialer,
+		listener: listener,
+	}
+	forwarders[f.id] = f
+	forwardersMu.Unlock()
+
+	go f.serve()
+	return f, nil
+}
+
+// target returns the gRPC dial target resolved to the forwarder
+func (f *forwarder) target() string {
+	return proxiedScheme + "://" + f.id + "/" + f.addr
+}
+
+func (f *forwarder) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.forward(conn)
+	}
+}
+
+func (f *forwarder) forward(conn net.Conn) {
+	defer conn.Close() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), forwarderDialTimeout)
+	remote, err := f.dialer.DialContext(ctx, "tcp", f.addr)
+	cancel()
+	if err != nil {
+		log.Warnf("failed connecting to the Signal Service %s through the proxy: %v", f.addr, err)
+		return
+	}
+	defer remote.Close() //nolint:errcheck
+
+	go func() {
+		_, _ = io.Copy(remote, conn)
+		_ = remote.Close()
+	}()
+	_, _ = io.Copy(conn, remote)
+}
+
+func (f *forwarder) close() {
+	forwardersMu.Lock()
+	delete(forwarders, f.id)
+	forwardersMu.Unlock()
+	_ = f.listener.Close()
+}
+
+// proxiedResolverBuilder resolves the proxiedScheme targets to the address of their forwarder
+type proxiedResolverBuilder struct{}
+
+func (proxiedResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	forwardersMu.Lock()
+	f, ok := forwarders[target.Authority]
+	forwardersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no Signal Service forwarder %s", target.Authority)
+	}
+	err := cc.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: f.listener.Addr().String()}}})
+	if err != nil {
+		return nil, err
+	}
+	return proxiedResolver{}, nil
+}
+
+func (proxiedResolverBuilder) Scheme() string {
+	return proxiedScheme
+}
+
+// proxiedResolver has nothing to re-resolve as the forwarder address doesn't change
+type proxiedResolver struct{}
+
+func (proxiedResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (proxiedResolver) Close() {}
@@ -0,0 +1,114 @@
+package signaling
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/netbirdio/netbird/signal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+
+	"ztnav2client/internal/netproxy"
+)
+
+// startTunnelProxy starts an HTTP CONNECT proxy and returns its address and the number of tunnels it has opened
+func startTunnelProxy(t *testing.T) (string, *int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	var tunnels int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer target.Close() //nolint:errcheck
+				atomic.AddInt32(&tunnels, 1)
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener.Addr().String(), &tunnels
+}
+
+func TestGrpcClient_Proxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	sProto.RegisterSignalExchangeServer(grpcServer, server.NewServer())
+	go func() { _ = grpcServer.Serve(listener) }()
+	defer grpcServer.Stop()
+
+	proxyAddr, tunnels := startTunnelProxy(t)
+	dialer, err := netproxy.New("http://" + proxyAddr)
+	require.NoError(t, err)
+
+	aliceKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	alice, err := NewGrpcClient(ctx, listener.Addr().String(), aliceKey, false, dialer)
+	require.NoError(t, err)
+	defer alice.Close() //nolint:errcheck
+	bob, err := NewGrpcClient(ctx, listener.Addr().String(), bobKey, false, nil)
+	require.NoError(t, err)
+	defer bob.Close() //nolint:errcheck
+	assert.Equal(t, int32(1), atomic.LoadInt32(tunnels), "only alice connects through the proxy")
+
+	// the server only forwards the messages of the registered peers
+	go func() {
+		_ = alice.Receive(func(msg *sProto.Message) error { return nil })
+	}()
+	received := make(chan *sProto.Message, 1)
+	go func() {
+		_ = bob.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+	alice.WaitStreamConnected()
+	bob.WaitStreamConnected()
+	assert.True(t, alice.StreamConnected())
+
+	err = alice.Send(&sProto.Message{
+		Key:       aliceKey.PublicKey().String(),
+		RemoteKey: bobKey.PublicKey().String(),
+		Body:      &sProto.Body{Type: sProto.Body_OFFER, Payload: "ufrag:pwd"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, aliceKey.PublicKey().String(), msg.Key)
+		assert.Equal(t, "ufrag:pwd", msg.GetBody().GetPayload())
+	case <-time.After(2 * time.Second):
+		t.Fatal("message hasn't been received")
+	}
+}
@@ -0,0 +1,195 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultLANGroup is the multicast group the LAN signaling messages are exchanged on
+	DefaultLANGroup = "239.255.77.88:51899"
+
+	lanMaxMessageSize = 65535
+)
+
+// lanMagic prefixes every LAN signaling datagram to ignore unrelated traffic sent to the group
+var lanMagic = []byte("WTLAN1")
+
+// LANConfig is a configuration of the LAN signaling
+type LANConfig struct {
+	// Group is the multicast group address, DefaultLANGroup if empty
+	Group string
+	// Interface is the interface to join the multicast group on, the system default if nil
+	Interface *net.Interface
+}
+
+// LANClient exchanges signaling messages with the peers of the local network over UDP multicast, without any server.
+// The message bodies are encrypted and authenticated with the WireGuard keys of the peers like the messages
+// passed through the Signal Exchange, so only the addressed peer can read a message and it can verify the sender.
+type LANClient struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	key   wgtypes.Key
+	group *net.UDPAddr
+	// conn is bound to the group and receives the messages
+	conn *net.UDPConn
+	// sendConn sends the messages, a socket bound to a multicast address can't be used as a source
+	sendConn *net.UDPConn
+
+	mu        sync.Mutex
+	receiving bool
+	// connectedCh is closed once Receive has been called
+	connectedCh chan struct{}
+}
+
+// NewLANClient joins the LAN signaling multicast group
+func NewLANClient(ctx context.Context, config LANConfig, key wgtypes.Key) (*LANClient, error) {
+	group := config.Group
+	if group == "" {
+		group = DefaultLANGroup
+	}
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	if !groupAddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("LAN signaling group %s is not a multicast address", group)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", config.Interface, groupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed joining LAN signaling group %s: %w", group, err)
+	}
+	sendConn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if config.Interface != nil {
+		// send on the same interface the group has been joined on
+		err = ipv4.NewPacketConn(sendConn).SetMulticastInterface(config.Interface)
+		if err != nil {
+			_ = conn.Close()
+			_ = sendConn.Close()
+			return nil, fmt.Errorf("failed setting LAN signaling interface %s: %w", config.Interface.Name, err)
+		}
+	}
+
+	clientCtx, cancel := context.WithCancel(ctx)
+	return &LANClient{
+		ctx:         clientCtx,
+		cancel:      cancel,
+		key:         key,
+		group:       groupAddr,
+		conn:        conn,
+		sendConn:    sendConn,
+		connectedCh: make(chan struct{}),
+	}, nil
+}
+
+// Close leaves the multicast group and stops Receive
+func (c *LANClient) Close() error {
+	c.cancel()
+	err := c.sendConn.Close()
+	if err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+	return c.conn.Close()
+}
+
+// Ready indicates whether the client can send messages
+func (c *LANClient) Ready() bool {
+	return c.ctx.Err() == nil
+}
+
+// StreamConnected indicates whether the client is receiving messages
+func (c *LANClient) StreamConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.receiving && c.ctx.Err() == nil
+}
+
+// WaitStreamConnected blocks until Receive has been called or the client has been closed
+func (c *LANClient) WaitStreamConnected() {
+	select {
+	case <-c.ctx.Done():
+	case <-c.connectedCh:
+	}
+}
+
+// Send multicasts a message to the local network, only the peer identified by msg.RemoteKey is able to read it
+func (c *LANClient) Send(msg *sProto.Message) error {
+	encryptedMessage, err := encryptMessage(c.key, msg)
+	if err != nil {
+		return err
+	}
+	b, err := proto.Marshal(encryptedMessage)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendConn.WriteToUDP(append(append([]byte{}, lanMagic...), b...), c.group)
+	return err
+}
+
+// Receive passes the messages multicast by the local peers to this peer to msgHandler until the client has been closed
+func (c *LANClient) Receive(msgHandler func(msg *sProto.Message) error) error {
+	c.mu.Lock()
+	if c.receiving {
+		c.mu.Unlock()
+		return fmt.Errorf("LAN signaling client is already receiving messages")
+	}
+	c.receiving = true
+	close(c.connectedCh)
+	c.mu.Unlock()
+
+	ourKey := c.key.PublicKey().String()
+	buf := make([]byte, lanMaxMessageSize)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed reading LAN signaling messages: %w", err)
+		}
+
+		if !bytes.HasPrefix(buf[:n], lanMagic) {
+			continue
+		}
+		encryptedMessage := &sProto.EncryptedMessage{}
+		err = proto.Unmarshal(buf[len(lanMagic):n], encryptedMessage)
+		if err != nil {
+			log.Debugf("received malformed LAN signaling message from %s: %v", addr, err)
+			continue
+		}
+		// the group is shared by all the peers, including our own messages
+		if encryptedMessage.RemoteKey != ourKey {
+			continue
+		}
+
+		msg, err := decryptMessage(c.key, encryptedMessage)
+		if err != nil {
+			// not encrypted by the owner of the sender's key
+			log.Debugf("failed authenticating LAN signaling message of Peer [key: %s] from %s: %v", encryptedMessage.Key, addr, err)
+			continue
+		}
+
+		log.Debugf("received a new LAN message from Peer [fingerprint: %s] at %s", msg.Key, addr)
+		err = msgHandler(msg)
+		if err != nil {
+			log.Errorf("error while handling LAN message of Peer [key: %s] error: [%s]", msg.Key, err.Error())
+		}
+	}
+}
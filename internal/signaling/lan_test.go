@@ -0,0 +1,78 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newTestLANClient(t *testing.T, group string) (*LANClient, wgtypes.Key) {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	client, err := NewLANClient(context.Background(), LANConfig{Group: group}, key)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client, key
+}
+
+func TestLANClient_SendReceive(t *testing.T) {
+	group := fmt.Sprintf("239.255.77.88:%d", 40000+rand.Intn(10000))
+	alice, aliceKey := newTestLANClient(t, group)
+	bob, bobKey := newTestLANClient(t, group)
+	// a peer that is not the recipient
+	carol, _ := newTestLANClient(t, group)
+
+	received := make(chan *sProto.Message, 10)
+	for _, client := range []*LANClient{bob, carol} {
+		go func(client *LANClient) {
+			_ = client.Receive(func(msg *sProto.Message) error {
+				received <- msg
+				return nil
+			})
+		}(client)
+		client.WaitStreamConnected()
+	}
+
+	err := alice.Send(&sProto.Message{
+		Key:       aliceKey.PublicKey().String(),
+		RemoteKey: bobKey.PublicKey().String(),
+		Body:      &sProto.Body{Type: sProto.Body_OFFER, Payload: "ufrag:pwd"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, aliceKey.PublicKey().String(), msg.Key)
+		assert.Equal(t, bobKey.PublicKey().String(), msg.RemoteKey)
+		assert.Equal(t, "ufrag:pwd", msg.GetBody().GetPayload())
+	case <-time.After(2 * time.Second):
+		t.Skip("multicast loopback doesn't deliver messages in this environment")
+	}
+
+	// carol pretends to be alice, bob can't authenticate the message
+	err = carol.Send(&sProto.Message{
+		Key:       aliceKey.PublicKey().String(),
+		RemoteKey: bobKey.PublicKey().String(),
+		Body:      &sProto.Body{Type: sProto.Body_OFFER, Payload: "forged:forged"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		t.Fatalf("forged or misaddressed message has been accepted: %v", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,314 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/netbirdio/netbird/encryption"
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"ztnav2client/internal/netproxy"
+)
+
+const (
+	// LongPollSendPath receives a single encrypted message in the request body
+	LongPollSendPath = "/api/signal/send"
+	// LongPollReceivePath holds the request until there are messages for the peer identified by the sProto.HeaderId header.
+	// Responds with the length-prefixed encrypted messages or with 204 No Content once there were none for a while.
+	LongPollReceivePath = "/api/signal/receive"
+
+	longPollContentType = "application/x-protobuf"
+
+	longPollSendTimeout = 5 * time.Second
+	// longPollReceiveTimeout has to be longer than the time the server holds a receive request
+	longPollReceiveTimeout = 90 * time.Second
+)
+
+// LongPollClient exchanges signaling messages with a signaling server over HTTP long-polling
+type LongPollClient struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	baseURL    string
+	key        wgtypes.Key
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// connected is true if the last receive request has succeeded
+	connected bool
+	// connectedCh used to notify goroutines waiting for the first successful receive request
+	connectedCh chan struct{}
+}
+
+// NewLongPollClient creates a long-polling client of the signaling server at baseURL, e.g. https://signal.example.com.
+// The server is reached through the dialer if it isn't nil.
+func NewLongPollClient(ctx context.Context, baseURL string, key wgtypes.Key, dialer netproxy.Dialer) (*LongPollClient, error) {
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		return nil, fmt.Errorf("invalid long-polling signal URL %s", baseURL)
+	}
+
+	httpClient := &http.Client{}
+	if dialer != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+		// the dialer already goes through the proxy
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+		httpClient.Transport = transport
+	}
+
+	clientCtx, cancel := context.WithCancel(ctx)
+	return &LongPollClient{
+		ctx:         clientCtx,
+		cancel:      cancel,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		key:         key,
+		httpClient:  httpClient,
+		connectedCh: make(chan struct{}),
+	}, nil
+}
+
+// Close stops receiving messages
+func (c *LongPollClient) Close() error {
+	c.cancel()
+	return nil
+}
+
+// Ready indicates whether the client is okay and Ready to be used
+func (c *LongPollClient) Ready() bool {
+	return c.ctx.Err() == nil && c.StreamConnected()
+}
+
+// StreamConnected indicates whether the last receive request has succeeded
+func (c *LongPollClient) StreamConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// WaitStreamConnected waits until a receive request has succeeded
+func (c *LongPollClient) WaitStreamConnected() {
+	c.mu.Lock()
+	ch := c.connectedCh
+	c.mu.Unlock()
+
+	select {
+	case <-c.ctx.Done():
+	case <-ch:
+	}
+}
+
+func (c *LongPollClient) setConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connected == connected {
+		return
+	}
+	c.connected = connected
+	if connected {
+		// there are goroutines waiting on this channel -> release them
+		close(c.connectedCh)
+	} else {
+		c.connectedCh = make(chan struct{})
+	}
+}
+
+// Send sends a message to the remote peer through the signaling server
+func (c *LongPollClient) Send(msg *sProto.Message) error {
+	encryptedMessage, err := encryptMessage(c.key, msg)
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(encryptedMessage)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, longPollSendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+LongPollSendPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", longPollContentType)
+	req.Header.Set(sProto.HeaderId, c.key.PublicKey().String())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signal server responded with %s while sending message to peer %s", resp.Status, msg.RemoteKey)
+	}
+	return nil
+}
+
+// Receive polls the signaling server for messages and passes them to msgHandler.
+// This function is blocking and retries with a backoff if the requests fail.
+// Returns nil once the client has been closed or an error if the server was unavailable for too long.
+func (c *LongPollClient) Receive(msgHandler func(msg *sProto.Message) error) error {
+	backOff := &backoff.ExponentialBackOff{
+		InitialInterval:     800 * time.Millisecond,
+		RandomizationFactor: 1,
+		Multiplier:          1.7,
+		MaxInterval:         10 * time.Second,
+		MaxElapsedTime:      3 * 30 * 24 * time.Hour, // 3 months
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+	backOff.Reset()
+
+	for {
+		messages, err := c.poll()
+		if c.ctx.Err() != nil {
+			c.setConnected(false)
+			return nil
+		}
+		if err != nil {
+			c.setConnected(false)
+			delay := backOff.NextBackOff()
+			if delay == backoff.Stop {
+				return fmt.Errorf("giving up receiving messages from the signal server: %w", err)
+			}
+			log.Warnf("failed receiving messages from the signal server, retrying in %s: %v", delay, err)
+			select {
+			case <-c.ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		backOff.Reset()
+		c.setConnected(true)
+
+		for _, encryptedMessage := range messages {
+			log.Debugf("received a new message from Peer [fingerprint: %s]", encryptedMessage.Key)
+			msg, err := decryptMessage(c.key, encryptedMessage)
+			if err != nil {
+				log.Errorf("failed decrypting message of Peer [key: %s] error: [%s]", encryptedMessage.Key, err.Error())
+				continue
+			}
+			err = msgHandler(msg)
+			if err != nil {
+				log.Errorf("error while handling message of Peer [key: %s] error: [%s]", msg.Key, err.Error())
+			}
+		}
+	}
+}
+
+// poll runs a single receive request
+func (c *LongPollClient) poll() ([]*sProto.EncryptedMessage, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, longPollReceiveTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+LongPollReceivePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(sProto.HeaderId, c.key.PublicKey().String())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("signal server responded with %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalLongPollMessages(body)
+}
+
+// MarshalLongPollMessages encodes messages as a receive response body
+func MarshalLongPollMessages(messages []*sProto.EncryptedMessage) ([]byte, error) {
+	var body []byte
+	for _, msg := range messages {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		body = protowire.AppendBytes(body, b)
+	}
+	return body, nil
+}
+
+// UnmarshalLongPollMessages decodes a receive response body
+func UnmarshalLongPollMessages(body []byte) ([]*sProto.EncryptedMessage, error) {
+	var messages []*sProto.EncryptedMessage
+	for len(body) > 0 {
+		b, n := protowire.ConsumeBytes(body)
+		if n < 0 {
+			return nil, fmt.Errorf("malformed signal messages: %w", protowire.ParseError(n))
+		}
+		body = body[n:]
+
+		msg := &sProto.EncryptedMessage{}
+		err := proto.Unmarshal(b, msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// decryptMessage decrypts the body of the msg using Wireguard private key and Remote peer's public key
+func decryptMessage(key wgtypes.Key, msg *sProto.EncryptedMessage) (*sProto.Message, error) {
+	remoteKey, err := wgtypes.ParseKey(msg.GetKey())
+	if err != nil {
+		return nil, err
+	}
+
+	body := &sProto.Body{}
+	err = encryption.DecryptMessage(remoteKey, key, msg.GetBody(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sProto.Message{
+		Key:       msg.Key,
+		RemoteKey: msg.RemoteKey,
+		Body:      body,
+	}, nil
+}
+
+// encryptMessage encrypts the body of the msg using Wireguard private key and Remote peer's public key
+func encryptMessage(key wgtypes.Key, msg *sProto.Message) (*sProto.EncryptedMessage, error) {
+	remoteKey, err := wgtypes.ParseKey(msg.RemoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedBody, err := encryption.EncryptMessage(remoteKey, key, msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sProto.EncryptedMessage{
+		Key:       msg.GetKey(),
+		RemoteKey: msg.GetRemoteKey(),
+		Body:      encryptedBody,
+	}, nil
+}
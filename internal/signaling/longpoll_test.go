@@ -0,0 +1,158 @@
+package signaling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/protobuf/proto"
+)
+
+// longPollServer is a minimal signaling server forwarding the encrypted messages between the peers
+type longPollServer struct {
+	mu     sync.Mutex
+	queues map[string][]*sProto.EncryptedMessage
+	notify map[string]chan struct{}
+}
+
+func newLongPollServer() *longPollServer {
+	return &longPollServer{
+		queues: make(map[string][]*sProto.EncryptedMessage),
+		notify: make(map[string]chan struct{}),
+	}
+}
+
+func (s *longPollServer) notifyCh(key string) chan struct{} {
+	ch, ok := s.notify[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		s.notify[key] = ch
+	}
+	return ch
+}
+
+func (s *longPollServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case LongPollSendPath:
+		body, _ := io.ReadAll(r.Body)
+		msg := &sProto.EncryptedMessage{}
+		if err := proto.Unmarshal(body, msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.queues[msg.RemoteKey] = append(s.queues[msg.RemoteKey], msg)
+		select {
+		case s.notifyCh(msg.RemoteKey) <- struct{}{}:
+		default:
+		}
+		s.mu.Unlock()
+	case LongPollReceivePath:
+		key := r.Header.Get(sProto.HeaderId)
+		for {
+			s.mu.Lock()
+			messages := s.queues[key]
+			delete(s.queues, key)
+			ch := s.notifyCh(key)
+			s.mu.Unlock()
+
+			if len(messages) > 0 {
+				body, _ := MarshalLongPollMessages(messages)
+				_, _ = w.Write(body)
+				return
+			}
+			select {
+			case <-ch:
+			case <-time.After(200 * time.Millisecond):
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestLongPollClient_SendReceive(t *testing.T) {
+	server := httptest.NewServer(newLongPollServer())
+	defer server.Close()
+
+	aliceKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	alice, err := NewLongPollClient(context.Background(), server.URL, aliceKey, nil)
+	require.NoError(t, err)
+	defer alice.Close()
+	bob, err := NewLongPollClient(context.Background(), server.URL, bobKey, nil)
+	require.NoError(t, err)
+
+	received := make(chan *sProto.Message, 10)
+	done := make(chan error)
+	go func() {
+		done <- bob.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+	bob.WaitStreamConnected()
+	assert.True(t, bob.Ready())
+
+	for _, payload := range []string{"ufrag:pwd", "candidate"} {
+		err = alice.Send(&sProto.Message{
+			Key:       aliceKey.PublicKey().String(),
+			RemoteKey: bobKey.PublicKey().String(),
+			Body:      &sProto.Body{Type: sProto.Body_OFFER, Payload: payload},
+		})
+		require.NoError(t, err)
+	}
+
+	for _, payload := range []string{"ufrag:pwd", "candidate"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, aliceKey.PublicKey().String(), msg.Key)
+			assert.Equal(t, payload, msg.GetBody().GetPayload())
+		case <-time.After(2 * time.Second):
+			t.Fatal("message hasn't been received")
+		}
+	}
+
+	require.NoError(t, bob.Close())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Receive should return once the client has been closed")
+	}
+	assert.False(t, bob.Ready())
+}
+
+func TestLongPollMessages_Marshal(t *testing.T) {
+	messages := []*sProto.EncryptedMessage{
+		{Key: "a", RemoteKey: "b", Body: []byte{1, 2, 3}},
+		{Key: "b", RemoteKey: "a"},
+	}
+	body, err := MarshalLongPollMessages(messages)
+	require.NoError(t, err)
+
+	decoded, err := UnmarshalLongPollMessages(body)
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	for i := range messages {
+		assert.True(t, proto.Equal(messages[i], decoded[i]))
+	}
+
+	_, err = UnmarshalLongPollMessages(body[:len(body)-1])
+	assert.Error(t, err)
+}
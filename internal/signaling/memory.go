@@ -0,0 +1,153 @@
+package signaling
+
+import (
+	"fmt"
+	"sync"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// memoryInboxSize is the number of messages a MemoryClient queues before Send starts failing
+const memoryInboxSize = 1024
+
+// Hub routes signaling messages between the MemoryClients of the same process,
+// e.g. to wire several engines together in tests. Messages are not encrypted.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[string]*MemoryClient
+}
+
+// NewHub creates an empty Hub
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[string]*MemoryClient),
+	}
+}
+
+// NewClient creates a client receiving the messages addressed to the peer with the given WireGuard public key.
+// A previous client of the same peer is replaced.
+func (h *Hub) NewClient(key string) *MemoryClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := &MemoryClient{
+		hub:         h,
+		key:         key,
+		inbox:       make(chan *sProto.Message, memoryInboxSize),
+		closed:      make(chan struct{}),
+		connectedCh: make(chan struct{}),
+	}
+	h.clients[key] = c
+	return c
+}
+
+func (h *Hub) deliver(msg *sProto.Message) error {
+	h.mu.Lock()
+	c, ok := h.clients[msg.RemoteKey]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s is not connected to the signal hub", msg.RemoteKey)
+	}
+
+	// the receiver must not share the message with the sender
+	msg = proto.Clone(msg).(*sProto.Message)
+	select {
+	case <-c.closed:
+		return fmt.Errorf("peer %s is not connected to the signal hub", msg.RemoteKey)
+	case c.inbox <- msg:
+		return nil
+	default:
+		return fmt.Errorf("inbox of peer %s is full", msg.RemoteKey)
+	}
+}
+
+func (h *Hub) remove(c *MemoryClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c.key] == c {
+		delete(h.clients, c.key)
+	}
+}
+
+// MemoryClient is a signaling Client connected to a Hub
+type MemoryClient struct {
+	hub   *Hub
+	key   string
+	inbox chan *sProto.Message
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	receiving bool
+	// connectedCh is closed once Receive has been called
+	connectedCh chan struct{}
+}
+
+// Close disconnects the client from the Hub and stops Receive
+func (c *MemoryClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.hub.remove(c)
+	})
+	return nil
+}
+
+// Send passes a message to the client of the remote peer
+func (c *MemoryClient) Send(msg *sProto.Message) error {
+	if !c.Ready() {
+		return fmt.Errorf("no connection to signal")
+	}
+	return c.hub.deliver(msg)
+}
+
+// Receive passes the messages addressed to this peer to msgHandler until the client has been closed
+func (c *MemoryClient) Receive(msgHandler func(msg *sProto.Message) error) error {
+	c.mu.Lock()
+	if c.receiving {
+		c.mu.Unlock()
+		return fmt.Errorf("client of peer %s is already receiving messages", c.key)
+	}
+	c.receiving = true
+	close(c.connectedCh)
+	c.mu.Unlock()
+
+	for {
+		select {
+		case <-c.closed:
+			return nil
+		case msg := <-c.inbox:
+			err := msgHandler(msg)
+			if err != nil {
+				log.Errorf("error while handling message of Peer [key: %s] error: [%s]", msg.Key, err.Error())
+			}
+		}
+	}
+}
+
+// Ready indicates whether the client is still connected to the Hub
+func (c *MemoryClient) Ready() bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// StreamConnected indicates whether Receive has been called
+func (c *MemoryClient) StreamConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.receiving && c.Ready()
+}
+
+// WaitStreamConnected blocks until Receive has been called or the client has been closed
+func (c *MemoryClient) WaitStreamConnected() {
+	select {
+	case <-c.closed:
+	case <-c.connectedCh:
+	}
+}
@@ -0,0 +1,67 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_RoutesMessages(t *testing.T) {
+	hub := NewHub()
+	alice := hub.NewClient("alice")
+	bob := hub.NewClient("bob")
+	defer alice.Close()
+	defer bob.Close()
+
+	received := make(chan *sProto.Message, 1)
+	go func() {
+		_ = bob.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+	bob.WaitStreamConnected()
+	assert.True(t, bob.StreamConnected())
+
+	sent := &sProto.Message{Key: "alice", RemoteKey: "bob", Body: &sProto.Body{Type: sProto.Body_OFFER, Payload: "ufrag:pwd"}}
+	require.NoError(t, alice.Send(sent))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "alice", msg.Key)
+		assert.Equal(t, "ufrag:pwd", msg.GetBody().GetPayload())
+		assert.NotSame(t, sent, msg)
+	case <-time.After(time.Second):
+		t.Fatal("message hasn't been delivered")
+	}
+
+	assert.Error(t, alice.Send(&sProto.Message{Key: "alice", RemoteKey: "carol", Body: &sProto.Body{}}), "unknown peer")
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub()
+	alice := hub.NewClient("alice")
+	bob := hub.NewClient("bob")
+
+	done := make(chan error)
+	go func() {
+		done <- bob.Receive(func(*sProto.Message) error { return nil })
+	}()
+	bob.WaitStreamConnected()
+
+	require.NoError(t, bob.Close())
+	require.NoError(t, bob.Close())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Receive should return once the client has been closed")
+	}
+
+	assert.False(t, bob.Ready())
+	assert.Error(t, alice.Send(&sProto.Message{Key: "alice", RemoteKey: "bob", Body: &sProto.Body{}}))
+	assert.Error(t, bob.Send(&sProto.Message{Key: "bob", RemoteKey: "alice", Body: &sProto.Body{}}))
+}
@@ -0,0 +1,241 @@
+package signaling
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+// duplicateWindow is how long a received message is remembered to drop its copies coming over the other transports
+const duplicateWindow = time.Minute
+
+// ParallelClient sends and receives the signaling messages over several transports at once,
+// e.g. over the Signal Exchange and the LAN so that peers of the same network connect also when the server is unreachable.
+// The copies of a message received over more than one transport are passed to the handler only once.
+type ParallelClient struct {
+	mu      sync.Mutex
+	clients []Client
+	closed  bool
+	// handler is set once Receive has been called, the transports added later start receiving right away
+	handler func(msg *sProto.Message) error
+	// receiving counts the transports that haven't stopped receiving yet
+	receiving sync.WaitGroup
+
+	seenMu sync.Mutex
+	seen   map[[sha256.Size]byte]struct{}
+	// seenOrder is the received messages in the order of their arrival, so the expired ones are pruned from its front
+	seenOrder []seenMessage
+}
+
+// seenMessage is the digest of a received message and the time it has been received at
+type seenMessage struct {
+	digest [sha256.Size]byte
+	at     time.Time
+}
+
+// NewParallelClient creates a client using all the given transports. Receive returns once all the transports have
+// stopped receiving, errors of the single transports are only logged.
+func NewParallelClient(clients ...Client) *ParallelClient {
+	return &ParallelClient{
+		clients: clients,
+		seen:    make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+// AddClient adds a transport, e.g. the Signal Exchange once it has become reachable.
+// The transport is closed right away if the ParallelClient has already been closed.
+func (c *ParallelClient) AddClient(client Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		if err := client.Close(); err != nil {
+			log.Debugf("failed closing signaling transport: %v", err)
+		}
+		return
+	}
+	c.clients = append(c.clients, client)
+	if c.handler != nil {
+		c.receive(client)
+	}
+}
+
+func (c *ParallelClient) getClients() []Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Client(nil), c.clients...)
+}
+
+// Close closes all the transports
+func (c *ParallelClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	var closeErr error
+	for _, client := range c.getClients() {
+		err := client.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// Send sends the message over all the ready transports. Succeeds if at least one of them has sent the message.
+func (c *ParallelClient) Send(msg *sProto.Message) error {
+	var sendErr error
+	sent := false
+	for _, client := range c.getClients() {
+		if !client.Ready() {
+			continue
+		}
+		err := client.Send(msg)
+		if err != nil {
+			log.Debugf("failed sending signaling message to peer %s over one of the transports: %v", msg.RemoteKey, err)
+			if sendErr == nil {
+				sendErr = err
+			}
+			continue
+		}
+		sent = true
+	}
+
+	if sent {
+		return nil
+	}
+	if sendErr == nil {
+		sendErr = fmt.Errorf("no connection to signal")
+	}
+	return sendErr
+}
+
+// Receive receives the messages of all the transports, blocks until every transport has stopped receiving.
+// Returns an error if all the transports have stopped while the client hasn't been closed.
+func (c *ParallelClient) Receive(msgHandler func(msg *sProto.Message) error) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.pruneSeen(stop)
+
+	c.mu.Lock()
+	c.handler = func(msg *sProto.Message) error {
+		if c.isDuplicate(msg, time.Now()) {
+			return nil
+		}
+		return msgHandler(msg)
+	}
+	for _, client := range c.clients {
+		c.receive(client)
+	}
+	c.mu.Unlock()
+
+	c.receiving.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the transports added from now on aren't received from anymore
+	c.handler = nil
+	if c.closed {
+		return nil
+	}
+	return fmt.Errorf("all the signaling transports have stopped receiving")
+}
+
+// receive starts receiving from the transport, c.mu must be held
+func (c *ParallelClient) receive(client Client) {
+	handler := c.handler
+	c.receiving.Add(1)
+	go func() {
+		defer c.receiving.Done()
+		err := client.Receive(handler)
+		if err != nil {
+			log.Warnf("stopped receiving signaling messages over one of the transports: %v", err)
+		}
+	}()
+}
+
+// isDuplicate returns true if the same message has been received within the duplicateWindow.
+// The session and the counter are part of the message, so the identical candidates of a new attempt aren't dropped.
+// The messages without a session are never duplicates, they come from the peers not signaling over the LAN.
+func (c *ParallelClient) isDuplicate(msg *sProto.Message, now time.Time) bool {
+	body := msg.GetBody()
+	session := GetSession(body)
+	if session.ID == 0 {
+		return false
+	}
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%d|%d|%d",
+		msg.GetKey(), msg.GetRemoteKey(), body.GetType(), body.GetPayload(), body.GetWgListenPort(),
+		session.ID, session.Counter)))
+
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+
+	if _, ok := c.seen[digest]; ok {
+		return true
+	}
+	c.seen[digest] = struct{}{}
+	c.seenOrder = append(c.seenOrder, seenMessage{digest: digest, at: now})
+	return false
+}
+
+// pruneSeen forgets the messages received before the duplicateWindow every duplicateWindow until stop is closed
+func (c *ParallelClient) pruneSeen(stop <-chan struct{}) {
+	ticker := time.NewTicker(duplicateWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.pruneSeenBefore(now.Add(-duplicateWindow))
+		}
+	}
+}
+
+func (c *ParallelClient) pruneSeenBefore(before time.Time) {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+
+	expired := 0
+	for expired < len(c.seenOrder) && !c.seenOrder[expired].at.After(before) {
+		delete(c.seen, c.seenOrder[expired].digest)
+		expired++
+	}
+	c.seenOrder = append([]seenMessage(nil), c.seenOrder[expired:]...)
+}
+
+// Ready indicates whether at least one of the transports can send messages
+func (c *ParallelClient) Ready() bool {
+	for _, client := range c.getClients() {
+		if client.Ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamConnected indicates whether at least one of the transports is receiving messages
+func (c *ParallelClient) StreamConnected() bool {
+	for _, client := range c.getClients() {
+		if client.StreamConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitStreamConnected blocks until one of the transports is receiving messages
+func (c *ParallelClient) WaitStreamConnected() {
+	clients := c.getClients()
+	connected := make(chan struct{}, len(clients))
+	for _, client := range clients {
+		go func(client Client) {
+			client.WaitStreamConnected()
+			connected <- struct{}{}
+		}(client)
+	}
+	<-connected
+}
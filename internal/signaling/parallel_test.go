@@ -0,0 +1,167 @@
+package signaling
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelClient_DropsDuplicates(t *testing.T) {
+	// two independent transports between the same peers
+	server, lan := NewHub(), NewHub()
+	alice := NewParallelClient(server.NewClient("alice"), lan.NewClient("alice"))
+	bob := NewParallelClient(server.NewClient("bob"), lan.NewClient("bob"))
+	defer alice.Close()
+	defer bob.Close()
+
+	var mu sync.Mutex
+	var received []*sProto.Message
+	go func() {
+		_ = bob.Receive(func(msg *sProto.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, msg)
+			return nil
+		})
+	}()
+	bob.WaitStreamConnected()
+
+	for i, payload := range []string{"ufrag:pwd", "candidate"} {
+		body := &sProto.Body{Payload: payload}
+		SetSession(body, Session{ID: 1, Counter: uint64(i + 1)})
+		require.NoError(t, alice.Send(&sProto.Message{Key: "alice", RemoteKey: "bob", Body: body}))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	assert.Len(t, received, 2, "each message should be handled once")
+	mu.Unlock()
+}
+
+func TestParallelClient_FallsBack(t *testing.T) {
+	server, lan := NewHub(), NewHub()
+	serverClient := server.NewClient("alice")
+	alice := NewParallelClient(serverClient, lan.NewClient("alice"))
+	bobLAN := lan.NewClient("bob")
+	defer alice.Close()
+	defer bobLAN.Close()
+
+	// the server is unreachable
+	require.NoError(t, serverClient.Close())
+	assert.True(t, alice.Ready())
+
+	received := make(chan *sProto.Message, 1)
+	go func() {
+		_ = bobLAN.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+	bobLAN.WaitStreamConnected()
+
+	require.NoError(t, alice.Send(&sProto.Message{Key: "alice", RemoteKey: "bob", Body: &sProto.Body{Payload: "ufrag:pwd"}}))
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message should be sent over the LAN")
+	}
+
+	require.NoError(t, alice.Close())
+	assert.False(t, alice.Ready())
+	assert.Error(t, alice.Send(&sProto.Message{Key: "alice", RemoteKey: "bob", Body: &sProto.Body{}}))
+}
+
+func TestParallelClient_ReceivesUntilAllTransportsStopped(t *testing.T) {
+	server, lan := NewHub(), NewHub()
+	serverClient, lanClient := server.NewClient("alice"), lan.NewClient("alice")
+	alice := NewParallelClient(serverClient, lanClient)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- alice.Receive(func(msg *sProto.Message) error { return nil })
+	}()
+	alice.WaitStreamConnected()
+
+	// the server gave up but the LAN still works
+	require.NoError(t, serverClient.Close())
+	select {
+	case <-done:
+		t.Fatal("Receive should go on while the LAN transport is receiving")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, lanClient.Close())
+	select {
+	case err := <-done:
+		assert.Error(t, err, "all the transports have stopped without closing the client")
+	case <-time.After(time.Second):
+		t.Fatal("Receive should return once all the transports have stopped")
+	}
+}
+
+func TestParallelClient_AddClient(t *testing.T) {
+	server, lan := NewHub(), NewHub()
+	alice := NewParallelClient(lan.NewClient("alice"))
+	bob := server.NewClient("bob")
+	defer bob.Close()
+
+	received := make(chan *sProto.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- alice.Receive(func(msg *sProto.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+	alice.WaitStreamConnected()
+
+	// the server has become reachable after the start
+	alice.AddClient(server.NewClient("alice"))
+	require.Eventually(t, func() bool {
+		return bob.Send(&sProto.Message{Key: "bob", RemoteKey: "alice", Body: &sProto.Body{Payload: "ufrag:pwd"}}) == nil
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message should be received over the added transport")
+	}
+
+	require.NoError(t, alice.Close())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Receive should return once the client has been closed")
+	}
+
+	// a transport added after the close is closed right away
+	late := server.NewClient("alice")
+	alice.AddClient(late)
+	assert.False(t, late.Ready())
+}
+
+func TestParallelClient_IsDuplicate(t *testing.T) {
+	c := NewParallelClient()
+	now := time.Now()
+	candidate := func(session Session) *sProto.Message {
+		body := &sProto.Body{Type: sProto.Body_CANDIDATE, Payload: "candidate:1 1 udp 2130706431 192.168.1.2 51820 typ host"}
+		SetSession(body, session)
+		return &sProto.Message{Key: "alice", RemoteKey: "bob", Body: body}
+	}
+
+	assert.False(t, c.isDuplicate(candidate(Session{ID: 1, Counter: 1}), now))
+	assert.True(t, c.isDuplicate(candidate(Session{ID: 1, Counter: 1}), now), "the copy of another transport")
+	assert.False(t, c.isDuplicate(candidate(Session{ID: 1, Counter: 2}), now), "the same candidate signaled again")
+	assert.False(t, c.isDuplicate(candidate(Session{ID: 2, Counter: 1}), now), "the same candidate of a new attempt")
+	assert.False(t, c.isDuplicate(candidate(Session{}), now))
+	assert.False(t, c.isDuplicate(candidate(Session{}), now), "the candidates of the peers without sessions are identical across attempts")
+
+	c.pruneSeenBefore(now)
+	assert.Empty(t, c.seen)
+	assert.Empty(t, c.seenOrder)
+	assert.False(t, c.isDuplicate(candidate(Session{ID: 1, Counter: 1}), now.Add(duplicateWindow)))
+}
@@ -0,0 +1,93 @@
+package signaling
+
+// replayWindowSize is the number of recent message counters remembered per remote peer.
+// Messages can be reordered, e.g. candidates sent concurrently, so older counters within the window are still accepted once.
+const replayWindowSize = 1024
+
+// replayRetiredSessions is the number of previous sessions remembered per remote peer, their messages are stale
+const replayRetiredSessions = 64
+
+// ReplayWindow detects replayed and stale signaling messages of a remote peer by their sessions and counters
+type ReplayWindow struct {
+	session uint64
+	highest uint64
+	seen    [replayWindowSize / 64]uint64
+	// retired are the previous sessions of the remote peer, the oldest first
+	retired []uint64
+}
+
+// Accept returns true if a message of the session with the given counter hasn't been received yet
+// and isn't older than the window.
+// A new session of the remote peer starts a new window, so the messages of a restarted peer are accepted
+// even if its counters went back with its clock. The messages of the recent previous sessions are rejected.
+// Counter 0 is used by peers that don't support sessions and is always accepted.
+func (w *ReplayWindow) Accept(session, counter uint64) bool {
+	if counter == 0 {
+		return true
+	}
+
+	if session != w.session {
+		if w.isRetired(session) {
+			return false
+		}
+		if w.session != 0 {
+			w.retire(w.session)
+		}
+		w.session = session
+		w.highest = 0
+	}
+
+	if counter > w.highest {
+		shift := counter - w.highest
+		if shift >= replayWindowSize || w.highest == 0 {
+			w.seen = [replayWindowSize / 64]uint64{}
+		} else {
+			for c := w.highest + 1; c < counter; c++ {
+				w.clear(c)
+			}
+		}
+		w.highest = counter
+		w.set(counter)
+		return true
+	}
+
+	if w.highest-counter >= replayWindowSize {
+		return false
+	}
+	if w.isSet(counter) {
+		return false
+	}
+	w.set(counter)
+	return true
+}
+
+func (w *ReplayWindow) isRetired(session uint64) bool {
+	for _, retired := range w.retired {
+		if retired == session {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *ReplayWindow) retire(session uint64) {
+	if len(w.retired) == replayRetiredSessions {
+		w.retired = w.retired[1:]
+	}
+	w.retired = append(w.retired, session)
+}
+
+func (w *ReplayWindow) set(counter uint64) {
+	i := counter % replayWindowSize
+	w.seen[i/64] |= 1 << (i % 64)
+}
+
+func (w *ReplayWindow) clear(counter uint64) {
+	i := counter % replayWindowSize
+	w.seen[i/64] &^= 1 << (i % 64)
+}
+
+func (w *ReplayWindow) isSet(counter uint64) bool {
+	i := counter % replayWindowSize
+	return w.seen[i/64]&(1<<(i%64)) != 0
+}
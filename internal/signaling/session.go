@@ -0,0 +1,149 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The session of an offer or answer is carried in fields of the message body unknown to the Signal Exchange proto,
+// so that peers not supporting sessions keep working and simply ignore them.
+const (
+	bodySessionIDField protowire.Number = 1001
+	bodyReplyToField   protowire.Number = 1002
+	bodyCounterField   protowire.Number = 1003
+)
+
+// Session identifies the connection attempt a signaling message belongs to
+type Session struct {
+	// ID is a random identifier of the sender's connection attempt, 0 if the sender doesn't support sessions
+	ID uint64
+	// ReplyTo is the session of the offer an answer replies to
+	ReplyTo uint64
+	// Counter increases with every message of the sender, used to detect replayed and stale messages
+	Counter uint64
+}
+
+// NewSessionID generates a random non-zero session identifier
+func NewSessionID() uint64 {
+	var b [8]byte
+	for {
+		_, err := rand.Read(b[:])
+		if err != nil {
+			// crypto/rand doesn't fail on supported platforms, fall back to the time
+			return uint64(time.Now().UnixNano())
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+// MessageCounter generates the monotonic counters of the messages sent by this peer.
+// It starts from the current time so that the counters keep growing across restarts.
+type MessageCounter struct {
+	last uint64
+}
+
+// NewMessageCounter creates a MessageCounter
+func NewMessageCounter() *MessageCounter {
+	return &MessageCounter{last: uint64(time.Now().UnixNano())}
+}
+
+// Next returns the counter of the next message
+func (c *MessageCounter) Next() uint64 {
+	return atomic.AddUint64(&c.last, 1)
+}
+
+// SetSession adds the session to an offer or answer body
+func SetSession(body *sProto.Body, session Session) {
+	var b []byte
+	for _, f := range []struct {
+		num   protowire.Number
+		value uint64
+	}{
+		{bodySessionIDField, session.ID},
+		{bodyReplyToField, session.ReplyTo},
+		{bodyCounterField, session.Counter},
+	} {
+		if f.value == 0 {
+			continue
+		}
+		b = protowire.AppendTag(b, f.num, protowire.VarintType)
+		b = protowire.AppendVarint(b, f.value)
+	}
+	replaceUnknownFields(body, b, bodySessionIDField, bodyReplyToField, bodyCounterField)
+}
+
+// replaceUnknownFields replaces the unknown fields nums of the body with the encoded fields b.
+// The other unknown fields are kept, so that the extensions of an offer or answer can be set independently.
+func replaceUnknownFields(body *sProto.Body, b []byte, nums ...protowire.Number) {
+	var kept []byte
+	unknown := body.ProtoReflect().GetUnknown()
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(num, typ, unknown[n:])
+		if m < 0 {
+			break
+		}
+		field := unknown[:n+m]
+		unknown = unknown[n+m:]
+
+		replaced := false
+		for _, r := range nums {
+			if num == r {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			kept = append(kept, field...)
+		}
+	}
+	body.ProtoReflect().SetUnknown(append(kept, b...))
+}
+
+// GetSession reads the session of an offer or answer body, zero values if the sender doesn't support sessions
+func GetSession(body *sProto.Body) Session {
+	session := Session{}
+	b := body.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return Session{}
+		}
+		b = b[n:]
+
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return Session{}
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return Session{}
+		}
+		b = b[n:]
+
+		switch num {
+		case bodySessionIDField:
+			session.ID = value
+		case bodyReplyToField:
+			session.ReplyTo = value
+		case bodyCounterField:
+			session.Counter = value
+		}
+	}
+	return session
+}
@@ -0,0 +1,99 @@
+package signaling
+
+import (
+	"testing"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSession_Body(t *testing.T) {
+	body := &sProto.Body{Type: sProto.Body_ANSWER, Payload: "ufrag:pwd", WgListenPort: 51820}
+	session := Session{ID: NewSessionID(), ReplyTo: NewSessionID(), Counter: NewMessageCounter().Next()}
+	SetSession(body, session)
+
+	// the session survives the encoding done by the transports
+	b, err := proto.Marshal(body)
+	require.NoError(t, err)
+	decoded := &sProto.Body{}
+	require.NoError(t, proto.Unmarshal(b, decoded))
+
+	assert.Equal(t, session, GetSession(decoded))
+	assert.Equal(t, "ufrag:pwd", decoded.GetPayload())
+	assert.Equal(t, uint32(51820), decoded.GetWgListenPort())
+
+	// peers not supporting sessions
+	assert.Equal(t, Session{}, GetSession(&sProto.Body{Payload: "ufrag:pwd"}))
+}
+
+func TestMessageCounter(t *testing.T) {
+	c := NewMessageCounter()
+	first := c.Next()
+	assert.Greater(t, c.Next(), first)
+	assert.Greater(t, NewMessageCounter().Next(), first, "counter should keep growing after a restart")
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := &ReplayWindow{}
+
+	assert.True(t, w.Accept(1, 1000))
+	assert.False(t, w.Accept(1, 1000), "replayed")
+	assert.True(t, w.Accept(1, 1002))
+	// reordered but not seen yet
+	assert.True(t, w.Accept(1, 1001))
+	assert.False(t, w.Accept(1, 1001))
+
+	assert.True(t, w.Accept(1, 1000+replayWindowSize+10))
+	assert.False(t, w.Accept(1, 1002), "older than the window")
+	assert.True(t, w.Accept(1, 1000+replayWindowSize+5))
+
+	// legacy peers
+	assert.True(t, w.Accept(1, 0))
+	assert.True(t, w.Accept(1, 0))
+}
+
+func TestReplayWindow_NewSession(t *testing.T) {
+	w := &ReplayWindow{}
+	assert.True(t, w.Accept(1, 5000))
+	assert.True(t, w.Accept(1, 5001))
+
+	// the remote peer restarted after its clock stepped back
+	assert.True(t, w.Accept(2, 1000), "a new session should start a new window")
+	assert.True(t, w.Accept(2, 1001))
+	assert.False(t, w.Accept(2, 1001), "replayed")
+
+	// the messages of the previous session are stale
+	assert.False(t, w.Accept(1, 5002))
+	assert.False(t, w.Accept(1, 5001))
+	assert.True(t, w.Accept(2, 1002))
+
+	// legacy peers without sessions
+	w = &ReplayWindow{}
+	assert.True(t, w.Accept(0, 0))
+	assert.True(t, w.Accept(0, 0))
+}
+
+func TestCapabilities_Body(t *testing.T) {
+	body := &sProto.Body{Type: sProto.Body_OFFER, Payload: "ufrag:pwd"}
+	session := Session{ID: 1, Counter: 2}
+	SetSession(body, session)
+	SetCapabilities(body, []string{"direct-mode", "tcp-candidates"})
+
+	b, err := proto.Marshal(body)
+	require.NoError(t, err)
+	decoded := &sProto.Body{}
+	require.NoError(t, proto.Unmarshal(b, decoded))
+
+	// both extensions are kept next to each other
+	assert.Equal(t, []string{"direct-mode", "tcp-candidates"}, GetCapabilities(decoded))
+	assert.Equal(t, session, GetSession(decoded))
+
+	SetCapabilities(decoded, []string{"end-of-candidates"})
+	assert.Equal(t, []string{"end-of-candidates"}, GetCapabilities(decoded))
+	assert.Equal(t, session, GetSession(decoded))
+
+	// peers not negotiating capabilities
+	assert.Nil(t, GetCapabilities(&sProto.Body{Payload: "ufrag:pwd"}))
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+
 	mgmProto "github.com/netbirdio/netbird/management/proto"
 	"ztnav2client/internal"
+	"ztnav2client/internal/ice"
 	nbStatus "ztnav2client/status"
 	"ztnav2client/util"
 )
@@ -15,6 +18,18 @@ func main() {
 	ctx := context.Background()
 	config, err := internal.GetConfig(configPath, "")
 
+	// "nat" prints the behaviour of the NAT discovered against the configured STUN servers
+	if len(os.Args) > 1 && os.Args[1] == "nat" {
+		if err != nil {
+			panic(err)
+		}
+		if err = discoverNAT(ctx, config.Stuns); err != nil {
+			fmt.Fprintf(os.Stderr, "NAT discovery failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	initConf := mgmProto.SyncResponse{
 		NetworkMap: &mgmProto.NetworkMap{
 			RemotePeers: config.Peers,
@@ -33,3 +48,27 @@ func main() {
 	}
 	fmt.Println("Connected")
 }
+
+func discoverNAT(ctx context.Context, stuns []*mgmProto.HostConfig) error {
+	var urls []*ice.URL
+	for _, stun := range stuns {
+		url, err := ice.ParseURL(stun.Uri)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, url)
+	}
+
+	result, err := internal.DetectNAT(ctx, urls)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Public address: %s\n", result.PublicAddr)
+	fmt.Printf("Behind NAT: %t\n", result.BehindNAT)
+	fmt.Printf("Mapping: %s\n", result.Mapping)
+	fmt.Printf("Filtering: %s\n", result.Filtering)
+	if result.Symmetric() {
+		fmt.Println("Symmetric NAT: server reflexive candidates are skipped, connections rely on peer reflexive or relay candidates")
+	}
+	return nil
+}
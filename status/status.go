@@ -17,6 +17,22 @@ type PeerState struct {
 	Direct                 bool
 	LocalIceCandidateType  string
 	RemoteIceCandidateType string
+	// LocalIceCandidateEndpoint and RemoteIceCandidateEndpoint are the addresses of the selected ICE candidate pair
+	LocalIceCandidateEndpoint  string
+	RemoteIceCandidateEndpoint string
+	// Latency is the round trip time of the selected ICE candidate pair
+	Latency time.Duration
+	// PacketsSent, PacketsReceived, BytesSent and BytesReceived count the traffic over the selected ICE candidate pair
+	PacketsSent     uint64
+	PacketsReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	// NextConnAttempt is the time the next connection attempt to the peer is scheduled for
+	NextConnAttempt time.Time
+	// Version is the agent version of the remote peer
+	Version string
+	// Capabilities are the capabilities announced by the remote peer
+	Capabilities []string
 }
 
 // LocalPeerState contains the latest state of the local peer
@@ -25,6 +41,11 @@ type LocalPeerState struct {
 	PubKey          string
 	KernelInterface bool
 	FQDN            string
+	// NATMapping and NATFiltering are the behaviours of the NAT in front of the peer, empty until discovered
+	NATMapping   string
+	NATFiltering string
+	// PublicAddress is the address the STUN servers see the peer's requests coming from
+	PublicAddress string
 }
 
 // SignalState contains the latest state of a signal connection
@@ -39,12 +60,23 @@ type ManagementState struct {
 	Connected bool
 }
 
+// RelayState contains the latest health of a STUN or TURN server
+type RelayState struct {
+	URI       string
+	Available bool
+	// Latency is the round trip time of the last successful probe
+	Latency time.Duration
+	// Err is the error of the last failed probe
+	Err error
+}
+
 // FullStatus contains the full state held by the Status instance
 type FullStatus struct {
 	Peers           []PeerState
 	ManagementState ManagementState
 	SignalState     SignalState
 	LocalPeerState  LocalPeerState
+	Relays          []RelayState
 }
 
 // Status holds a state of peers, signal and management connections
@@ -55,6 +87,7 @@ type Status struct {
 	signal       SignalState
 	management   ManagementState
 	localPeer    LocalPeerState
+	relays       []RelayState
 }
 
 // NewRecorder returns a new Status instance
@@ -125,6 +158,13 @@ func (d *Status) UpdatePeerState(receivedState PeerState) error {
 		peerState.Relayed = receivedState.Relayed
 		peerState.LocalIceCandidateType = receivedState.LocalIceCandidateType
 		peerState.RemoteIceCandidateType = receivedState.RemoteIceCandidateType
+		peerState.LocalIceCandidateEndpoint = receivedState.LocalIceCandidateEndpoint
+		peerState.RemoteIceCandidateEndpoint = receivedState.RemoteIceCandidateEndpoint
+		peerState.Latency = receivedState.Latency
+		peerState.PacketsSent = receivedState.PacketsSent
+		peerState.PacketsReceived = receivedState.PacketsReceived
+		peerState.BytesSent = receivedState.BytesSent
+		peerState.BytesReceived = receivedState.BytesReceived
 	}
 
 	d.peers[receivedState.PubKey] = peerState
@@ -138,6 +178,28 @@ func (d *Status) UpdatePeerState(receivedState PeerState) error {
 	return nil
 }
 
+// UpdatePeerICEStats update peer's state selected candidate pair endpoints, latency and traffic only
+func (d *Status) UpdatePeerICEStats(receivedState PeerState) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	peerState, ok := d.peers[receivedState.PubKey]
+	if !ok {
+		return errors.New("peer doesn't exist")
+	}
+
+	peerState.LocalIceCandidateEndpoint = receivedState.LocalIceCandidateEndpoint
+	peerState.RemoteIceCandidateEndpoint = receivedState.RemoteIceCandidateEndpoint
+	peerState.Latency = receivedState.Latency
+	peerState.PacketsSent = receivedState.PacketsSent
+	peerState.PacketsReceived = receivedState.PacketsReceived
+	peerState.BytesSent = receivedState.BytesSent
+	peerState.BytesReceived = receivedState.BytesReceived
+	d.peers[receivedState.PubKey] = peerState
+
+	return nil
+}
+
 // UpdatePeerFQDN update peer's state fqdn only
 func (d *Status) UpdatePeerFQDN(peerPubKey, fqdn string) error {
 	d.mux.Lock()
@@ -154,6 +216,39 @@ func (d *Status) UpdatePeerFQDN(peerPubKey, fqdn string) error {
 	return nil
 }
 
+// UpdatePeerNextAttempt update peer's state next connection attempt only
+func (d *Status) UpdatePeerNextAttempt(peerPubKey string, next time.Time) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	peerState, ok := d.peers[peerPubKey]
+	if !ok {
+		return errors.New("peer doesn't exist")
+	}
+
+	peerState.NextConnAttempt = next
+	d.peers[peerPubKey] = peerState
+
+	return nil
+}
+
+// UpdatePeerCapabilities update peer's state agent version and capabilities only
+func (d *Status) UpdatePeerCapabilities(peerPubKey, version string, capabilities []string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	peerState, ok := d.peers[peerPubKey]
+	if !ok {
+		return errors.New("peer doesn't exist")
+	}
+
+	peerState.Version = version
+	peerState.Capabilities = capabilities
+	d.peers[peerPubKey] = peerState
+
+	return nil
+}
+
 // GetPeerStateChangeNotifier returns a change notifier channel for a peer
 func (d *Status) GetPeerStateChangeNotifier(peer string) <-chan struct{} {
 	d.mux.Lock()
@@ -174,6 +269,16 @@ func (d *Status) UpdateLocalPeerState(localPeerState LocalPeerState) {
 	d.localPeer = localPeerState
 }
 
+// UpdateLocalPeerNAT updates the discovered NAT behaviour of the local peer, the rest of its state is kept
+func (d *Status) UpdateLocalPeerNAT(natMapping, natFiltering, publicAddress string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.localPeer.NATMapping = natMapping
+	d.localPeer.NATFiltering = natFiltering
+	d.localPeer.PublicAddress = publicAddress
+}
+
 // CleanLocalPeerState cleans local peer status
 func (d *Status) CleanLocalPeerState() {
 	d.mux.Lock()
@@ -222,6 +327,13 @@ func (d *Status) MarkSignalConnected(signalURL string) {
 	}
 }
 
+// UpdateRelayStates replaces the health of the STUN and TURN servers
+func (d *Status) UpdateRelayStates(relays []RelayState) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.relays = append([]RelayState(nil), relays...)
+}
+
 // GetFullStatus gets full status
 func (d *Status) GetFullStatus() FullStatus {
 	d.mux.Lock()
@@ -231,6 +343,7 @@ func (d *Status) GetFullStatus() FullStatus {
 		ManagementState: d.management,
 		SignalState:     d.signal,
 		LocalPeerState:  d.localPeer,
+		Relays:          append([]RelayState(nil), d.relays...),
 	}
 
 	for _, status := range d.peers {
@@ -1,8 +1,10 @@
 package status
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestAddPeer(t *testing.T) {
@@ -129,6 +131,22 @@ func TestUpdateLocalPeerState(t *testing.T) {
 	assert.Equal(t, localPeerState, status.localPeer, "local peer status should be equal")
 }
 
+func TestUpdateLocalPeerNAT(t *testing.T) {
+	localPeerState := LocalPeerState{
+		IP:     "10.10.10.10",
+		PubKey: "abc",
+	}
+	status := NewRecorder()
+	status.UpdateLocalPeerState(localPeerState)
+
+	status.UpdateLocalPeerNAT("endpoint-independent", "address-dependent", "203.0.113.1:40000")
+
+	localPeerState.NATMapping = "endpoint-independent"
+	localPeerState.NATFiltering = "address-dependent"
+	localPeerState.PublicAddress = "203.0.113.1:40000"
+	assert.Equal(t, localPeerState, status.localPeer, "only the NAT state should be updated")
+}
+
 func TestCleanLocalPeerState(t *testing.T) {
 	emptyLocalPeerState := LocalPeerState{}
 	localPeerState := LocalPeerState{
@@ -241,3 +259,92 @@ func TestGetFullStatus(t *testing.T) {
 	assert.Equal(t, signalState, fullStatus.SignalState, "signal status should be equal")
 	assert.ElementsMatch(t, []PeerState{peerState1, peerState2}, fullStatus.Peers, "peers states should match")
 }
+
+func TestStatus_UpdatePeerNextAttempt(t *testing.T) {
+	key := "abc"
+	next := time.Now().Add(time.Minute)
+	status := NewRecorder()
+	status.peers[key] = PeerState{PubKey: key}
+
+	err := status.UpdatePeerNextAttempt(key, next)
+	assert.NoError(t, err, "shouldn't return error")
+
+	state, exists := status.peers[key]
+	assert.True(t, exists, "state should be found")
+	assert.Equal(t, next, state.NextConnAttempt, "next attempt should be equal")
+
+	err = status.UpdatePeerNextAttempt("non_existing_key", next)
+	assert.Error(t, err, "should return error when peer doesn't exist")
+}
+
+func TestStatus_UpdatePeerCapabilities(t *testing.T) {
+	key := "abc"
+	status := NewRecorder()
+	status.peers[key] = PeerState{PubKey: key}
+
+	err := status.UpdatePeerCapabilities(key, "0.12.0", []string{"direct-mode"})
+	assert.NoError(t, err, "shouldn't return error")
+
+	state, exists := status.peers[key]
+	assert.True(t, exists, "state should be found")
+	assert.Equal(t, "0.12.0", state.Version, "version should be equal")
+	assert.Equal(t, []string{"direct-mode"}, state.Capabilities, "capabilities should be equal")
+
+	err = status.UpdatePeerCapabilities("non_existing_key", "0.12.0", nil)
+	assert.Error(t, err, "should return error when peer doesn't exist")
+}
+
+func TestStatus_UpdatePeerICEStats(t *testing.T) {
+	key := "abc"
+	status := NewRecorder()
+	status.peers[key] = PeerState{PubKey: key, ConnStatus: "Connected", Direct: true}
+
+	stats := PeerState{
+		PubKey:                     key,
+		LocalIceCandidateEndpoint:  "10.0.0.1:51820",
+		RemoteIceCandidateEndpoint: "10.0.0.2:51820",
+		Latency:                    15 * time.Millisecond,
+		PacketsSent:                10,
+		PacketsReceived:            12,
+		BytesSent:                  1000,
+		BytesReceived:              1200,
+	}
+	err := status.UpdatePeerICEStats(stats)
+	assert.NoError(t, err, "shouldn't return error")
+
+	state, exists := status.peers[key]
+	assert.True(t, exists, "state should be found")
+	assert.Equal(t, "Connected", state.ConnStatus, "connection status shouldn't change")
+	assert.True(t, state.Direct, "direct shouldn't change")
+	assert.Equal(t, stats.LocalIceCandidateEndpoint, state.LocalIceCandidateEndpoint, "local endpoint should be equal")
+	assert.Equal(t, stats.RemoteIceCandidateEndpoint, state.RemoteIceCandidateEndpoint, "remote endpoint should be equal")
+	assert.Equal(t, stats.Latency, state.Latency, "latency should be equal")
+	assert.Equal(t, stats.PacketsSent, state.PacketsSent, "packets sent should be equal")
+	assert.Equal(t, stats.BytesReceived, state.BytesReceived, "bytes received should be equal")
+
+	// the stats are cleared once the peer has disconnected
+	err = status.UpdatePeerState(PeerState{PubKey: key, ConnStatus: "Disconnected"})
+	assert.NoError(t, err, "shouldn't return error")
+	state = status.peers[key]
+	assert.Empty(t, state.RemoteIceCandidateEndpoint, "remote endpoint should be cleared")
+	assert.Zero(t, state.Latency, "latency should be cleared")
+	assert.Zero(t, state.PacketsSent, "packets sent should be cleared")
+
+	err = status.UpdatePeerICEStats(PeerState{PubKey: "non_existing_key"})
+	assert.Error(t, err, "should return error when peer doesn't exist")
+}
+
+func TestStatus_UpdateRelayStates(t *testing.T) {
+	status := NewRecorder()
+	relays := []RelayState{
+		{URI: "stun:stun.example.com:3478", Available: true, Latency: 20 * time.Millisecond},
+		{URI: "turn:turn.example.com:3478?transport=udp", Err: errors.New("timeout")},
+	}
+	status.UpdateRelayStates(relays)
+
+	fullStatus := status.GetFullStatus()
+	assert.Equal(t, relays, fullStatus.Relays, "relay states should be equal")
+
+	relays[0].Available = false
+	assert.True(t, status.GetFullStatus().Relays[0].Available, "relay states should be copied")
+}